@@ -1,10 +1,23 @@
 package config
 
 import (
+	"encoding/json"
+	"log"
 	"myapp/database"
 	logger "myapp/log"
+	"myapp/middleware"
+	"myapp/servers/dlna"
+	"myapp/servers/mpd"
 	"myapp/servers/music"
+	"myapp/servers/podcast"
+	"myapp/servers/scrobble"
+	"myapp/servers/tagging"
+	"myapp/servers/user"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -18,10 +31,30 @@ type Config struct {
 	SrvConfig struct {
 		Addr string
 		Port string
+		// 可信的反向代理地址或网段，只有来自这些地址的请求才使用 X-Forwarded-For 中的客户端 IP
+		TrustedProxies []string
 	}
 
+	// 用户和登录令牌配置
+	UserConfig user.UserConfig
+
 	// 音乐配置
 	MusicConfig music.MusicConfig
+
+	// 播客配置
+	PodcastConfig podcast.PodcastConfig
+
+	// DLNA 媒体服务器配置
+	DLNAConfig dlna.DLNAConfig
+
+	// MPD 协议服务配置
+	MPDConfig mpd.MPDConfig
+
+	// 听歌记录提交配置
+	ScrobbleConfig scrobble.ScrobbleConfig
+
+	// 声学指纹和标签修正配置
+	TaggingConfig tagging.TaggingConfig
 }
 
 func LoadConfig() *Config {
@@ -62,17 +95,154 @@ func LoadConfig() *Config {
 		},
 
 		SrvConfig: struct {
-			Addr string
-			Port string
+			Addr           string
+			Port           string
+			TrustedProxies []string
 		}{
 			"0.0.0.0",
 			"8888",
+			// 默认不信任任何代理，客户端 IP 取连接的对端地址
+			splitList(getEnv("TRUSTED_PROXIES", "")),
+		},
+
+		UserConfig: user.UserConfig{
+			AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+			Mail: user.MailConfig{
+				Driver:       getEnv("MAIL_DRIVER", "log"),
+				From:         getEnv("MAIL_FROM", "digital-hub <noreply@localhost>"),
+				SMTPHost:     getEnv("SMTP_HOST", ""),
+				SMTPPort:     getEnvInt("SMTP_PORT", 587),
+				SMTPUsername: getEnv("SMTP_USERNAME", ""),
+				SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+				Dir:          getEnv("MAIL_DIR", "./mails"),
+				TemplateDir:  getEnv("MAIL_TEMPLATE_DIR", ""),
+			},
+			AppURL:                   getEnv("APP_URL", "http://localhost:5173"),
+			RequireEmailVerification: getEnv("REQUIRE_EMAIL_VERIFICATION", "false") == "true",
+			TOTPIssuer:               getEnv("TOTP_ISSUER", "digital-hub"),
+			// open: 开放注册，invite: 凭邀请码注册，closed: 关闭注册
+			RegistrationMode: getEnv("REGISTRATION_MODE", "open"),
+			// 审计日志保留时长，0 表示永久保留
+			AuditRetention: getEnvDuration("AUDIT_RETENTION", 365*24*time.Hour),
+			OIDC: user.OIDCConfig{
+				Issuer:       getEnv("OIDC_ISSUER", ""),
+				Name:         getEnv("OIDC_NAME", "单点登录"),
+				ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+				RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/user/oidc/callback"),
+				Scopes:       splitList(getEnv("OIDC_SCOPES", "")),
+				RoleClaim:    getEnv("OIDC_ROLE_CLAIM", ""),
+				// 格式: 组名=角色,组名=角色
+				RoleMapping:   parseMapping(getEnv("OIDC_ROLE_MAPPING", "")),
+				AutoProvision: getEnv("OIDC_AUTO_PROVISION", "true") == "true",
+				LinkByEmail:   getEnv("OIDC_LINK_BY_EMAIL", "false") == "true",
+			},
+			// 登录失败锁定
+			LoginMaxAttempts:   getEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+			LoginIPMaxAttempts: getEnvInt("LOGIN_IP_MAX_ATTEMPTS", 20),
+			LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", time.Minute),
+			LoginLockoutMax:    getEnvDuration("LOGIN_LOCKOUT_MAX", 24*time.Hour),
+
+			AvatarDir: getEnv("AVATAR_DIR", "./avatars"),
+			// 注销账号后保留数据的时长，期间可以撤销
+			DeletionGracePeriod: getEnvDuration("ACCOUNT_DELETION_GRACE", 7*24*time.Hour),
+			// 旧版本通过 ADMIN_ROLES 指定管理员角色编号，首次启动时迁移
+			LegacyAdminRoles: parseRoles(getEnv("ADMIN_ROLES", "1")),
+			// 首次部署时创建管理员，已有管理员时忽略
+			BootstrapAdmin:         getEnv("ADMIN_USERNAME", ""),
+			BootstrapAdminPassword: getEnv("ADMIN_PASSWORD", ""),
 		},
 
 		MusicConfig: music.MusicConfig{
-			MusicDir: getEnv("MUSIC_DIR", "./songs"),
+			MusicDir:  getEnv("MUSIC_DIR", "./songs"),
+			Libraries: parseLibraries(getEnv("MUSIC_LIBRARIES", ""), parseRoles(getEnv("ADMIN_ROLES", "1"))),
+			IgnorePatterns: func() []string {
+				// 未设置时使用默认规则，设置为空时不忽略任何目录
+				if _, ok := os.LookupEnv("MUSIC_IGNORE"); !ok {
+					return nil
+				}
+				return append([]string{}, splitList(os.Getenv("MUSIC_IGNORE"))...)
+			}(),
+			Extensions:   splitList(getEnv("MUSIC_EXTENSIONS", "")),
+			MimeTypes:    splitList(getEnv("MUSIC_MIME_TYPES", "")),
+			PublicURL:    getEnv("PUBLIC_URL", ""),
+			FeedImageURL: getEnv("FEED_IMAGE_URL", ""),
 		},
+
+		PodcastConfig: podcast.PodcastConfig{
+			Dir:          getEnv("PODCAST_DIR", "./podcasts"),
+			Library:      getEnv("PODCAST_LIBRARY", "podcasts"),
+			PollInterval: getEnvDuration("PODCAST_POLL_INTERVAL", time.Hour),
+			AutoDownload: getEnvInt("PODCAST_AUTO_DOWNLOAD", 3),
+			// 单集文件大小上限（MB）
+			MaxEpisodeSize: int64(getEnvInt("PODCAST_MAX_EPISODE_MB", 1024)) << 20,
+			// 允许订阅局域网中的播客，开启后用户可以让服务端访问内网地址
+			AllowPrivateHosts: getEnv("PODCAST_ALLOW_PRIVATE_HOSTS", "false") == "true",
+		},
+
+		DLNAConfig: dlna.DLNAConfig{
+			Enabled:        getEnv("DLNA_ENABLED", "false") == "true",
+			FriendlyName:   getEnv("DLNA_NAME", "digital-hub"),
+			UUID:           getEnv("DLNA_UUID", ""),
+			NotifyInterval: getEnvDuration("DLNA_NOTIFY_INTERVAL", 15*time.Minute),
+		},
+
+		MPDConfig: mpd.MPDConfig{
+			Enabled: getEnv("MPD_ENABLED", "false") == "true",
+			Addr:    getEnv("MPD_ADDR", ":6600"),
+		},
+
+		ScrobbleConfig: scrobble.ScrobbleConfig{
+			RetryInterval: getEnvDuration("SCROBBLE_RETRY_INTERVAL", time.Minute),
+			MaxAttempts:   getEnvInt("SCROBBLE_MAX_ATTEMPTS", 20),
+			Timeout:       getEnvDuration("SCROBBLE_TIMEOUT", 15*time.Second),
+			// 自建 ListenBrainz、Libre.fm 等服务的主机名，逗号分隔
+			AllowedHosts: splitList(getEnv("SCROBBLE_ALLOWED_HOSTS", "")),
+		},
+
+		TaggingConfig: tagging.TaggingConfig{
+			FFmpeg:      getEnv("FFMPEG_PATH", "ffmpeg"),
+			Interval:    getEnvDuration("TAGGING_INTERVAL", 10*time.Minute),
+			MinScore:    getEnvFloat("TAGGING_MIN_SCORE", 0.5),
+			AcoustIDURL: getEnv("ACOUSTID_URL", ""),
+			AcoustIDKey: getEnv("ACOUSTID_API_KEY", ""),
+			LocalDump:   getEnv("TAGGING_LOCAL_DUMP", ""),
+		},
+	}
+}
+
+// parseMapping 解析 key=value 形式的逗号分隔列表
+func parseMapping(value string) map[string]string {
+	mapping := map[string]string{}
+	for _, item := range splitList(value) {
+		if key, val, ok := strings.Cut(item, "="); ok {
+			mapping[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+	return mapping
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
+
+// parseRoles 解析逗号分隔的角色编号，忽略无效项
+func parseRoles(value string) []int32 {
+	var roles []int32
+	for _, item := range splitList(value) {
+		if role, err := strconv.ParseInt(item, 10, 32); err == nil {
+			roles = append(roles, int32(role))
+		}
+	}
+	return roles
 }
 
 func getEnv(key, defaultValue string) string {
@@ -82,3 +252,67 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// parseLibraries 解析 JSON 格式的多音乐库配置，例如：
+// [{"name":"shared","dir":"./songs","public":true},{"name":"kids","dir":"./kids","allow_roles":["admin"]}]
+// 旧版本的 allow_roles 为数字角色编号，属于 ADMIN_ROLES 的编号转换为 admin 角色；
+// 其他编号在迁移后没有对应的角色，为避免扩大访问范围，配置错误时直接退出
+func parseLibraries(value string, legacyAdminRoles []int32) []music.LibraryConfig {
+	if value == "" {
+		return nil
+	}
+
+	var items []struct {
+		music.LibraryConfig
+		AllowRoles []json.RawMessage `json:"allow_roles"`
+	}
+	if err := json.Unmarshal([]byte(value), &items); err != nil {
+		log.Fatalf("解析 MUSIC_LIBRARIES 失败: %v", err)
+	}
+	libraries := make([]music.LibraryConfig, 0, len(items))
+	for _, item := range items {
+		lib := item.LibraryConfig
+		for _, raw := range item.AllowRoles {
+			var name string
+			if err := json.Unmarshal(raw, &name); err == nil {
+				lib.AllowRoles = append(lib.AllowRoles, name)
+				continue
+			}
+			var legacy int32
+			if err := json.Unmarshal(raw, &legacy); err != nil {
+				log.Fatalf("音乐库 %s 的 allow_roles 格式错误: %s", lib.Name, raw)
+			}
+			if !slices.Contains(legacyAdminRoles, legacy) {
+				log.Fatalf("音乐库 %s 的 allow_roles 使用了旧的角色编号 %d，请改为角色名", lib.Name, legacy)
+			}
+			log.Printf("警告: 音乐库 %s 的 allow_roles 使用了旧的角色编号 %d，已按 %s 角色处理", lib.Name, legacy, middleware.RoleAdmin)
+			lib.AllowRoles = append(lib.AllowRoles, middleware.RoleAdmin)
+		}
+		libraries = append(libraries, lib)
+	}
+	return libraries
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
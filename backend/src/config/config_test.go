@@ -0,0 +1,33 @@
+package config
+
+import (
+	"myapp/middleware"
+	"slices"
+	"testing"
+)
+
+// 旧版本 allow_roles 中属于 ADMIN_ROLES 的数字编号按 admin 角色处理，角色名保持不变
+func TestParseLibrariesLegacyRoles(t *testing.T) {
+	libraries := parseLibraries(`[
+		{"name":"shared","dir":"./songs","public":true},
+		{"name":"kids","dir":"./kids","allow_roles":[1]},
+		{"name":"mixed","dir":"./mixed","allow_roles":["curator",3]}
+	]`, []int32{1, 3})
+
+	want := map[string][]string{
+		"shared": nil,
+		"kids":   {middleware.RoleAdmin},
+		"mixed":  {"curator", middleware.RoleAdmin},
+	}
+	if len(libraries) != len(want) {
+		t.Fatalf("解析出 %d 个音乐库", len(libraries))
+	}
+	for _, lib := range libraries {
+		if !slices.Equal(lib.AllowRoles, want[lib.Name]) {
+			t.Errorf("%s 的 allow_roles 为 %v，应为 %v", lib.Name, lib.AllowRoles, want[lib.Name])
+		}
+	}
+	if !libraries[0].Public {
+		t.Error("其他字段应正常解析")
+	}
+}
@@ -4,7 +4,10 @@ go 1.24.0
 
 toolchain go1.24.11
 
-require gorm.io/driver/mysql v1.6.0
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	gorm.io/driver/mysql v1.6.0
+)
 
 require (
 	github.com/golang-jwt/jwt/v4 v4.5.2 // indirect
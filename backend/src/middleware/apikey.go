@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 个人 API 密钥
+// 供脚本和第三方集成使用，可以代替访问令牌放在 Authorization: Bearer 或 X-API-Key 请求头中。
+// 密钥的权限不超过所属用户，并受创建时选择的范围限制：
+// read 允许只读请求，write 允许所有请求，权限名（如 library:rescan）允许使用用户拥有的该权限
+
+const APIKeyPrefix = "dhk_"
+
+// API 密钥范围
+const (
+	ScopeRead  = "read"  // GET、HEAD 请求
+	ScopeWrite = "write" // 所有请求
+)
+
+// APIKeyInfo 校验通过的 API 密钥
+type APIKeyInfo struct {
+	ID        string
+	UserID    string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// ValidateAPIKey 校验 API 密钥并记录使用的客户端地址，由用户服务设置
+var ValidateAPIKey func(key, ip string) (*APIKeyInfo, bool)
+
+// ValidScope 判断是否为可分配给 API 密钥的范围
+func ValidScope(scope string) bool {
+	if scope == ScopeRead || scope == ScopeWrite {
+		return true
+	}
+	_, ok := Permissions[scope]
+	return ok
+}
+
+// isAPIKey 判断凭据是否为 API 密钥
+func isAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// apiKeyFromRequest 从请求头中读取 API 密钥
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) == 2 && parts[0] == "Bearer" && isAPIKey(parts[1]) {
+		return parts[1]
+	}
+	return ""
+}
+
+// authenticateAPIKey 校验 API 密钥及其读写范围，通过后写入当前用户
+func authenticateAPIKey(c *gin.Context, key string) (int, string) {
+	if ValidateAPIKey == nil {
+		return http.StatusUnauthorized, "不支持 API 密钥"
+	}
+	info, ok := ValidateAPIKey(key, c.ClientIP())
+	if !ok {
+		return http.StatusUnauthorized, "无效的 API 密钥"
+	}
+	safe := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+	if !slices.Contains(info.Scopes, ScopeWrite) && !(safe && slices.Contains(info.Scopes, ScopeRead)) {
+		return http.StatusForbidden, "API 密钥的范围不允许该操作"
+	}
+
+	c.Set("user_id", info.UserID)
+	c.Set("api_key_id", info.ID)
+	c.Set("api_key_scopes", info.Scopes)
+	if info.ExpiresAt != nil {
+		c.Set("token_expires_at", *info.ExpiresAt)
+	}
+	return http.StatusOK, ""
+}
+
+// CurrentScopes 返回当前请求使用的 API 密钥范围，使用访问令牌时为 nil（不受限制）
+func CurrentScopes(c *gin.Context) []string {
+	if scopes, ok := c.Get("api_key_scopes"); ok {
+		return scopes.([]string)
+	}
+	return nil
+}
+
+// ScopesAllow 判断 API 密钥范围是否允许使用该权限，scopes 为 nil 时不受限制
+func ScopesAllow(scopes []string, permission string) bool {
+	return scopes == nil || slices.Contains(scopes, permission)
+}
+
+// RejectAPIKey 拒绝使用 API 密钥的请求，用于修改密码、管理密钥等只允许登录会话进行的操作
+func RejectAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("api_key_id") != "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "该操作不能使用 API 密钥",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// 其他服务产生的审计事件，统一写入用户服务的审计日志
+
+// 审计事件类型
+const (
+	AuditMPDLogin          = "auth.mpd_login"
+	AuditLibraryRescan     = "library.rescan"
+	AuditCommentHide       = "moderation.comment_hide"
+	AuditCommentRestore    = "moderation.comment_restore"
+	AuditSuggestionApprove = "moderation.tag_approve"
+)
+
+// 审计目标类型
+const (
+	AuditTargetUser       = "user"
+	AuditTargetLibrary    = "library"
+	AuditTargetComment    = "comment"
+	AuditTargetSuggestion = "tag_suggestion"
+)
+
+// AuditRecord 审计事件，未填写的操作者和客户端信息从请求中补全
+type AuditRecord struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	TargetName string
+	IP         string // 不经过 HTTP 的协议（如 MPD）由调用方提供客户端 IP
+	Detail     map[string]interface{}
+}
+
+// Audit 写入审计事件，由用户服务设置；c 为 nil 时表示不经过 HTTP 的操作，err 不为 nil 时记录为失败
+var Audit func(c *gin.Context, record *AuditRecord, err error)
+
+// RecordAudit 未启用审计日志时忽略
+func RecordAudit(c *gin.Context, record *AuditRecord, err error) {
+	if Audit != nil {
+		Audit(c, record, err)
+	}
+}
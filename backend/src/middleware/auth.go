@@ -9,9 +9,36 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// AuthMiddleware JWT 认证中间件
+// 管理员重置密码后、用户修改密码前允许访问的接口，由用户服务注册
+var passwordChangeRoutes = map[string]bool{}
+
+// AllowBeforePasswordChange 允许需要修改密码的令牌访问该接口，path 为完整路由路径
+func AllowBeforePasswordChange(method, path string) {
+	passwordChangeRoutes[method+" "+path] = true
+}
+
+// mustChangePassword 令牌是否签发给需要修改密码的用户
+func mustChangePassword(claims jwt.MapClaims) bool {
+	v, _ := claims["pwd_change"].(bool)
+	return v
+}
+
+// AuthMiddleware JWT 认证中间件，也接受 API 密钥
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 使用 API 密钥的请求
+		if key := apiKeyFromRequest(c); key != "" {
+			if status, message := authenticateAPIKey(c, key); status != http.StatusOK {
+				c.JSON(status, gin.H{
+					"error": message,
+				})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
 		// 1. 从请求头获取 Authorization
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -62,8 +89,8 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// 5. 提取 claims 中的 user_id
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			userID, exists := claims["user_id"]
-			if !exists {
+			userID, exists := claims["user_id"].(string)
+			if !exists || !isAccessToken(claims) {
 				c.JSON(http.StatusUnauthorized, gin.H{
 					"error": "令牌中缺少用户信息",
 				})
@@ -71,8 +98,27 @@ func AuthMiddleware() gin.HandlerFunc {
 				return
 			}
 
-			// 6. 将 user_id 存入 gin 上下文（重要！）
-			c.Set("user_id", userID.(string))
+			// 6. 检查令牌是否已被吊销（登出、修改密码等）
+			if isRevoked(claims) {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "认证令牌已被吊销",
+				})
+				c.Abort()
+				return
+			}
+
+			// 需要修改密码的用户只能访问修改密码等少数接口
+			if mustChangePassword(claims) && !passwordChangeRoutes[c.Request.Method+" "+c.FullPath()] {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":                "请先修改密码",
+					"must_change_password": true,
+				})
+				c.Abort()
+				return
+			}
+
+			// 7. 将 user_id 和会话信息存入 gin 上下文（重要！）
+			setTokenContext(c, userID, claims)
 
 			// 可选：打印日志
 			// log.Printf("用户认证成功 - UserID: %s", userID)
@@ -84,7 +130,89 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 7. 继续处理请求
+		// 8. 继续处理请求
 		c.Next()
 	}
 }
+
+// OptionalAuthMiddleware 可选认证中间件
+// 携带有效令牌或 API 密钥时写入 user_id，未携带时按匿名用户继续处理
+// 音频和 SSE 请求无法设置请求头，因此也接受查询参数 token
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// API 密钥无效或范围不足时按匿名用户处理
+		if key := apiKeyFromRequest(c); key != "" {
+			authenticateAPIKey(c, key)
+			c.Next()
+			return
+		}
+
+		tokenString := c.Query("token")
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				tokenString = parts[1]
+			}
+		}
+
+		if tokenString != "" {
+			if userID, claims, ok := parseAccessToken(tokenString); ok {
+				setTokenContext(c, userID, claims)
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// parseAccessToken 校验访问令牌并返回其中的 user_id
+func parseAccessToken(tokenString string) (string, jwt.MapClaims, bool) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", nil, false
+	}
+
+	// 需要修改密码的令牌按匿名用户处理
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !isAccessToken(claims) || isRevoked(claims) || mustChangePassword(claims) {
+		return "", nil, false
+	}
+	userID, ok := claims["user_id"].(string)
+	return userID, claims, ok
+}
+
+// isAccessToken 分享令牌等其他用途的令牌与登录令牌共用密钥，通过 typ 声明区分；
+// 旧版登录令牌没有 typ 声明
+func isAccessToken(claims jwt.MapClaims) bool {
+	typ, ok := claims["typ"]
+	return !ok || typ == "access"
+}
+
+// setTokenContext 写入当前用户和令牌信息，登出时据此吊销令牌和会话
+func setTokenContext(c *gin.Context, userID string, claims jwt.MapClaims) {
+	c.Set("user_id", userID)
+	if jti, ok := claims["jti"].(string); ok {
+		c.Set("token_id", jti)
+	}
+	if sid, ok := claims["sid"].(string); ok {
+		c.Set("session_id", sid)
+	}
+	// 旧令牌没有角色声明，由 CurrentRoles 按需查询
+	if list, ok := claims["roles"].([]interface{}); ok {
+		roles := make([]string, 0, len(list))
+		for _, role := range list {
+			if name, ok := role.(string); ok {
+				roles = append(roles, name)
+			}
+		}
+		c.Set("roles", roles)
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		c.Set("token_expires_at", exp.Time)
+	}
+}
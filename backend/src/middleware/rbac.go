@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 基于角色的权限控制
+// 访问令牌中携带用户的角色名，角色对应的权限由用户服务从数据库加载并在修改时同步到这里，
+// 因此修改角色权限立即生效，修改用户的角色在下次刷新令牌后生效
+
+// 权限
+const (
+	PermAll              = "*"                 // 所有权限，仅内置管理员角色使用
+	PermUsersManage      = "users:manage"      // 管理用户
+	PermRolesManage      = "roles:manage"      // 管理角色和分配角色
+	PermLibraryRescan    = "library:rescan"    // 重新扫描音乐库
+	PermCommentsModerate = "comments:moderate" // 审核评论
+	PermTaggingReview    = "tagging:review"    // 审核标签修正建议
+	PermAuditRead        = "audit:read"        // 查看和导出审计日志
+)
+
+// Permissions 所有可分配的权限及说明
+var Permissions = map[string]string{
+	PermUsersManage:      "管理用户",
+	PermRolesManage:      "管理角色和分配角色",
+	PermLibraryRescan:    "重新扫描音乐库",
+	PermCommentsModerate: "审核评论",
+	PermTaggingReview:    "审核标签修正建议",
+	PermAuditRead:        "查看和导出审计日志",
+}
+
+// 内置角色
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// LookupRoles 根据用户ID查询角色，由用户服务设置；
+// 用于没有携带角色的旧令牌和非 HTTP 场景（MPD、DLNA 等）
+var LookupRoles func(userID string) []string
+
+var rolePermissions = struct {
+	sync.RWMutex
+	roles map[string][]string
+}{roles: map[string][]string{}}
+
+// SetRolePermissions 设置角色的权限
+func SetRolePermissions(role string, permissions []string) {
+	rolePermissions.Lock()
+	defer rolePermissions.Unlock()
+	rolePermissions.roles[role] = slices.Clone(permissions)
+}
+
+// RemoveRole 删除角色，持有该角色的令牌不再具有相应权限
+func RemoveRole(role string) {
+	rolePermissions.Lock()
+	defer rolePermissions.Unlock()
+	delete(rolePermissions.roles, role)
+}
+
+// HasPermission 判断角色中是否有任意一个具有该权限
+func HasPermission(roles []string, permission string) bool {
+	rolePermissions.RLock()
+	defer rolePermissions.RUnlock()
+	for _, role := range roles {
+		perms := rolePermissions.roles[role]
+		if slices.Contains(perms, PermAll) || slices.Contains(perms, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions 返回角色集合拥有的全部权限
+func RolePermissions(roles []string) []string {
+	if HasPermission(roles, PermAll) {
+		perms := make([]string, 0, len(Permissions))
+		for perm := range Permissions {
+			perms = append(perms, perm)
+		}
+		slices.Sort(perms)
+		return perms
+	}
+
+	rolePermissions.RLock()
+	defer rolePermissions.RUnlock()
+	var perms []string
+	for _, role := range roles {
+		for _, perm := range rolePermissions.roles[role] {
+			if !slices.Contains(perms, perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+	slices.Sort(perms)
+	return perms
+}
+
+// CurrentRoles 获取当前请求用户的角色，需在认证中间件之后调用
+func CurrentRoles(c *gin.Context) []string {
+	if roles, ok := c.Get("roles"); ok {
+		return roles.([]string)
+	}
+	userID := c.GetString("user_id")
+	if userID == "" || LookupRoles == nil {
+		return nil
+	}
+	roles := LookupRoles(userID)
+	c.Set("roles", roles)
+	return roles
+}
+
+// Can 判断当前请求用户是否具有该权限，使用 API 密钥时还需要密钥范围包含该权限
+func Can(c *gin.Context, permission string) bool {
+	return HasPermission(CurrentRoles(c), permission) && ScopesAllow(CurrentScopes(c), permission)
+}
+
+// RequirePermission 权限检查中间件，需要同时具有所有列出的权限，需放在 AuthMiddleware 之后
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_id") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "未提供认证令牌",
+			})
+			c.Abort()
+			return
+		}
+
+		roles := CurrentRoles(c)
+		scopes := CurrentScopes(c)
+		for _, perm := range permissions {
+			if !HasPermission(roles, perm) || !ScopesAllow(scopes, perm) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "权限不足",
+				})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
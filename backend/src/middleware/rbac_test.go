@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 测试用的 API 密钥，对应 ValidateAPIKey 的返回值；过期和吊销的密钥由用户服务校验失败
+var testAPIKeys = map[string]*APIKeyInfo{
+	APIKeyPrefix + "read":   {ID: "k1", UserID: "admin-1", Scopes: []string{ScopeRead}},
+	APIKeyPrefix + "write":  {ID: "k2", UserID: "admin-1", Scopes: []string{ScopeWrite}},
+	APIKeyPrefix + "rescan": {ID: "k3", UserID: "admin-1", Scopes: []string{ScopeWrite, PermLibraryRescan}},
+	APIKeyPrefix + "member": {ID: "k4", UserID: "user-1", Scopes: []string{ScopeWrite, PermLibraryRescan}},
+}
+
+func setupRBAC(t *testing.T) {
+	SetRolePermissions(RoleAdmin, []string{PermAll})
+	SetRolePermissions(RoleUser, nil)
+	SetRolePermissions("curator", []string{PermLibraryRescan})
+	LookupRoles = func(userID string) []string {
+		if userID == "admin-1" {
+			return []string{RoleAdmin}
+		}
+		return []string{RoleUser}
+	}
+	ValidateAPIKey = func(key, ip string) (*APIKeyInfo, bool) {
+		info, ok := testAPIKeys[key]
+		return info, ok
+	}
+	t.Cleanup(func() {
+		LookupRoles = nil
+		ValidateAPIKey = nil
+		RemoveRole("curator")
+	})
+}
+
+func signToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestScopesAllow(t *testing.T) {
+	cases := []struct {
+		scopes []string
+		perm   string
+		want   bool
+	}{
+		{nil, PermLibraryRescan, true}, // 访问令牌不受范围限制
+		{[]string{}, PermLibraryRescan, false},
+		{[]string{ScopeWrite}, PermLibraryRescan, false},
+		{[]string{ScopeWrite, PermLibraryRescan}, PermLibraryRescan, true},
+		{[]string{PermAuditRead}, PermLibraryRescan, false},
+	}
+	for _, tc := range cases {
+		if got := ScopesAllow(tc.scopes, tc.perm); got != tc.want {
+			t.Errorf("ScopesAllow(%v, %s) = %v", tc.scopes, tc.perm, got)
+		}
+	}
+}
+
+// 未认证返回 401，已认证但缺少权限或密钥范围不足返回 403
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRBAC(t)
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/music/songs", AuthMiddleware(), ok)
+	r.POST("/music/favorites", AuthMiddleware(), ok)
+	r.POST("/music/rescan", AuthMiddleware(), RequirePermission(PermLibraryRescan), ok)
+	r.POST("/anonymous/rescan", RequirePermission(PermLibraryRescan), ok)
+
+	admin := signToken(t, jwt.MapClaims{"typ": "access", "user_id": "admin-1", "roles": []string{RoleAdmin}})
+	member := signToken(t, jwt.MapClaims{"typ": "access", "user_id": "user-1", "roles": []string{RoleUser}})
+	curator := signToken(t, jwt.MapClaims{"typ": "access", "user_id": "user-2", "roles": []string{RoleUser, "curator"}})
+	// 旧令牌没有角色声明，通过 LookupRoles 查询
+	legacy := signToken(t, jwt.MapClaims{"user_id": "admin-1"})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		bearer string
+		apiKey string
+		want   int
+	}{
+		{"未登录", http.MethodPost, "/music/rescan", "", "", http.StatusUnauthorized},
+		{"未经认证中间件", http.MethodPost, "/anonymous/rescan", "", "", http.StatusUnauthorized},
+		{"令牌无效", http.MethodPost, "/music/rescan", "not-a-token", "", http.StatusUnauthorized},
+		{"普通用户", http.MethodPost, "/music/rescan", member, "", http.StatusForbidden},
+		{"自定义角色", http.MethodPost, "/music/rescan", curator, "", http.StatusOK},
+		{"管理员", http.MethodPost, "/music/rescan", admin, "", http.StatusOK},
+		{"旧令牌按数据库中的角色", http.MethodPost, "/music/rescan", legacy, "", http.StatusOK},
+
+		{"无效的 API 密钥", http.MethodGet, "/music/songs", "", APIKeyPrefix + "expired", http.StatusUnauthorized},
+		{"只读密钥读取", http.MethodGet, "/music/songs", "", APIKeyPrefix + "read", http.StatusOK},
+		{"只读密钥写入", http.MethodPost, "/music/favorites", "", APIKeyPrefix + "read", http.StatusForbidden},
+		{"只读密钥经 Authorization 写入", http.MethodPost, "/music/favorites", APIKeyPrefix + "read", "", http.StatusForbidden},
+		{"读写密钥写入", http.MethodPost, "/music/favorites", "", APIKeyPrefix + "write", http.StatusOK},
+		{"密钥范围不含权限", http.MethodPost, "/music/rescan", "", APIKeyPrefix + "write", http.StatusForbidden},
+		{"密钥范围包含权限", http.MethodPost, "/music/rescan", "", APIKeyPrefix + "rescan", http.StatusOK},
+		{"密钥权限不超过所属用户", http.MethodPost, "/music/rescan", "", APIKeyPrefix + "member", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		if tc.apiKey != "" {
+			req.Header.Set("X-API-Key", tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: 状态码为 %d，应为 %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+// 角色权限修改后立即生效，删除角色后持有该角色的令牌失去相应权限
+func TestRolePermissionsChange(t *testing.T) {
+	setupRBAC(t)
+	roles := []string{RoleUser, "curator"}
+	if !HasPermission(roles, PermLibraryRescan) || HasPermission(roles, PermAuditRead) {
+		t.Fatal("自定义角色的权限不正确")
+	}
+	SetRolePermissions("curator", []string{PermAuditRead})
+	if HasPermission(roles, PermLibraryRescan) || !HasPermission(roles, PermAuditRead) {
+		t.Error("修改角色权限应立即生效")
+	}
+	RemoveRole("curator")
+	if HasPermission(roles, PermAuditRead) {
+		t.Error("删除角色后不应再具有其权限")
+	}
+	if got := RolePermissions([]string{RoleAdmin}); len(got) != len(Permissions) {
+		t.Errorf("管理员应具有全部权限: %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 令牌吊销列表
+// 用户服务在启动时从数据库加载，登出或检测到刷新令牌重用时写入；
+// 条目在对应的访问令牌全部过期后即可清除
+
+const (
+	RevokeKindToken   = "token"   // 单个访问令牌（jti）
+	RevokeKindSession = "session" // 同一登录会话（sid，即刷新令牌族）签发的所有访问令牌
+	RevokeKindUser    = "user"    // 用户在某一时间（精确到秒）之前签发的所有令牌
+)
+
+type revocationEntry struct {
+	at        time.Time // 仅用户级条目使用：早于该时间签发的令牌无效
+	expiresAt time.Time
+}
+
+var revocations = struct {
+	sync.RWMutex
+	entries map[string]map[string]revocationEntry
+}{
+	entries: map[string]map[string]revocationEntry{
+		RevokeKindToken:   {},
+		RevokeKindSession: {},
+		RevokeKindUser:    {},
+	},
+}
+
+// Revoke 将令牌、会话或用户加入吊销列表，at 仅对用户级条目有意义
+func Revoke(kind, key string, at, expiresAt time.Time) {
+	revocations.Lock()
+	defer revocations.Unlock()
+	entries, ok := revocations.entries[kind]
+	if !ok || key == "" {
+		return
+	}
+	// 用户级条目保留最晚的时间点
+	if old, exists := entries[key]; exists && old.at.After(at) {
+		at = old.at
+	}
+	if old, exists := entries[key]; exists && old.expiresAt.After(expiresAt) {
+		expiresAt = old.expiresAt
+	}
+	entries[key] = revocationEntry{at: at, expiresAt: expiresAt}
+}
+
+// PurgeRevocations 清除已过期的吊销条目
+func PurgeRevocations(now time.Time) {
+	revocations.Lock()
+	defer revocations.Unlock()
+	for _, entries := range revocations.entries {
+		for key, entry := range entries {
+			if now.After(entry.expiresAt) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// isRevoked 检查访问令牌是否已被吊销
+func isRevoked(claims jwt.MapClaims) bool {
+	revocations.RLock()
+	defer revocations.RUnlock()
+
+	if jti, _ := claims["jti"].(string); jti != "" {
+		if _, ok := revocations.entries[RevokeKindToken][jti]; ok {
+			return true
+		}
+	}
+	if sid, _ := claims["sid"].(string); sid != "" {
+		if _, ok := revocations.entries[RevokeKindSession][sid]; ok {
+			return true
+		}
+	}
+	if userID, _ := claims["user_id"].(string); userID != "" {
+		if entry, ok := revocations.entries[RevokeKindUser][userID]; ok {
+			iat, err := claims.GetIssuedAt()
+			if err != nil || iat == nil || iat.Before(entry.at) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package dlna
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const xmlContentType = `text/xml; charset="utf-8"`
+
+func (ds *DLNAService) GetDeviceDescription(c *gin.Context) {
+	c.Data(http.StatusOK, xmlContentType, ds.deviceDescription())
+}
+
+func (ds *DLNAService) GetContentDirectorySCPD(c *gin.Context) {
+	c.Data(http.StatusOK, xmlContentType, []byte(contentDirectorySCPD))
+}
+
+func (ds *DLNAService) GetConnectionManagerSCPD(c *gin.Context) {
+	c.Data(http.StatusOK, xmlContentType, []byte(connectionManagerSCPD))
+}
+
+// ContentDirectory 控制接口：Browse / Search 等
+func (ds *DLNAService) ContentDirectoryControl(c *gin.Context) {
+	action, args, err := parseSOAPRequest(c)
+	if err != nil {
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidAction, Description: "Invalid Action"})
+		return
+	}
+
+	switch action {
+	case "Browse":
+		ds.browse(c, args)
+	case "Search":
+		ds.handleSearch(c, args)
+	case "GetSearchCapabilities":
+		writeSOAPResponse(c, contentDirectoryType, action, []soapArg{
+			{"SearchCaps", "dc:title,dc:creator,upnp:artist,upnp:album,upnp:class"},
+		})
+	case "GetSortCapabilities":
+		writeSOAPResponse(c, contentDirectoryType, action, []soapArg{{"SortCaps", ""}})
+	case "GetSystemUpdateID":
+		writeSOAPResponse(c, contentDirectoryType, action, []soapArg{
+			{"Id", strconv.FormatUint(uint64(ds.systemUpdateID()), 10)},
+		})
+	default:
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidAction, Description: "Invalid Action"})
+	}
+}
+
+func (ds *DLNAService) browse(c *gin.Context, args map[string]string) {
+	start, count, ok := parseRange(args)
+	if !ok {
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidArgs, Description: "Invalid Args"})
+		return
+	}
+
+	var objects []object
+	var total int64
+	var err error
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		var obj *object
+		obj, err = ds.lookup(args["ObjectID"])
+		if err == nil {
+			objects, total = []object{*obj}, 1
+		}
+	case "BrowseDirectChildren":
+		objects, total, err = ds.children(args["ObjectID"], start, count)
+	default:
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidArgs, Description: "Invalid BrowseFlag"})
+		return
+	}
+	if err != nil {
+		writeUPnPError(c, err)
+		return
+	}
+	ds.writeResult(c, "Browse", objects, total)
+}
+
+func (ds *DLNAService) handleSearch(c *gin.Context, args map[string]string) {
+	start, count, ok := parseRange(args)
+	if !ok {
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidArgs, Description: "Invalid Args"})
+		return
+	}
+
+	objects, total, err := ds.search(args["SearchCriteria"], start, count)
+	if err != nil {
+		writeUPnPError(c, err)
+		return
+	}
+	ds.writeResult(c, "Search", objects, total)
+}
+
+func (ds *DLNAService) writeResult(c *gin.Context, action string, objects []object, total int64) {
+	writeSOAPResponse(c, contentDirectoryType, action, []soapArg{
+		{"Result", ds.didl(objects, "http://"+c.Request.Host)},
+		{"NumberReturned", strconv.Itoa(len(objects))},
+		{"TotalMatches", strconv.FormatInt(total, 10)},
+		{"UpdateID", strconv.FormatUint(uint64(ds.systemUpdateID()), 10)},
+	})
+}
+
+func parseRange(args map[string]string) (int, int, bool) {
+	start, err1 := strconv.Atoi(defaultArg(args["StartingIndex"], "0"))
+	count, err2 := strconv.Atoi(defaultArg(args["RequestedCount"], "0"))
+	if err1 != nil || err2 != nil || start < 0 || count < 0 {
+		return 0, 0, false
+	}
+	return start, count, true
+}
+
+func defaultArg(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func writeUPnPError(c *gin.Context, err error) {
+	var ue *upnpError
+	if errors.As(err, &ue) {
+		writeSOAPFault(c, ue)
+		return
+	}
+	writeSOAPFault(c, &upnpError{Code: upnpActionFailed, Description: "Action Failed"})
+}
+
+// ConnectionManager 控制接口，只有一个默认连接
+func (ds *DLNAService) ConnectionManagerControl(c *gin.Context) {
+	action, args, err := parseSOAPRequest(c)
+	if err != nil {
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidAction, Description: "Invalid Action"})
+		return
+	}
+
+	switch action {
+	case "GetProtocolInfo":
+		var source []string
+		for _, mimeType := range []string{"audio/mpeg", "audio/flac", "audio/x-flac", "audio/wav", "audio/aac",
+			"audio/mp4", "audio/ogg", "audio/opus", "audio/x-ms-wma", "audio/x-ape", "audio/x-dsf"} {
+			source = append(source, protocolInfo(mimeType))
+		}
+		writeSOAPResponse(c, connectionManagerType, action, []soapArg{
+			{"Source", strings.Join(source, ",")},
+			{"Sink", ""},
+		})
+	case "GetCurrentConnectionIDs":
+		writeSOAPResponse(c, connectionManagerType, action, []soapArg{{"ConnectionIDs", "0"}})
+	case "GetCurrentConnectionInfo":
+		if args["ConnectionID"] != "0" {
+			writeSOAPFault(c, &upnpError{Code: 706, Description: "Invalid connection reference"})
+			return
+		}
+		writeSOAPResponse(c, connectionManagerType, action, []soapArg{
+			{"RcsID", "-1"},
+			{"AVTransportID", "-1"},
+			{"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"},
+			{"Direction", "Output"},
+			{"Status", "OK"},
+		})
+	default:
+		writeSOAPFault(c, &upnpError{Code: upnpInvalidAction, Description: "Invalid Action"})
+	}
+}
+
+// 事件订阅：目录变化通过 SystemUpdateID 轮询获得，这里只应答订阅请求，部分电视订阅失败会拒绝连接
+func (ds *DLNAService) Subscribe(c *gin.Context) {
+	sid := c.GetHeader("SID")
+	if sid == "" {
+		sid = "uuid:" + uuid.NewString()
+	}
+	c.Header("SID", sid)
+	c.Header("TIMEOUT", "Second-1800")
+	c.Status(http.StatusOK)
+}
+
+func (ds *DLNAService) Unsubscribe(c *gin.Context) {
+	c.Status(http.StatusOK)
+}
+
+// 播放音乐文件，文件名为 <ID>.<扩展名>
+func (ds *DLNAService) ServeMedia(c *gin.Context) {
+	file := c.Param("file")
+	id, err := strconv.ParseUint(strings.TrimSuffix(file, filepath.Ext(file)), 10, 64)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	m, err := ds.musics().track(uint(id))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	fullPath, ok := ds.ms.MusicFilePath(m)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	mimeType := mimeTypeOf(strings.ToLower(filepath.Ext(fullPath)))
+	c.Header("Content-Type", mimeType)
+	c.Header("transferMode.dlna.org", "Streaming")
+	c.Header("contentFeatures.dlna.org", contentFeatures(mimeType))
+	c.File(fullPath)
+}
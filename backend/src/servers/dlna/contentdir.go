@@ -0,0 +1,549 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"myapp/servers/music"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ContentDirectory 目录结构：
+//
+//	0
+//	├── artists
+//	│   └── artist$<艺术家>
+//	│       └── album$<艺术家>$<专辑>
+//	│           └── track$<ID>
+//	├── albums
+//	│   └── album$<艺术家>$<专辑>
+//	└── tracks
+//	    └── track$<ID>
+//
+// 艺术家和专辑名使用 base64 编码放入对象ID，避免名称中的特殊字符
+
+const (
+	rootID    = "0"
+	artistsID = "artists"
+	albumsID  = "albums"
+	tracksID  = "tracks"
+
+	artistPrefix = "artist$"
+	albumPrefix  = "album$"
+	trackPrefix  = "track$"
+)
+
+const unknownName = "未知"
+
+type object struct {
+	ID         string
+	ParentID   string
+	Title      string
+	Class      string
+	Artist     string
+	Album      string
+	ChildCount int64
+	Music      *music.Music
+}
+
+func (o *object) isContainer() bool {
+	return o.Music == nil
+}
+
+func encodeName(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func decodeName(value string) (string, bool) {
+	name, err := base64.RawURLEncoding.DecodeString(value)
+	return string(name), err == nil
+}
+
+func artistObjectID(artist string) string {
+	return artistPrefix + encodeName(artist)
+}
+
+func albumObjectID(artist, album string) string {
+	return albumPrefix + encodeName(artist) + "$" + encodeName(album)
+}
+
+func displayName(name string) string {
+	if name == "" {
+		return unknownName
+	}
+	return name
+}
+
+var errNoSuchObject = &upnpError{Code: upnpNoSuchObject, Description: "No such object"}
+
+func (ds *DLNAService) musics() *musicQuery {
+	return &musicQuery{ds: ds}
+}
+
+// musicQuery 限定在公开音乐库内的查询
+type musicQuery struct {
+	ds *DLNAService
+}
+
+func (q *musicQuery) scope() (*gorm.DB, bool) {
+	libraries := q.ds.ms.PublicLibraries()
+	if len(libraries) == 0 {
+		return nil, false
+	}
+	return q.ds.db.Model(&music.Music{}).Where("library IN ?", libraries), true
+}
+
+type artistRow struct {
+	Artist string
+	Albums int64
+}
+
+type albumRow struct {
+	Artist string
+	Album  string
+	Tracks int64
+}
+
+func (q *musicQuery) artists() ([]artistRow, error) {
+	var rows []artistRow
+	db, ok := q.scope()
+	if !ok {
+		return rows, nil
+	}
+	err := db.Select("artist, COUNT(DISTINCT album) AS albums").
+		Group("artist").Order("artist ASC").Scan(&rows).Error
+	return rows, err
+}
+
+func (q *musicQuery) albums(artist *string) ([]albumRow, error) {
+	var rows []albumRow
+	db, ok := q.scope()
+	if !ok {
+		return rows, nil
+	}
+	if artist != nil {
+		db = db.Where("artist = ?", *artist)
+	}
+	err := db.Select("artist, album, COUNT(*) AS tracks").
+		Group("artist, album").Order("album ASC, artist ASC").Scan(&rows).Error
+	return rows, err
+}
+
+func (q *musicQuery) count(where string, args ...interface{}) (int64, error) {
+	var total int64
+	db, ok := q.scope()
+	if !ok {
+		return 0, nil
+	}
+	if where != "" {
+		db = db.Where(where, args...)
+	}
+	err := db.Count(&total).Error
+	return total, err
+}
+
+func (q *musicQuery) countDistinct(column string, where string, args ...interface{}) (int64, error) {
+	var total int64
+	db, ok := q.scope()
+	if !ok {
+		return 0, nil
+	}
+	if where != "" {
+		db = db.Where(where, args...)
+	}
+	err := db.Distinct(column).Count(&total).Error
+	return total, err
+}
+
+// tracks 分页查询音乐，返回当前页和总数
+func (q *musicQuery) tracks(start, count int, order string, where string, args ...interface{}) ([]music.Music, int64, error) {
+	var list []music.Music
+	db, ok := q.scope()
+	if !ok {
+		return list, 0, nil
+	}
+	if where != "" {
+		db = db.Where(where, args...)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	query := db.Order(order).Offset(start)
+	if count > 0 {
+		query = query.Limit(count)
+	}
+	err := query.Find(&list).Error
+	return list, total, err
+}
+
+func (q *musicQuery) track(id uint) (*music.Music, error) {
+	var m music.Music
+	db, ok := q.scope()
+	if !ok {
+		return nil, errNoSuchObject
+	}
+	if err := db.First(&m, id).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// systemUpdateID 根据音乐总数和最大ID生成，增删音乐后控制点会刷新缓存
+func (ds *DLNAService) systemUpdateID() uint32 {
+	var stat struct {
+		Total int64
+		MaxID int64
+	}
+	db, ok := ds.musics().scope()
+	if !ok {
+		return 0
+	}
+	db.Select("COUNT(*) AS total, COALESCE(MAX(id), 0) AS max_id").Scan(&stat)
+	return uint32(stat.Total + stat.MaxID)
+}
+
+func trackObject(m *music.Music, parentID string) object {
+	title := m.Title
+	if title == "" {
+		title = strings.TrimSuffix(m.Name, filepath.Ext(m.Name))
+	}
+	return object{
+		ID:       trackPrefix + strconv.FormatUint(uint64(m.ID), 10),
+		ParentID: parentID,
+		Title:    title,
+		Class:    "object.item.audioItem.musicTrack",
+		Artist:   m.Artist,
+		Album:    m.Album,
+		Music:    m,
+	}
+}
+
+func albumObject(row albumRow, parentID string) object {
+	return object{
+		ID:         albumObjectID(row.Artist, row.Album),
+		ParentID:   parentID,
+		Title:      displayName(row.Album),
+		Class:      "object.container.album.musicAlbum",
+		Artist:     row.Artist,
+		ChildCount: row.Tracks,
+	}
+}
+
+// lookup 解析对象ID，返回对象本身
+func (ds *DLNAService) lookup(id string) (*object, error) {
+	q := ds.musics()
+	switch {
+	case id == rootID:
+		return &object{ID: rootID, ParentID: "-1", Title: ds.cfg.FriendlyName,
+			Class: "object.container.storageFolder", ChildCount: 3}, nil
+
+	case id == artistsID:
+		n, err := q.countDistinct("artist", "")
+		return &object{ID: artistsID, ParentID: rootID, Title: "艺术家",
+			Class: "object.container.storageFolder", ChildCount: n}, err
+
+	case id == albumsID:
+		rows, err := q.albums(nil)
+		return &object{ID: albumsID, ParentID: rootID, Title: "专辑",
+			Class: "object.container.storageFolder", ChildCount: int64(len(rows))}, err
+
+	case id == tracksID:
+		n, err := q.count("")
+		return &object{ID: tracksID, ParentID: rootID, Title: "全部歌曲",
+			Class: "object.container.storageFolder", ChildCount: n}, err
+
+	case strings.HasPrefix(id, artistPrefix):
+		artist, ok := decodeName(strings.TrimPrefix(id, artistPrefix))
+		if !ok {
+			return nil, errNoSuchObject
+		}
+		n, err := q.countDistinct("album", "artist = ?", artist)
+		if err == nil && n == 0 {
+			return nil, errNoSuchObject
+		}
+		return &object{ID: id, ParentID: artistsID, Title: displayName(artist),
+			Class: "object.container.person.musicArtist", ChildCount: n}, err
+
+	case strings.HasPrefix(id, albumPrefix):
+		artist, album, ok := parseAlbumID(id)
+		if !ok {
+			return nil, errNoSuchObject
+		}
+		n, err := q.count("artist = ? AND album = ?", artist, album)
+		if err == nil && n == 0 {
+			return nil, errNoSuchObject
+		}
+		obj := albumObject(albumRow{Artist: artist, Album: album, Tracks: n}, artistObjectID(artist))
+		return &obj, err
+
+	case strings.HasPrefix(id, trackPrefix):
+		musicID, err := strconv.ParseUint(strings.TrimPrefix(id, trackPrefix), 10, 64)
+		if err != nil {
+			return nil, errNoSuchObject
+		}
+		m, err := q.track(uint(musicID))
+		if err != nil {
+			return nil, errNoSuchObject
+		}
+		obj := trackObject(m, albumObjectID(m.Artist, m.Album))
+		return &obj, nil
+	}
+	return nil, errNoSuchObject
+}
+
+func parseAlbumID(id string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(id, albumPrefix), "$")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	artist, ok1 := decodeName(parts[0])
+	album, ok2 := decodeName(parts[1])
+	return artist, album, ok1 && ok2
+}
+
+// children 列出容器的子对象，返回当前页和总数
+func (ds *DLNAService) children(id string, start, count int) ([]object, int64, error) {
+	q := ds.musics()
+	var objects []object
+
+	switch {
+	case id == rootID:
+		for _, childID := range []string{artistsID, albumsID, tracksID} {
+			obj, err := ds.lookup(childID)
+			if err != nil {
+				return nil, 0, err
+			}
+			objects = append(objects, *obj)
+		}
+
+	case id == artistsID:
+		rows, err := q.artists()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range rows {
+			objects = append(objects, object{ID: artistObjectID(row.Artist), ParentID: artistsID,
+				Title: displayName(row.Artist), Class: "object.container.person.musicArtist", ChildCount: row.Albums})
+		}
+
+	case id == albumsID:
+		rows, err := q.albums(nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range rows {
+			objects = append(objects, albumObject(row, albumsID))
+		}
+
+	case id == tracksID:
+		// 全部歌曲可能很多，直接在数据库中分页
+		list, total, err := q.tracks(start, count, "title ASC, id ASC", "")
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range list {
+			objects = append(objects, trackObject(&list[i], tracksID))
+		}
+		return objects, total, nil
+
+	case strings.HasPrefix(id, artistPrefix):
+		artist, ok := decodeName(strings.TrimPrefix(id, artistPrefix))
+		if !ok {
+			return nil, 0, errNoSuchObject
+		}
+		rows, err := q.albums(&artist)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range rows {
+			objects = append(objects, albumObject(row, id))
+		}
+
+	case strings.HasPrefix(id, albumPrefix):
+		artist, album, ok := parseAlbumID(id)
+		if !ok {
+			return nil, 0, errNoSuchObject
+		}
+		list, total, err := q.tracks(start, count, "name ASC", "artist = ? AND album = ?", artist, album)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range list {
+			objects = append(objects, trackObject(&list[i], id))
+		}
+		return objects, total, nil
+
+	default:
+		return nil, 0, errNoSuchObject
+	}
+
+	total := int64(len(objects))
+	return paginate(objects, start, count), total, nil
+}
+
+func paginate(objects []object, start, count int) []object {
+	if start >= len(objects) {
+		return nil
+	}
+	objects = objects[start:]
+	if count > 0 && count < len(objects) {
+		objects = objects[:count]
+	}
+	return objects
+}
+
+// 搜索条件中支持的属性，例如 upnp:artist contains "周杰伦" and dc:title = "晴天"
+var searchExpr = regexp.MustCompile(`(dc:title|dc:creator|upnp:artist|upnp:album)\s+(contains|=)\s+"((?:[^"\\]|\\.)*)"`)
+
+var searchColumns = map[string]string{
+	"dc:title":    "title",
+	"dc:creator":  "artist",
+	"upnp:artist": "artist",
+	"upnp:album":  "album",
+}
+
+// search 只搜索歌曲，多个条件按 and 或 or 组合（不支持括号嵌套）
+func (ds *DLNAService) search(criteria string, start, count int) ([]object, int64, error) {
+	criteria = strings.TrimSpace(criteria)
+	if strings.Contains(criteria, `derivedfrom "object.container`) || strings.Contains(criteria, `= "object.container`) {
+		return nil, 0, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, match := range searchExpr.FindAllStringSubmatch(criteria, -1) {
+		column := searchColumns[match[1]]
+		value := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(match[3])
+		if match[2] == "=" {
+			conditions = append(conditions, column+" = ?")
+		} else {
+			conditions = append(conditions, "INSTR("+column+", ?) > 0")
+		}
+		args = append(args, value)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		joiner := " AND "
+		if strings.Contains(strings.ToLower(criteria), " or ") {
+			joiner = " OR "
+		}
+		where = "(" + strings.Join(conditions, joiner) + ")"
+	} else if criteria != "*" && !strings.Contains(criteria, "upnp:class") {
+		return nil, 0, &upnpError{Code: upnpInvalidArgs, Description: "Unsupported search criteria"}
+	}
+
+	list, total, err := ds.musics().tracks(start, count, "title ASC, id ASC", where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var objects []object
+	for i := range list {
+		objects = append(objects, trackObject(&list[i], albumObjectID(list[i].Artist, list[i].Album)))
+	}
+	return objects, total, nil
+}
+
+// didl 生成 DIDL-Lite 文档，baseURL 用于拼接音乐文件地址
+func (ds *DLNAService) didl(objects []object, baseURL string) string {
+	var buf bytes.Buffer
+	buf.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">`)
+
+	for i := range objects {
+		obj := &objects[i]
+		if obj.isContainer() {
+			fmt.Fprintf(&buf, `<container id="%s" parentID="%s" restricted="1" searchable="1" childCount="%d">`,
+				escapeAttr(obj.ID), escapeAttr(obj.ParentID), obj.ChildCount)
+			writeElement(&buf, "dc:title", obj.Title)
+			writeElement(&buf, "upnp:class", obj.Class)
+			if obj.Artist != "" {
+				writeElement(&buf, "upnp:artist", obj.Artist)
+				writeElement(&buf, "dc:creator", obj.Artist)
+			}
+			buf.WriteString(`</container>`)
+			continue
+		}
+
+		fmt.Fprintf(&buf, `<item id="%s" parentID="%s" restricted="1">`, escapeAttr(obj.ID), escapeAttr(obj.ParentID))
+		writeElement(&buf, "dc:title", obj.Title)
+		writeElement(&buf, "upnp:class", obj.Class)
+		if obj.Artist != "" {
+			writeElement(&buf, "upnp:artist", obj.Artist)
+			writeElement(&buf, "dc:creator", obj.Artist)
+		}
+		if obj.Album != "" {
+			writeElement(&buf, "upnp:album", obj.Album)
+		}
+		if res, ok := ds.resource(obj.Music, baseURL); ok {
+			buf.WriteString(res)
+		}
+		buf.WriteString(`</item>`)
+	}
+
+	buf.WriteString(`</DIDL-Lite>`)
+	return buf.String()
+}
+
+// resource 生成 res 元素，文件通过 /dlna/media 接口提供
+func (ds *DLNAService) resource(m *music.Music, baseURL string) (string, bool) {
+	fullPath, ok := ds.ms.MusicFilePath(m)
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", false
+	}
+
+	ext := strings.ToLower(filepath.Ext(fullPath))
+	mimeType := mimeTypeOf(ext)
+	url := fmt.Sprintf("%s/dlna/media/%d%s", baseURL, m.ID, ext)
+	return fmt.Sprintf(`<res protocolInfo="%s" size="%d">%s</res>`,
+		escapeAttr(protocolInfo(mimeType)), info.Size(), escapeAttr(url)), true
+}
+
+func mimeTypeOf(ext string) string {
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// contentFeatures 声明支持按字节范围跳转（DLNA.ORG_OP=01）
+func contentFeatures(mimeType string) string {
+	features := "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+	if mimeType == "audio/mpeg" {
+		return "DLNA.ORG_PN=MP3;" + features
+	}
+	return features
+}
+
+func protocolInfo(mimeType string) string {
+	return "http-get:*:" + mimeType + ":" + contentFeatures(mimeType)
+}
+
+func writeElement(buf *bytes.Buffer, name, value string) {
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(buf, []byte(value))
+	buf.WriteString("</" + name + ">")
+}
+
+func escapeAttr(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
@@ -0,0 +1,19 @@
+package dlna
+
+// 局域网设备无法登录，所有接口都不需要认证，只暴露公开音乐库
+func (ds *DLNAService) RegisterRoutes() {
+	dlnaGroup := ds.rg
+
+	dlnaGroup.GET("/device.xml", ds.GetDeviceDescription)                // 设备描述
+	dlnaGroup.GET("/ContentDirectory.xml", ds.GetContentDirectorySCPD)   // 服务描述
+	dlnaGroup.GET("/ConnectionManager.xml", ds.GetConnectionManagerSCPD) // 服务描述
+
+	dlnaGroup.POST("/control/ContentDirectory", ds.ContentDirectoryControl)   // Browse / Search
+	dlnaGroup.POST("/control/ConnectionManager", ds.ConnectionManagerControl) // 连接管理
+
+	dlnaGroup.Handle("SUBSCRIBE", "/event/:service", ds.Subscribe)
+	dlnaGroup.Handle("UNSUBSCRIBE", "/event/:service", ds.Unsubscribe)
+
+	dlnaGroup.GET("/media/:file", ds.ServeMedia) // 播放文件
+	dlnaGroup.HEAD("/media/:file", ds.ServeMedia)
+}
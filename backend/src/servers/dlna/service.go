@@ -0,0 +1,72 @@
+package dlna
+
+import (
+	"context"
+	logger "myapp/log"
+	"myapp/servers/music"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type DLNAConfig struct {
+	Enabled      bool
+	FriendlyName string
+	// 设备 UUID，为空时根据主机名生成，保证重启后电视上不会出现重复的设备
+	UUID string
+	// HTTP 服务端口，用于生成 SSDP 通告中的 LOCATION
+	HTTPPort string
+	// SSDP 存活通告间隔
+	NotifyInterval time.Duration
+}
+
+// DLNAService 局域网 UPnP 媒体服务器，只暴露公开音乐库
+type DLNAService struct {
+	ctx  context.Context
+	cfg  *DLNAConfig
+	db   *gorm.DB
+	ms   *music.MusicService
+	uuid string
+	rg   *gin.RouterGroup
+}
+
+func NewDLNAService(ctx context.Context, cfg *DLNAConfig, db *gorm.DB, r *gin.Engine, ms *music.MusicService) *DLNAService {
+	if cfg.HTTPPort == "" {
+		logger.ZError(&ctx, "DLNA 服务未配置 HTTP 端口", nil)
+		return nil
+	}
+	if cfg.FriendlyName == "" {
+		cfg.FriendlyName = "digital-hub"
+	}
+	if cfg.NotifyInterval <= 0 {
+		cfg.NotifyInterval = 15 * time.Minute
+	}
+
+	id := cfg.UUID
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = uuid.NewSHA1(uuid.NameSpaceDNS, []byte("digital-hub-dlna."+hostname)).String()
+	}
+
+	rg := r.Group("/dlna")
+
+	return &DLNAService{
+		ctx:  ctx,
+		cfg:  cfg,
+		db:   db,
+		ms:   ms,
+		uuid: id,
+		rg:   rg,
+	}
+}
+
+func (ds *DLNAService) Start() {
+	// 启动 SSDP 发现服务
+	go ds.serveSSDP()
+	go ds.notifyLoop()
+
+	ds.RegisterRoutes()
+}
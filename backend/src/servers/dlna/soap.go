@@ -0,0 +1,117 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SOAP 控制请求：SOAPACTION 请求头给出服务类型和动作名，请求体中动作元素的子元素即参数
+
+var errInvalidSOAP = errors.New("无效的 SOAP 请求")
+
+// UPnP 错误码
+const (
+	upnpInvalidAction = 401
+	upnpInvalidArgs   = 402
+	upnpActionFailed  = 501
+	upnpNoSuchObject  = 701
+)
+
+type soapArg struct {
+	Name  string
+	Value string
+}
+
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Description)
+}
+
+// parseSOAPRequest 返回动作名和参数
+func parseSOAPRequest(c *gin.Context) (string, map[string]string, error) {
+	header := strings.Trim(c.GetHeader("SOAPACTION"), `"`)
+	idx := strings.LastIndex(header, "#")
+	if idx < 0 {
+		return "", nil, errInvalidSOAP
+	}
+	action := header[idx+1:]
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Envelope > Body > Action > 参数
+	args := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	var current string
+	var value strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, errInvalidSOAP
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 4 {
+				current = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 4 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 4 {
+				args[current] = value.String()
+			}
+			depth--
+		}
+	}
+	return action, args, nil
+}
+
+func writeSOAPResponse(c *gin.Context, serviceType, action string, args []soapArg) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	buf.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&buf, `<u:%sResponse xmlns:u="%s">`, action, serviceType)
+	for _, arg := range args {
+		buf.WriteString("<" + arg.Name + ">")
+		xml.EscapeText(&buf, []byte(arg.Value))
+		buf.WriteString("</" + arg.Name + ">")
+	}
+	fmt.Fprintf(&buf, `</u:%sResponse>`, action)
+	buf.WriteString(`</s:Body></s:Envelope>`)
+
+	c.Header("EXT", "")
+	c.Data(http.StatusOK, `text/xml; charset="utf-8"`, buf.Bytes())
+}
+
+func writeSOAPFault(c *gin.Context, err *upnpError) {
+	var desc bytes.Buffer
+	xml.EscapeText(&desc, []byte(err.Description))
+
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`+
+		`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, err.Code, desc.String())
+	c.Data(http.StatusInternalServerError, `text/xml; charset="utf-8"`, []byte(body))
+}
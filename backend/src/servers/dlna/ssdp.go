@@ -0,0 +1,174 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	logger "myapp/log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SSDP 发现协议：监听 239.255.255.250:1900 上的 M-SEARCH 请求并单播应答，同时定期组播存活通告
+
+const (
+	ssdpAddr   = "239.255.255.250:1900"
+	ssdpMaxAge = 1800
+	serverName = "Linux/1.0 UPnP/1.0 digital-hub/1.0"
+)
+
+const (
+	deviceType            = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// 设备需要通告的全部类型，以及对应的 USN
+func (ds *DLNAService) notificationTypes() map[string]string {
+	udn := "uuid:" + ds.uuid
+	return map[string]string{
+		"upnp:rootdevice":     udn + "::upnp:rootdevice",
+		udn:                   udn,
+		deviceType:            udn + "::" + deviceType,
+		contentDirectoryType:  udn + "::" + contentDirectoryType,
+		connectionManagerType: udn + "::" + connectionManagerType,
+	}
+}
+
+func (ds *DLNAService) serveSSDP() {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		logger.ZError(&ds.ctx, "解析 SSDP 地址失败", err)
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		logger.ZError(&ds.ctx, "监听 SSDP 组播失败", err)
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	for {
+		select {
+		case <-ds.ctx.Done():
+			return
+		default:
+		}
+
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" {
+			continue
+		}
+		if req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		go ds.answerSearch(remote, req.Header.Get("ST"), req.Header.Get("MX"))
+	}
+}
+
+// answerSearch 按 MX 随机延迟后单播应答匹配的搜索目标
+func (ds *DLNAService) answerSearch(remote *net.UDPAddr, st, mx string) {
+	types := ds.notificationTypes()
+	var targets []string
+	if st == "ssdp:all" {
+		for nt := range types {
+			targets = append(targets, nt)
+		}
+	} else if _, ok := types[st]; ok {
+		targets = []string{st}
+	} else {
+		return
+	}
+
+	delay := 1
+	fmt.Sscanf(mx, "%d", &delay)
+	if delay > 5 {
+		delay = 5
+	}
+	if delay > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(delay) * int64(time.Second))))
+	}
+
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	location := ds.location(conn.LocalAddr())
+
+	for _, target := range targets {
+		msg := "HTTP/1.1 200 OK\r\n" +
+			fmt.Sprintf("CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge) +
+			"DATE: " + time.Now().UTC().Format(http.TimeFormat) + "\r\n" +
+			"EXT:\r\n" +
+			"LOCATION: " + location + "\r\n" +
+			"SERVER: " + serverName + "\r\n" +
+			"ST: " + target + "\r\n" +
+			"USN: " + types[target] + "\r\n" +
+			"\r\n"
+		conn.Write([]byte(msg))
+	}
+}
+
+func (ds *DLNAService) notifyLoop() {
+	ds.notify("ssdp:alive")
+
+	ticker := time.NewTicker(ds.cfg.NotifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ds.ctx.Done():
+			ds.notify("ssdp:byebye")
+			return
+		case <-ticker.C:
+			ds.notify("ssdp:alive")
+		}
+	}
+}
+
+func (ds *DLNAService) notify(nts string) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		logger.ZError(&ds.ctx, "发送 SSDP 通告失败", err)
+		return
+	}
+	defer conn.Close()
+	location := ds.location(conn.LocalAddr())
+
+	for nt, usn := range ds.notificationTypes() {
+		var msg strings.Builder
+		msg.WriteString("NOTIFY * HTTP/1.1\r\n")
+		msg.WriteString("HOST: " + ssdpAddr + "\r\n")
+		if nts == "ssdp:alive" {
+			fmt.Fprintf(&msg, "CACHE-CONTROL: max-age=%d\r\n", ssdpMaxAge)
+			msg.WriteString("LOCATION: " + location + "\r\n")
+			msg.WriteString("SERVER: " + serverName + "\r\n")
+		}
+		msg.WriteString("NT: " + nt + "\r\n")
+		msg.WriteString("NTS: " + nts + "\r\n")
+		msg.WriteString("USN: " + usn + "\r\n")
+		msg.WriteString("\r\n")
+		conn.Write([]byte(msg.String()))
+	}
+}
+
+// location 使用发送数据包的本机地址生成设备描述地址，保证对端能够访问
+func (ds *DLNAService) location(local net.Addr) string {
+	host := "127.0.0.1"
+	if udp, ok := local.(*net.UDPAddr); ok && udp.IP != nil && !udp.IP.IsUnspecified() {
+		host = udp.IP.String()
+	}
+	return fmt.Sprintf("http://%s/dlna/device.xml", net.JoinHostPort(host, ds.cfg.HTTPPort))
+}
@@ -0,0 +1,1079 @@
+package mpd
+
+import (
+	"errors"
+	"fmt"
+	"myapp/middleware"
+	"myapp/servers/music"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 收藏列表作为唯一的存储播放列表
+const favoritesPlaylist = "favorites"
+
+type command struct {
+	fn func(s *session, args []string) error
+	// 需要登录的命令，播放队列和收藏按用户区分
+	auth bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		// 连接
+		"ping":        {fn: cmdPing},
+		"password":    {fn: cmdPassword},
+		"commands":    {fn: cmdCommands},
+		"notcommands": {fn: cmdNotCommands},
+		"tagtypes":    {fn: cmdTagTypes},
+		"urlhandlers": {fn: cmdPing},
+		"decoders":    {fn: cmdPing},
+		"outputs":     {fn: cmdPing},
+
+		// 状态
+		"status":      {fn: cmdStatus},
+		"stats":       {fn: cmdStats},
+		"currentsong": {fn: cmdCurrentSong},
+
+		// 播放控制，只记录状态，由网页端实际播放
+		"play":     {fn: cmdPlay, auth: true},
+		"playid":   {fn: cmdPlayID, auth: true},
+		"pause":    {fn: cmdPause, auth: true},
+		"stop":     {fn: cmdStop, auth: true},
+		"next":     {fn: cmdNext, auth: true},
+		"previous": {fn: cmdPrevious, auth: true},
+		"seek":     {fn: cmdSeek, auth: true},
+		"seekid":   {fn: cmdSeekID, auth: true},
+		"seekcur":  {fn: cmdSeekCur, auth: true},
+
+		// 播放选项，淡入淡出由网页端播放器执行
+		"crossfade": {fn: cmdCrossfade, auth: true},
+
+		// 音乐库
+		"lsinfo":      {fn: cmdLsInfo},
+		"listall":     {fn: cmdListAll},
+		"listallinfo": {fn: cmdListAllInfo},
+		"find":        {fn: cmdFind},
+		"search":      {fn: cmdSearch},
+		"findadd":     {fn: cmdFindAdd, auth: true},
+		"searchadd":   {fn: cmdSearchAdd, auth: true},
+		"list":        {fn: cmdList},
+		"count":       {fn: cmdCount},
+		"update":      {fn: cmdUpdate, auth: true},
+		"rescan":      {fn: cmdUpdate, auth: true},
+
+		// 播放队列
+		"add":            {fn: cmdAdd, auth: true},
+		"addid":          {fn: cmdAddID, auth: true},
+		"delete":         {fn: cmdDelete, auth: true},
+		"deleteid":       {fn: cmdDeleteID, auth: true},
+		"clear":          {fn: cmdClear, auth: true},
+		"move":           {fn: cmdMove, auth: true},
+		"moveid":         {fn: cmdMoveID, auth: true},
+		"playlist":       {fn: cmdPlaylist, auth: true},
+		"playlistinfo":   {fn: cmdPlaylistInfo, auth: true},
+		"playlistid":     {fn: cmdPlaylistID, auth: true},
+		"plchanges":      {fn: cmdPlChanges, auth: true},
+		"plchangesposid": {fn: cmdPlChangesPosID, auth: true},
+
+		// 存储播放列表（收藏）
+		"listplaylists":    {fn: cmdListPlaylists, auth: true},
+		"listplaylist":     {fn: cmdListPlaylist, auth: true},
+		"listplaylistinfo": {fn: cmdListPlaylistInfo, auth: true},
+		"load":             {fn: cmdLoad, auth: true},
+		"playlistadd":      {fn: cmdPlaylistAdd, auth: true},
+		"playlistdelete":   {fn: cmdPlaylistDelete, auth: true},
+		"playlistclear":    {fn: cmdPlaylistClear, auth: true},
+		"save":             {fn: cmdSave, auth: true},
+	}
+}
+
+func requireArgs(args []string, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return newAck(ackErrorArg, "参数数量不正确")
+	}
+	return nil
+}
+
+func cmdPing(s *session, args []string) error {
+	return nil
+}
+
+// password 参数格式为 "用户名:密码"，密码也可以是本人的 API 密钥，开启两步验证的账号只能使用 API 密钥
+func cmdPassword(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	username, password, ok := strings.Cut(args[0], ":")
+	if !ok {
+		return newAck(ackErrorPassword, "密码格式为 用户名:密码")
+	}
+
+	if s.srv.authenticate == nil {
+		return newAck(ackErrorPassword, "未启用登录")
+	}
+	userID, scopes, err := s.srv.authenticate(username, password, s.remoteIP())
+	middleware.RecordAudit(nil, &middleware.AuditRecord{
+		ActorID:    userID,
+		Action:     middleware.AuditMPDLogin,
+		TargetType: middleware.AuditTargetUser,
+		TargetID:   userID,
+		TargetName: username,
+		IP:         s.remoteIP(),
+	}, err)
+	if err != nil {
+		return newAck(ackErrorPassword, "%s", err.Error())
+	}
+
+	s.userID = userID
+	s.scopes = scopes
+	s.libraries = s.srv.ms.LibrariesFor(userID)
+	return nil
+}
+
+func cmdCommands(s *session, args []string) error {
+	names := []string{"close", "idle", "noidle", "command_list_begin", "command_list_ok_begin", "command_list_end"}
+	for name, cmd := range commands {
+		if !cmd.auth || s.userID != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.w, "command: %s\n", name)
+	}
+	return nil
+}
+
+func cmdNotCommands(s *session, args []string) error {
+	var names []string
+	for name, cmd := range commands {
+		if cmd.auth && s.userID == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.w, "command: %s\n", name)
+	}
+	return nil
+}
+
+// tagtypes 的 enable/disable/clear/all 子命令直接忽略，始终返回全部标签
+func cmdTagTypes(s *session, args []string) error {
+	if len(args) > 0 {
+		return nil
+	}
+	for _, tag := range supportedTags {
+		fmt.Fprintf(s.w, "tagtype: %s\n", tag)
+	}
+	return nil
+}
+
+// 当前队列和播放状态，未登录时返回空队列
+func (s *session) queue() (*music.QueueState, []music.QueueEntry, error) {
+	if s.userID == "" {
+		return &music.QueueState{State: music.PlaybackStop}, nil, nil
+	}
+	return s.srv.ms.Queue(s.userID)
+}
+
+// 正在播放的条目在队列中的下标，不存在时返回 -1
+func currentIndex(state *music.QueueState, entries []music.QueueEntry) int {
+	for i := range entries {
+		if entries[i].ID == state.CurrentID {
+			return i
+		}
+	}
+	return -1
+}
+
+func cmdStatus(s *session, args []string) error {
+	state, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.w, "volume: -1\nrepeat: 0\nrandom: 0\nsingle: 0\nconsume: 0\n")
+	fmt.Fprintf(s.w, "playlist: %d\nplaylistlength: %d\n", state.Version, len(entries))
+
+	current := currentIndex(state, entries)
+	playState := state.State
+	if current < 0 {
+		playState = music.PlaybackStop
+	}
+	fmt.Fprintf(s.w, "state: %s\n", playState)
+	if state.Crossfade > 0 {
+		fmt.Fprintf(s.w, "xfade: %.0f\n", state.Crossfade)
+	}
+	if current >= 0 {
+		fmt.Fprintf(s.w, "song: %d\nsongid: %d\n", current, entries[current].ID)
+		fmt.Fprintf(s.w, "elapsed: %.3f\n", state.Elapsed)
+		if m := entries[current].Music; m.SampleRate > 0 && m.SampleCount > 0 {
+			duration := float64(m.SampleCount) / float64(m.SampleRate)
+			fmt.Fprintf(s.w, "time: %.0f:%.0f\nduration: %.3f\n", state.Elapsed, duration, duration)
+		}
+		if current+1 < len(entries) {
+			fmt.Fprintf(s.w, "nextsong: %d\nnextsongid: %d\n", current+1, entries[current+1].ID)
+		}
+	}
+	return nil
+}
+
+func cmdStats(s *session, args []string) error {
+	var stat struct {
+		Artists int64
+		Albums  int64
+		Songs   int64
+	}
+	err := s.musics().
+		Select("COUNT(DISTINCT artist) AS artists, COUNT(DISTINCT album) AS albums, COUNT(*) AS songs").
+		Scan(&stat).Error
+	if err != nil {
+		return err
+	}
+	var latest music.Music
+	s.musics().Order("created_at DESC").Limit(1).Find(&latest)
+
+	fmt.Fprintf(s.w, "artists: %d\nalbums: %d\nsongs: %d\n", stat.Artists, stat.Albums, stat.Songs)
+	fmt.Fprintf(s.w, "uptime: 0\nplaytime: 0\ndb_playtime: 0\n")
+	if !latest.CreatedAt.IsZero() {
+		fmt.Fprintf(s.w, "db_update: %d\n", latest.CreatedAt.Unix())
+	}
+	return nil
+}
+
+func cmdCurrentSong(s *session, args []string) error {
+	state, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if current := currentIndex(state, entries); current >= 0 {
+		writeQueueEntry(s.w, &entries[current])
+	}
+	return nil
+}
+
+func (s *session) setPlayback(state string, currentID *uint, elapsed *float64) error {
+	_, err := s.srv.ms.SetPlayback(s.userID, &music.PlaybackUpdate{
+		State:     &state,
+		CurrentID: currentID,
+		Elapsed:   elapsed,
+	})
+	return err
+}
+
+// playEntry 从头播放队列中的第 index 首
+func (s *session) playEntry(entries []music.QueueEntry, index int) error {
+	if index < 0 || index >= len(entries) {
+		return newAck(ackErrorArg, "位置超出队列范围")
+	}
+	zero := 0.0
+	return s.setPlayback(music.PlaybackPlay, &entries[index].ID, &zero)
+}
+
+func cmdPlay(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	state, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		pos, err := parseInt(args[0])
+		if err != nil {
+			return err
+		}
+		if pos >= 0 {
+			return s.playEntry(entries, pos)
+		}
+	}
+
+	// 未指定位置时继续播放当前歌曲
+	if current := currentIndex(state, entries); current >= 0 {
+		return s.setPlayback(music.PlaybackPlay, nil, nil)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.playEntry(entries, 0)
+}
+
+func cmdPlayID(s *session, args []string) error {
+	if len(args) == 0 {
+		return cmdPlay(s, nil)
+	}
+	id, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	return s.playEntry(entries, indexOfID(entries, id))
+}
+
+func indexOfID(entries []music.QueueEntry, id uint) int {
+	for i := range entries {
+		if entries[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func cmdPause(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	state, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if currentIndex(state, entries) < 0 {
+		return nil
+	}
+
+	pause := state.State == music.PlaybackPlay
+	if len(args) == 1 {
+		pause = args[0] == "1"
+	}
+	if pause {
+		return s.setPlayback(music.PlaybackPause, nil, nil)
+	}
+	return s.setPlayback(music.PlaybackPlay, nil, nil)
+}
+
+func cmdStop(s *session, args []string) error {
+	return s.setPlayback(music.PlaybackStop, nil, nil)
+}
+
+func cmdNext(s *session, args []string) error {
+	return s.skip(1)
+}
+
+func cmdPrevious(s *session, args []string) error {
+	return s.skip(-1)
+}
+
+// skip 切换到相邻的歌曲，越过队列两端时停止播放
+func (s *session) skip(delta int) error {
+	state, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	current := currentIndex(state, entries)
+	if current < 0 {
+		return nil
+	}
+	next := current + delta
+	if next < 0 || next >= len(entries) {
+		none := uint(0)
+		return s.setPlayback(music.PlaybackStop, &none, nil)
+	}
+	zero := 0.0
+	return s.setPlayback(state.State, &entries[next].ID, &zero)
+}
+
+func cmdSeek(s *session, args []string) error {
+	if err := requireArgs(args, 2, 2); err != nil {
+		return err
+	}
+	pos, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if pos < 0 || pos >= len(entries) {
+		return newAck(ackErrorArg, "位置超出队列范围")
+	}
+	return s.seek(entries[pos].ID, args[1])
+}
+
+func cmdSeekID(s *session, args []string) error {
+	if err := requireArgs(args, 2, 2); err != nil {
+		return err
+	}
+	id, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	return s.seek(id, args[1])
+}
+
+func cmdSeekCur(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	state, _, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if state.CurrentID == 0 {
+		return newAck(ackErrorArg, "当前没有播放")
+	}
+
+	// +N / -N 为相对当前进度
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		delta, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return newAck(ackErrorArg, "无效的时间: %s", args[0])
+		}
+		elapsed := max(state.Elapsed+delta, 0)
+		return s.setPlayback(state.State, nil, &elapsed)
+	}
+	return s.seek(state.CurrentID, args[0])
+}
+
+func cmdCrossfade(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	seconds, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	crossfade := float64(seconds)
+	_, err = s.srv.ms.SetPlayback(s.userID, &music.PlaybackUpdate{Crossfade: &crossfade})
+	if err != nil {
+		return newAck(ackErrorArg, "%s", err.Error())
+	}
+	return nil
+}
+
+func (s *session) seek(id uint, value string) error {
+	elapsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || elapsed < 0 {
+		return newAck(ackErrorArg, "无效的时间: %s", value)
+	}
+	state, _, err := s.queue()
+	if err != nil {
+		return err
+	}
+	playState := state.State
+	if playState == music.PlaybackStop {
+		playState = music.PlaybackPause
+	}
+	err = s.setPlayback(playState, &id, &elapsed)
+	if errors.Is(err, music.ErrQueueItemNotFound) {
+		return newAck(ackErrorNoExist, "队列中不存在该歌曲")
+	}
+	return err
+}
+
+func cmdLsInfo(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	uri := ""
+	if len(args) == 1 {
+		uri = args[0]
+	}
+
+	dirs, files, err := s.listDir(uri)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		fmt.Fprintf(s.w, "directory: %s\n", dir)
+	}
+	for i := range files {
+		writeSong(s.w, &files[i])
+	}
+	if strings.Trim(uri, "/") == "" && s.userID != "" {
+		fmt.Fprintf(s.w, "playlist: %s\n", favoritesPlaylist)
+	}
+	return nil
+}
+
+func cmdListAll(s *session, args []string) error {
+	return s.listAll(args, false)
+}
+
+func cmdListAllInfo(s *session, args []string) error {
+	return s.listAll(args, true)
+}
+
+func (s *session) listAll(args []string, info bool) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	uri := ""
+	if len(args) == 1 {
+		uri = args[0]
+	}
+	songs, err := s.songsUnder(uri)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for i := range songs {
+		file := songURI(&songs[i])
+		for _, dir := range parentDirs(file) {
+			if !written[dir] {
+				written[dir] = true
+				fmt.Fprintf(s.w, "directory: %s\n", dir)
+			}
+		}
+		if info {
+			writeSong(s.w, &songs[i])
+		} else {
+			fmt.Fprintf(s.w, "file: %s\n", file)
+		}
+	}
+	return nil
+}
+
+// findSongs 按过滤条件查询歌曲，exact 区分 find 和 search
+func (s *session) findSongs(args []string, exact bool) ([]music.Music, error) {
+	args, start, end, err := splitFilterArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, newAck(ackErrorArg, "缺少过滤条件")
+	}
+	conds, err := parseFilter(args, exact)
+	if err != nil {
+		return nil, err
+	}
+
+	query := applyFilter(s.musics(), conds).Order("library ASC, file_path ASC").Offset(start)
+	if end >= 0 {
+		query = query.Limit(end - start)
+	}
+	var list []music.Music
+	err = query.Find(&list).Error
+	return list, err
+}
+
+func cmdFind(s *session, args []string) error {
+	return s.writeSongs(s.findSongs(args, true))
+}
+
+func cmdSearch(s *session, args []string) error {
+	return s.writeSongs(s.findSongs(args, false))
+}
+
+func (s *session) writeSongs(list []music.Music, err error) error {
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		writeSong(s.w, &list[i])
+	}
+	return nil
+}
+
+func cmdFindAdd(s *session, args []string) error {
+	return s.addSongs(s.findSongs(args, true))
+}
+
+func cmdSearchAdd(s *session, args []string) error {
+	return s.addSongs(s.findSongs(args, false))
+}
+
+func (s *session) addSongs(list []music.Music, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = s.enqueue(list, -1)
+	return err
+}
+
+func (s *session) enqueue(list []music.Music, pos int) ([]uint, error) {
+	ids := make([]uint, 0, len(list))
+	for _, m := range list {
+		ids = append(ids, m.ID)
+	}
+	return s.srv.ms.QueueAdd(s.userID, ids, pos)
+}
+
+// list TAG [FILTER] [group TAG]，旧式的 list album ARTIST 按艺术家过滤
+func cmdList(s *session, args []string) error {
+	if len(args) == 0 {
+		return newAck(ackErrorArg, "缺少标签")
+	}
+	tag := strings.ToLower(args[0])
+	column, ok := tagColumns[tag]
+	if !ok {
+		return newAck(ackErrorArg, "不支持的标签: %s", args[0])
+	}
+	args = args[1:]
+
+	// 分组参数不影响结果，直接忽略
+	for i := 0; i+1 < len(args); {
+		if strings.EqualFold(args[i], "group") {
+			args = append(args[:i], args[i+2:]...)
+			continue
+		}
+		i++
+	}
+	if len(args) == 1 && tag == "album" && !strings.HasPrefix(args[0], "(") {
+		args = []string{"artist", args[0]}
+	}
+
+	db := s.musics()
+	if len(args) > 0 {
+		conds, err := parseFilter(args, true)
+		if err != nil {
+			return err
+		}
+		db = applyFilter(db, conds)
+	}
+
+	var values []string
+	if err := db.Distinct(column).Order(column+" ASC").Pluck(column, &values).Error; err != nil {
+		return err
+	}
+	name := displayTag(tag)
+	for _, value := range values {
+		if value != "" {
+			fmt.Fprintf(s.w, "%s: %s\n", name, value)
+		}
+	}
+	return nil
+}
+
+func cmdCount(s *session, args []string) error {
+	list, err := s.findSongs(args, true)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "songs: %d\nplaytime: 0\n", len(list))
+	return nil
+}
+
+func cmdUpdate(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	// 与网页端的重新扫描接口使用相同的权限
+	if !s.can(middleware.PermLibraryRescan) {
+		return newAck(ackErrorPermission, "没有重新扫描音乐库的权限")
+	}
+	libraries := s.libraries
+	if len(args) == 1 && strings.Trim(args[0], "/") != "" {
+		library, _ := splitURI(args[0])
+		if !s.canAccessLibrary(library) {
+			return newAck(ackErrorNoExist, "目录不存在: %s", args[0])
+		}
+		libraries = []string{library}
+	}
+	if !s.srv.ms.RescanLibraries(libraries) {
+		return newAck(ackErrorUpdate, "扫描正在进行中")
+	}
+	fmt.Fprintf(s.w, "updating_db: %d\n", time.Now().Unix())
+	return nil
+}
+
+// add URI [POS]，URI 为目录时递归添加
+func cmdAdd(s *session, args []string) error {
+	if err := requireArgs(args, 1, 2); err != nil {
+		return err
+	}
+	pos := -1
+	if len(args) == 2 {
+		var err error
+		if pos, err = parseInt(args[1]); err != nil {
+			return err
+		}
+	}
+	songs, err := s.songsUnder(args[0])
+	if err != nil {
+		return err
+	}
+	_, err = s.enqueue(songs, pos)
+	return err
+}
+
+func cmdAddID(s *session, args []string) error {
+	if err := requireArgs(args, 1, 2); err != nil {
+		return err
+	}
+	pos := -1
+	if len(args) == 2 {
+		var err error
+		if pos, err = parseInt(args[1]); err != nil {
+			return err
+		}
+	}
+	m, err := s.findByURI(args[0])
+	if err != nil {
+		return err
+	}
+	ids, err := s.enqueue([]music.Music{*m}, pos)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "Id: %d\n", ids[0])
+	return nil
+}
+
+// entriesInRange 返回 POS 或 START:END 范围内的队列条目
+func entriesInRange(entries []music.QueueEntry, arg string) ([]music.QueueEntry, error) {
+	start, end, err := parseRange(arg)
+	if err != nil {
+		return nil, err
+	}
+	if end < 0 || end > len(entries) {
+		end = len(entries)
+	}
+	if start >= len(entries) || start > end {
+		return nil, newAck(ackErrorArg, "位置超出队列范围")
+	}
+	return entries[start:end], nil
+}
+
+func cmdDelete(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	selected, err := entriesInRange(entries, args[0])
+	if err != nil {
+		return err
+	}
+	ids := make([]uint, 0, len(selected))
+	for _, entry := range selected {
+		ids = append(ids, entry.ID)
+	}
+	return s.srv.ms.QueueRemove(s.userID, ids)
+}
+
+func cmdDeleteID(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	id, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	if err := s.srv.ms.QueueRemove(s.userID, []uint{id}); err != nil {
+		return newAck(ackErrorNoExist, "%s", err.Error())
+	}
+	return nil
+}
+
+func cmdClear(s *session, args []string) error {
+	return s.srv.ms.QueueClear(s.userID)
+}
+
+// move FROM TO，只支持移动单个条目
+func cmdMove(s *session, args []string) error {
+	if err := requireArgs(args, 2, 2); err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	selected, err := entriesInRange(entries, args[0])
+	if err != nil {
+		return err
+	}
+	if len(selected) != 1 {
+		return newAck(ackErrorArg, "只支持移动单个条目")
+	}
+	to, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	return s.srv.ms.QueueMove(s.userID, selected[0].ID, to)
+}
+
+func cmdMoveID(s *session, args []string) error {
+	if err := requireArgs(args, 2, 2); err != nil {
+		return err
+	}
+	id, err := parseUint(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	if err := s.srv.ms.QueueMove(s.userID, id, to); err != nil {
+		return newAck(ackErrorNoExist, "%s", err.Error())
+	}
+	return nil
+}
+
+func cmdPlaylist(s *session, args []string) error {
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		fmt.Fprintf(s.w, "%d:file: %s\n", i, songURI(&entries[i].Music))
+	}
+	return nil
+}
+
+func cmdPlaylistInfo(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		if entries, err = entriesInRange(entries, args[0]); err != nil {
+			return err
+		}
+	}
+	for i := range entries {
+		writeQueueEntry(s.w, &entries[i])
+	}
+	return nil
+}
+
+func cmdPlaylistID(s *session, args []string) error {
+	if err := requireArgs(args, 0, 1); err != nil {
+		return err
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		id, err := parseUint(args[0])
+		if err != nil {
+			return err
+		}
+		i := indexOfID(entries, id)
+		if i < 0 {
+			return newAck(ackErrorNoExist, "队列中不存在该歌曲")
+		}
+		entries = entries[i : i+1]
+	}
+	for i := range entries {
+		writeQueueEntry(s.w, &entries[i])
+	}
+	return nil
+}
+
+// plchanges 不记录每个版本的变化，版本不同时返回整个队列
+func (s *session) changedEntries(args []string) ([]music.QueueEntry, error) {
+	if err := requireArgs(args, 1, 2); err != nil {
+		return nil, err
+	}
+	version, err := parseUint(args[0])
+	if err != nil {
+		return nil, err
+	}
+	state, entries, err := s.queue()
+	if err != nil {
+		return nil, err
+	}
+	if version == state.Version {
+		return nil, nil
+	}
+	if len(args) == 2 {
+		return entriesInRange(entries, args[1])
+	}
+	return entries, nil
+}
+
+func cmdPlChanges(s *session, args []string) error {
+	entries, err := s.changedEntries(args)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		writeQueueEntry(s.w, &entries[i])
+	}
+	return nil
+}
+
+func cmdPlChangesPosID(s *session, args []string) error {
+	entries, err := s.changedEntries(args)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(s.w, "cpos: %d\nId: %d\n", entry.Position, entry.ID)
+	}
+	return nil
+}
+
+func checkPlaylistName(name string) error {
+	if name != favoritesPlaylist {
+		return newAck(ackErrorNoExist, "播放列表不存在，只支持收藏列表 %s", favoritesPlaylist)
+	}
+	return nil
+}
+
+func (s *session) favorites(args []string) ([]music.Music, error) {
+	if len(args) == 0 {
+		return nil, newAck(ackErrorArg, "缺少播放列表名称")
+	}
+	if err := checkPlaylistName(args[0]); err != nil {
+		return nil, err
+	}
+	return s.srv.ms.FavoriteMusic(s.userID)
+}
+
+func cmdListPlaylists(s *session, args []string) error {
+	fmt.Fprintf(s.w, "playlist: %s\nLast-Modified: %s\n", favoritesPlaylist, time.Now().UTC().Format(time.RFC3339))
+	return nil
+}
+
+func cmdListPlaylist(s *session, args []string) error {
+	list, err := s.favorites(args)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		fmt.Fprintf(s.w, "file: %s\n", songURI(&list[i]))
+	}
+	return nil
+}
+
+func cmdListPlaylistInfo(s *session, args []string) error {
+	return s.writeSongs(s.favorites(args))
+}
+
+// load NAME [START:END] 将收藏加入播放队列
+func cmdLoad(s *session, args []string) error {
+	if err := requireArgs(args, 1, 3); err != nil {
+		return err
+	}
+	list, err := s.favorites(args)
+	if err != nil {
+		return err
+	}
+	if len(args) >= 2 {
+		start, end, err := parseRange(args[1])
+		if err != nil {
+			return err
+		}
+		if end < 0 || end > len(list) {
+			end = len(list)
+		}
+		if start > end {
+			return newAck(ackErrorArg, "位置超出播放列表范围")
+		}
+		list = list[start:end]
+	}
+	pos := -1
+	if len(args) == 3 {
+		if pos, err = parseInt(args[2]); err != nil {
+			return err
+		}
+	}
+	_, err = s.enqueue(list, pos)
+	return err
+}
+
+// playlistadd NAME URI，已收藏的歌曲跳过
+func cmdPlaylistAdd(s *session, args []string) error {
+	if err := requireArgs(args, 2, 3); err != nil {
+		return err
+	}
+	if err := checkPlaylistName(args[0]); err != nil {
+		return err
+	}
+	songs, err := s.songsUnder(args[1])
+	if err != nil {
+		return err
+	}
+	return s.addFavorites(songs)
+}
+
+func (s *session) addFavorites(songs []music.Music) error {
+	existing, err := s.srv.ms.FavoriteMusic(s.userID)
+	if err != nil {
+		return err
+	}
+	favorited := make(map[uint]bool, len(existing))
+	for _, m := range existing {
+		favorited[m.ID] = true
+	}
+	for _, m := range songs {
+		if favorited[m.ID] {
+			continue
+		}
+		if err := s.srv.ms.AddFavorite(s.userID, m.ID); err != nil {
+			return err
+		}
+		favorited[m.ID] = true
+	}
+	return nil
+}
+
+func cmdPlaylistDelete(s *session, args []string) error {
+	if err := requireArgs(args, 2, 2); err != nil {
+		return err
+	}
+	list, err := s.favorites(args)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseRange(args[1])
+	if err != nil {
+		return err
+	}
+	if end < 0 || end > len(list) {
+		end = len(list)
+	}
+	if start >= len(list) || start > end {
+		return newAck(ackErrorArg, "位置超出播放列表范围")
+	}
+	for _, m := range list[start:end] {
+		if err := s.srv.ms.RemoveFavorite(s.userID, m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdPlaylistClear(s *session, args []string) error {
+	if err := requireArgs(args, 1, 1); err != nil {
+		return err
+	}
+	list, err := s.favorites(args)
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		if err := s.srv.ms.RemoveFavorite(s.userID, m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save NAME [MODE]，将播放队列中的歌曲加入收藏
+func cmdSave(s *session, args []string) error {
+	if err := requireArgs(args, 1, 2); err != nil {
+		return err
+	}
+	if args[0] != favoritesPlaylist {
+		return newAck(ackErrorExist, "无法创建播放列表，只支持保存到收藏列表 %s", favoritesPlaylist)
+	}
+	_, entries, err := s.queue()
+	if err != nil {
+		return err
+	}
+	songs := make([]music.Music, 0, len(entries))
+	for _, entry := range entries {
+		songs = append(songs, entry.Music)
+	}
+	return s.addFavorites(songs)
+}
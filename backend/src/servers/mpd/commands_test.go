@@ -0,0 +1,39 @@
+package mpd
+
+import (
+	"errors"
+	"myapp/middleware"
+	"testing"
+)
+
+// update 与网页端的重新扫描接口使用相同的权限，API 密钥还需包含该范围
+func TestUpdateRequiresRescanPermission(t *testing.T) {
+	middleware.SetRolePermissions("test-librarian", []string{middleware.PermLibraryRescan})
+	defer middleware.RemoveRole("test-librarian")
+	roles := map[string][]string{"librarian": {"test-librarian"}, "member": {"user"}}
+	lookup := middleware.LookupRoles
+	middleware.LookupRoles = func(userID string) []string { return roles[userID] }
+	defer func() { middleware.LookupRoles = lookup }()
+
+	cases := []struct {
+		name    string
+		session *session
+	}{
+		{"未登录", &session{}},
+		{"普通用户", &session{userID: "member"}},
+		{"只读 API 密钥", &session{userID: "librarian", scopes: []string{"music:read"}}},
+	}
+	for _, tc := range cases {
+		var ack *ackError
+		if err := cmdUpdate(tc.session, nil); !errors.As(err, &ack) || ack.Code != ackErrorPermission {
+			t.Errorf("%s: 应该返回权限错误，err = %v", tc.name, err)
+		}
+	}
+
+	if !(&session{userID: "librarian"}).can(middleware.PermLibraryRescan) {
+		t.Error("具有权限的用户应该可以重新扫描")
+	}
+	if !(&session{userID: "librarian", scopes: []string{middleware.PermLibraryRescan}}).can(middleware.PermLibraryRescan) {
+		t.Error("包含该范围的 API 密钥应该可以重新扫描")
+	}
+}
@@ -0,0 +1,171 @@
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"myapp/servers/music"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 音乐库在 MPD 中的目录结构：第一级目录为音乐库名称，下面是音乐库中的相对路径，
+// 歌曲的 URI 为 "<音乐库>/<相对路径>"
+
+func songURI(m *music.Music) string {
+	return m.Library + m.FilePath
+}
+
+// splitURI 拆分为音乐库名称和以 / 开头的相对路径
+func splitURI(uri string) (string, string) {
+	uri = strings.Trim(uri, "/")
+	library, rest, _ := strings.Cut(uri, "/")
+	if rest == "" {
+		return library, ""
+	}
+	return library, "/" + rest
+}
+
+// musics 限定在当前用户可访问的音乐库内
+func (s *session) musics() *gorm.DB {
+	return s.srv.db.Model(&music.Music{}).Where("library IN ?", s.libraries)
+}
+
+func (s *session) canAccessLibrary(library string) bool {
+	for _, name := range s.libraries {
+		if name == library {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) findByURI(uri string) (*music.Music, error) {
+	library, filePath := splitURI(uri)
+	var m music.Music
+	err := s.musics().Where("library = ? AND path_hash = ?", library, music.HashFilePath(filePath)).First(&m).Error
+	if err != nil {
+		return nil, newAck(ackErrorNoExist, "歌曲不存在: %s", uri)
+	}
+	return &m, nil
+}
+
+// songsUnder 返回 URI 对应的歌曲，URI 为目录时递归返回目录下的全部歌曲
+func (s *session) songsUnder(uri string) ([]music.Music, error) {
+	var list []music.Music
+	if strings.Trim(uri, "/") == "" {
+		err := s.musics().Order("library ASC, file_path ASC").Find(&list).Error
+		return list, err
+	}
+
+	library, filePath := splitURI(uri)
+	if !s.canAccessLibrary(library) {
+		return nil, newAck(ackErrorNoExist, "目录不存在: %s", uri)
+	}
+	db := s.musics().Where("library = ?", library)
+	if filePath != "" {
+		db = db.Where("file_path = ? OR file_path LIKE ?", filePath, escapeLike(filePath)+"/%")
+	}
+	if err := db.Order("file_path ASC").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 && filePath != "" {
+		return nil, newAck(ackErrorNoExist, "目录不存在: %s", uri)
+	}
+	return list, nil
+}
+
+// listDir 列出目录下的直接子目录和歌曲
+func (s *session) listDir(uri string) ([]string, []music.Music, error) {
+	if strings.Trim(uri, "/") == "" {
+		return append([]string{}, s.libraries...), nil, nil
+	}
+
+	all, err := s.songsUnder(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	library, filePath := splitURI(uri)
+	prefix := filePath + "/"
+
+	seen := make(map[string]bool)
+	var dirs []string
+	var files []music.Music
+	for _, m := range all {
+		if m.FilePath == filePath {
+			// URI 本身是歌曲
+			files = append(files, m)
+			continue
+		}
+		rest := strings.TrimPrefix(m.FilePath, prefix)
+		if dir, _, nested := strings.Cut(rest, "/"); nested {
+			name := library + prefix + dir
+			if !seen[name] {
+				seen[name] = true
+				dirs = append(dirs, name)
+			}
+			continue
+		}
+		files = append(files, m)
+	}
+	sort.Strings(dirs)
+	return dirs, files, nil
+}
+
+// parentDirs 返回歌曲所在的各级目录，listall 需要在歌曲前输出目录
+func parentDirs(uri string) []string {
+	var dirs []string
+	for dir := path.Dir(uri); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
+
+func writeSong(w *bufio.Writer, m *music.Music) {
+	fmt.Fprintf(w, "file: %s\n", songURI(m))
+	fmt.Fprintf(w, "Last-Modified: %s\n", m.CreatedAt.UTC().Format(time.RFC3339))
+	if m.Title != "" {
+		fmt.Fprintf(w, "Title: %s\n", m.Title)
+	}
+	if m.Artist != "" {
+		fmt.Fprintf(w, "Artist: %s\n", m.Artist)
+	}
+	if m.Album != "" {
+		fmt.Fprintf(w, "Album: %s\n", m.Album)
+	}
+	if m.SampleRate > 0 && m.SampleCount > 0 {
+		duration := float64(m.SampleCount) / float64(m.SampleRate)
+		fmt.Fprintf(w, "Time: %.0f\nduration: %.3f\n", duration, duration)
+	}
+}
+
+func writeQueueEntry(w *bufio.Writer, entry *music.QueueEntry) {
+	writeSong(w, &entry.Music)
+	fmt.Fprintf(w, "Pos: %d\nId: %d\n", entry.Position, entry.ID)
+}
+
+// tagValue 返回歌曲的标签值，用于 list 命令
+func tagValue(m *music.Music, tag string) string {
+	switch tagColumns[tag] {
+	case "artist":
+		return m.Artist
+	case "album":
+		return m.Album
+	case "title":
+		return m.Title
+	}
+	return ""
+}
+
+// displayTag 返回 MPD 协议中的标签名称
+func displayTag(tag string) string {
+	for _, name := range supportedTags {
+		if strings.EqualFold(name, tag) {
+			return name
+		}
+	}
+	return tag
+}
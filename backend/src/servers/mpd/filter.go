@@ -0,0 +1,305 @@
+package mpd
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 过滤条件，支持旧式的 TAG VALUE 参数对和新式的表达式
+// 例如：find artist "周杰伦" album "叶惠美"，或 find "((artist == '周杰伦') AND (album contains '叶'))"
+
+type condition struct {
+	tag    string
+	op     string // ==, !=, contains, starts_with, base
+	value  string
+	negate bool
+}
+
+// 标签与数据库字段的对应关系
+var tagColumns = map[string]string{
+	"artist":      "artist",
+	"albumartist": "artist",
+	"album":       "album",
+	"title":       "title",
+	"name":        "title",
+}
+
+// 客户端可查询的标签
+var supportedTags = []string{"Artist", "AlbumArtist", "Album", "Title"}
+
+// splitFilterArgs 去掉末尾的 sort 和 window 参数，返回 window 范围
+func splitFilterArgs(args []string) ([]string, int, int, error) {
+	start, end := 0, -1
+	for len(args) >= 2 {
+		key := strings.ToLower(args[len(args)-2])
+		if key == "sort" {
+			args = args[:len(args)-2]
+			continue
+		}
+		if key == "window" {
+			var err error
+			start, end, err = parseRange(args[len(args)-1])
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			args = args[:len(args)-2]
+			continue
+		}
+		break
+	}
+	return args, start, end, nil
+}
+
+// parseFilter 解析过滤条件，exact 为 false 时旧式参数按包含匹配（search 命令）
+func parseFilter(args []string, exact bool) ([]condition, error) {
+	if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "(") {
+		p := &exprParser{input: strings.TrimSpace(args[0])}
+		conds, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		return conds, nil
+	}
+
+	if len(args)%2 != 0 {
+		return nil, newAck(ackErrorArg, "参数数量不正确")
+	}
+	var conds []condition
+	for i := 0; i < len(args); i += 2 {
+		tag := strings.ToLower(args[i])
+		op := "contains"
+		if exact {
+			op = "=="
+		}
+		if tag == "base" {
+			op = "base"
+		}
+		if err := checkTag(tag); err != nil {
+			return nil, err
+		}
+		conds = append(conds, condition{tag: tag, op: op, value: args[i+1]})
+	}
+	return conds, nil
+}
+
+func checkTag(tag string) error {
+	switch tag {
+	case "any", "file", "base":
+		return nil
+	}
+	if _, ok := tagColumns[tag]; !ok {
+		return newAck(ackErrorArg, "不支持的标签: %s", tag)
+	}
+	return nil
+}
+
+// exprParser 解析过滤表达式，只支持 AND 组合和 ! 取反
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) parse() ([]condition, error) {
+	conds, err := p.parseExpr(false)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.input) {
+		return nil, newAck(ackErrorArg, "过滤表达式末尾有多余内容")
+	}
+	return conds, nil
+}
+
+func (p *exprParser) parseExpr(negate bool) ([]condition, error) {
+	p.skipSpace()
+	if !p.consume("(") {
+		return nil, newAck(ackErrorArg, "过滤表达式需要以 ( 开头")
+	}
+	p.skipSpace()
+
+	// 取反
+	if p.consume("!") {
+		conds, err := p.parseExpr(!negate)
+		if err != nil {
+			return nil, err
+		}
+		if len(conds) > 1 && !negate {
+			return nil, newAck(ackErrorArg, "不支持对组合条件取反")
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, newAck(ackErrorArg, "过滤表达式缺少 )")
+		}
+		return conds, nil
+	}
+
+	// 组合条件：(EXPR AND EXPR ...)
+	if p.peek() == '(' {
+		var conds []condition
+		for {
+			sub, err := p.parseExpr(negate)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, sub...)
+			p.skipSpace()
+			if p.consume(")") {
+				return conds, nil
+			}
+			if !p.consumeWord("AND") {
+				return nil, newAck(ackErrorArg, "只支持 AND 组合条件")
+			}
+		}
+	}
+
+	// 单个条件：(TAG OP 'VALUE') 或 (base 'VALUE')
+	tag := strings.ToLower(p.word())
+	if err := checkTag(tag); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	op := "base"
+	if tag != "base" {
+		op = p.word()
+		switch op {
+		case "==", "!=", "contains", "starts_with":
+		case "eq":
+			op = "=="
+		default:
+			return nil, newAck(ackErrorArg, "不支持的比较运算: %s", op)
+		}
+		if op == "!=" {
+			op, negate = "==", !negate
+		}
+		p.skipSpace()
+	}
+	value, err := p.stringLiteral()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.consume(")") {
+		return nil, newAck(ackErrorArg, "过滤表达式缺少 )")
+	}
+	return []condition{{tag: tag, op: op, value: value, negate: negate}}, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) consume(s string) bool {
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) consumeWord(word string) bool {
+	start := p.pos
+	if strings.EqualFold(p.word(), word) {
+		p.skipSpace()
+		return true
+	}
+	p.pos = start
+	return false
+}
+
+func (p *exprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		if ch == ' ' || ch == '\t' || ch == '(' || ch == ')' || ch == '\'' || ch == '"' {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *exprParser) stringLiteral() (string, error) {
+	q := p.peek()
+	if q != '\'' && q != '"' {
+		return "", newAck(ackErrorArg, "过滤表达式需要字符串")
+	}
+	p.pos++
+	var value strings.Builder
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		p.pos++
+		switch {
+		case ch == '\\' && p.pos < len(p.input):
+			value.WriteByte(p.input[p.pos])
+			p.pos++
+		case ch == q:
+			return value.String(), nil
+		default:
+			value.WriteByte(ch)
+		}
+	}
+	return "", errUnterminatedQuote
+}
+
+// applyFilter 将过滤条件转换为 SQL 条件
+func applyFilter(db *gorm.DB, conds []condition) *gorm.DB {
+	for _, cond := range conds {
+		var where string
+		var args []interface{}
+
+		switch cond.tag {
+		case "base":
+			library, filePath := splitURI(cond.value)
+			if filePath == "" {
+				where, args = "library = ?", []interface{}{library}
+			} else {
+				where, args = "library = ? AND file_path LIKE ?", []interface{}{library, escapeLike(filePath) + "/%"}
+			}
+		case "file":
+			where, args = compare("CONCAT(library, file_path)", cond.op, cond.value)
+		case "any":
+			var parts []string
+			for _, column := range []string{"title", "artist", "album", "name"} {
+				w, a := compare(column, cond.op, cond.value)
+				parts = append(parts, w)
+				args = append(args, a...)
+			}
+			where = strings.Join(parts, " OR ")
+		default:
+			where, args = compare(tagColumns[cond.tag], cond.op, cond.value)
+		}
+
+		if cond.negate {
+			db = db.Where("NOT ("+where+")", args...)
+		} else {
+			db = db.Where("("+where+")", args...)
+		}
+	}
+	return db
+}
+
+func compare(column, op, value string) (string, []interface{}) {
+	switch op {
+	case "contains":
+		return "INSTR(" + column + ", ?) > 0", []interface{}{value}
+	case "starts_with":
+		return column + " LIKE ?", []interface{}{escapeLike(value) + "%"}
+	default:
+		return column + " = ?", []interface{}{value}
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
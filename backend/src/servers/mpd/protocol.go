@@ -0,0 +1,112 @@
+package mpd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MPD 文本协议：每行一个命令，参数以空格分隔，含空格的参数用双引号包裹并以反斜杠转义。
+// 成功时以 OK 结尾，失败时返回 ACK [错误码@命令序号] {命令} 错误信息
+
+const protocolVersion = "0.23.5"
+
+// ACK 错误码
+const (
+	ackErrorNotList    = 1
+	ackErrorArg        = 2
+	ackErrorPassword   = 3
+	ackErrorPermission = 4
+	ackErrorUnknown    = 5
+	ackErrorNoExist    = 50
+	ackErrorSystem     = 52
+	ackErrorUpdate     = 54
+	ackErrorExist      = 56
+)
+
+type ackError struct {
+	Code    int
+	Message string
+}
+
+func (e *ackError) Error() string {
+	return e.Message
+}
+
+func newAck(code int, format string, args ...interface{}) *ackError {
+	return &ackError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+var errUnterminatedQuote = errors.New("引号未闭合")
+
+// tokenize 拆分命令行
+func tokenize(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuote, inToken := false, false
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case inQuote && ch == '\\' && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case ch == '"':
+			inQuote = !inQuote
+			inToken = true
+		case !inQuote && (ch == ' ' || ch == '\t'):
+			if inToken {
+				args = append(args, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteByte(ch)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, errUnterminatedQuote
+	}
+	if inToken {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// parseRange 解析 POS 或 START:END，END 省略时到队列末尾（以 -1 表示）
+func parseRange(arg string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(arg, ":")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, newAck(ackErrorArg, "无效的位置: %s", arg)
+	}
+	if !isRange {
+		return start, start + 1, nil
+	}
+	if endStr == "" {
+		return start, -1, nil
+	}
+	end, err := strconv.Atoi(endStr)
+	if err != nil || end < start {
+		return 0, 0, newAck(ackErrorArg, "无效的范围: %s", arg)
+	}
+	return start, end, nil
+}
+
+func parseUint(arg string) (uint, error) {
+	n, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return 0, newAck(ackErrorArg, "需要一个非负整数: %s", arg)
+	}
+	return uint(n), nil
+}
+
+func parseInt(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, newAck(ackErrorArg, "需要一个整数: %s", arg)
+	}
+	return n, nil
+}
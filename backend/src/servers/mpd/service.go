@@ -0,0 +1,72 @@
+package mpd
+
+import (
+	"context"
+	"errors"
+	logger "myapp/log"
+	"myapp/servers/music"
+	"net"
+
+	"gorm.io/gorm"
+)
+
+type MPDConfig struct {
+	Enabled bool
+	// 监听地址，MPD 默认端口为 6600
+	Addr string
+}
+
+// MPDService MPD 协议前端，供 ncmpcpp 等终端客户端浏览音乐库和管理播放队列。
+// 服务端不输出音频，播放状态与网页端共享
+type MPDService struct {
+	ctx context.Context
+	cfg *MPDConfig
+	db  *gorm.DB
+	ms  *music.MusicService
+	// 用户名密码认证，由用户服务提供，未设置时不能登录；
+	// 使用 API 密钥登录时返回密钥的范围，nil 表示不受限制
+	authenticate func(username, password, ip string) (string, []string, error)
+}
+
+func NewMPDService(ctx context.Context, cfg *MPDConfig, db *gorm.DB, ms *music.MusicService) *MPDService {
+	if cfg.Addr == "" {
+		cfg.Addr = ":6600"
+	}
+	return &MPDService{
+		ctx: ctx,
+		cfg: cfg,
+		db:  db,
+		ms:  ms,
+	}
+}
+
+// SetAuthenticator 设置 password 命令使用的认证方法，与网页登录共用失败锁定
+func (mpd *MPDService) SetAuthenticator(fn func(username, password, ip string) (string, []string, error)) {
+	mpd.authenticate = fn
+}
+
+func (mpd *MPDService) Start() {
+	listener, err := net.Listen("tcp", mpd.cfg.Addr)
+	if err != nil {
+		logger.ZError(&mpd.ctx, "启动 MPD 服务失败", err, "addr", mpd.cfg.Addr)
+		return
+	}
+
+	go func() {
+		<-mpd.ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			go newSession(mpd, conn).run()
+		}
+	}()
+}
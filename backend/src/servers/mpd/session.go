@@ -0,0 +1,270 @@
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"myapp/middleware"
+	"myapp/servers/music"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 客户端长时间无请求时断开连接，idle 状态下不计时
+const sessionTimeout = 5 * time.Minute
+
+var errClose = errors.New("close")
+
+// session 一个客户端连接，未认证时只能浏览公开音乐库
+type session struct {
+	srv       *MPDService
+	conn      net.Conn
+	w         *bufio.Writer
+	lines     chan string
+	done      chan struct{}
+	userID    string
+	scopes    []string // 使用 API 密钥登录时的范围，nil 表示不受限制
+	libraries []string
+}
+
+func newSession(srv *MPDService, conn net.Conn) *session {
+	return &session{
+		srv:       srv,
+		conn:      conn,
+		w:         bufio.NewWriter(conn),
+		lines:     make(chan string),
+		done:      make(chan struct{}),
+		libraries: srv.ms.PublicLibraries(),
+	}
+}
+
+// can 判断登录的用户是否具有该权限，角色在每次检查时查询，修改角色后立即生效
+func (s *session) can(permission string) bool {
+	if s.userID == "" || middleware.LookupRoles == nil {
+		return false
+	}
+	return middleware.HasPermission(middleware.LookupRoles(s.userID), permission) && middleware.ScopesAllow(s.scopes, permission)
+}
+
+// remoteIP 客户端地址，用于登录失败锁定
+func (s *session) remoteIP() string {
+	host, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	if err != nil {
+		return s.conn.RemoteAddr().String()
+	}
+	return host
+}
+
+func (s *session) run() {
+	defer s.conn.Close()
+	defer close(s.done)
+
+	go s.readLines()
+
+	fmt.Fprintf(s.w, "OK MPD %s\n", protocolVersion)
+	s.w.Flush()
+
+	for {
+		timer := time.NewTimer(sessionTimeout)
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-s.lines:
+			timer.Stop()
+		case <-timer.C:
+			return
+		}
+		if !ok {
+			return
+		}
+
+		var err error
+		switch line {
+		case "command_list_begin", "command_list_ok_begin":
+			var list []string
+			if list, ok = s.readCommandList(); !ok {
+				return
+			}
+			err = s.execute(list, line == "command_list_ok_begin")
+		default:
+			err = s.execute([]string{line}, false)
+		}
+		if errors.Is(err, errClose) {
+			return
+		}
+	}
+}
+
+// readLines 在单独的协程中读取请求，idle 时需要同时等待 noidle 和状态变化
+func (s *session) readLines() {
+	defer close(s.lines)
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		select {
+		case s.lines <- strings.TrimRight(scanner.Text(), "\r"):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) readCommandList() ([]string, bool) {
+	var list []string
+	for line := range s.lines {
+		if line == "command_list_end" {
+			return list, true
+		}
+		list = append(list, line)
+	}
+	return nil, false
+}
+
+// execute 依次执行命令，出错时停止并返回 ACK
+func (s *session) execute(list []string, listOK bool) error {
+	defer s.w.Flush()
+
+	for i, line := range list {
+		args, err := tokenize(line)
+		if err != nil || len(args) == 0 {
+			s.writeAck(i, "", newAck(ackErrorUnknown, "无效的命令"))
+			return nil
+		}
+		name := strings.ToLower(args[0])
+
+		switch name {
+		case "close":
+			return errClose
+		case "idle":
+			if len(list) > 1 {
+				s.writeAck(i, name, newAck(ackErrorArg, "idle 不能在命令列表中使用"))
+				return nil
+			}
+			return s.idle(args[1:])
+		case "noidle":
+			// 不在 idle 状态时忽略
+			continue
+		}
+
+		cmd, ok := commands[name]
+		if !ok {
+			s.writeAck(i, name, newAck(ackErrorUnknown, "未知命令 \"%s\"", name))
+			return nil
+		}
+		if cmd.auth && s.userID == "" {
+			s.writeAck(i, name, newAck(ackErrorPermission, "需要先通过 password 命令登录"))
+			return nil
+		}
+		if err := cmd.fn(s, args[1:]); err != nil {
+			s.writeAck(i, name, err)
+			return nil
+		}
+		if listOK {
+			s.w.WriteString("list_OK\n")
+		}
+	}
+	s.w.WriteString("OK\n")
+	return nil
+}
+
+func (s *session) writeAck(index int, name string, err error) {
+	var ack *ackError
+	if !errors.As(err, &ack) {
+		ack = newAck(ackErrorSystem, "%s", err.Error())
+	}
+	fmt.Fprintf(s.w, "ACK [%d@%d] {%s} %s\n", ack.Code, index, name, ack.Message)
+}
+
+// 可订阅的子系统
+var idleSubsystems = []string{"database", "update", "stored_playlist", "playlist", "player"}
+
+// idle 等待状态变化，收到 noidle 时立即返回
+func (s *session) idle(subsystems []string) error {
+	for _, name := range subsystems {
+		if !slices.Contains(idleSubsystems, strings.ToLower(name)) {
+			s.writeAck(0, "idle", newAck(ackErrorArg, "未知的子系统: %s", name))
+			return nil
+		}
+	}
+	s.w.Flush()
+	wants := func(name string) bool {
+		return len(subsystems) == 0 || slices.ContainsFunc(subsystems, func(sub string) bool {
+			return strings.EqualFold(sub, name)
+		})
+	}
+
+	events := s.srv.ms.SubscribeEvents(s.libraries)
+	defer s.srv.ms.UnsubscribeEvents(events)
+
+	// 队列变化通过轮询版本号发现，网页端修改队列时同样能通知到客户端
+	lastVersion, lastState := s.queueSnapshot()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	changed := make(map[string]bool)
+	for len(changed) == 0 {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				return errClose
+			}
+			if line != "noidle" {
+				// idle 状态下只允许 noidle，其他命令视为客户端异常
+				return errClose
+			}
+			s.w.WriteString("OK\n")
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				return errClose
+			}
+			switch event.Type {
+			case music.EventRescanStarted, music.EventRescanDone:
+				if wants("update") {
+					changed["update"] = true
+				}
+				if event.Type == music.EventRescanDone && wants("database") {
+					changed["database"] = true
+				}
+			case music.EventTrackAdded, music.EventTrackRemoved, music.EventTrackUpdated:
+				if wants("database") {
+					changed["database"] = true
+				}
+			}
+
+		case <-ticker.C:
+			version, state := s.queueSnapshot()
+			if version != lastVersion && wants("playlist") {
+				changed["playlist"] = true
+			}
+			if state != lastState && wants("player") {
+				changed["player"] = true
+			}
+			lastVersion, lastState = version, state
+		}
+	}
+
+	for _, name := range idleSubsystems {
+		if changed[name] {
+			fmt.Fprintf(s.w, "changed: %s\n", name)
+		}
+	}
+	s.w.WriteString("OK\n")
+	return nil
+}
+
+// queueSnapshot 返回队列版本和播放状态摘要，未登录时为空
+func (s *session) queueSnapshot() (uint, string) {
+	if s.userID == "" {
+		return 0, ""
+	}
+	var state music.QueueState
+	err := s.srv.db.Where("user_id = ?", s.userID).Limit(1).Find(&state).Error
+	if err != nil {
+		return 0, ""
+	}
+	return state.Version, fmt.Sprintf("%s:%d:%.0f", state.State, state.CurrentID, state.Elapsed)
+}
@@ -1,16 +1,69 @@
 package music
 
 import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"myapp/middleware"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// 获取整个音乐列表
+// 获取可访问的音乐库列表
+func (ms *MusicService) GetLibraries(c *gin.Context) {
+	var libraries []LibraryResponse
+	for _, name := range ms.accessibleLibraries(c) {
+		lib, _ := ms.getLibrary(name)
+		libraries = append(libraries, LibraryResponse{
+			Name:   name,
+			Public: lib.cfg.Public,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"data":    libraries,
+		"message": "获取成功",
+	})
+}
+
+// 获取整个音乐列表，可通过 library 参数指定音乐库，
+// 登录用户还可以通过 tag（逗号分隔）、min_rating、max_rating 按自己的标签和评分筛选
 func (ms *MusicService) GetMusicList(c *gin.Context) {
-	musicList, err := ms.getMusicList()
+	libraries := ms.accessibleLibraries(c)
+	if name := c.Query("library"); name != "" {
+		if !slices.Contains(libraries, name) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "无权访问该音乐库",
+			})
+			return
+		}
+		libraries = []string{name}
+	}
+
+	filter, err := parseMusicFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !filter.empty() && filter.UserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"message": "按标签或评分筛选需要登录",
+		})
+		return
+	}
+
+	musicList, err := ms.getMusicList(libraries, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -27,9 +80,12 @@ func (ms *MusicService) GetMusicList(c *gin.Context) {
 
 // 播放音乐
 func (ms *MusicService) PlayMusic(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := parseMusicIDParam(c)
+	if !ok {
+		return
+	}
 
-	music, err := ms.getMusicByID(id)
+	music, err := ms.getMusicByID(id, ms.accessibleLibraries(c))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
@@ -49,8 +105,11 @@ func (ms *MusicService) PlayMusic(c *gin.Context) {
 
 // 下载音乐文件
 func (ms *MusicService) DownloadMusic(c *gin.Context) {
-	id := c.Param("id")
-	music, err := ms.getMusicByID(id)
+	id, ok := parseMusicIDParam(c)
+	if !ok {
+		return
+	}
+	music, err := ms.getMusicByID(id, ms.accessibleLibraries(c))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
@@ -59,10 +118,38 @@ func (ms *MusicService) DownloadMusic(c *gin.Context) {
 		return
 	}
 
-	// 构建完整文件路径
-	fullPath := filepath.Join(ms.cfg.MusicDir, filepath.Base(music.FilePath))
+	ms.serveMusicFile(c, music, false)
+}
+
+// 解析路径中的音乐ID，ID 必须是数字，否则会被当作 SQL 条件拼接到查询中
+func parseMusicIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "无效的音乐ID",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// 发送音乐文件，attachment 为 true 时以附件形式下载
+func (ms *MusicService) serveMusicFile(c *gin.Context, music *Music, attachment bool) {
+	fullPath, ok := ms.musicFilePath(music)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "音乐库不存在",
+		})
+		return
+	}
 
 	// 发送文件
+	if attachment {
+		c.FileAttachment(fullPath, filepath.Base(fullPath))
+		return
+	}
 	c.File(fullPath)
 }
 
@@ -76,7 +163,7 @@ func (ms *MusicService) AddToFavorite(c *gin.Context) {
 		return
 	}
 
-	if err := ms.addToFavorite(userID, req.MusicID); err != nil {
+	if err := ms.addToFavorite(userID, req.MusicID, ms.accessibleLibraries(c)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -93,7 +180,7 @@ func (ms *MusicService) RemoveFromFavorite(c *gin.Context) {
 		return
 	}
 
-	if err := ms.removeFromFavorite(userID, uint(musicID)); err != nil {
+	if err := ms.removeFromFavorite(userID, uint(musicID), ms.accessibleLibraries(c)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -104,7 +191,7 @@ func (ms *MusicService) RemoveFromFavorite(c *gin.Context) {
 func (ms *MusicService) GetFavoriteMusic(c *gin.Context) {
 	userID := c.GetString("user_id")
 
-	musicList, err := ms.getUserMusicList(userID)
+	musicList, err := ms.getUserMusicList(userID, ms.accessibleLibraries(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取收藏列表失败"})
 		return
@@ -117,7 +204,7 @@ func (ms *MusicService) GetFavoriteMusic(c *gin.Context) {
 
 func (ms *MusicService) GetFavoriteMusicIDs(c *gin.Context) {
 	userID := c.GetString("user_id")
-	ids, err := ms.getUserMusicIDs(userID)
+	ids, err := ms.getUserMusicIDs(userID, ms.accessibleLibraries(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取收藏列表ID失败"})
 		return
@@ -127,12 +214,11 @@ func (ms *MusicService) GetFavoriteMusicIDs(c *gin.Context) {
 
 func (ms *MusicService) CheckFavorite(c *gin.Context) {
 	userID := c.GetString("user_id")
-	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无效的音乐ID"})
+	musicID, ok := parseMusicID(c)
+	if !ok {
 		return
 	}
-	isFav, err := ms.isInMyMusic(userID, uint(musicID))
+	isFav, err := ms.isInMyMusic(userID, musicID, ms.accessibleLibraries(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "检查失败"})
 		return
@@ -140,3 +226,1024 @@ func (ms *MusicService) CheckFavorite(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"is_favorite": isFav})
 }
+
+// 通过 SSE 推送音乐库变化事件
+func (ms *MusicService) StreamEvents(c *gin.Context) {
+	ch := ms.events.Subscribe(ms.accessibleLibraries(c))
+	defer ms.events.Unsubscribe(ch)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"timestamp": time.Now().Unix()})
+			return true
+		}
+	})
+}
+
+// 重新扫描音乐目录，可通过 library 参数指定音乐库
+func (ms *MusicService) Rescan(c *gin.Context) {
+	libraries := ms.accessibleLibraries(c)
+	if name := c.Query("library"); name != "" {
+		if !slices.Contains(libraries, name) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "无权访问该音乐库",
+			})
+			return
+		}
+		libraries = []string{name}
+	}
+	if len(libraries) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "没有可扫描的音乐库",
+		})
+		return
+	}
+
+	if !ms.RescanLibraries(libraries) {
+		ms.auditRescan(c, libraries, errors.New("扫描正在进行中"))
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    409,
+			"message": "扫描正在进行中",
+		})
+		return
+	}
+
+	ms.auditRescan(c, libraries, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已开始重新扫描",
+	})
+}
+
+func (ms *MusicService) auditRescan(c *gin.Context, libraries []string, err error) {
+	record := &middleware.AuditRecord{
+		Action:     middleware.AuditLibraryRescan,
+		TargetType: middleware.AuditTargetLibrary,
+		Detail:     map[string]interface{}{"libraries": libraries},
+	}
+	if name := c.Query("library"); name != "" {
+		record.TargetID = name
+		record.TargetName = name
+	}
+	middleware.RecordAudit(c, record, err)
+}
+
+// 创建分享链接
+func (ms *MusicService) CreateShare(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	share, err := ms.createShare(userID, &req, ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    share,
+		"message": "创建分享成功",
+	})
+}
+
+// 获取我创建的分享
+func (ms *MusicService) GetMyShares(c *gin.Context) {
+	userID := c.GetString("user_id")
+	shares, err := ms.getUserShares(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取分享列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": shares})
+}
+
+// 撤销分享
+func (ms *MusicService) RevokeShare(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if err := ms.revokeShare(userID, c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "撤销分享成功"})
+}
+
+// 通过分享链接查看内容（无需登录）
+func (ms *MusicService) GetSharedContent(c *gin.Context) {
+	share, err := ms.resolveShare(c.Param("token"))
+	if err != nil {
+		ms.shareError(c, err)
+		return
+	}
+
+	musicList, err := ms.getShareMusicList(share)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分享的音乐不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"type":           share.Type,
+			"allow_download": share.AllowDownload,
+			"expires_at":     share.ExpiresAt,
+			"music":          musicList,
+		},
+	})
+}
+
+// 通过分享链接播放音乐（无需登录）
+func (ms *MusicService) StreamShared(c *gin.Context) {
+	ms.serveShared(c, false)
+}
+
+// 通过分享链接下载音乐，需要分享允许下载
+func (ms *MusicService) DownloadShared(c *gin.Context) {
+	ms.serveShared(c, true)
+}
+
+func (ms *MusicService) serveShared(c *gin.Context, download bool) {
+	share, err := ms.loadShare(c.Param("token"))
+	if err != nil {
+		ms.shareError(c, err)
+		return
+	}
+	if download && !share.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "该分享不允许下载"})
+		return
+	}
+
+	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return
+	}
+	music, err := ms.getShareMusic(share, uint(musicID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "音乐不存在"})
+		return
+	}
+
+	// 播放器拖动进度会发起多次 Range 请求，同一首音乐在分享会话有效期内只计一次使用；
+	// 没有携带会话 Cookie 的请求每次都计入使用次数
+	cookieName := shareSessionCookie(share.ID)
+	if session, err := c.Cookie(cookieName); err != nil || !validShareSession(session, share.ID, music.ID) {
+		if err := ms.consumeShare(share); err != nil {
+			ms.shareError(c, err)
+			return
+		}
+		if session, err := signShareSession(share.ID, music.ID); err == nil {
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(cookieName, session, int(shareSessionTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+		}
+	}
+
+	ms.serveMusicFile(c, music, download)
+}
+
+func (ms *MusicService) shareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrShareInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrShareExpired), errors.Is(err, ErrShareRevoked), errors.Is(err, ErrShareExhausted):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分享链接校验失败"})
+	}
+}
+
+// 获取电台列表，未登录用户只能看到公开电台
+func (ms *MusicService) GetChannels(c *gin.Context) {
+	channels, err := ms.getChannels(c.GetString("user_id") != "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取电台列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": channels})
+}
+
+func (ms *MusicService) CreateChannel(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	channel, err := ms.createChannel(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": channel, "message": "创建电台成功"})
+}
+
+func (ms *MusicService) UpdateChannel(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	id, ok := parseChannelID(c)
+	if !ok {
+		return
+	}
+	channel, err := ms.updateChannel(userID, id, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": channel, "message": "修改电台成功"})
+}
+
+func (ms *MusicService) DeleteChannel(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, ok := parseChannelID(c)
+	if !ok {
+		return
+	}
+	if err := ms.deleteChannel(userID, id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除电台成功"})
+}
+
+// 获取电台正在播放的音乐
+func (ms *MusicService) GetNowPlaying(c *gin.Context) {
+	channel, station, ok := ms.resolveStation(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": NowPlayingResponse{
+			Channel:   channel,
+			Music:     station.current(),
+			Listeners: station.listenerCount(),
+		},
+	})
+}
+
+// 收听电台，输出不间断的 MP3 流，客户端请求 Icy-MetaData 时插入当前曲目信息
+func (ms *MusicService) StreamChannel(c *gin.Context) {
+	channel, station, ok := ms.resolveStation(c)
+	if !ok {
+		return
+	}
+
+	ch := station.subscribe(ms.accessibleLibraries(c))
+	defer station.unsubscribe(ch)
+
+	c.Header("Content-Type", "audio/mpeg")
+	c.Header("Cache-Control", "no-cache, no-store")
+	c.Header("icy-name", channel.Name)
+	c.Header("icy-description", channel.Description)
+	c.Header("icy-pub", "0")
+
+	var w io.Writer = c.Writer
+	if c.GetHeader("Icy-MetaData") == "1" {
+		c.Header("icy-metaint", strconv.Itoa(icyMetaInterval))
+		w = &icyWriter{
+			w:       c.Writer,
+			metaInt: icyMetaInterval,
+			title: func() string {
+				if music := station.current(); music != nil {
+					return music.Name
+				}
+				return channel.Name
+			},
+		}
+	}
+	c.Status(http.StatusOK)
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case frame, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+func parseChannelID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "电台不存在"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (ms *MusicService) resolveStation(c *gin.Context) (*Channel, *radioStation, bool) {
+	id, ok := parseChannelID(c)
+	if !ok {
+		return nil, nil, false
+	}
+	channel, err := ms.getChannel(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "电台不存在"})
+		return nil, nil, false
+	}
+	if !channel.Public && c.GetString("user_id") == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "该电台需要登录后收听"})
+		return nil, nil, false
+	}
+	allowed, err := ms.canListen(channel, ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取电台节目单失败"})
+		return nil, nil, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权收听该电台"})
+		return nil, nil, false
+	}
+	station, ok := ms.radio.get(channel.ID)
+	if !ok {
+		station = ms.radio.start(channel.ID)
+	}
+	return channel, station, true
+}
+
+// 获取订阅令牌和收藏订阅地址
+func (ms *MusicService) GetFeedToken(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ft, err := ms.getFeedToken(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取订阅令牌失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"token":         ft.Token,
+			"favorites_url": ms.baseURL(c) + "/music/feed/" + ft.Token + "/favorites.xml",
+			"playlists_url": ms.baseURL(c) + "/music/feed/" + ft.Token + "/playlists/",
+		},
+	})
+}
+
+// 重置订阅令牌
+func (ms *MusicService) ResetFeedToken(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ft, err := ms.resetFeedToken(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置订阅令牌失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"token":         ft.Token,
+			"favorites_url": ms.baseURL(c) + "/music/feed/" + ft.Token + "/favorites.xml",
+			"playlists_url": ms.baseURL(c) + "/music/feed/" + ft.Token + "/playlists/",
+		},
+		"message": "重置订阅令牌成功",
+	})
+}
+
+// 收藏列表的 RSS 订阅源，通过订阅令牌认证
+func (ms *MusicService) GetFavoritesFeed(c *gin.Context) {
+	userID, err := ms.getUserByFeedToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订阅地址无效"})
+		return
+	}
+
+	feed, err := ms.buildFavoritesFeed(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成订阅源失败"})
+		return
+	}
+	ms.writeFeed(c, feed)
+}
+
+// 歌单的 RSS 订阅源，通过订阅令牌认证，只能订阅自己的歌单
+func (ms *MusicService) GetPlaylistFeed(c *gin.Context) {
+	userID, err := ms.getUserByFeedToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订阅地址无效"})
+		return
+	}
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+
+	feed, err := ms.buildPlaylistFeed(c, userID, playlistID)
+	if errors.Is(err, ErrPlaylistNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成订阅源失败"})
+		return
+	}
+	ms.writeFeed(c, feed)
+}
+
+func (ms *MusicService) writeFeed(c *gin.Context, feed *rssFeed) {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成订阅源失败"})
+		return
+	}
+	c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
+
+// 通过签名链接播放音乐或获取封面，供订阅源中的链接使用
+func (ms *MusicService) ServeSigned(c *gin.Context) {
+	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return
+	}
+
+	kind := signedKindStream
+	if c.Param("file") == "cover" {
+		kind = signedKindCover
+	}
+	userID, ok := ms.verifySignedLink(c, kind, uint(musicID))
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "链接无效或已过期"})
+		return
+	}
+
+	// 签发链接的用户仍需有该音乐库的访问权限
+	libraries := ms.librariesFor(ms.lookupCaller(userID))
+	music, err := ms.getMusicByID(uint(musicID), libraries)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "音乐不存在"})
+		return
+	}
+
+	if kind == signedKindStream {
+		ms.serveMusicFile(c, music, false)
+		return
+	}
+
+	fullPath, ok := ms.musicFilePath(music)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "音乐库不存在"})
+		return
+	}
+	data, mimeType, err := extractCover(fullPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到封面"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, mimeType, data)
+}
+
+// 获取播放队列和播放状态
+func (ms *MusicService) GetQueue(c *gin.Context) {
+	state, entries, err := ms.Queue(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取播放队列失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"state": state,
+			"items": entries,
+			"next":  prefetchHints(state, entries),
+		},
+	})
+}
+
+// 添加到播放队列，未指定 position 时追加到末尾
+func (ms *MusicService) AddToQueue(c *gin.Context) {
+	var req struct {
+		MusicIDs []uint `json:"music_ids" binding:"required,min=1"`
+		Position *int   `json:"position"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	pos := -1
+	if req.Position != nil {
+		pos = *req.Position
+	}
+	ids, err := ms.QueueAdd(c.GetString("user_id"), req.MusicIDs, pos)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": ids, "message": "已添加到播放队列"})
+}
+
+func (ms *MusicService) RemoveFromQueue(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的条目ID"})
+		return
+	}
+	if err := ms.QueueRemove(c.GetString("user_id"), []uint{uint(id)}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已从播放队列移除"})
+}
+
+func (ms *MusicService) ClearQueue(c *gin.Context) {
+	if err := ms.QueueClear(c.GetString("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "清空播放队列失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已清空播放队列"})
+}
+
+// 调整队列顺序
+func (ms *MusicService) MoveQueueItem(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的条目ID"})
+		return
+	}
+	var req struct {
+		Position *int `json:"position" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := ms.QueueMove(c.GetString("user_id"), uint(id), *req.Position); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已调整播放顺序"})
+}
+
+// 上报播放状态，网页端切歌或暂停时调用
+func (ms *MusicService) UpdatePlayback(c *gin.Context) {
+	var req PlaybackUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	state, err := ms.SetPlayback(c.GetString("user_id"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": state})
+}
+
+// 解析音乐列表的标签和评分筛选参数
+func parseMusicFilter(c *gin.Context) (*MusicFilter, error) {
+	filter := &MusicFilter{UserID: c.GetString("user_id")}
+	if tags := c.Query("tag"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			tag, err := normalizeTag(tag)
+			if err != nil {
+				return nil, err
+			}
+			filter.Tags = append(filter.Tags, tag)
+		}
+		filter.Tags = uniqueStrings(filter.Tags)
+	}
+	for key, target := range map[string]*int{"min_rating": &filter.MinRating, "max_rating": &filter.MaxRating} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 5 {
+			return nil, errors.New("评分应为 1 到 5")
+		}
+		*target = n
+	}
+	if filter.MaxRating > 0 && filter.MinRating > filter.MaxRating {
+		return nil, errors.New("最低评分不能高于最高评分")
+	}
+	return filter, nil
+}
+
+func parseMusicID(c *gin.Context) (uint, bool) {
+	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return 0, false
+	}
+	return uint(musicID), true
+}
+
+// 获取我的所有评分
+func (ms *MusicService) GetMyRatings(c *gin.Context) {
+	ratings, err := ms.getUserRatings(c.GetString("user_id"), ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取评分失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": ratings})
+}
+
+// 获取音乐的平均评分和我的评分
+func (ms *MusicService) GetRating(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	summary, err := ms.getRatingSummary(c.GetString("user_id"), musicID, ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+func (ms *MusicService) SetRating(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Rating int `json:"rating" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := ms.setRating(c.GetString("user_id"), musicID, req.Rating, ms.accessibleLibraries(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "评分成功"})
+}
+
+func (ms *MusicService) RemoveRating(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	if err := ms.removeRating(c.GetString("user_id"), musicID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已取消评分"})
+}
+
+// 获取我使用过的标签及次数
+func (ms *MusicService) GetMyTags(c *gin.Context) {
+	tags, err := ms.getUserTags(c.GetString("user_id"), ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取标签失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tags})
+}
+
+func (ms *MusicService) GetMusicTags(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	if _, err := ms.checkMusicAccess(musicID, ms.accessibleLibraries(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := ms.getMusicTags(c.GetString("user_id"), musicID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取标签失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tags})
+}
+
+// 替换音乐的全部标签
+func (ms *MusicService) SetMusicTags(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	tags, err := ms.setMusicTags(c.GetString("user_id"), musicID, req.Tags, ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tags, "message": "标签已更新"})
+}
+
+func (ms *MusicService) AddMusicTag(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Tag string `json:"tag" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := ms.addMusicTag(c.GetString("user_id"), musicID, req.Tag, ms.accessibleLibraries(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "标签已添加"})
+}
+
+func (ms *MusicService) RemoveMusicTag(c *gin.Context) {
+	musicID, ok := parseMusicID(c)
+	if !ok {
+		return
+	}
+	if err := ms.removeMusicTag(c.GetString("user_id"), musicID, c.Param("tag")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "标签已删除"})
+}
+
+// 获取音乐的评论；审核员不带 music_id 时按时间倒序查看最近评论，hidden=true 只看被隐藏的评论
+func (ms *MusicService) GetComments(c *gin.Context) {
+	user := ms.resolveCaller(c)
+	if c.Query("music_id") == "" {
+		if !user.can(middleware.PermCommentsModerate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少音乐ID"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		comments, err := ms.getRecentComments(c.Query("hidden") == "true", limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取评论失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": comments})
+		return
+	}
+
+	musicID, err := strconv.ParseUint(c.Query("music_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return
+	}
+	comments, err := ms.getComments(user, uint(musicID), ms.librariesFor(user))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": comments})
+}
+
+// 发表评论，timestamp 可选，格式为 "1:23" 或秒数
+func (ms *MusicService) AddComment(c *gin.Context) {
+	var req struct {
+		MusicID   uint   `json:"music_id" binding:"required"`
+		ParentID  *uint  `json:"parent_id"`
+		Body      string `json:"body" binding:"required"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	user := ms.resolveCaller(c)
+	comment, err := ms.addComment(user, req.MusicID, req.ParentID, req.Body, req.Timestamp, ms.librariesFor(user))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": comment, "message": "评论成功"})
+}
+
+func parseCommentID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的评论ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (ms *MusicService) EditComment(c *gin.Context) {
+	id, ok := parseCommentID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	comment, err := ms.editComment(c.GetString("user_id"), id, req.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": comment, "message": "评论已修改"})
+}
+
+func (ms *MusicService) DeleteComment(c *gin.Context) {
+	id, ok := parseCommentID(c)
+	if !ok {
+		return
+	}
+	if err := ms.deleteComment(ms.resolveCaller(c), id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "评论已删除"})
+}
+
+// 审核员隐藏或恢复评论
+func (ms *MusicService) ModerateComment(c *gin.Context) {
+	id, ok := parseCommentID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Hidden bool `json:"hidden"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	comment, err := ms.moderateComment(ms.resolveCaller(c), id, req.Hidden)
+	record := &middleware.AuditRecord{
+		Action:     middleware.AuditCommentRestore,
+		TargetType: middleware.AuditTargetComment,
+		TargetID:   strconv.FormatUint(uint64(id), 10),
+	}
+	if req.Hidden {
+		record.Action = middleware.AuditCommentHide
+	}
+	if comment != nil {
+		record.Detail = map[string]interface{}{"music_id": comment.MusicID, "author_id": comment.UserID}
+	}
+	middleware.RecordAudit(c, record, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": comment, "message": "操作成功"})
+}
+
+func parsePlaylistID(c *gin.Context) (uint, bool) {
+	playlistID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的歌单ID"})
+		return 0, false
+	}
+	return uint(playlistID), true
+}
+
+func (ms *MusicService) playlistError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound), errors.Is(err, ErrPlaylistItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// 获取我的歌单
+func (ms *MusicService) GetPlaylists(c *gin.Context) {
+	playlists, err := ms.listPlaylists(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取歌单失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": playlists})
+}
+
+func (ms *MusicService) CreatePlaylist(c *gin.Context) {
+	var req PlaylistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	playlist, err := ms.createPlaylist(c.GetString("user_id"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": playlist, "message": "创建歌单成功"})
+}
+
+// 获取歌单及其中的音乐
+func (ms *MusicService) GetPlaylist(c *gin.Context) {
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+	playlist, err := ms.getPlaylist(ms.db, c.GetString("user_id"), playlistID)
+	if err != nil {
+		ms.playlistError(c, err)
+		return
+	}
+	entries, err := ms.playlistEntries(playlist.ID, ms.accessibleLibraries(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取歌单失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"playlist": playlist, "items": entries}})
+}
+
+func (ms *MusicService) UpdatePlaylist(c *gin.Context) {
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+	var req PlaylistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	playlist, err := ms.updatePlaylist(c.GetString("user_id"), playlistID, &req)
+	if err != nil {
+		ms.playlistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": playlist, "message": "修改歌单成功"})
+}
+
+func (ms *MusicService) DeletePlaylist(c *gin.Context) {
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+	if err := ms.deletePlaylist(c.GetString("user_id"), playlistID); err != nil {
+		ms.playlistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除歌单成功"})
+}
+
+// 将音乐追加到歌单末尾
+func (ms *MusicService) AddToPlaylist(c *gin.Context) {
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		MusicIDs []uint `json:"music_ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := ms.addToPlaylist(c.GetString("user_id"), playlistID, req.MusicIDs, ms.accessibleLibraries(c)); err != nil {
+		ms.playlistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已添加到歌单"})
+}
+
+func (ms *MusicService) RemoveFromPlaylist(c *gin.Context) {
+	playlistID, ok := parsePlaylistID(c)
+	if !ok {
+		return
+	}
+	itemID, err := strconv.ParseUint(c.Param("item"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的条目ID"})
+		return
+	}
+	if err := ms.removeFromPlaylist(c.GetString("user_id"), playlistID, uint(itemID)); err != nil {
+		ms.playlistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已从歌单移除"})
+}
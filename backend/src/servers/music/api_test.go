@@ -0,0 +1,120 @@
+package music
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// newAPITestService 创建使用模拟数据库的服务，bob 只能访问 music 音乐库
+func newAPITestService(t *testing.T) (*MusicService, sqlmock.Sqlmock, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	db, mock := newMockDB(t)
+	ms := &MusicService{
+		db: db,
+		libraries: map[string]*Library{
+			"music":   {cfg: LibraryConfig{Name: "music", AllowUsers: []string{"bob"}}},
+			"private": {cfg: LibraryConfig{Name: "private", AllowUsers: []string{"carol"}}},
+		},
+		libraryNames: []string{"music", "private"},
+	}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID := c.GetHeader("X-Test-User"); userID != "" {
+			c.Set("user_id", userID)
+			c.Set("roles", []string{"user"})
+		}
+	})
+	return ms, mock, r
+}
+
+func expectCaller(mock sqlmock.Sqlmock, userID, username string) {
+	mock.ExpectQuery("SELECT id, username FROM `users`").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(userID, username))
+}
+
+func serve(r *gin.Engine, method, target, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if userID != "" {
+		req.Header.Set("X-Test-User", userID)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// 没有任何可访问的音乐库时不能扫描，返回 403 而不是“扫描正在进行中”
+func TestRescanWithoutLibraries(t *testing.T) {
+	ms, mock, r := newAPITestService(t)
+	r.POST("/music/rescan", ms.Rescan)
+
+	expectCaller(mock, "u3", "dave")
+	if w := serve(r, http.MethodPost, "/music/rescan", "u3"); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// 取消收藏和检查收藏只作用于可访问音乐库中的音乐
+func TestFavoriteLibraryScope(t *testing.T) {
+	ms, mock, r := newAPITestService(t)
+	r.DELETE("/music/favorite/:id", ms.RemoveFromFavorite)
+	r.GET("/music/favorite/check/:id", ms.CheckFavorite)
+
+	expectCaller(mock, "u2", "bob")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_music` WHERE user_id = ? AND music_id = ? AND music_id IN (SELECT `id` FROM `musics` WHERE library IN (?))")).
+		WithArgs("u2", 5, "music").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	if w := serve(r, http.MethodDelete, "/music/favorite/5", "u2"); w.Code != http.StatusBadRequest {
+		t.Errorf("无权访问的音乐不能取消收藏，status = %d", w.Code)
+	}
+
+	expectCaller(mock, "u2", "bob")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_music` JOIN musics ON musics.id = user_music.music_id WHERE user_music.user_id = ? AND user_music.music_id = ? AND musics.library IN (?)")).
+		WithArgs("u2", 5, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	w := serve(r, http.MethodGet, "/music/favorite/check/5", "u2")
+	if w.Code != http.StatusOK || w.Body.String() != `{"is_favorite":false}` {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(r, http.MethodGet, "/music/favorite/check/abc", "u2"); w.Code != http.StatusBadRequest {
+		t.Errorf("无效的音乐ID应返回 400，status = %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// 音乐ID必须是数字，不能作为 SQL 条件拼接到查询中
+func TestMusicIDMustBeNumeric(t *testing.T) {
+	ms, mock, r := newAPITestService(t)
+	r.GET("/music/play/:id", ms.PlayMusic)
+	r.GET("/music/download/:id", ms.DownloadMusic)
+
+	for _, target := range []string{"/music/play/1%20OR%201=1", "/music/download/1)%20OR%20(1=1"} {
+		if w := serve(r, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+
+	expectCaller(mock, "u2", "bob")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `musics` WHERE library IN (?) AND `musics`.`id` = ?")).
+		WithArgs("music", 7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "library", "name", "file_path"}).AddRow(7, "music", "Song", "/song.mp3"))
+	if w := serve(r, http.MethodGet, "/music/play/7", "u2"); w.Code != http.StatusOK {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
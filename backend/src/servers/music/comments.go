@@ -0,0 +1,266 @@
+package music
+
+import (
+	"errors"
+	"myapp/middleware"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const maxCommentLength = 2000
+
+// Comment 音乐评论，ParentID 不为空时为回复，Timestamp 为评论对应的播放位置（秒）
+type Comment struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MusicID   uint      `gorm:"not null;index" json:"music_id"`
+	UserID    string    `gorm:"type:varchar(255);not null;index" json:"user_id"`
+	ParentID  *uint     `gorm:"index" json:"parent_id"`
+	Body      string    `gorm:"type:text;not null" json:"body"`
+	Timestamp *float64  `json:"timestamp"`
+	Hidden    bool      `gorm:"not null;default:false" json:"hidden"`           // 被管理员隐藏
+	HiddenBy  string    `gorm:"type:varchar(255);not null;default:''" json:"-"` // 隐藏操作的审核员
+	Deleted   bool      `gorm:"not null;default:false" json:"deleted"`          // 已删除但仍有回复，保留占位
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Username string     `gorm:"-" json:"username"`
+	Replies  []*Comment `gorm:"-" json:"replies,omitempty"`
+}
+
+func (Comment) TableName() string {
+	return "music_comments"
+}
+
+// parseCommentTimestamp 解析 "1:23"、"1:02:03" 或秒数形式的播放位置
+func parseCommentTimestamp(value string) (*float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return nil, errors.New("时间格式错误")
+	}
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return nil, errors.New("时间格式错误")
+		}
+		// 分、秒部分不能超过 59
+		if i > 0 && n >= 60 {
+			return nil, errors.New("时间格式错误")
+		}
+		seconds = seconds*60 + n
+	}
+	return &seconds, nil
+}
+
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("评论内容不能为空")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return "", errors.New("评论内容过长")
+	}
+	return body, nil
+}
+
+func (ms *MusicService) addComment(user *caller, musicID uint, parentID *uint, body, timestamp string, libraries []string) (*Comment, error) {
+	body, err := validateCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+	music, err := ms.checkMusicAccess(musicID, libraries)
+	if err != nil {
+		return nil, err
+	}
+	at, err := parseCommentTimestamp(timestamp)
+	if err != nil {
+		return nil, err
+	}
+	// 已知时长时检查播放位置不超过曲目长度
+	if at != nil && music.SampleRate > 0 && music.SampleCount > 0 {
+		if *at > float64(music.SampleCount)/float64(music.SampleRate) {
+			return nil, errors.New("时间超出曲目长度")
+		}
+	}
+	if parentID != nil {
+		var parent Comment
+		if err := ms.db.Where("music_id = ?", musicID).First(&parent, *parentID).Error; err != nil {
+			return nil, errors.New("回复的评论不存在")
+		}
+	}
+
+	comment := Comment{
+		MusicID:   musicID,
+		UserID:    user.ID,
+		ParentID:  parentID,
+		Body:      body,
+		Timestamp: at,
+	}
+	if err := ms.db.Create(&comment).Error; err != nil {
+		return nil, err
+	}
+	comment.Username = user.Username
+	return &comment, nil
+}
+
+func (ms *MusicService) getComment(id uint) (*Comment, error) {
+	var comment Comment
+	if err := ms.db.First(&comment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("评论不存在")
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// editComment 用户只能编辑自己的评论
+func (ms *MusicService) editComment(userID string, id uint, body string) (*Comment, error) {
+	body, err := validateCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := ms.getComment(id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.UserID != userID || comment.Deleted {
+		return nil, errors.New("评论不存在")
+	}
+	if err := ms.db.Model(comment).Update("body", body).Error; err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// deleteComment 删除评论，有回复的评论只清空内容以保留讨论结构
+func (ms *MusicService) deleteComment(user *caller, id uint) error {
+	comment, err := ms.getComment(id)
+	if err != nil {
+		return err
+	}
+	if comment.UserID != user.ID && !user.can(middleware.PermCommentsModerate) {
+		return errors.New("无权删除该评论")
+	}
+
+	var replies int64
+	if err := ms.db.Model(&Comment{}).Where("parent_id = ?", id).Count(&replies).Error; err != nil {
+		return err
+	}
+	if replies > 0 {
+		return ms.db.Model(comment).Updates(map[string]any{"body": "", "deleted": true}).Error
+	}
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(comment).Error; err != nil {
+			return err
+		}
+		// 父评论已删除且不再有回复时一并移除
+		for parentID := comment.ParentID; parentID != nil; {
+			var parent Comment
+			if err := tx.First(&parent, *parentID).Error; err != nil {
+				return nil
+			}
+			var count int64
+			tx.Model(&Comment{}).Where("parent_id = ?", parent.ID).Count(&count)
+			if !parent.Deleted || count > 0 {
+				return nil
+			}
+			if err := tx.Delete(&parent).Error; err != nil {
+				return err
+			}
+			parentID = parent.ParentID
+		}
+		return nil
+	})
+}
+
+// moderateComment 审核员隐藏或恢复评论
+func (ms *MusicService) moderateComment(moderator *caller, id uint, hidden bool) (*Comment, error) {
+	comment, err := ms.getComment(id)
+	if err != nil {
+		return nil, err
+	}
+	hiddenBy := ""
+	if hidden {
+		hiddenBy = moderator.ID
+	}
+	err = ms.db.Model(comment).Updates(map[string]any{"hidden": hidden, "hidden_by": hiddenBy}).Error
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// getComments 获取音乐的评论，按发布时间组织为树形结构
+func (ms *MusicService) getComments(user *caller, musicID uint, libraries []string) ([]*Comment, error) {
+	if _, err := ms.checkMusicAccess(musicID, libraries); err != nil {
+		return nil, err
+	}
+	var comments []*Comment
+	if err := ms.db.Where("music_id = ?", musicID).Order("id ASC").Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	ms.fillUsernames(comments)
+
+	moderator := user.can(middleware.PermCommentsModerate)
+	byID := make(map[uint]*Comment, len(comments))
+	for _, comment := range comments {
+		// 被隐藏的评论只对审核员和作者本人可见
+		if comment.Hidden && !moderator && comment.UserID != user.ID {
+			comment.Body = ""
+		}
+		byID[comment.ID] = comment
+	}
+	roots := []*Comment{}
+	for _, comment := range comments {
+		if comment.ParentID != nil {
+			if parent, ok := byID[*comment.ParentID]; ok {
+				parent.Replies = append(parent.Replies, comment)
+				continue
+			}
+		}
+		roots = append(roots, comment)
+	}
+	return roots, nil
+}
+
+// getRecentComments 审核员查看最近的评论，用于审核
+func (ms *MusicService) getRecentComments(hiddenOnly bool, limit int) ([]*Comment, error) {
+	query := ms.db.Order("id DESC").Limit(limit)
+	if hiddenOnly {
+		query = query.Where("hidden = ?", true)
+	}
+	var comments []*Comment
+	if err := query.Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	ms.fillUsernames(comments)
+	return comments, nil
+}
+
+func (ms *MusicService) fillUsernames(comments []*Comment) {
+	ids := make([]string, 0, len(comments))
+	for _, comment := range comments {
+		ids = append(ids, comment.UserID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	var users []caller
+	ms.db.Table("users").Select("id, username").Where("id IN ?", uniqueStrings(ids)).Scan(&users)
+	names := make(map[string]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	for _, comment := range comments {
+		comment.Username = names[comment.UserID]
+	}
+}
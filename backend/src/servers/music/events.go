@@ -0,0 +1,85 @@
+package music
+
+import (
+	"sync"
+	"time"
+)
+
+// 音乐库事件类型
+const (
+	EventTrackAdded     = "track_added"
+	EventTrackRemoved   = "track_removed"
+	EventTrackUpdated   = "track_updated"
+	EventRescanStarted  = "rescan_started"
+	EventRescanProgress = "rescan_progress"
+	EventRescanDone     = "rescan_done"
+)
+
+// 每个订阅者的缓冲区大小，消费过慢的客户端会丢弃事件
+const eventBufferSize = 64
+
+type LibraryEvent struct {
+	Type      string `json:"type"`
+	Library   string `json:"library"`
+	Music     *Music `json:"music,omitempty"`
+	Scanned   int    `json:"scanned,omitempty"`
+	Total     int    `json:"total,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// EventHub 将音乐库变化广播给所有已连接的客户端
+type EventHub struct {
+	mu          sync.RWMutex
+	subscribers map[chan LibraryEvent]map[string]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[chan LibraryEvent]map[string]bool),
+	}
+}
+
+// Subscribe 订阅指定音乐库的事件
+func (h *EventHub) Subscribe(libraries []string) chan LibraryEvent {
+	allowed := make(map[string]bool, len(libraries))
+	for _, name := range libraries {
+		allowed[name] = true
+	}
+
+	ch := make(chan LibraryEvent, eventBufferSize)
+	h.mu.Lock()
+	h.subscribers[ch] = allowed
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan LibraryEvent) {
+	h.mu.Lock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	h.mu.Unlock()
+}
+
+func (h *EventHub) Publish(event LibraryEvent) {
+	if h == nil {
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch, allowed := range h.subscribers {
+		if !allowed[event.Library] {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// 客户端消费过慢，丢弃该事件
+		}
+	}
+}
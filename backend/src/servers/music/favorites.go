@@ -33,43 +33,45 @@ func (ms *MusicService) removeFromMusic(userID string, musicID string) error {
 }
 
 // 获取用户的收藏列表
-func (ms *MusicService) getUserMusicList(userID string) ([]Music, error) {
+func (ms *MusicService) getUserMusicList(userID string, libraries []string) ([]Music, error) {
 	var musicList []Music
 	err := ms.db.Table("musics").
 		Joins("JOIN user_music ON musics.id = user_music.music_id").
-		Where("user_music.user_id = ?", userID).
+		Where("user_music.user_id = ? AND musics.library IN ?", userID, libraries).
 		Order("user_music.created_at DESC").
 		Find(&musicList).Error
 	return musicList, err
 }
 
-// 检查是否已收藏
-func (ms *MusicService) isInMyMusic(userID string, musicID uint) (bool, error) {
+// 检查是否已收藏，只统计可访问音乐库中的音乐
+func (ms *MusicService) isInMyMusic(userID string, musicID uint, libraries []string) (bool, error) {
 	var count int64
 	err := ms.db.Model(&UserMusic{}).
-		Where("user_id = ? AND music_id = ?", userID, musicID).
+		Joins("JOIN musics ON musics.id = user_music.music_id").
+		Where("user_music.user_id = ? AND user_music.music_id = ? AND musics.library IN ?", userID, musicID, libraries).
 		Count(&count).Error
 	return count > 0, err
 }
 
 // 获取用户收藏的音乐ID列表（用于前端标记）
-func (ms *MusicService) getUserMusicIDs(userID string) ([]uint, error) {
+func (ms *MusicService) getUserMusicIDs(userID string, libraries []string) ([]uint, error) {
 	var ids []uint
 	err := ms.db.Model(&UserMusic{}).
-		Where("user_id = ?", userID).
-		Pluck("music_id", &ids).Error
+		Joins("JOIN musics ON musics.id = user_music.music_id").
+		Where("user_music.user_id = ? AND musics.library IN ?", userID, libraries).
+		Pluck("user_music.music_id", &ids).Error
 	return ids, err
 }
 
-func (ms MusicService) addToFavorite(userID string, musicID uint) error {
+func (ms MusicService) addToFavorite(userID string, musicID uint, libraries []string) error {
 	var music Music
-	if err := ms.db.First(&music, musicID).Error; err != nil {
+	if err := ms.db.Where("library IN ?", libraries).First(&music, musicID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New(("音乐不存在"))
 		}
 		return err
 	}
-	fav, _ := ms.isInMyMusic(userID, musicID)
+	fav, _ := ms.isInMyMusic(userID, musicID, libraries)
 	if fav {
 		return errors.New("已经收藏过该音乐")
 	}
@@ -77,8 +79,10 @@ func (ms MusicService) addToFavorite(userID string, musicID uint) error {
 	return ms.addToMusic(userID, musicID)
 }
 
-func (ms *MusicService) removeFromFavorite(userID string, musicID uint) error {
-	result := ms.db.Where("user_id = ? AND music_id = ?", userID, musicID).
+// 取消收藏，无权访问的音乐按未收藏处理
+func (ms *MusicService) removeFromFavorite(userID string, musicID uint, libraries []string) error {
+	accessible := ms.db.Model(&Music{}).Select("id").Where("library IN ?", libraries)
+	result := ms.db.Where("user_id = ? AND music_id = ? AND music_id IN (?)", userID, musicID, accessible).
 		Delete(&UserMusic{})
 
 	if result.Error != nil {
@@ -91,3 +95,18 @@ func (ms *MusicService) removeFromFavorite(userID string, musicID uint) error {
 
 	return nil
 }
+
+// FavoriteMusic 返回用户的收藏列表，供 MPD 等其他协议使用
+func (ms *MusicService) FavoriteMusic(userID string) ([]Music, error) {
+	return ms.getUserMusicList(userID, ms.LibrariesFor(userID))
+}
+
+// AddFavorite 收藏音乐
+func (ms *MusicService) AddFavorite(userID string, musicID uint) error {
+	return ms.addToFavorite(userID, musicID, ms.LibrariesFor(userID))
+}
+
+// RemoveFavorite 取消收藏
+func (ms *MusicService) RemoveFavorite(userID string, musicID uint) error {
+	return ms.removeFromFavorite(userID, musicID, ms.LibrariesFor(userID))
+}
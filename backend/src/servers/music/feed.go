@@ -0,0 +1,381 @@
+package music
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"myapp/middleware"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 订阅源中的播放链接有效期，播客客户端每次刷新订阅源都会拿到新的链接
+const feedLinkExpiry = 30 * 24 * time.Hour
+
+// 签名链接的用途
+const (
+	signedKindStream = "stream"
+	signedKindCover  = "cover"
+)
+
+// FeedToken 每个用户一个订阅令牌，播客客户端无法设置 Authorization 请求头
+type FeedToken struct {
+	UserID    string    `gorm:"type:varchar(255);primaryKey" json:"-"`
+	Token     string    `gorm:"type:varchar(64);not null;unique" json:"token"`
+	Version   int       `gorm:"not null;default:0" json:"-"` // 每次重置加一，参与签名，使之前签发的链接失效
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// 订阅源条目，收藏或加入歌单的时间作为节目的发布时间
+type feedEntry struct {
+	Music
+	AddedAt time.Time
+	GUID    string `gorm:"-"` // 为空时使用音乐ID，歌单中同一首音乐可以出现多次
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	ITunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Language    string       `xml:"language,omitempty"`
+	Author      string       `xml:"itunes:author"`
+	Explicit    string       `xml:"itunes:explicit"`
+	Image       *rssImageRef `xml:"itunes:image,omitempty"`
+	Items       []rssItem    `xml:"item"`
+}
+
+type rssImageRef struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	GUID      rssGUID      `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+	Author    string       `xml:"itunes:author,omitempty"`
+	Image     *rssImageRef `xml:"itunes:image,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func generateFeedToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// 获取用户的订阅令牌，不存在时创建
+func (ms *MusicService) getFeedToken(userID string) (*FeedToken, error) {
+	var ft FeedToken
+	err := ms.db.Where("user_id = ?", userID).First(&ft).Error
+	if err == nil {
+		return &ft, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return ms.resetFeedToken(userID)
+}
+
+// 重置订阅令牌，旧的订阅地址和由它签发的链接立即失效
+func (ms *MusicService) resetFeedToken(userID string) (*FeedToken, error) {
+	token, err := generateFeedToken()
+	if err != nil {
+		return nil, err
+	}
+	ft := FeedToken{UserID: userID, Token: token, Version: ms.feedTokenVersion(userID) + 1, CreatedAt: time.Now()}
+	if err := ms.db.Save(&ft).Error; err != nil {
+		return nil, err
+	}
+	return &ft, nil
+}
+
+// feedTokenVersion 返回订阅令牌的版本，没有令牌时返回 -1
+func (ms *MusicService) feedTokenVersion(userID string) int {
+	var versions []int
+	ms.db.Model(&FeedToken{}).Where("user_id = ?", userID).Limit(1).Pluck("version", &versions)
+	if len(versions) == 0 {
+		return -1
+	}
+	return versions[0]
+}
+
+func (ms *MusicService) getUserByFeedToken(token string) (string, error) {
+	var ft FeedToken
+	if err := ms.db.Where("token = ?", token).First(&ft).Error; err != nil {
+		return "", err
+	}
+	return ft.UserID, nil
+}
+
+func (ms *MusicService) getFeedEntries(userID string, libraries []string) ([]feedEntry, error) {
+	var entries []feedEntry
+	err := ms.db.Table("musics").
+		Select("musics.*, user_music.created_at AS added_at").
+		Joins("JOIN user_music ON musics.id = user_music.music_id").
+		Where("user_music.user_id = ? AND musics.library IN ?", userID, libraries).
+		Order("user_music.created_at DESC").
+		Scan(&entries).Error
+	return entries, err
+}
+
+// 签名链接：对用途、音乐ID、用户ID、订阅令牌版本和过期时间做 HMAC，用户ID用于播放时重新校验音乐库权限，
+// 重置订阅令牌后版本变化，之前签发的链接随之失效
+func signLink(kind string, musicID uint, userID string, version int, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(middleware.JWTSecret))
+	fmt.Fprintf(mac, "%s:%d:%s:%d:%d", kind, musicID, userID, version, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signedLinkQuery(kind string, musicID uint, userID string, version int, expires time.Time) string {
+	exp := expires.Unix()
+	query := url.Values{}
+	query.Set("uid", userID)
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("sig", signLink(kind, musicID, userID, version, exp))
+	return query.Encode()
+}
+
+// verifySignedLink 按用户当前的订阅令牌版本校验签名链接，返回签发链接的用户ID
+func (ms *MusicService) verifySignedLink(c *gin.Context, kind string, musicID uint) (string, bool) {
+	userID := c.Query("uid")
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	version := ms.feedTokenVersion(userID)
+	if version < 0 {
+		return "", false
+	}
+	expected := signLink(kind, musicID, userID, version, exp)
+	if !hmac.Equal([]byte(expected), []byte(c.Query("sig"))) {
+		return "", false
+	}
+	return userID, true
+}
+
+// 对外访问的地址，未配置 PublicURL 时根据请求推断；
+// 只有来自可信代理的请求才使用 X-Forwarded-Proto 和 X-Forwarded-Host，否则客户端可以伪造订阅源中的链接
+func (ms *MusicService) baseURL(c *gin.Context) string {
+	if ms.cfg.PublicURL != "" {
+		return strings.TrimRight(ms.cfg.PublicURL, "/")
+	}
+	scheme, host := "http", c.Request.Host
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if ms.fromTrustedProxy(c) {
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// fromTrustedProxy 判断请求是否直接来自配置的可信代理
+func (ms *MusicService) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range ms.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies 解析可信代理的地址或网段，与 gin 的 SetTrustedProxies 格式相同
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的代理地址: %s", proxy)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的代理网段: %s", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (ms *MusicService) buildFavoritesFeed(c *gin.Context, userID string) (*rssFeed, error) {
+	user := ms.lookupCaller(userID)
+	entries, err := ms.getFeedEntries(userID, ms.librariesFor(user))
+	if err != nil {
+		return nil, err
+	}
+	return ms.buildFeed(c, user, rssChannel{
+		Title:       fmt.Sprintf("%s 的收藏", user.Username),
+		Description: fmt.Sprintf("%s 在 digital-hub 中收藏的音乐", user.Username),
+	}, entries)
+}
+
+// buildPlaylistFeed 歌单的订阅源，只能订阅自己的歌单，节目按歌单中的顺序排列
+func (ms *MusicService) buildPlaylistFeed(c *gin.Context, userID string, playlistID uint) (*rssFeed, error) {
+	playlist, err := ms.getPlaylist(ms.db, userID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	user := ms.lookupCaller(userID)
+	items, err := ms.playlistEntries(playlist.ID, ms.librariesFor(user))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]feedEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, feedEntry{Music: item.Music, AddedAt: item.AddedAt, GUID: fmt.Sprintf("playlist-item-%d", item.ID)})
+	}
+
+	description := playlist.Description
+	if description == "" {
+		description = fmt.Sprintf("%s 在 digital-hub 中创建的歌单", user.Username)
+	}
+	return ms.buildFeed(c, user, rssChannel{Title: playlist.Name, Description: description}, entries)
+}
+
+// buildFeed 补全频道信息，为每首音乐生成签名的播放和封面链接
+func (ms *MusicService) buildFeed(c *gin.Context, user *caller, channel rssChannel, entries []feedEntry) (*rssFeed, error) {
+	if !user.loggedIn() {
+		return nil, errors.New("用户不存在")
+	}
+	ft, err := ms.getFeedToken(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	base := ms.baseURL(c)
+	expires := time.Now().Add(feedLinkExpiry)
+	channel.Link = base
+	channel.Author = user.Username
+	channel.Explicit = "false"
+	if ms.cfg.FeedImageURL != "" {
+		channel.Image = &rssImageRef{Href: ms.cfg.FeedImageURL}
+	}
+
+	for _, entry := range entries {
+		fullPath, ok := ms.musicFilePath(&entry.Music)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
+		mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fullPath)))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		guid := entry.GUID
+		if guid == "" {
+			guid = fmt.Sprintf("music-%d", entry.ID)
+		}
+
+		item := rssItem{
+			Title:   entry.Name,
+			GUID:    rssGUID{IsPermaLink: "false", Value: guid},
+			PubDate: entry.AddedAt.Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL: fmt.Sprintf("%s/music/signed/%d/stream%s?%s", base, entry.ID,
+					strings.ToLower(filepath.Ext(fullPath)),
+					signedLinkQuery(signedKindStream, entry.ID, user.ID, ft.Version, expires)),
+				Length: info.Size(),
+				Type:   mimeType,
+			},
+		}
+		if ms.covers.hasCover(fullPath, info) {
+			item.Image = &rssImageRef{Href: fmt.Sprintf("%s/music/signed/%d/cover?%s", base, entry.ID,
+				signedLinkQuery(signedKindCover, entry.ID, user.ID, ft.Version, expires))}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	return &rssFeed{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: channel,
+	}, nil
+}
+
+// 订阅源中每首歌都要判断有没有封面，缓存读取结果，文件修改后重新读取
+const maxCoverCacheEntries = 50000
+
+type coverCache struct {
+	mu      sync.Mutex
+	entries map[string]coverCacheEntry
+}
+
+type coverCacheEntry struct {
+	modTime time.Time
+	size    int64
+	has     bool
+}
+
+func (cc *coverCache) hasCover(fullPath string, info os.FileInfo) bool {
+	cc.mu.Lock()
+	entry, ok := cc.entries[fullPath]
+	cc.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.has
+	}
+
+	_, _, err := extractCover(fullPath)
+	entry = coverCacheEntry{modTime: info.ModTime(), size: info.Size(), has: err == nil}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	// 已删除文件的条目不会主动清理，超过上限时整体清空
+	if cc.entries == nil || len(cc.entries) >= maxCoverCacheEntries {
+		cc.entries = make(map[string]coverCacheEntry)
+	}
+	cc.entries[fullPath] = entry
+	return entry.has
+}
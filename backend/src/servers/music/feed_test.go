@@ -0,0 +1,265 @@
+package music
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// 重置订阅令牌后版本变化，之前签发的链接不再有效
+func TestSignLinkIncludesTokenVersion(t *testing.T) {
+	exp := time.Now().Add(feedLinkExpiry).Unix()
+	sig := signLink(signedKindStream, 1, "u1", 1, exp)
+	if sig != signLink(signedKindStream, 1, "u1", 1, exp) {
+		t.Fatal("相同参数的签名应该一致")
+	}
+	if sig == signLink(signedKindStream, 1, "u1", 2, exp) {
+		t.Error("令牌版本不同时签名应该不同")
+	}
+	if sig == signLink(signedKindCover, 1, "u1", 1, exp) {
+		t.Error("用途不同时签名应该不同")
+	}
+}
+
+// 带 APIC 帧的 ID3v2.3 标签
+func id3WithCover(image []byte) []byte {
+	body := append([]byte{0}, "image/jpeg\x00"...)
+	body = append(body, 3, 0) // 图片类型、空描述
+	body = append(body, image...)
+
+	frame := append([]byte("APIC"), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, body...)
+
+	size := len(frame)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(header, frame...)
+}
+
+func TestCoverCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(path, []byte("no tags"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cc := &coverCache{}
+	stat := func() os.FileInfo {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	info := stat()
+	if cc.hasCover(path, info) {
+		t.Fatal("没有标签的文件不应有封面")
+	}
+	// 文件未修改时使用缓存结果，不再读取文件
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if cc.hasCover(path, info) {
+		t.Error("文件未修改时应该使用缓存结果")
+	}
+
+	// 文件修改后重新读取
+	if err := os.WriteFile(path, id3WithCover([]byte("jpeg-data")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !cc.hasCover(path, stat()) {
+		t.Error("文件修改后应该重新读取封面")
+	}
+}
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+// 只有来自可信代理的请求才使用转发的协议和主机
+func TestBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, err := parseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		publicURL string
+		remote    string
+		tls       bool
+		headers   map[string]string
+		want      string
+	}{
+		{"配置了对外地址", "https://music.example.com/", "203.0.113.9:1234", false,
+			map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "evil.example"}, "https://music.example.com"},
+		{"直接访问", "", "203.0.113.9:1234", false, nil, "http://hub.local"},
+		{"直接访问 HTTPS", "", "203.0.113.9:1234", true, nil, "https://hub.local"},
+		{"客户端伪造转发头", "", "203.0.113.9:1234", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://hub.local"},
+		{"可信代理", "", "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "music.example.com"}, "https://music.example.com"},
+		{"可信网段", "", "192.168.1.20:1234", false,
+			map[string]string{"X-Forwarded-Proto": "https"}, "https://hub.local"},
+		{"可信代理转发无效协议", "", "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-Proto": "javascript"}, "http://hub.local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &MusicService{cfg: &MusicConfig{PublicURL: tt.publicURL}, trustedProxies: proxies}
+			req := httptest.NewRequest(http.MethodGet, "http://hub.local/music/feed/token", nil)
+			req.RemoteAddr = tt.remote
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			if got := ms.baseURL(c); got != tt.want {
+				t.Errorf("baseURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("无效的代理地址应该报错")
+	}
+}
+
+// 歌单订阅源按歌单顺序列出可访问的音乐，链接使用订阅令牌当前的版本签名，只能订阅自己的歌单
+func TestPlaylistFeed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	for _, name := range []string{"b.mp3", "a.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, mock := newMockDB(t)
+	ms := &MusicService{
+		cfg: &MusicConfig{PublicURL: "https://music.example.com"},
+		db:  db,
+		libraries: map[string]*Library{
+			"music":   {cfg: LibraryConfig{Name: "music", Dir: dir, AllowUsers: []string{"alice"}}},
+			"private": {cfg: LibraryConfig{Name: "private", Dir: dir, AllowUsers: []string{"carol"}}},
+		},
+		libraryNames: []string{"music", "private"},
+		covers:       &coverCache{},
+	}
+	r := gin.New()
+	r.GET("/music/feed/:token/playlists/:id", ms.GetPlaylistFeed)
+
+	feedToken := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "token", "version"}).AddRow("u1", "feed-token", 2)
+	}
+	mock.ExpectQuery("SELECT \\* FROM `feed_tokens` WHERE token = \\?").
+		WithArgs("feed-token", 1).WillReturnRows(feedToken())
+	mock.ExpectQuery("SELECT \\* FROM `playlists` WHERE id = \\? AND user_id = \\?").
+		WithArgs(7, "u1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "description"}).AddRow(7, "u1", "Road Trip", ""))
+	mock.ExpectQuery("SELECT id, username FROM `users`").
+		WithArgs("u1").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow("u1", "alice"))
+	added := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE playlist_items.playlist_id = ? AND musics.library IN (?) ORDER BY playlist_items.position ASC, playlist_items.id ASC")).
+		WithArgs(7, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "library", "name", "file_path", "item_id", "added_at"}).
+			AddRow(2, "music", "Song B", "b.mp3", 11, added).
+			AddRow(1, "music", "Song A", "a.mp3", 12, added.Add(time.Hour)).
+			AddRow(2, "music", "Song B", "b.mp3", 13, added.Add(2*time.Hour)))
+	mock.ExpectQuery("SELECT \\* FROM `feed_tokens` WHERE user_id = \\?").
+		WithArgs("u1", 1).WillReturnRows(feedToken())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/music/feed/feed-token/playlists/7", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var feed rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Channel.Title != "Road Trip" || !strings.Contains(w.Body.String(), "<itunes:author>alice</itunes:author>") {
+		t.Errorf("频道信息错误: %s", w.Body.String())
+	}
+	wantItems := []struct {
+		title, guid string
+		musicID     uint
+	}{
+		{"Song B", "playlist-item-11", 2},
+		{"Song A", "playlist-item-12", 1},
+		{"Song B", "playlist-item-13", 2},
+	}
+	if len(feed.Channel.Items) != len(wantItems) {
+		t.Fatalf("节目数量为 %d", len(feed.Channel.Items))
+	}
+	for i, want := range wantItems {
+		item := feed.Channel.Items[i]
+		if item.Title != want.title || item.GUID.Value != want.guid {
+			t.Errorf("第 %d 个节目为 %q %q", i, item.Title, item.GUID.Value)
+		}
+		prefix := fmt.Sprintf("https://music.example.com/music/signed/%d/stream.mp3?", want.musicID)
+		if !strings.HasPrefix(item.Enclosure.URL, prefix) {
+			t.Fatalf("播放链接错误: %s", item.Enclosure.URL)
+		}
+		query, err := url.ParseQuery(strings.TrimPrefix(item.Enclosure.URL, prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.Get("uid") != "u1" || query.Get("sig") != signLink(signedKindStream, want.musicID, "u1", 2, expires) {
+			t.Errorf("播放链接应使用订阅令牌当前的版本签名: %v", query)
+		}
+	}
+
+	// 其他用户的歌单按不存在处理
+	mock.ExpectQuery("SELECT \\* FROM `feed_tokens` WHERE token = \\?").
+		WithArgs("feed-token", 1).WillReturnRows(feedToken())
+	mock.ExpectQuery("SELECT \\* FROM `playlists` WHERE id = \\? AND user_id = \\?").
+		WithArgs(8, "u1", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/music/feed/feed-token/playlists/8", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("其他用户的歌单应返回 404，status = %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
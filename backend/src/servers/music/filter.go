@@ -0,0 +1,208 @@
+package music
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 默认支持的音频扩展名
+var DefaultExtensions = []string{
+	".mp3", ".flac", ".wav", ".aac", ".ogg", ".m4a",
+	".opus", ".wma", ".ape", ".dsf",
+}
+
+// 部分格式系统 mime 表中没有，下载时需要正确的 Content-Type
+var extraMimeTypes = map[string]string{
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".opus": "audio/opus",
+	".wma":  "audio/x-ms-wma",
+	".ape":  "audio/x-ape",
+	".dsf":  "audio/x-dsf",
+}
+
+func init() {
+	for ext, typ := range extraMimeTypes {
+		if mime.TypeByExtension(ext) == "" {
+			_ = mime.AddExtensionType(ext, typ)
+		}
+	}
+}
+
+// FileFilter 决定音乐库中的哪些文件会被索引
+// 扫描和实时监控共用同一个过滤器，保证两者行为一致
+type FileFilter struct {
+	root       string
+	extensions map[string]bool
+	mimeTypes  []string
+	global     ignoreRules
+
+	mu       sync.Mutex
+	dirRules map[string]ignoreRules // 目录相对路径 -> .hubignore 规则
+}
+
+func NewFileFilter(root string, cfg *MusicConfig) *FileFilter {
+	extensions := cfg.Extensions
+	if len(extensions) == 0 {
+		extensions = DefaultExtensions
+	}
+	extSet := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extSet[ext] = true
+	}
+
+	patterns := cfg.IgnorePatterns
+	if patterns == nil {
+		patterns = DefaultIgnorePatterns
+	}
+
+	return &FileFilter{
+		root:       root,
+		extensions: extSet,
+		mimeTypes:  cfg.MimeTypes,
+		global:     parseIgnoreRules(patterns),
+		dirRules:   make(map[string]ignoreRules),
+	}
+}
+
+// IsIgnored 判断文件或目录是否被忽略规则排除，父目录被忽略时子路径同样被忽略
+func (f *FileFilter) IsIgnored(filePath string, isDir bool) bool {
+	rel, ok := f.relPath(filePath)
+	if !ok {
+		return true
+	}
+	if rel == "." {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if f.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return f.ignored(rel, isDir)
+}
+
+// Accept 判断文件是否应被索引为音乐
+func (f *FileFilter) Accept(filePath string) bool {
+	if path.Base(filepath.ToSlash(filePath)) == IgnoreFileName {
+		return false
+	}
+	if f.IsIgnored(filePath, false) {
+		return false
+	}
+	return f.isMusicFile(filePath)
+}
+
+// InvalidateDir 在 .hubignore 变化后清除该目录的规则缓存
+func (f *FileFilter) InvalidateDir(dir string) {
+	rel, ok := f.relPath(dir)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	delete(f.dirRules, rel)
+	f.mu.Unlock()
+}
+
+func (f *FileFilter) isMusicFile(filePath string) bool {
+	if f.extensions[strings.ToLower(filepath.Ext(filePath))] {
+		return true
+	}
+	if len(f.mimeTypes) == 0 {
+		return false
+	}
+
+	contentType := detectContentType(filePath)
+	for _, allowed := range f.mimeTypes {
+		if matchMimeType(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按 gitignore 语义依次应用全局规则和从根目录到父目录的 .hubignore，后命中的规则优先
+func (f *FileFilter) ignored(rel string, isDir bool) bool {
+	_, ignored := f.global.match(rel, isDir)
+
+	dir := "."
+	parts := strings.Split(rel, "/")
+	for i := 0; i < len(parts); i++ {
+		if i > 0 {
+			dir = strings.Join(parts[:i], "/")
+		}
+		rules := f.rulesForDir(dir)
+		if len(rules) == 0 {
+			continue
+		}
+		sub := strings.Join(parts[i:], "/")
+		if matched, ign := rules.match(sub, isDir); matched {
+			ignored = ign
+		}
+	}
+	return ignored
+}
+
+func (f *FileFilter) rulesForDir(dir string) ignoreRules {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if rules, ok := f.dirRules[dir]; ok {
+		return rules
+	}
+	rules := loadIgnoreFile(filepath.Join(f.root, filepath.FromSlash(dir), IgnoreFileName))
+	f.dirRules[dir] = rules
+	return rules
+}
+
+func (f *FileFilter) relPath(filePath string) (string, bool) {
+	rel, err := filepath.Rel(f.root, filePath)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+func detectContentType(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, _ := file.Read(buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// 支持 audio/* 形式的通配
+func matchMimeType(pattern, contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == contentType
+}
@@ -0,0 +1,442 @@
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 无缝播放信息：有损编码器会在音频前后补充静音采样，专辑内连续的曲目（现场录音、古典乐）
+// 需要播放器按采样精确裁掉这些静音才能无缝衔接
+
+// MP3 解码器固有的输出延迟
+const mp3DecoderDelay = 529
+
+var errNoGapless = errors.New("无法读取无缝播放信息")
+
+// gaplessInfo 单位均为采样（每声道）
+type gaplessInfo struct {
+	SampleRate  int
+	SampleCount int64 // 有效采样数，不含编码器补充的静音
+	Delay       int   // 解码输出开头需要丢弃的采样数
+	Padding     int   // 解码输出末尾需要丢弃的采样数
+}
+
+// readGapless 按扩展名读取无缝播放信息，不支持的格式返回 errNoGapless
+func readGapless(filePath string) (*gaplessInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3":
+		info, err := readMP3Gapless(file)
+		if err != nil {
+			return nil, err
+		}
+		// 没有 LAME 标签时使用 iTunes 写入的信息
+		if info.Delay == 0 && info.Padding == 0 {
+			if smpb := readID3iTunSMPB(filePath); smpb != nil && smpb.SampleCount > 0 {
+				smpb.SampleRate = info.SampleRate
+				return smpb, nil
+			}
+		}
+		return info, nil
+	case ".m4a", ".m4b", ".mp4", ".aac", ".alac":
+		return readMP4Gapless(file)
+	case ".flac":
+		return readFLACGapless(file)
+	case ".wav", ".wave":
+		return readWAVGapless(file)
+	case ".ogg", ".oga", ".opus":
+		return readOggGapless(file)
+	}
+	return nil, errNoGapless
+}
+
+// readMP3Gapless 读取首帧中的 Xing/Info 和 LAME 标签，没有帧数信息时逐帧计数
+func readMP3Gapless(r io.Reader) (*gaplessInfo, error) {
+	fr := newMP3FrameReader(r)
+	h, frame, err := fr.Next()
+	if err != nil {
+		return nil, errNoGapless
+	}
+
+	info := &gaplessInfo{SampleRate: h.sampleRate}
+	frames := int64(-1)
+	hasXing := false
+	var encoderDelay, encoderPadding int
+
+	// Xing 标签位于帧头和边信息之后，所在的帧不含音频
+	offset := 4 + mp3SideInfoSize(h)
+	if h.layer == 3 && len(frame) >= offset+8 {
+		switch string(frame[offset : offset+4]) {
+		case "Xing", "Info":
+			hasXing = true
+			frames, encoderDelay, encoderPadding = parseXing(frame[offset:])
+		}
+	}
+	if !hasXing && len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		hasXing = true
+		frames = int64(binary.BigEndian.Uint32(frame[36+14:]))
+	}
+
+	if frames < 0 {
+		frames = 0
+		if !hasXing {
+			frames = 1
+		}
+		for {
+			if _, _, err := fr.Next(); err != nil {
+				break
+			}
+			frames++
+		}
+	}
+
+	total := frames * int64(h.samples)
+	if encoderDelay > 0 || encoderPadding > 0 {
+		info.Delay = encoderDelay + mp3DecoderDelay
+		info.Padding = max(0, encoderPadding-mp3DecoderDelay)
+		total -= int64(encoderDelay + encoderPadding)
+	}
+	info.SampleCount = max(0, total)
+	return info, nil
+}
+
+// mp3SideInfoSize 返回 Layer III 边信息的长度
+func mp3SideInfoSize(h *mp3FrameHeader) int {
+	if h.version == mpegVersion1 {
+		if h.channels == 1 {
+			return 17
+		}
+		return 32
+	}
+	if h.channels == 1 {
+		return 9
+	}
+	return 17
+}
+
+// parseXing 返回音频帧数（不含 Xing 帧本身，未记录时为 -1）以及 LAME 标签中的编码器延迟和填充
+func parseXing(b []byte) (int64, int, int) {
+	flags := binary.BigEndian.Uint32(b[4:8])
+	pos := 8
+	frames := int64(-1)
+	if flags&0x1 != 0 {
+		if len(b) < pos+4 {
+			return -1, 0, 0
+		}
+		frames = int64(binary.BigEndian.Uint32(b[pos:]))
+		pos += 4
+	}
+	if flags&0x2 != 0 {
+		pos += 4 // 字节数
+	}
+	if flags&0x4 != 0 {
+		pos += 100 // TOC
+	}
+	if flags&0x8 != 0 {
+		pos += 4 // 质量
+	}
+
+	// LAME 标签：编码器版本(9) 修订号(1) 低通(1) 峰值(4) 增益(2+2) 标志(1) 码率(1) 延迟和填充各 12 位(3)
+	if len(b) < pos+24 {
+		return frames, 0, 0
+	}
+	encoder := string(b[pos : pos+4])
+	if encoder != "LAME" && encoder != "Lavf" && encoder != "Lavc" && encoder != "L3.9" {
+		return frames, 0, 0
+	}
+	d := b[pos+21 : pos+24]
+	delay := int(d[0])<<4 | int(d[1])>>4
+	padding := int(d[1]&0x0F)<<8 | int(d[2])
+	return frames, delay, padding
+}
+
+// parseiTunSMPB 解析 iTunes 的无缝播放信息，格式为十六进制字段：
+// " 00000000 00000840 000001CA 00000000000D9CF6 ..." 依次为保留、开头延迟、末尾填充、有效采样数
+func parseiTunSMPB(value string) *gaplessInfo {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return nil
+	}
+	delay, err1 := strconv.ParseInt(fields[1], 16, 64)
+	padding, err2 := strconv.ParseInt(fields[2], 16, 64)
+	count, err3 := strconv.ParseInt(fields[3], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil
+	}
+	return &gaplessInfo{SampleCount: count, Delay: int(delay), Padding: int(padding)}
+}
+
+// readID3iTunSMPB 读取 iTunes 编码的 MP3 中描述为 iTunSMPB 的 COMM 帧
+func readID3iTunSMPB(filePath string) *gaplessInfo {
+	var info *gaplessInfo
+	_ = readID3v2Frames(filePath, func(id string, body []byte) bool {
+		if id != "COMM" || len(body) < 5 {
+			return true
+		}
+		// COMM: 编码(1) 语言(3) 描述(以 0 结尾) 文本，iTunes 使用 ISO-8859-1 编码
+		rest := body[4:]
+		end := bytes.IndexByte(rest, 0)
+		if body[0] != 0 || end < 0 || string(rest[:end]) != "iTunSMPB" {
+			return true
+		}
+		info = parseiTunSMPB(string(bytes.TrimRight(rest[end+1:], "\x00")))
+		return info == nil
+	})
+	return info
+}
+
+// readMP4Gapless 读取 moov 中的 iTunSMPB，没有时使用音频轨道的时长
+func readMP4Gapless(r io.ReadSeeker) (*gaplessInfo, error) {
+	moov, err := findMP4Box(r, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &gaplessInfo{}
+	walkMP4Boxes(moov, func(path string, body []byte) bool {
+		switch path {
+		case "trak/mdia/mdhd":
+			// 只取第一个轨道，音乐文件通常只有一个音频轨道
+			if info.SampleRate == 0 {
+				info.SampleRate, info.SampleCount = parseMDHD(body)
+			}
+		case "udta/meta/ilst/----":
+			if smpb := parseFreeformSMPB(body); smpb != nil {
+				info.Delay, info.Padding = smpb.Delay, smpb.Padding
+				info.SampleCount = smpb.SampleCount
+			}
+		}
+		return true
+	})
+	if info.SampleRate == 0 {
+		return nil, errNoGapless
+	}
+	return info, nil
+}
+
+// findMP4Box 在顶层查找指定的 box 并读取其内容
+func findMP4Box(r io.ReadSeeker, name string) ([]byte, error) {
+	var header [16]byte
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, errNoGapless
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			return nil, errNoGapless
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return nil, errNoGapless
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return nil, errNoGapless
+		}
+		if string(header[4:8]) == name {
+			if size-headerSize > 64<<20 {
+				return nil, errNoGapless
+			}
+			body := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, errNoGapless
+			}
+			return body, nil
+		}
+		if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return nil, errNoGapless
+		}
+	}
+}
+
+// 包含子 box 的容器，meta 在子 box 前有 4 字节的版本和标志
+var mp4Containers = map[string]int{
+	"trak": 0, "mdia": 0, "udta": 0, "meta": 4, "ilst": 0,
+}
+
+// walkMP4Boxes 深度优先遍历 box，path 为相对 moov 的路径
+func walkMP4Boxes(data []byte, fn func(path string, body []byte) bool) {
+	var walk func(data []byte, prefix string) bool
+	walk = func(data []byte, prefix string) bool {
+		for len(data) >= 8 {
+			size := int(binary.BigEndian.Uint32(data[:4]))
+			name := string(data[4:8])
+			if size < 8 || size > len(data) {
+				return true
+			}
+			body := data[8:size]
+			path := prefix + name
+			if !fn(path, body) {
+				return false
+			}
+			if skip, ok := mp4Containers[name]; ok && len(body) >= skip {
+				if !walk(body[skip:], path+"/") {
+					return false
+				}
+			}
+			data = data[size:]
+		}
+		return true
+	}
+	walk(data, "")
+}
+
+// parseMDHD 返回时间单位（音频轨道即采样率）和时长
+func parseMDHD(body []byte) (int, int64) {
+	if len(body) < 24 {
+		return 0, 0
+	}
+	if body[0] == 1 {
+		if len(body) < 36 {
+			return 0, 0
+		}
+		return int(binary.BigEndian.Uint32(body[20:24])), int64(binary.BigEndian.Uint64(body[24:32]))
+	}
+	return int(binary.BigEndian.Uint32(body[12:16])), int64(binary.BigEndian.Uint32(body[16:20]))
+}
+
+// parseFreeformSMPB 解析 ---- 自定义标签，内含 mean、name 和 data 三个 box
+func parseFreeformSMPB(body []byte) *gaplessInfo {
+	var name, value string
+	walkMP4Boxes(body, func(path string, b []byte) bool {
+		if len(b) < 4 {
+			return true
+		}
+		switch path {
+		case "name":
+			name = string(b[4:])
+		case "data":
+			// 类型(4) 区域(4) 数据
+			if len(b) >= 8 {
+				value = string(b[8:])
+			}
+		}
+		return true
+	})
+	if name != "iTunSMPB" {
+		return nil
+	}
+	return parseiTunSMPB(value)
+}
+
+// readFLACGapless 读取 STREAMINFO 中的采样率和总采样数，FLAC 为无损格式，没有填充
+func readFLACGapless(r io.Reader) (*gaplessInfo, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(10)
+	if err != nil {
+		return nil, errNoGapless
+	}
+	if string(head[:3]) == "ID3" {
+		if _, err := br.Discard(10 + syncsafe(head[6:10])); err != nil {
+			return nil, errNoGapless
+		}
+	}
+
+	var block [4 + 4 + 34]byte
+	if _, err := io.ReadFull(br, block[:]); err != nil || string(block[:4]) != "fLaC" || block[4]&0x7F != 0 {
+		return nil, errNoGapless
+	}
+	info := block[8:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	total := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 {
+		return nil, errNoGapless
+	}
+	return &gaplessInfo{SampleRate: sampleRate, SampleCount: total}, nil
+}
+
+// readWAVGapless 按数据块大小计算采样数
+func readWAVGapless(r io.Reader) (*gaplessInfo, error) {
+	br := bufio.NewReader(r)
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil || string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errNoGapless
+	}
+
+	info := &gaplessInfo{}
+	blockAlign := 0
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			return nil, errNoGapless
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, body); err != nil || size < 16 {
+				return nil, errNoGapless
+			}
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			blockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+		case "data":
+			if blockAlign == 0 {
+				return nil, errNoGapless
+			}
+			info.SampleCount = size / int64(blockAlign)
+			return info, nil
+		default:
+			if _, err := br.Discard(int(size + size%2)); err != nil {
+				return nil, errNoGapless
+			}
+		}
+	}
+}
+
+// readOggGapless 从首页识别 Vorbis/Opus，从末页的 granule position 得到总采样数
+func readOggGapless(r io.ReadSeeker) (*gaplessInfo, error) {
+	first := make([]byte, 512)
+	n, _ := io.ReadFull(r, first)
+	first = first[:n]
+	if n < 28 || string(first[:4]) != "OggS" {
+		return nil, errNoGapless
+	}
+	packet := first[27+int(first[26]):]
+
+	info := &gaplessInfo{}
+	switch {
+	case len(packet) >= 16 && string(packet[1:7]) == "vorbis":
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		// Opus 固定以 48kHz 解码，pre-skip 为开头需要丢弃的采样
+		info.SampleRate = 48000
+		info.Delay = int(binary.LittleEndian.Uint16(packet[10:12]))
+	default:
+		return nil, errNoGapless
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errNoGapless
+	}
+	start := max(0, end-64*1024)
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, errNoGapless
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errNoGapless
+	}
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || len(tail) < i+14 {
+		return nil, errNoGapless
+	}
+	granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+	info.SampleCount = max(0, granule-int64(info.Delay))
+	return info, nil
+}
@@ -0,0 +1,215 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+var errNoCover = errors.New("未找到封面")
+
+// 从文件标签或目录结构中获取的音乐信息
+type musicTags struct {
+	Title  string
+	Artist string
+	Album  string
+}
+
+// readID3v2Frames 遍历 MP3 文件 ID3v2.3/2.4 标签中的帧，fn 返回 false 时停止
+func readID3v2Frames(filePath string, fn func(id string, body []byte) bool) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:3]) != "ID3" {
+		return errNoCover
+	}
+	version := header[3]
+	if version < 3 || version > 4 {
+		// ID3v2.2 使用 3 字节帧头（PIC），较少见，暂不支持
+		return errNoCover
+	}
+	size := syncsafe(header[6:10])
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(file, tag); err != nil {
+		return errNoCover
+	}
+
+	for len(tag) >= 10 {
+		id := string(tag[:4])
+		if id[0] == 0 {
+			break // 填充区
+		}
+		var frameSize int
+		if version == 4 {
+			frameSize = syncsafe(tag[4:8])
+		} else {
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		}
+		if frameSize <= 0 || frameSize > len(tag)-10 {
+			break
+		}
+		if !fn(id, tag[10:10+frameSize]) {
+			return nil
+		}
+		tag = tag[10+frameSize:]
+	}
+	return nil
+}
+
+// extractCover 读取 ID3v2 标签中的封面图片（APIC 帧），返回图片数据和 MIME 类型
+func extractCover(filePath string) ([]byte, string, error) {
+	var data []byte
+	var mimeType string
+	err := readID3v2Frames(filePath, func(id string, body []byte) bool {
+		if id != "APIC" {
+			return true
+		}
+		var err error
+		data, mimeType, err = parseAPIC(body)
+		return err != nil
+	})
+	if err != nil || data == nil {
+		return nil, "", errNoCover
+	}
+	return data, mimeType, nil
+}
+
+// readTags 读取标题、艺术家和专辑，标签缺失时按 艺术家/专辑/文件 的目录结构推断
+func readTags(filePath, relativePath string) musicTags {
+	var tags musicTags
+	_ = readID3v2Frames(filePath, func(id string, body []byte) bool {
+		switch id {
+		case "TIT2":
+			tags.Title = decodeID3Text(body)
+		case "TPE1":
+			tags.Artist = decodeID3Text(body)
+		case "TALB":
+			tags.Album = decodeID3Text(body)
+		}
+		return true
+	})
+
+	dirs := strings.Split(strings.Trim(path.Dir(relativePath), "/"), "/")
+	if len(dirs) == 1 && dirs[0] == "" {
+		dirs = nil
+	}
+	if tags.Album == "" && len(dirs) >= 1 {
+		tags.Album = dirs[len(dirs)-1]
+	}
+	if tags.Artist == "" && len(dirs) >= 2 {
+		tags.Artist = dirs[len(dirs)-2]
+	}
+	if tags.Title == "" {
+		tags.Title = getFileName(relativePath)
+	}
+	return tags
+}
+
+// 文本帧：编码(1) 文本，多个值以 0 分隔时只取第一个
+func decodeID3Text(body []byte) string {
+	if len(body) < 2 {
+		return ""
+	}
+	text := body[1:]
+
+	var s string
+	switch body[0] {
+	case 1, 2: // UTF-16 带 BOM / UTF-16BE
+		bigEndian := body[0] == 2
+		if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			bigEndian, text = true, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			bigEndian, text = false, text[2:]
+		}
+		u := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			var v uint16
+			if bigEndian {
+				v = binary.BigEndian.Uint16(text[i:])
+			} else {
+				v = binary.LittleEndian.Uint16(text[i:])
+			}
+			if v == 0 {
+				break
+			}
+			u = append(u, v)
+		}
+		s = string(utf16.Decode(u))
+	case 3: // UTF-8
+		if i := bytes.IndexByte(text, 0); i >= 0 {
+			text = text[:i]
+		}
+		s = string(text)
+	default: // ISO-8859-1
+		if i := bytes.IndexByte(text, 0); i >= 0 {
+			text = text[:i]
+		}
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		s = string(runes)
+	}
+	return strings.TrimSpace(s)
+}
+
+// APIC: 编码(1) MIME(以0结尾) 图片类型(1) 描述(按编码以0或00结尾) 图片数据
+func parseAPIC(body []byte) ([]byte, string, error) {
+	if len(body) < 4 {
+		return nil, "", errNoCover
+	}
+	encoding := body[0]
+	rest := body[1:]
+
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, "", errNoCover
+	}
+	mimeType := string(rest[:end])
+	rest = rest[end+1:]
+	if len(rest) < 1 {
+		return nil, "", errNoCover
+	}
+	rest = rest[1:] // 图片类型
+
+	// UTF-16 编码的描述以两个 0 字节结尾
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				rest = rest[i+2:]
+				break
+			}
+		}
+	} else {
+		end = bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return nil, "", errNoCover
+		}
+		rest = rest[end+1:]
+	}
+
+	if len(rest) == 0 {
+		return nil, "", errNoCover
+	}
+	if mimeType == "" || mimeType == "-->" {
+		mimeType = "image/jpeg"
+	} else if mimeType == "JPG" || mimeType == "jpg" {
+		mimeType = "image/jpeg"
+	} else if mimeType == "PNG" || mimeType == "png" {
+		mimeType = "image/png"
+	}
+	return rest, mimeType, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
@@ -0,0 +1,122 @@
+package music
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// 每个目录下的忽略规则文件，语法与 .gitignore 相同
+const IgnoreFileName = ".hubignore"
+
+// 默认忽略的目录：群晖缩略图、回收站以及未下载完成的文件
+var DefaultIgnorePatterns = []string{"@eaDir/", ".Trash*/", "#recycle/", "incomplete/"}
+
+type ignoreRule struct {
+	pattern  string
+	negate   bool // 以 ! 开头，重新包含被忽略的文件
+	dirOnly  bool // 以 / 结尾，只匹配目录
+	anchored bool // 包含 /，相对规则所在目录匹配完整路径
+}
+
+type ignoreRules []ignoreRule
+
+func parseIgnoreRules(lines []string) ignoreRules {
+	var rules ignoreRules
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// \# 和 \! 表示字面量
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func loadIgnoreFile(filePath string) ignoreRules {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return parseIgnoreRules(lines)
+}
+
+// match 判断相对规则所在目录的路径 rel 是否匹配
+// 返回值 matched 表示是否有规则命中，ignored 表示最后命中的规则是否忽略该路径
+func (rules ignoreRules) match(rel string, isDir bool) (matched, ignored bool) {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		var ok bool
+		if rule.anchored {
+			ok = globMatch(rule.pattern, rel)
+		} else {
+			ok = globMatch(rule.pattern, path.Base(rel))
+		}
+		if ok {
+			matched = true
+			ignored = !rule.negate
+		}
+	}
+	return matched, ignored
+}
+
+// globMatch 按 / 分段匹配，支持 ** 匹配任意层目录
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,270 @@
+package music
+
+import (
+	"fmt"
+	"myapp/middleware"
+	"path/filepath"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 未配置 Libraries 时使用 MusicDir 作为默认音乐库
+const DefaultLibraryName = "default"
+
+type LibraryConfig struct {
+	Name       string   `json:"name"`
+	Dir        string   `json:"dir"`
+	Public     bool     `json:"public"`      // 匿名用户也可访问
+	AllowUsers []string `json:"allow_users"` // 允许访问的用户ID或用户名
+	AllowRoles []string `json:"allow_roles"` // 允许访问的角色名
+	// 由其他服务管理的音乐库，不能通过音乐接口访问，由该服务按自己的规则检查权限后提供文件
+	Internal bool `json:"-"`
+}
+
+type Library struct {
+	cfg     LibraryConfig
+	watcher *FileWatcher
+}
+
+type LibraryResponse struct {
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+// 发起请求的用户，未登录时 ID 为空
+type caller struct {
+	ID       string
+	Username string
+	Roles    []string `gorm:"-"`
+	Scopes   []string `gorm:"-"` // 使用 API 密钥时的范围，nil 表示不受限制
+}
+
+func (c *caller) loggedIn() bool {
+	return c.ID != ""
+}
+
+// 判断用户是否具有该权限
+func (c *caller) can(permission string) bool {
+	return c.loggedIn() && middleware.HasPermission(c.Roles, permission) && middleware.ScopesAllow(c.Scopes, permission)
+}
+
+// 判断用户是否可以访问该音乐库
+func (lib *Library) canAccess(c *caller) bool {
+	if lib.cfg.Internal {
+		return false
+	}
+	if lib.cfg.Public {
+		return true
+	}
+	if !c.loggedIn() {
+		return false
+	}
+	// 未设置任何限制时，所有登录用户都可访问
+	if len(lib.cfg.AllowUsers) == 0 && len(lib.cfg.AllowRoles) == 0 {
+		return true
+	}
+	if slices.Contains(lib.cfg.AllowUsers, c.ID) || slices.Contains(lib.cfg.AllowUsers, c.Username) {
+		return true
+	}
+	for _, role := range c.Roles {
+		if slices.Contains(lib.cfg.AllowRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLibrary 追加一个音乐库，未配置 Libraries 时保留由 MusicDir 生成的默认音乐库
+func (cfg *MusicConfig) AddLibrary(lib LibraryConfig) {
+	cfg.Libraries = append(libraryConfigs(cfg), lib)
+}
+
+func libraryConfigs(cfg *MusicConfig) []LibraryConfig {
+	if len(cfg.Libraries) > 0 {
+		return cfg.Libraries
+	}
+	return []LibraryConfig{{
+		Name:   DefaultLibraryName,
+		Dir:    cfg.MusicDir,
+		Public: true,
+	}}
+}
+
+// 解析当前请求的用户
+// 请求中的角色来自访问令牌，其他场景从数据库查询
+func (ms *MusicService) resolveCaller(c *gin.Context) *caller {
+	user := ms.findCaller(c.GetString("user_id"))
+	if user.loggedIn() {
+		user.Roles = middleware.CurrentRoles(c)
+		user.Scopes = middleware.CurrentScopes(c)
+	}
+	return user
+}
+
+func (ms *MusicService) lookupCaller(userID string) *caller {
+	user := ms.findCaller(userID)
+	if user.loggedIn() && middleware.LookupRoles != nil {
+		user.Roles = middleware.LookupRoles(userID)
+	}
+	return user
+}
+
+func (ms *MusicService) findCaller(userID string) *caller {
+	if userID == "" {
+		return &caller{}
+	}
+
+	var user caller
+	err := ms.db.Table("users").
+		Select("id, username").
+		Where("id = ?", userID).
+		Scan(&user).Error
+	if err != nil || user.ID == "" {
+		return &caller{}
+	}
+	return &user
+}
+
+// 获取当前用户可访问的音乐库名称
+func (ms *MusicService) accessibleLibraries(c *gin.Context) []string {
+	return ms.librariesFor(ms.resolveCaller(c))
+}
+
+func (ms *MusicService) librariesFor(user *caller) []string {
+	var names []string
+	for _, name := range ms.libraryNames {
+		if ms.libraries[name].canAccess(user) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (ms *MusicService) getLibrary(name string) (*Library, bool) {
+	lib, ok := ms.libraries[name]
+	return lib, ok
+}
+
+// 获取音乐文件在磁盘上的完整路径
+func (ms *MusicService) musicFilePath(music *Music) (string, bool) {
+	lib, ok := ms.getLibrary(music.Library)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(lib.cfg.Dir, filepath.FromSlash(music.FilePath)), true
+}
+
+// IndexFile 立即将音乐库目录下的文件加入数据库，供其他服务在写入文件后获取音乐记录
+func (ms *MusicService) IndexFile(library, filePath string) (*Music, error) {
+	lib, ok := ms.getLibrary(library)
+	if !ok {
+		return nil, fmt.Errorf("音乐库不存在: %s", library)
+	}
+	if !lib.watcher.filter.Accept(filePath) {
+		return nil, fmt.Errorf("不支持的文件类型: %s", filepath.Base(filePath))
+	}
+	return lib.watcher.indexFile(filePath)
+}
+
+// LibraryDir 返回音乐库的目录
+func (ms *MusicService) LibraryDir(library string) (string, bool) {
+	lib, ok := ms.getLibrary(library)
+	if !ok {
+		return "", false
+	}
+	return lib.cfg.Dir, true
+}
+
+// PublicLibraries 返回匿名用户可访问的音乐库，供无认证的局域网协议使用
+func (ms *MusicService) PublicLibraries() []string {
+	return ms.librariesFor(&caller{})
+}
+
+// MusicFilePath 返回音乐文件在磁盘上的完整路径
+func (ms *MusicService) MusicFilePath(music *Music) (string, bool) {
+	return ms.musicFilePath(music)
+}
+
+// AllLibraries 返回所有音乐库名称，供后台任务使用
+func (ms *MusicService) AllLibraries() []string {
+	return slices.Clone(ms.libraryNames)
+}
+
+// UpdateTags 修改音乐的标签信息，不写回文件。重新扫描时不会覆盖已有的标签
+func (ms *MusicService) UpdateTags(musicID uint, title, artist, album string) (*Music, error) {
+	var music Music
+	if err := ms.db.First(&music, musicID).Error; err != nil {
+		return nil, err
+	}
+	err := ms.db.Model(&music).Updates(map[string]interface{}{
+		"title":  title,
+		"artist": artist,
+		"album":  album,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	music.Title, music.Artist, music.Album = title, artist, album
+	ms.events.Publish(LibraryEvent{Library: music.Library, Type: EventTrackUpdated, Music: &music})
+	return &music, nil
+}
+
+// RescanLibraries 重新扫描指定的音乐库，所有音乐库都在扫描中时返回 false
+func (ms *MusicService) RescanLibraries(libraries []string) bool {
+	started := false
+	for _, name := range libraries {
+		if lib, ok := ms.getLibrary(name); ok && lib.watcher.Rescan() {
+			started = true
+		}
+	}
+	return started
+}
+
+// SubscribeEvents 订阅音乐库变化事件，使用完毕后需调用 UnsubscribeEvents
+func (ms *MusicService) SubscribeEvents(libraries []string) chan LibraryEvent {
+	return ms.events.Subscribe(libraries)
+}
+
+func (ms *MusicService) UnsubscribeEvents(ch chan LibraryEvent) {
+	ms.events.Unsubscribe(ch)
+}
+
+// DeleteUserData 删除用户在音乐服务中的数据（收藏、歌单、评分、标签、评论、播放队列、分享、订阅令牌和电台），
+// 在删除用户的事务中调用
+func (ms *MusicService) DeleteUserData(tx *gorm.DB, userID string) error {
+	var channelIDs []uint
+	if err := tx.Model(&Channel{}).Where("owner_id = ?", userID).Pluck("id", &channelIDs).Error; err != nil {
+		return err
+	}
+
+	playlists := tx.Model(&Playlist{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("playlist_id IN (?)", playlists).Delete(&PlaylistItem{}).Error; err != nil {
+		return err
+	}
+
+	models := []interface{}{
+		&UserMusic{}, &Playlist{}, &Rating{}, &MusicTag{}, &QueueItem{}, &QueueState{}, &FeedToken{},
+	}
+	for _, model := range models {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	// 其他用户对其评论的回复保留，成为顶层评论
+	if err := tx.Where("user_id = ?", userID).Delete(&Comment{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("owner_id = ?", userID).Delete(&Share{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("owner_id = ?", userID).Delete(&Channel{}).Error; err != nil {
+		return err
+	}
+
+	for _, id := range channelIDs {
+		ms.radio.stopStation(id)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package music
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLibraryCanAccess(t *testing.T) {
+	admin := &caller{ID: "u1", Username: "alice", Roles: []string{"admin"}}
+	member := &caller{ID: "u2", Username: "bob", Roles: []string{"user"}}
+	anonymous := &caller{}
+
+	cases := []struct {
+		name string
+		cfg  LibraryConfig
+		want map[*caller]bool
+	}{
+		{"公开", LibraryConfig{Public: true}, map[*caller]bool{admin: true, member: true, anonymous: true}},
+		{"不限制", LibraryConfig{}, map[*caller]bool{admin: true, member: true, anonymous: false}},
+		{"按用户名", LibraryConfig{AllowUsers: []string{"bob"}}, map[*caller]bool{admin: false, member: true, anonymous: false}},
+		{"按角色", LibraryConfig{AllowRoles: []string{"admin"}}, map[*caller]bool{admin: true, member: false, anonymous: false}},
+		{"内部", LibraryConfig{Public: true, Internal: true}, map[*caller]bool{admin: false, member: false, anonymous: false}},
+	}
+	for _, tc := range cases {
+		lib := &Library{cfg: tc.cfg}
+		for c, want := range tc.want {
+			if got := lib.canAccess(c); got != want {
+				t.Errorf("%s: canAccess(%q) = %v，应为 %v", tc.name, c.Username, got, want)
+			}
+		}
+	}
+}
+
+// 公开电台未登录也可收听，不能播放创建者私有音乐库中的音乐
+func TestChannelLibraries(t *testing.T) {
+	ms := &MusicService{
+		libraries: map[string]*Library{
+			"music":   {cfg: LibraryConfig{Name: "music", Public: true}},
+			"private": {cfg: LibraryConfig{Name: "private", AllowUsers: []string{"alice"}}},
+			"shared":  {cfg: LibraryConfig{Name: "shared"}},
+		},
+		libraryNames: []string{"music", "private", "shared"},
+	}
+	owner := &caller{ID: "u1", Username: "alice"}
+
+	if got := ms.channelLibraries(owner, false); !slices.Equal(got, []string{"music", "private", "shared"}) {
+		t.Errorf("非公开电台的音乐库为 %v", got)
+	}
+	if got := ms.channelLibraries(owner, true); !slices.Equal(got, []string{"music"}) {
+		t.Errorf("公开电台的音乐库为 %v", got)
+	}
+}
@@ -0,0 +1,155 @@
+package music
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+// MP3 帧解析，只读取帧头用于按实际码率推送数据，不做解码
+
+var mp3Bitrates = [2][3][16]int{
+	// MPEG-1: Layer I, II, III
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	// MPEG-2 / 2.5: Layer I, II, III
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+var mp3SampleRates = map[int][3]int{
+	mpegVersion1:  {44100, 48000, 32000},
+	mpegVersion2:  {22050, 24000, 16000},
+	mpegVersion25: {11025, 12000, 8000},
+}
+
+const (
+	mpegVersion25 = 0
+	mpegVersion2  = 2
+	mpegVersion1  = 3
+)
+
+var errNotMP3Frame = errors.New("不是有效的 MP3 帧头")
+
+type mp3FrameHeader struct {
+	version    int
+	layer      int // 1, 2, 3
+	bitrate    int // bps
+	sampleRate int
+	padding    bool
+	channels   int
+	size       int
+	samples    int
+}
+
+func (h *mp3FrameHeader) duration() time.Duration {
+	return time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate)
+}
+
+func parseMP3FrameHeader(b []byte) (*mp3FrameHeader, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return nil, errNotMP3Frame
+	}
+
+	version := int(b[1]>>3) & 0x03
+	layerBits := int(b[1]>>1) & 0x03
+	bitrateIdx := int(b[2]>>4) & 0x0F
+	sampleRateIdx := int(b[2]>>2) & 0x03
+	if version == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || sampleRateIdx == 3 {
+		return nil, errNotMP3Frame
+	}
+
+	h := &mp3FrameHeader{
+		version:    version,
+		layer:      4 - layerBits,
+		sampleRate: mp3SampleRates[version][sampleRateIdx],
+		padding:    b[2]&0x02 != 0,
+		channels:   2,
+	}
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+
+	table := 0
+	if version != mpegVersion1 {
+		table = 1
+	}
+	h.bitrate = mp3Bitrates[table][h.layer-1][bitrateIdx] * 1000
+
+	pad := 0
+	if h.padding {
+		pad = 1
+	}
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.size = (12*h.bitrate/h.sampleRate + pad) * 4
+	case h.layer == 3 && version != mpegVersion1:
+		h.samples = 576
+		h.size = 72*h.bitrate/h.sampleRate + pad
+	default:
+		h.samples = 1152
+		h.size = 144*h.bitrate/h.sampleRate + pad
+	}
+	return h, nil
+}
+
+// mp3FrameReader 逐帧读取 MP3 数据，跳过 ID3v2 标签和无法识别的字节
+type mp3FrameReader struct {
+	r *bufio.Reader
+}
+
+func newMP3FrameReader(r io.Reader) *mp3FrameReader {
+	return &mp3FrameReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (fr *mp3FrameReader) skipID3v2() error {
+	head, err := fr.r.Peek(10)
+	if err != nil || string(head[:3]) != "ID3" {
+		return nil
+	}
+	size := syncsafe(head[6:10])
+	if head[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	_, err = fr.r.Discard(10 + size)
+	return err
+}
+
+// Next 返回下一帧的帧头和完整数据
+func (fr *mp3FrameReader) Next() (*mp3FrameHeader, []byte, error) {
+	for {
+		head, err := fr.r.Peek(4)
+		if err != nil {
+			return nil, nil, io.EOF
+		}
+		if string(head[:3]) == "ID3" {
+			if err := fr.skipID3v2(); err != nil {
+				return nil, nil, io.EOF
+			}
+			continue
+		}
+
+		h, err := parseMP3FrameHeader(head)
+		if err != nil || h.size < 4 {
+			// 丢弃一个字节继续寻找同步字
+			if _, err := fr.r.Discard(1); err != nil {
+				return nil, nil, io.EOF
+			}
+			continue
+		}
+
+		frame := make([]byte, h.size)
+		if _, err := io.ReadFull(fr.r, frame); err != nil {
+			return nil, nil, io.EOF
+		}
+		return h, frame, nil
+	}
+}
@@ -1,28 +1,61 @@
 package music
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 )
 
 type Music struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"type:varchar(255);not null;index;unique" json:"name"`
-	FilePath  string    `gorm:"type:varchar(1024);not null" json:"file_path"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Library  string `gorm:"type:varchar(64);not null;default:'';uniqueIndex:idx_music_library_path_hash" json:"library"`
+	Name     string `gorm:"type:varchar(255);not null;index" json:"name"`
+	FilePath string `gorm:"type:varchar(1024);not null" json:"file_path"` // 库内相对路径，唯一标识曲目
+	// FilePath 的 SHA-256，完整路径超出 MySQL 索引长度限制，按哈希建立库内唯一索引
+	PathHash string `gorm:"type:char(64);not null;default:'';uniqueIndex:idx_music_library_path_hash" json:"-"`
+	Title    string `gorm:"type:varchar(255)" json:"title"`
+	Artist   string `gorm:"type:varchar(255);index" json:"artist"`
+	Album    string `gorm:"type:varchar(255);index" json:"album"`
+
+	// 无缝播放信息，单位为采样，无法识别的格式为 0
+	SampleRate     int   `gorm:"not null;default:0" json:"sample_rate"`
+	SampleCount    int64 `gorm:"not null;default:0" json:"sample_count"`    // 有效采样数
+	EncoderDelay   int   `gorm:"not null;default:0" json:"encoder_delay"`   // 解码后开头需要丢弃的采样数
+	EncoderPadding int   `gorm:"not null;default:0" json:"encoder_padding"` // 解码后末尾需要丢弃的采样数
+
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
-func (ms *MusicService) getMusicList() ([]Music, error) {
+// HashFilePath 计算库内相对路径的哈希，按路径查找曲目时使用 path_hash 列以利用唯一索引
+func HashFilePath(filePath string) string {
+	sum := sha256.Sum256([]byte(filePath))
+	return hex.EncodeToString(sum[:])
+}
+
+// applyGapless 写入无缝播放信息
+func (m *Music) applyGapless(info *gaplessInfo) {
+	m.SampleRate = info.SampleRate
+	m.SampleCount = info.SampleCount
+	m.EncoderDelay = info.Delay
+	m.EncoderPadding = info.Padding
+}
+
+func (ms *MusicService) getMusicList(libraries []string, filter *MusicFilter) ([]Music, error) {
 	var musicList []Music
-	result := ms.db.Order("id ASC").Find(&musicList)
+	query := ms.db.Where("library IN ?", libraries)
+	if filter != nil && !filter.empty() {
+		query = filter.apply(query)
+	}
+	result := query.Order("id ASC").Find(&musicList)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return musicList, nil
 }
 
-func (ms *MusicService) getMusicByID(id string) (*Music, error) {
+func (ms *MusicService) getMusicByID(id uint, libraries []string) (*Music, error) {
 	var music Music
-	result := ms.db.First(&music, id)
+	result := ms.db.Where("library IN ?", libraries).First(&music, id)
 	if result.Error != nil {
 		return nil, result.Error
 	}
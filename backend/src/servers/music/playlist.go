@@ -0,0 +1,194 @@
+package music
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 歌单：用户自建的有序音乐列表，只有创建者可见，可通过订阅源在播客客户端中收听
+
+const maxPlaylistNameLength = 100
+
+var (
+	ErrPlaylistNotFound     = errors.New("歌单不存在")
+	ErrPlaylistItemNotFound = errors.New("歌单中不存在该条目")
+)
+
+type Playlist struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      string    `gorm:"type:varchar(255);not null;index" json:"-"`
+	Name        string    `gorm:"type:varchar(255);not null" json:"name"`
+	Description string    `gorm:"type:text" json:"description"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// PlaylistItem 歌单条目，Position 只用于排序，不保证连续
+type PlaylistItem struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	PlaylistID uint      `gorm:"not null;index:idx_playlist_item_position" json:"-"`
+	Position   int       `gorm:"not null;index:idx_playlist_item_position" json:"position"`
+	MusicID    uint      `gorm:"not null;index" json:"music_id"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type PlaylistRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// PlaylistEntry 歌单中的一首音乐，音乐已删除或无权访问的条目不返回
+type PlaylistEntry struct {
+	ID      uint      `json:"id"`
+	Music   Music     `json:"music"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func normalizePlaylist(req *PlaylistRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("歌单名称不能为空")
+	}
+	if utf8.RuneCountInString(req.Name) > maxPlaylistNameLength {
+		return errors.New("歌单名称过长")
+	}
+	return nil
+}
+
+func (ms *MusicService) createPlaylist(userID string, req *PlaylistRequest) (*Playlist, error) {
+	if err := normalizePlaylist(req); err != nil {
+		return nil, err
+	}
+	playlist := &Playlist{UserID: userID, Name: req.Name, Description: req.Description}
+	if err := ms.db.Create(playlist).Error; err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+func (ms *MusicService) listPlaylists(userID string) ([]Playlist, error) {
+	playlists := []Playlist{}
+	err := ms.db.Where("user_id = ?", userID).Order("id ASC").Find(&playlists).Error
+	return playlists, err
+}
+
+// getPlaylist 获取用户自己的歌单，其他用户的歌单按不存在处理
+func (ms *MusicService) getPlaylist(tx *gorm.DB, userID string, playlistID uint) (*Playlist, error) {
+	var playlist Playlist
+	err := tx.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func (ms *MusicService) updatePlaylist(userID string, playlistID uint, req *PlaylistRequest) (*Playlist, error) {
+	if err := normalizePlaylist(req); err != nil {
+		return nil, err
+	}
+	playlist, err := ms.getPlaylist(ms.db, userID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	playlist.Name = req.Name
+	playlist.Description = req.Description
+	if err := ms.db.Save(playlist).Error; err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+func (ms *MusicService) deletePlaylist(userID string, playlistID uint) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := ms.getPlaylist(tx, userID, playlistID); err != nil {
+			return err
+		}
+		if err := tx.Where("playlist_id = ?", playlistID).Delete(&PlaylistItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Playlist{}, playlistID).Error
+	})
+}
+
+// playlistEntries 按顺序返回歌单中可访问的音乐
+func (ms *MusicService) playlistEntries(playlistID uint, libraries []string) ([]PlaylistEntry, error) {
+	var rows []struct {
+		Music
+		ItemID  uint
+		AddedAt time.Time
+	}
+	err := ms.db.Table("musics").
+		Select("musics.*, playlist_items.id AS item_id, playlist_items.created_at AS added_at").
+		Joins("JOIN playlist_items ON musics.id = playlist_items.music_id").
+		Where("playlist_items.playlist_id = ? AND musics.library IN ?", playlistID, libraries).
+		Order("playlist_items.position ASC, playlist_items.id ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]PlaylistEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, PlaylistEntry{ID: row.ItemID, Music: row.Music, AddedAt: row.AddedAt})
+	}
+	return entries, nil
+}
+
+// addToPlaylist 将音乐追加到歌单末尾，音乐必须在可访问的音乐库中
+func (ms *MusicService) addToPlaylist(userID string, playlistID uint, musicIDs []uint, libraries []string) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		playlist, err := ms.getPlaylist(tx, userID, playlistID)
+		if err != nil {
+			return err
+		}
+		var count int64
+		err = tx.Model(&Music{}).
+			Where("id IN ? AND library IN ?", musicIDs, libraries).
+			Distinct("id").Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if int(count) != len(uniqueIDs(musicIDs)) {
+			return errors.New("音乐不存在")
+		}
+
+		var last int
+		err = tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).
+			Select("COALESCE(MAX(position), -1)").Scan(&last).Error
+		if err != nil {
+			return err
+		}
+		items := make([]PlaylistItem, 0, len(musicIDs))
+		for i, musicID := range musicIDs {
+			items = append(items, PlaylistItem{PlaylistID: playlistID, Position: last + 1 + i, MusicID: musicID})
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			return err
+		}
+		// 更新歌单的修改时间
+		return tx.Model(playlist).Update("updated_at", time.Now()).Error
+	})
+}
+
+func (ms *MusicService) removeFromPlaylist(userID string, playlistID, itemID uint) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		playlist, err := ms.getPlaylist(tx, userID, playlistID)
+		if err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND playlist_id = ?", itemID, playlistID).Delete(&PlaylistItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPlaylistItemNotFound
+		}
+		return tx.Model(playlist).Update("updated_at", time.Now()).Error
+	})
+}
@@ -0,0 +1,362 @@
+package music
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 服务端播放队列：每个用户一个队列，网页端和 MPD 客户端共享，便于在不同设备上接续播放
+
+// 播放状态
+const (
+	PlaybackStop  = "stop"
+	PlaybackPlay  = "play"
+	PlaybackPause = "pause"
+)
+
+var (
+	ErrQueueItemNotFound = errors.New("队列中不存在该条目")
+	ErrInvalidPlayback   = errors.New("无效的播放状态")
+)
+
+// QueueItem 队列条目，Position 只用于排序，不保证连续
+type QueueItem struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"type:varchar(255);not null;index:idx_queue_user_position" json:"-"`
+	Position  int       `gorm:"not null;index:idx_queue_user_position" json:"position"`
+	MusicID   uint      `gorm:"not null;index" json:"music_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (QueueItem) TableName() string {
+	return "play_queue"
+}
+
+// QueueState 队列版本和播放状态，每次修改队列 Version 加一
+type QueueState struct {
+	UserID    string    `gorm:"type:varchar(255);primaryKey" json:"-"`
+	Version   uint      `gorm:"not null;default:0" json:"version"`
+	State     string    `gorm:"type:varchar(16);not null;default:'stop'" json:"state"`
+	CurrentID uint      `json:"current_id"`
+	Elapsed   float64   `json:"elapsed"`
+	Crossfade float64   `gorm:"not null;default:0" json:"crossfade"` // 淡入淡出秒数，0 表示关闭
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (QueueState) TableName() string {
+	return "play_queue_state"
+}
+
+// QueueEntry 队列中的一首音乐，Position 为在可见队列中的下标
+type QueueEntry struct {
+	ID       uint  `json:"id"`
+	Position int   `json:"position"`
+	Music    Music `json:"music"`
+}
+
+// PlaybackUpdate 更新播放状态，为 nil 的字段保持不变
+type PlaybackUpdate struct {
+	State     *string  `json:"state"`
+	CurrentID *uint    `json:"current_id"`
+	Elapsed   *float64 `json:"elapsed"`
+	Crossfade *float64 `json:"crossfade"`
+}
+
+// 预加载的曲目数
+const prefetchCount = 2
+
+// 淡入淡出时长上限（秒）
+const maxCrossfade = 30
+
+// PrefetchHint 下一首的预加载提示，播放器据此提前缓冲，并决定无缝衔接还是淡入淡出
+type PrefetchHint struct {
+	ItemID    uint    `json:"item_id"`
+	MusicID   uint    `json:"music_id"`
+	Position  int     `json:"position"`
+	URL       string  `json:"url"`
+	Gapless   bool    `json:"gapless"`   // 与上一首是同一专辑的连续曲目，应按采样无缝衔接
+	Crossfade float64 `json:"crossfade"` // 与上一首之间的淡入淡出秒数，无缝衔接时为 0
+}
+
+// LibrariesFor 返回用户可访问的音乐库，userID 为空时只返回公开音乐库
+func (ms *MusicService) LibrariesFor(userID string) []string {
+	return ms.librariesFor(ms.lookupCaller(userID))
+}
+
+// 加载可见的队列条目，音乐已删除或无权访问的条目不返回
+func (ms *MusicService) loadQueue(tx *gorm.DB, userID string) ([]QueueItem, []QueueEntry, error) {
+	var items []QueueItem
+	if err := tx.Where("user_id = ?", userID).Order("position ASC, id ASC").Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+
+	musicIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		musicIDs = append(musicIDs, item.MusicID)
+	}
+	var musics []Music
+	err := tx.Where("id IN ? AND library IN ?", musicIDs, ms.LibrariesFor(userID)).Find(&musics).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]Music, len(musics))
+	for _, m := range musics {
+		byID[m.ID] = m
+	}
+
+	var visible []QueueItem
+	var entries []QueueEntry
+	for _, item := range items {
+		m, ok := byID[item.MusicID]
+		if !ok {
+			continue
+		}
+		entries = append(entries, QueueEntry{ID: item.ID, Position: len(entries), Music: m})
+		visible = append(visible, item)
+	}
+	return visible, entries, nil
+}
+
+// 按新的顺序重写排序字段，并增加队列版本
+func saveQueueOrder(tx *gorm.DB, userID string, items []QueueItem) error {
+	for i := range items {
+		if items[i].Position == i && items[i].ID != 0 {
+			continue
+		}
+		items[i].Position = i
+		var err error
+		if items[i].ID == 0 {
+			err = tx.Create(&items[i]).Error
+		} else {
+			err = tx.Model(&QueueItem{}).Where("id = ?", items[i].ID).Update("position", i).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bumpQueueVersion(tx, userID)
+}
+
+func bumpQueueVersion(tx *gorm.DB, userID string) error {
+	return tx.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{"version": gorm.Expr("version + 1")}),
+	}).Create(&QueueState{UserID: userID, Version: 1, State: PlaybackStop}).Error
+}
+
+func (ms *MusicService) getQueueState(tx *gorm.DB, userID string) (*QueueState, error) {
+	state := QueueState{UserID: userID, State: PlaybackStop}
+	err := tx.Where("user_id = ?", userID).First(&state).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Queue 返回用户的播放状态和队列
+func (ms *MusicService) Queue(userID string) (*QueueState, []QueueEntry, error) {
+	state, err := ms.getQueueState(ms.db, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, entries, err := ms.loadQueue(ms.db, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, entries, nil
+}
+
+// prefetchHints 返回当前曲目之后的预加载提示，未在播放时从队首开始
+func prefetchHints(state *QueueState, entries []QueueEntry) []PrefetchHint {
+	current := slices.IndexFunc(entries, func(entry QueueEntry) bool { return entry.ID == state.CurrentID })
+	hints := []PrefetchHint{}
+	for i := current + 1; i < len(entries) && len(hints) < prefetchCount; i++ {
+		hint := PrefetchHint{
+			ItemID:    entries[i].ID,
+			MusicID:   entries[i].Music.ID,
+			Position:  entries[i].Position,
+			URL:       fmt.Sprintf("/music/download/%d", entries[i].Music.ID),
+			Crossfade: state.Crossfade,
+		}
+		if i > 0 && sameRelease(&entries[i-1].Music, &entries[i].Music) {
+			hint.Gapless = true
+			hint.Crossfade = 0
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
+
+// sameRelease 判断两首音乐是否属于同一专辑目录，现场录音等专辑的连续曲目不应淡入淡出
+func sameRelease(a, b *Music) bool {
+	return a.Library == b.Library && a.Album != "" && a.Album == b.Album &&
+		path.Dir(a.FilePath) == path.Dir(b.FilePath)
+}
+
+// QueueAdd 将音乐插入队列的 pos 位置，pos 小于 0 或超出队列长度时追加到末尾，返回新条目的ID
+func (ms *MusicService) QueueAdd(userID string, musicIDs []uint, pos int) ([]uint, error) {
+	if len(musicIDs) == 0 {
+		return nil, nil
+	}
+
+	var ids []uint
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&Music{}).
+			Where("id IN ? AND library IN ?", musicIDs, ms.LibrariesFor(userID)).
+			Distinct("id").Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if int(count) != len(uniqueIDs(musicIDs)) {
+			return errors.New("音乐不存在")
+		}
+
+		items, _, err := ms.loadQueue(tx, userID)
+		if err != nil {
+			return err
+		}
+		if pos < 0 || pos > len(items) {
+			pos = len(items)
+		}
+		added := make([]QueueItem, 0, len(musicIDs))
+		for _, musicID := range musicIDs {
+			added = append(added, QueueItem{UserID: userID, MusicID: musicID})
+		}
+		items = slices.Insert(items, pos, added...)
+		if err := saveQueueOrder(tx, userID, items); err != nil {
+			return err
+		}
+		for _, item := range items[pos : pos+len(added)] {
+			ids = append(ids, item.ID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func uniqueIDs(ids []uint) []uint {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// QueueRemove 从队列中删除条目
+func (ms *MusicService) QueueRemove(userID string, itemIDs []uint) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND id IN ?", userID, itemIDs).Delete(&QueueItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrQueueItemNotFound
+		}
+		// 删除正在播放的条目时停止播放
+		err := tx.Model(&QueueState{}).Where("user_id = ? AND current_id IN ?", userID, itemIDs).
+			Updates(map[string]interface{}{"state": PlaybackStop, "current_id": 0, "elapsed": 0}).Error
+		if err != nil {
+			return err
+		}
+		return bumpQueueVersion(tx, userID)
+	})
+}
+
+// QueueMove 将条目移动到队列的 to 位置
+func (ms *MusicService) QueueMove(userID string, itemID uint, to int) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		items, _, err := ms.loadQueue(tx, userID)
+		if err != nil {
+			return err
+		}
+		from := slices.IndexFunc(items, func(item QueueItem) bool { return item.ID == itemID })
+		if from < 0 {
+			return ErrQueueItemNotFound
+		}
+		if to < 0 || to >= len(items) {
+			return errors.New("无效的队列位置")
+		}
+
+		item := items[from]
+		items = slices.Delete(items, from, from+1)
+		items = slices.Insert(items, to, item)
+		return saveQueueOrder(tx, userID, items)
+	})
+}
+
+// QueueClear 清空队列并停止播放
+func (ms *MusicService) QueueClear(userID string) error {
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&QueueItem{}).Error; err != nil {
+			return err
+		}
+		if err := bumpQueueVersion(tx, userID); err != nil {
+			return err
+		}
+		return tx.Model(&QueueState{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"state": PlaybackStop, "current_id": 0, "elapsed": 0}).Error
+	})
+}
+
+// SetPlayback 更新播放状态，播放中的条目必须在队列中
+func (ms *MusicService) SetPlayback(userID string, update *PlaybackUpdate) (*QueueState, error) {
+	var state *QueueState
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		state, err = ms.getQueueState(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if update.State != nil {
+			switch *update.State {
+			case PlaybackStop, PlaybackPlay, PlaybackPause:
+				state.State = *update.State
+			default:
+				return ErrInvalidPlayback
+			}
+		}
+		if update.CurrentID != nil {
+			if *update.CurrentID != 0 {
+				var count int64
+				err := tx.Model(&QueueItem{}).
+					Where("user_id = ? AND id = ?", userID, *update.CurrentID).
+					Count(&count).Error
+				if err != nil {
+					return err
+				}
+				if count == 0 {
+					return ErrQueueItemNotFound
+				}
+			}
+			if state.CurrentID != *update.CurrentID {
+				state.Elapsed = 0
+			}
+			state.CurrentID = *update.CurrentID
+		}
+		if update.Elapsed != nil && *update.Elapsed >= 0 {
+			state.Elapsed = *update.Elapsed
+		}
+		if update.Crossfade != nil {
+			if *update.Crossfade < 0 || *update.Crossfade > maxCrossfade {
+				return fmt.Errorf("淡入淡出时长应在 0 到 %d 秒之间", maxCrossfade)
+			}
+			state.Crossfade = *update.Crossfade
+		}
+		if state.State != PlaybackStop && state.CurrentID == 0 {
+			return ErrInvalidPlayback
+		}
+		return tx.Save(state).Error
+	})
+	return state, err
+}
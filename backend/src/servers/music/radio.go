@@ -0,0 +1,498 @@
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 电台节目来源
+const (
+	ChannelSourceTracks    = "tracks"    // 指定的音乐列表
+	ChannelSourceFavorites = "favorites" // 创建者的收藏列表
+	ChannelSourceRule      = "rule"      // 按音乐库和关键字筛选
+)
+
+const (
+	// 推送数据领先实际播放的时长，给客户端留出缓冲
+	radioPrebuffer = time.Second
+	// 每个听众缓冲的帧数，约 6 秒，消费过慢时丢帧
+	radioListenerBuffer = 256
+	// 没有可播放的音乐时的重试间隔
+	radioIdleRetry = 10 * time.Second
+	// ICY 元数据插入间隔（字节）
+	icyMetaInterval = 16000
+)
+
+type Channel struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"type:varchar(128);not null;unique" json:"name"`
+	Description string    `gorm:"type:varchar(1024)" json:"description"`
+	OwnerID     string    `gorm:"type:varchar(255);not null;index" json:"owner_id"`
+	SourceType  string    `gorm:"type:varchar(32);not null" json:"source_type"`
+	MusicIDs    []uint    `gorm:"serializer:json;type:text" json:"music_ids"`
+	Library     string    `gorm:"type:varchar(64)" json:"library"`
+	Keyword     string    `gorm:"type:varchar(255)" json:"keyword"`
+	Shuffle     bool      `json:"shuffle"`
+	Public      bool      `json:"public"` // 未登录用户也可收听
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type ChannelRequest struct {
+	Name        string `json:"name" binding:"required,max=128"`
+	Description string `json:"description"`
+	SourceType  string `json:"source_type" binding:"required,oneof=tracks favorites rule"`
+	MusicIDs    []uint `json:"music_ids"`
+	Library     string `json:"library"`
+	Keyword     string `json:"keyword"`
+	Shuffle     bool   `json:"shuffle"`
+	Public      bool   `json:"public"`
+}
+
+type NowPlayingResponse struct {
+	Channel   *Channel `json:"channel"`
+	Music     *Music   `json:"music"`
+	Listeners int      `json:"listeners"`
+}
+
+// radioStation 是一个持续运行的电台，所有听众共享同一个播放位置
+type radioStation struct {
+	ms        *MusicService
+	channelID uint
+
+	mu sync.RWMutex
+	// 听众及其可访问的音乐库，切歌时断开无权收听新曲目的听众
+	listeners  map[chan []byte][]string
+	nowPlaying *Music
+
+	stop chan struct{}
+}
+
+// RadioManager 管理所有正在运行的电台
+type RadioManager struct {
+	ms       *MusicService
+	mu       sync.Mutex
+	stations map[uint]*radioStation
+}
+
+func NewRadioManager(ms *MusicService) *RadioManager {
+	return &RadioManager{
+		ms:       ms,
+		stations: make(map[uint]*radioStation),
+	}
+}
+
+// StartAll 启动数据库中所有电台
+func (rm *RadioManager) StartAll() {
+	var channels []Channel
+	if err := rm.ms.db.Find(&channels).Error; err != nil {
+		log.Printf("加载电台列表失败: %v", err)
+		return
+	}
+	for _, ch := range channels {
+		rm.start(ch.ID)
+	}
+}
+
+func (rm *RadioManager) start(channelID uint) *radioStation {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if st, ok := rm.stations[channelID]; ok {
+		return st
+	}
+	st := &radioStation{
+		ms:        rm.ms,
+		channelID: channelID,
+		listeners: make(map[chan []byte][]string),
+		stop:      make(chan struct{}),
+	}
+	rm.stations[channelID] = st
+	go st.run()
+	return st
+}
+
+func (rm *RadioManager) stopStation(channelID uint) {
+	rm.mu.Lock()
+	st, ok := rm.stations[channelID]
+	delete(rm.stations, channelID)
+	rm.mu.Unlock()
+
+	if ok {
+		close(st.stop)
+		st.closeListeners()
+	}
+}
+
+func (rm *RadioManager) get(channelID uint) (*radioStation, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	st, ok := rm.stations[channelID]
+	return st, ok
+}
+
+func (st *radioStation) subscribe(libraries []string) chan []byte {
+	ch := make(chan []byte, radioListenerBuffer)
+	st.mu.Lock()
+	st.listeners[ch] = libraries
+	st.mu.Unlock()
+	return ch
+}
+
+func (st *radioStation) unsubscribe(ch chan []byte) {
+	st.mu.Lock()
+	if _, ok := st.listeners[ch]; ok {
+		delete(st.listeners, ch)
+		close(ch)
+	}
+	st.mu.Unlock()
+}
+
+func (st *radioStation) closeListeners() {
+	st.mu.Lock()
+	for ch := range st.listeners {
+		delete(st.listeners, ch)
+		close(ch)
+	}
+	st.mu.Unlock()
+}
+
+func (st *radioStation) listenerCount() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.listeners)
+}
+
+func (st *radioStation) current() *Music {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.nowPlaying
+}
+
+func (st *radioStation) broadcast(frame []byte) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for ch := range st.listeners {
+		select {
+		case ch <- frame:
+		default:
+			// 听众网络过慢，丢弃该帧
+		}
+	}
+}
+
+// setNowPlaying 切换当前曲目，节目单在收听期间可能加入听众无权访问的音乐库，此时断开这些听众
+func (st *radioStation) setNowPlaying(music *Music) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.nowPlaying = music
+	for ch, libraries := range st.listeners {
+		if !slices.Contains(libraries, music.Library) {
+			delete(st.listeners, ch)
+			close(ch)
+		}
+	}
+}
+
+// sleep 等待指定时长，电台停止时返回 false
+func (st *radioStation) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-st.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (st *radioStation) run() {
+	var lastID uint
+	for {
+		select {
+		case <-st.stop:
+			return
+		default:
+		}
+
+		// 每首歌结束后重新加载节目单，电台配置的修改在下一首生效
+		var channel Channel
+		if err := st.ms.db.First(&channel, st.channelID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return
+			}
+			if !st.sleep(radioIdleRetry) {
+				return
+			}
+			continue
+		}
+
+		tracks, err := st.ms.getChannelTracks(&channel)
+		if err != nil || len(tracks) == 0 {
+			if !st.sleep(radioIdleRetry) {
+				return
+			}
+			continue
+		}
+
+		next := nextChannelTrack(tracks, lastID, channel.Shuffle)
+		lastID = next.ID
+		if !st.play(&next) {
+			return
+		}
+	}
+}
+
+func nextChannelTrack(tracks []Music, lastID uint, shuffle bool) Music {
+	if shuffle {
+		if len(tracks) > 1 {
+			// 避免连续播放同一首
+			tracks = slices.DeleteFunc(slices.Clone(tracks), func(m Music) bool { return m.ID == lastID })
+		}
+		return tracks[rand.Intn(len(tracks))]
+	}
+
+	idx := slices.IndexFunc(tracks, func(m Music) bool { return m.ID == lastID })
+	return tracks[(idx+1)%len(tracks)]
+}
+
+// play 按实际码率推送一首歌的全部帧，电台停止时返回 false
+func (st *radioStation) play(music *Music) bool {
+	fullPath, ok := st.ms.musicFilePath(music)
+	if !ok {
+		return st.sleep(time.Second)
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		log.Printf("电台打开音乐文件失败: %v", err)
+		return st.sleep(time.Second)
+	}
+	defer file.Close()
+
+	st.setNowPlaying(music)
+
+	reader := newMP3FrameReader(file)
+	start := time.Now()
+	var sent time.Duration
+	for {
+		header, frame, err := reader.Next()
+		if err != nil {
+			break
+		}
+		st.broadcast(frame)
+		sent += header.duration()
+
+		if ahead := sent - time.Since(start) - radioPrebuffer; ahead > 100*time.Millisecond {
+			if !st.sleep(ahead) {
+				return false
+			}
+		}
+	}
+
+	// 等待最后缓冲的部分播放完毕
+	if rest := sent - time.Since(start) - radioPrebuffer; rest > 0 {
+		return st.sleep(rest)
+	}
+	return true
+}
+
+// 获取电台的节目单，范围限定在创建者可访问的音乐库内，只包含 MP3；
+// 公开电台未登录也可收听，只播放公开音乐库中的音乐
+func (ms *MusicService) getChannelTracks(channel *Channel) ([]Music, error) {
+	libraries := ms.channelLibraries(ms.lookupCaller(channel.OwnerID), channel.Public)
+
+	var tracks []Music
+	var err error
+	switch channel.SourceType {
+	case ChannelSourceTracks:
+		if len(channel.MusicIDs) == 0 {
+			return nil, nil
+		}
+		err = ms.db.Where("id IN ? AND library IN ?", channel.MusicIDs, libraries).Find(&tracks).Error
+		// 按节目单中的顺序播放
+		slices.SortStableFunc(tracks, func(a, b Music) int {
+			return slices.Index(channel.MusicIDs, a.ID) - slices.Index(channel.MusicIDs, b.ID)
+		})
+	case ChannelSourceFavorites:
+		tracks, err = ms.getUserMusicList(channel.OwnerID, libraries)
+	case ChannelSourceRule:
+		query := ms.db.Where("library IN ?", libraries)
+		if channel.Library != "" {
+			query = query.Where("library = ?", channel.Library)
+		}
+		if channel.Keyword != "" {
+			query = query.Where("name LIKE ?", "%"+escapeLike(channel.Keyword)+"%")
+		}
+		err = query.Order("id ASC").Find(&tracks).Error
+	default:
+		return nil, fmt.Errorf("未知的节目来源: %s", channel.SourceType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(tracks, func(m Music) bool {
+		return strings.ToLower(filepath.Ext(m.FilePath)) != ".mp3"
+	}), nil
+}
+
+func (ms *MusicService) channelLibraries(owner *caller, public bool) []string {
+	libraries := ms.librariesFor(owner)
+	if !public {
+		return libraries
+	}
+	anonymous := ms.librariesFor(&caller{})
+	return slices.DeleteFunc(libraries, func(name string) bool {
+		return !slices.Contains(anonymous, name)
+	})
+}
+
+func (ms *MusicService) getChannels(loggedIn bool) ([]Channel, error) {
+	var channels []Channel
+	query := ms.db.Order("id ASC")
+	if !loggedIn {
+		query = query.Where("public = ?", true)
+	}
+	err := query.Find(&channels).Error
+	return channels, err
+}
+
+// canListen 听众必须能访问电台节目单中所有音乐所在的音乐库，电台的播放流由所有听众共享，无法按听众过滤
+func (ms *MusicService) canListen(channel *Channel, libraries []string) (bool, error) {
+	tracks, err := ms.getChannelTracks(channel)
+	if err != nil {
+		return false, err
+	}
+	return tracksAccessible(tracks, libraries), nil
+}
+
+func tracksAccessible(tracks []Music, libraries []string) bool {
+	for _, track := range tracks {
+		if !slices.Contains(libraries, track.Library) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms *MusicService) getChannel(id uint) (*Channel, error) {
+	var channel Channel
+	if err := ms.db.First(&channel, id).Error; err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (ms *MusicService) createChannel(userID string, req *ChannelRequest) (*Channel, error) {
+	channel := &Channel{OwnerID: userID}
+	applyChannelRequest(channel, req)
+	if err := ms.db.Create(channel).Error; err != nil {
+		return nil, err
+	}
+	ms.radio.start(channel.ID)
+	return channel, nil
+}
+
+func (ms *MusicService) updateChannel(userID string, id uint, req *ChannelRequest) (*Channel, error) {
+	channel, err := ms.getChannel(id)
+	if err != nil {
+		return nil, errors.New("电台不存在")
+	}
+	if channel.OwnerID != userID {
+		return nil, errors.New("只能修改自己创建的电台")
+	}
+	wasPublic := channel.Public
+	applyChannelRequest(channel, req)
+	if err := ms.db.Save(channel).Error; err != nil {
+		return nil, err
+	}
+	// 改为公开时正在播放的可能是私有音乐库中的歌曲，重新开始播放
+	if channel.Public && !wasPublic {
+		ms.radio.stopStation(channel.ID)
+		ms.radio.start(channel.ID)
+	}
+	return channel, nil
+}
+
+func (ms *MusicService) deleteChannel(userID string, id uint) error {
+	channel, err := ms.getChannel(id)
+	if err != nil {
+		return errors.New("电台不存在")
+	}
+	if channel.OwnerID != userID {
+		return errors.New("只能删除自己创建的电台")
+	}
+	if err := ms.db.Delete(channel).Error; err != nil {
+		return err
+	}
+	ms.radio.stopStation(channel.ID)
+	return nil
+}
+
+func applyChannelRequest(channel *Channel, req *ChannelRequest) {
+	channel.Name = req.Name
+	channel.Description = req.Description
+	channel.SourceType = req.SourceType
+	channel.MusicIDs = req.MusicIDs
+	channel.Library = req.Library
+	channel.Keyword = req.Keyword
+	channel.Shuffle = req.Shuffle
+	channel.Public = req.Public
+}
+
+// icyWriter 按 Icecast/SHOUTcast 协议每隔 metaInt 字节插入一次元数据块
+type icyWriter struct {
+	w         io.Writer
+	metaInt   int
+	count     int
+	title     func() string
+	lastTitle string
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(iw.metaInt-iw.count, len(p))
+		if _, err := iw.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		iw.count += n
+		p = p[n:]
+
+		if iw.count == iw.metaInt {
+			if _, err := iw.w.Write(iw.metadata()); err != nil {
+				return written, err
+			}
+			iw.count = 0
+		}
+	}
+	return written, nil
+}
+
+// 标题未变化时只发送长度为 0 的元数据块
+func (iw *icyWriter) metadata() []byte {
+	title := iw.title()
+	if title == iw.lastTitle {
+		return []byte{0}
+	}
+	iw.lastTitle = title
+
+	text := fmt.Sprintf("StreamTitle='%s';", strings.ReplaceAll(title, "'", "’"))
+	blocks := min((len(text)+15)/16, 255)
+	buf := make([]byte, 1+blocks*16)
+	buf[0] = byte(blocks)
+	copy(buf[1:], text)
+	return buf
+}
@@ -0,0 +1,71 @@
+package music
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 非公开电台的节目单来自创建者的音乐库，其他登录用户不能借此收听受限音乐库中的音乐
+func TestRadioListenerLibraryAccess(t *testing.T) {
+	ms := &MusicService{
+		libraries: map[string]*Library{
+			"music":   {cfg: LibraryConfig{Name: "music"}},
+			"private": {cfg: LibraryConfig{Name: "private", AllowUsers: []string{"alice"}}},
+		},
+		libraryNames: []string{"music", "private"},
+	}
+	alice := ms.librariesFor(&caller{ID: "u1", Username: "alice"})
+	bob := ms.librariesFor(&caller{ID: "u2", Username: "bob"})
+
+	shared := []Music{{ID: 1, Library: "music"}}
+	mixed := []Music{{ID: 1, Library: "music"}, {ID: 2, Library: "private"}}
+	if !tracksAccessible(mixed, alice) {
+		t.Error("创建者应该可以收听自己的电台")
+	}
+	if tracksAccessible(mixed, bob) {
+		t.Error("其他用户不能收听包含受限音乐库的电台")
+	}
+	if !tracksAccessible(shared, bob) {
+		t.Error("节目单只包含共享音乐库时其他用户可以收听")
+	}
+
+	// 收听期间节目单加入受限音乐库的曲目时，切歌会断开无权访问的听众
+	st := &radioStation{ms: ms, listeners: map[chan []byte][]string{}}
+	aliceCh, bobCh := st.subscribe(alice), st.subscribe(bob)
+	st.setNowPlaying(&shared[0])
+	if st.listenerCount() != 2 {
+		t.Fatalf("听众数量为 %d", st.listenerCount())
+	}
+	st.setNowPlaying(&mixed[1])
+	if _, ok := <-bobCh; ok {
+		t.Error("无权访问当前曲目的听众应该被断开")
+	}
+	st.mu.RLock()
+	_, aliceListening := st.listeners[aliceCh]
+	st.mu.RUnlock()
+	if !aliceListening || st.listenerCount() != 1 {
+		t.Error("有权访问的听众应该继续收听")
+	}
+}
+
+// 电台ID必须是数字，不能作为 SQL 条件拼入查询
+func TestParseChannelID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, id := range []string{"1 OR 1=1", "1;DROP TABLE channels", "abc", "-1"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		if _, ok := parseChannelID(c); ok || w.Code != http.StatusNotFound {
+			t.Errorf("parseChannelID(%q) 应该返回 404，status = %d", id, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "42"}}
+	if id, ok := parseChannelID(c); !ok || id != 42 {
+		t.Errorf("parseChannelID(42) = %d, %v", id, ok)
+	}
+}
@@ -0,0 +1,258 @@
+package music
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 评分和标签都按用户区分，用于筛选自己的音乐库
+
+const maxTagLength = 32
+
+// Rating 用户对音乐的 1~5 星评分
+type Rating struct {
+	UserID    string    `gorm:"type:varchar(255);primaryKey" json:"-"`
+	MusicID   uint      `gorm:"primaryKey;autoIncrement:false;index" json:"music_id"`
+	Rating    int       `gorm:"not null" json:"rating"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Rating) TableName() string {
+	return "music_ratings"
+}
+
+// MusicTag 用户给音乐打的标签，例如 "focus"、"gym"
+type MusicTag struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_music_tag_unique;index:idx_music_tag_user_tag" json:"-"`
+	MusicID   uint      `gorm:"not null;uniqueIndex:idx_music_tag_unique;index" json:"music_id"`
+	Tag       string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_music_tag_unique;index:idx_music_tag_user_tag" json:"tag"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (MusicTag) TableName() string {
+	return "music_tags"
+}
+
+// RatingSummary 音乐的评分统计和当前用户的评分
+type RatingSummary struct {
+	MusicID uint    `json:"music_id"`
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+	Mine    int     `json:"mine"`
+}
+
+// TagCount 用户的标签及使用次数
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// MusicFilter 音乐列表的筛选条件，按当前用户的评分和标签筛选
+type MusicFilter struct {
+	UserID    string
+	Tags      []string // 同时包含所有标签
+	MinRating int
+	MaxRating int
+}
+
+func (f *MusicFilter) empty() bool {
+	return len(f.Tags) == 0 && f.MinRating == 0 && f.MaxRating == 0
+}
+
+// apply 为查询加上筛选条件，query 需以 musics 为主表
+func (f *MusicFilter) apply(query *gorm.DB) *gorm.DB {
+	for _, tag := range f.Tags {
+		query = query.Where("EXISTS (SELECT 1 FROM music_tags WHERE music_tags.music_id = musics.id AND music_tags.user_id = ? AND music_tags.tag = ?)",
+			f.UserID, tag)
+	}
+	if f.MinRating > 0 || f.MaxRating > 0 {
+		minRating, maxRating := max(f.MinRating, 1), f.MaxRating
+		if maxRating == 0 {
+			maxRating = 5
+		}
+		query = query.Where("EXISTS (SELECT 1 FROM music_ratings WHERE music_ratings.music_id = musics.id AND music_ratings.user_id = ? AND music_ratings.rating BETWEEN ? AND ?)",
+			f.UserID, minRating, maxRating)
+	}
+	return query
+}
+
+// checkMusicAccess 检查音乐存在且在可访问的音乐库中
+func (ms *MusicService) checkMusicAccess(musicID uint, libraries []string) (*Music, error) {
+	var music Music
+	if err := ms.db.Where("library IN ?", libraries).First(&music, musicID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("音乐不存在")
+		}
+		return nil, err
+	}
+	return &music, nil
+}
+
+func (ms *MusicService) setRating(userID string, musicID uint, rating int, libraries []string) error {
+	if rating < 1 || rating > 5 {
+		return errors.New("评分应为 1 到 5")
+	}
+	if _, err := ms.checkMusicAccess(musicID, libraries); err != nil {
+		return err
+	}
+	return ms.db.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "updated_at"}),
+	}).Create(&Rating{UserID: userID, MusicID: musicID, Rating: rating}).Error
+}
+
+func (ms *MusicService) removeRating(userID string, musicID uint) error {
+	result := ms.db.Where("user_id = ? AND music_id = ?", userID, musicID).Delete(&Rating{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("尚未评分")
+	}
+	return nil
+}
+
+// getRatingSummary 所有用户的平均分和当前用户的评分
+func (ms *MusicService) getRatingSummary(userID string, musicID uint, libraries []string) (*RatingSummary, error) {
+	if _, err := ms.checkMusicAccess(musicID, libraries); err != nil {
+		return nil, err
+	}
+	summary := RatingSummary{MusicID: musicID}
+	err := ms.db.Model(&Rating{}).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Where("music_id = ?", musicID).
+		Scan(&summary).Error
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		ms.db.Model(&Rating{}).Where("user_id = ? AND music_id = ?", userID, musicID).
+			Limit(1).Pluck("rating", &summary.Mine)
+	}
+	return &summary, nil
+}
+
+// getUserRatings 返回用户的所有评分，键为音乐ID（用于前端标记）
+func (ms *MusicService) getUserRatings(userID string, libraries []string) (map[uint]int, error) {
+	var ratings []Rating
+	err := ms.db.Joins("JOIN musics ON musics.id = music_ratings.music_id").
+		Where("music_ratings.user_id = ? AND musics.library IN ?", userID, libraries).
+		Find(&ratings).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint]int, len(ratings))
+	for _, r := range ratings {
+		result[r.MusicID] = r.Rating
+	}
+	return result, nil
+}
+
+// normalizeTag 标签统一为小写并去掉首尾空白
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("标签不能为空")
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", errors.New("标签过长")
+	}
+	if strings.ContainsAny(tag, ",\n\r\t") {
+		return "", errors.New("标签不能包含逗号或换行")
+	}
+	return tag, nil
+}
+
+func (ms *MusicService) getMusicTags(userID string, musicID uint) ([]string, error) {
+	tags := []string{}
+	err := ms.db.Model(&MusicTag{}).
+		Where("user_id = ? AND music_id = ?", userID, musicID).
+		Order("tag ASC").Pluck("tag", &tags).Error
+	return tags, err
+}
+
+// setMusicTags 替换用户给该音乐打的全部标签
+func (ms *MusicService) setMusicTags(userID string, musicID uint, tags []string, libraries []string) ([]string, error) {
+	if _, err := ms.checkMusicAccess(musicID, libraries); err != nil {
+		return nil, err
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND music_id = ?", userID, musicID).Delete(&MusicTag{}).Error; err != nil {
+			return err
+		}
+		for _, tag := range uniqueStrings(normalized) {
+			if err := tx.Create(&MusicTag{UserID: userID, MusicID: musicID, Tag: tag}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms.getMusicTags(userID, musicID)
+}
+
+func (ms *MusicService) addMusicTag(userID string, musicID uint, tag string, libraries []string) error {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	if _, err := ms.checkMusicAccess(musicID, libraries); err != nil {
+		return err
+	}
+	return ms.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&MusicTag{UserID: userID, MusicID: musicID, Tag: tag}).Error
+}
+
+func (ms *MusicService) removeMusicTag(userID string, musicID uint, tag string) error {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	result := ms.db.Where("user_id = ? AND music_id = ? AND tag = ?", userID, musicID, tag).Delete(&MusicTag{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("未找到该标签")
+	}
+	return nil
+}
+
+// getUserTags 返回用户使用过的标签及次数
+func (ms *MusicService) getUserTags(userID string, libraries []string) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := ms.db.Model(&MusicTag{}).
+		Select("music_tags.tag AS tag, COUNT(*) AS count").
+		Joins("JOIN musics ON musics.id = music_tags.music_id").
+		Where("music_tags.user_id = ? AND musics.library IN ?", userID, libraries).
+		Group("music_tags.tag").
+		Order("count DESC, tag ASC").
+		Scan(&tags).Error
+	return tags, err
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var result []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -4,11 +4,16 @@ import "myapp/middleware"
 
 func (ms *MusicService) RegisterRoutes() {
 	musicGroup := ms.rg
+	// 音乐库按用户权限过滤，未登录用户只能访问公开音乐库
+	musicGroup.Use(middleware.OptionalAuthMiddleware())
 
 	// 音乐接口
 	musicGroup.GET("", ms.GetMusicList)
+	musicGroup.GET("/libraries", ms.GetLibraries)
 	musicGroup.GET("/play/:id", ms.PlayMusic)
 	musicGroup.GET("/download/:id", ms.DownloadMusic)
+	musicGroup.GET("/events", ms.StreamEvents) // 音乐库实时事件（SSE）
+	musicGroup.POST("/rescan", middleware.AuthMiddleware(), middleware.RequirePermission(middleware.PermLibraryRescan), ms.Rescan)
 
 	// 收藏
 	favGroup := musicGroup.Group("/favorite")
@@ -18,4 +23,80 @@ func (ms *MusicService) RegisterRoutes() {
 	favGroup.GET("", ms.GetFavoriteMusic)          // 获取收藏列表
 	favGroup.GET("/ids", ms.GetFavoriteMusicIDs)   // 获取收藏ID列表
 	favGroup.GET("/check/:id", ms.CheckFavorite)   // 检查是否收藏
+
+	// 歌单
+	playlistGroup := musicGroup.Group("/playlists")
+	playlistGroup.Use(middleware.AuthMiddleware())
+	playlistGroup.GET("", ms.GetPlaylists)                          // 我的歌单
+	playlistGroup.POST("", ms.CreatePlaylist)                       // 创建歌单
+	playlistGroup.GET("/:id", ms.GetPlaylist)                       // 歌单详情和音乐
+	playlistGroup.PUT("/:id", ms.UpdatePlaylist)                    // 修改名称和简介
+	playlistGroup.DELETE("/:id", ms.DeletePlaylist)                 // 删除歌单
+	playlistGroup.POST("/:id/items", ms.AddToPlaylist)              // 添加音乐
+	playlistGroup.DELETE("/:id/items/:item", ms.RemoveFromPlaylist) // 移除条目
+
+	// 播放队列
+	queueGroup := musicGroup.Group("/queue")
+	queueGroup.Use(middleware.AuthMiddleware())
+	queueGroup.GET("", ms.GetQueue)                   // 获取播放队列
+	queueGroup.POST("", ms.AddToQueue)                // 添加到队列
+	queueGroup.DELETE("", ms.ClearQueue)              // 清空队列
+	queueGroup.DELETE("/:id", ms.RemoveFromQueue)     // 移除条目
+	queueGroup.PUT("/:id/position", ms.MoveQueueItem) // 调整顺序
+	queueGroup.PUT("/playback", ms.UpdatePlayback)    // 上报播放状态
+
+	// 评分
+	ratingGroup := musicGroup.Group("/ratings")
+	ratingGroup.Use(middleware.AuthMiddleware())
+	ratingGroup.GET("", ms.GetMyRatings)        // 我的全部评分
+	ratingGroup.GET("/:id", ms.GetRating)       // 平均评分和我的评分
+	ratingGroup.PUT("/:id", ms.SetRating)       // 评分
+	ratingGroup.DELETE("/:id", ms.RemoveRating) // 取消评分
+
+	// 标签
+	tagGroup := musicGroup.Group("/tags")
+	tagGroup.Use(middleware.AuthMiddleware())
+	tagGroup.GET("", ms.GetMyTags)                  // 我的标签及次数
+	tagGroup.GET("/:id", ms.GetMusicTags)           // 音乐的标签
+	tagGroup.PUT("/:id", ms.SetMusicTags)           // 替换标签
+	tagGroup.POST("/:id", ms.AddMusicTag)           // 添加标签
+	tagGroup.DELETE("/:id/:tag", ms.RemoveMusicTag) // 删除标签
+
+	// 评论，未登录用户可查看公开音乐库的评论
+	commentGroup := musicGroup.Group("/comments")
+	commentGroup.GET("", ms.GetComments)
+	commentGroup.POST("", middleware.AuthMiddleware(), ms.AddComment)
+	commentGroup.PUT("/:id", middleware.AuthMiddleware(), ms.EditComment)
+	commentGroup.DELETE("/:id", middleware.AuthMiddleware(), ms.DeleteComment)
+	commentGroup.PUT("/:id/moderation", middleware.AuthMiddleware(), middleware.RequirePermission(middleware.PermCommentsModerate), ms.ModerateComment) // 隐藏/恢复
+
+	// 分享
+	shareGroup := musicGroup.Group("/share")
+	shareGroup.Use(middleware.AuthMiddleware())
+	shareGroup.POST("", ms.CreateShare)       // 创建分享链接
+	shareGroup.GET("", ms.GetMyShares)        // 获取我的分享
+	shareGroup.DELETE("/:id", ms.RevokeShare) // 撤销分享
+
+	// 公开访问分享内容，无需登录
+	sharedGroup := musicGroup.Group("/shared/:token")
+	sharedGroup.GET("", ms.GetSharedContent)            // 查看分享内容
+	sharedGroup.GET("/stream/:id", ms.StreamShared)     // 播放
+	sharedGroup.GET("/download/:id", ms.DownloadShared) // 下载
+
+	// 收藏和歌单订阅源（RSS），播客客户端通过订阅令牌访问
+	feedGroup := musicGroup.Group("/feed")
+	feedGroup.GET("/token", middleware.AuthMiddleware(), ms.GetFeedToken)
+	feedGroup.POST("/token/reset", middleware.AuthMiddleware(), ms.ResetFeedToken)
+	feedGroup.GET("/:token/favorites.xml", ms.GetFavoritesFeed)
+	feedGroup.GET("/:token/playlists/:id", ms.GetPlaylistFeed)
+	musicGroup.GET("/signed/:id/:file", ms.ServeSigned) // 订阅源中的签名播放/封面链接
+
+	// 电台
+	radioGroup := musicGroup.Group("/radio")
+	radioGroup.GET("", ms.GetChannels)              // 电台列表
+	radioGroup.GET("/:id", ms.GetNowPlaying)        // 正在播放
+	radioGroup.GET("/:id/stream", ms.StreamChannel) // 收听电台
+	radioGroup.POST("", middleware.AuthMiddleware(), ms.CreateChannel)
+	radioGroup.PUT("/:id", middleware.AuthMiddleware(), ms.UpdateChannel)
+	radioGroup.DELETE("/:id", middleware.AuthMiddleware(), ms.DeleteChannel)
 }
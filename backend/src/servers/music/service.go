@@ -3,6 +3,7 @@ package music
 import (
 	"context"
 	logger "myapp/log"
+	"net"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -10,19 +11,92 @@ import (
 
 type MusicConfig struct {
 	MusicDir string
+
+	// 多音乐库配置，为空时使用 MusicDir 作为唯一的公开音乐库
+	Libraries []LibraryConfig
+
+	// 全局忽略规则（.gitignore 语法），为 nil 时使用 DefaultIgnorePatterns
+	IgnorePatterns []string
+	// 允许索引的扩展名，为空时使用 DefaultExtensions
+	Extensions []string
+	// 扩展名不在列表中时，按文件内容识别的 MIME 类型放行，支持 audio/* 通配
+	MimeTypes []string
+
+	// 对外访问地址，用于生成订阅源中的绝对链接，为空时根据请求推断
+	PublicURL string
+	// 订阅源封面图片地址
+	FeedImageURL string
+	// 可信的反向代理，未配置 PublicURL 时只有来自这些地址的请求才按转发的协议和主机生成链接
+	TrustedProxies []string
 }
 
 type MusicService struct {
-	cfg         *MusicConfig
-	db          *gorm.DB
-	fileWatcher *FileWatcher
-	rg          *gin.RouterGroup
+	cfg          *MusicConfig
+	db           *gorm.DB
+	libraries    map[string]*Library
+	libraryNames []string
+	events       *EventHub
+	radio        *RadioManager
+	covers       *coverCache
+	// 由 cfg.TrustedProxies 解析
+	trustedProxies []*net.IPNet
+	rg             *gin.RouterGroup
 }
 
 func NewMusicService(ctx context.Context, cfg *MusicConfig, db *gorm.DB, r *gin.Engine) *MusicService {
-	watcher, err := NewFileWatcher(cfg.MusicDir, db)
-	if watcher == nil {
-		logger.ZError(&ctx, "创建文件监控器失败", err)
+	events := NewEventHub()
+
+	libraries := make(map[string]*Library)
+	var libraryNames []string
+	for _, libCfg := range libraryConfigs(cfg) {
+		if libCfg.Name == "" || libCfg.Dir == "" {
+			logger.ZError(&ctx, "音乐库配置缺少名称或目录", nil, "library", libCfg.Name)
+			return nil
+		}
+		if _, exists := libraries[libCfg.Name]; exists {
+			logger.ZError(&ctx, "音乐库名称重复", nil, "library", libCfg.Name)
+			return nil
+		}
+
+		filter := NewFileFilter(libCfg.Dir, cfg)
+		watcher, err := NewFileWatcher(libCfg.Name, libCfg.Dir, db, events, filter)
+		if watcher == nil {
+			logger.ZError(&ctx, "创建文件监控器失败", err, "library", libCfg.Name)
+			return nil
+		}
+
+		libraries[libCfg.Name] = &Library{
+			cfg:     libCfg,
+			watcher: watcher,
+		}
+		libraryNames = append(libraryNames, libCfg.Name)
+	}
+
+	// 旧版本 name 字段为全局唯一，多音乐库下改为库内唯一
+	if db.Migrator().HasConstraint(&Music{}, "uni_musics_name") {
+		if err := db.Migrator().DropConstraint(&Music{}, "uni_musics_name"); err != nil {
+			logger.ZError(&ctx, "删除旧唯一约束失败", err)
+			return nil
+		}
+	}
+
+	// 递归扫描后不同目录下可能有同名文件，曲目改为按库内路径唯一
+	if db.Migrator().HasIndex(&Music{}, "idx_music_library_name") {
+		if err := db.Migrator().DropIndex(&Music{}, "idx_music_library_name"); err != nil {
+			logger.ZError(&ctx, "删除旧唯一索引失败", err)
+			return nil
+		}
+	}
+
+	// 完整路径超出 MySQL 索引长度限制，库内路径的唯一索引改为建在路径哈希上
+	if err := migratePathHash(db); err != nil {
+		logger.ZError(&ctx, "迁移音乐路径哈希失败", err)
+		return nil
+	}
+
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.ZError(&ctx, "可信代理配置错误", err)
 		return nil
 	}
 
@@ -33,7 +107,14 @@ func NewMusicService(ctx context.Context, cfg *MusicConfig, db *gorm.DB, r *gin.
 		return nil
 	}
 
-	err = db.AutoMigrate(&UserMusic{})
+	// 旧数据归入第一个音乐库
+	err = db.Model(&Music{}).Where("library = ?", "").Update("library", libraryNames[0]).Error
+	if err != nil {
+		logger.ZError(&ctx, "迁移旧音乐数据失败", err)
+		return nil
+	}
+
+	err = db.AutoMigrate(&UserMusic{}, &Share{}, &Channel{}, &FeedToken{}, &QueueItem{}, &QueueState{}, &Rating{}, &MusicTag{}, &Comment{}, &Playlist{}, &PlaylistItem{})
 	if err != nil {
 		logger.ZError(&ctx, "数据库自动迁移失败", err)
 		return nil
@@ -41,23 +122,51 @@ func NewMusicService(ctx context.Context, cfg *MusicConfig, db *gorm.DB, r *gin.
 
 	rg := r.Group("/music")
 
-	return &MusicService{
-		cfg:         cfg,
-		db:          db,
-		fileWatcher: watcher,
-		rg:          rg,
+	ms := &MusicService{
+		cfg:            cfg,
+		db:             db,
+		libraries:      libraries,
+		libraryNames:   libraryNames,
+		events:         events,
+		covers:         &coverCache{},
+		trustedProxies: trustedProxies,
+		rg:             rg,
 	}
+	ms.radio = NewRadioManager(ms)
+	return ms
 }
 
 func (ms *MusicService) Start() {
 	// 启动音乐服务的逻辑
-	if ms.fileWatcher != nil {
-		if err := ms.fileWatcher.Start(); err != nil {
-			logger.ZError(nil, "启动文件监控失败", err)
+	for _, name := range ms.libraryNames {
+		lib := ms.libraries[name]
+		if err := lib.watcher.Start(); err != nil {
+			logger.ZError(nil, "启动文件监控失败", err, "library", name)
 		}
-	} else {
-		logger.ZError(nil, "文件监控器未初始化", nil)
 	}
 
+	// 启动所有电台
+	ms.radio.StartAll()
+
 	ms.RegisterRoutes()
 }
+
+// migratePathHash 删除直接建在路径上的旧唯一索引，为已有曲目补全路径哈希，之后由 AutoMigrate 创建新索引
+func migratePathHash(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Music{}) {
+		return nil
+	}
+	if migrator.HasIndex(&Music{}, "idx_music_library_path") {
+		if err := migrator.DropIndex(&Music{}, "idx_music_library_path"); err != nil {
+			return err
+		}
+	}
+	if !migrator.HasColumn(&Music{}, "PathHash") {
+		if err := migrator.AddColumn(&Music{}, "PathHash"); err != nil {
+			return err
+		}
+	}
+	return db.Model(&Music{}).Where("path_hash = ?", "").
+		Update("path_hash", gorm.Expr("SHA2(file_path, 256)")).Error
+}
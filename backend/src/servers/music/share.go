@@ -0,0 +1,287 @@
+package music
+
+import (
+	"errors"
+	"myapp/middleware"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 分享目标类型
+const (
+	ShareTypeTrack     = "track"
+	ShareTypeFavorites = "favorites"
+)
+
+const (
+	defaultShareExpiry = 7 * 24 * time.Hour
+	maxShareExpiry     = 365 * 24 * time.Hour
+
+	// 一次播放或下载的会话有效期，期间同一首音乐的后续 Range 请求不再计入使用次数
+	shareSessionTTL = 30 * time.Minute
+)
+
+type Share struct {
+	ID            string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	OwnerID       string     `gorm:"type:varchar(255);not null;index" json:"owner_id"`
+	Type          string     `gorm:"type:varchar(32);not null" json:"type"`
+	MusicID       uint       `json:"music_id,omitempty"`
+	AllowDownload bool       `json:"allow_download"`
+	MaxUses       int        `json:"max_uses"` // 0 表示不限次数
+	UseCount      int        `json:"use_count"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateShareRequest struct {
+	Type          string `json:"type" binding:"required,oneof=track favorites"`
+	MusicID       uint   `json:"music_id"`
+	ExpiresIn     int64  `json:"expires_in"` // 秒，为 0 时默认 7 天
+	AllowDownload bool   `json:"allow_download"`
+	MaxUses       int    `json:"max_uses" binding:"min=0"`
+}
+
+type ShareResponse struct {
+	Share
+	Token string `json:"token,omitempty"`
+}
+
+var (
+	ErrShareInvalid   = errors.New("分享链接无效")
+	ErrShareExpired   = errors.New("分享链接已过期")
+	ErrShareRevoked   = errors.New("分享链接已被撤销")
+	ErrShareExhausted = errors.New("分享链接已达到使用次数上限")
+)
+
+// 分享令牌与登录令牌共用密钥，通过 typ 声明区分
+func signShareToken(share *Share) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      "share",
+		"share_id": share.ID,
+		"iat":      share.CreatedAt.Unix(),
+		"exp":      share.ExpiresAt.Unix(),
+	})
+	return token.SignedString([]byte(middleware.JWTSecret))
+}
+
+func parseShareToken(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(middleware.JWTSecret), nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", ErrShareExpired
+		}
+		return "", ErrShareInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "share" {
+		return "", ErrShareInvalid
+	}
+	shareID, ok := claims["share_id"].(string)
+	if !ok {
+		return "", ErrShareInvalid
+	}
+	return shareID, nil
+}
+
+// 分享会话令牌，通过 Cookie 下发给播放分享音乐的浏览器，绑定分享和音乐
+func signShareSession(shareID string, musicID uint) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      "share_session",
+		"share_id": shareID,
+		"music_id": musicID,
+		"exp":      time.Now().Add(shareSessionTTL).Unix(),
+	})
+	return token.SignedString([]byte(middleware.JWTSecret))
+}
+
+// validShareSession 判断会话令牌是否属于该分享和音乐且未过期
+func validShareSession(tokenString, shareID string, musicID uint) bool {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(middleware.JWTSecret), nil
+	})
+	if err != nil {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "share_session" || claims["share_id"] != shareID {
+		return false
+	}
+	id, ok := claims["music_id"].(float64)
+	return ok && uint(id) == musicID
+}
+
+func shareSessionCookie(shareID string) string {
+	return "share_" + shareID
+}
+
+func (ms *MusicService) createShare(userID string, req *CreateShareRequest, libraries []string) (*ShareResponse, error) {
+	expiresIn := time.Duration(req.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultShareExpiry
+	}
+	if expiresIn > maxShareExpiry {
+		return nil, errors.New("有效期不能超过 365 天")
+	}
+
+	share := &Share{
+		ID:            uuid.New().String(),
+		OwnerID:       userID,
+		Type:          req.Type,
+		AllowDownload: req.AllowDownload,
+		MaxUses:       req.MaxUses,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(expiresIn),
+	}
+
+	if req.Type == ShareTypeTrack {
+		var music Music
+		err := ms.db.Where("library IN ?", libraries).First(&music, req.MusicID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("音乐不存在")
+			}
+			return nil, err
+		}
+		share.MusicID = music.ID
+	}
+
+	if err := ms.db.Create(share).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := signShareToken(share)
+	if err != nil {
+		return nil, err
+	}
+	return &ShareResponse{Share: *share, Token: token}, nil
+}
+
+func (ms *MusicService) getUserShares(userID string) ([]Share, error) {
+	var shares []Share
+	err := ms.db.Where("owner_id = ?", userID).Order("created_at DESC").Find(&shares).Error
+	return shares, err
+}
+
+func (ms *MusicService) revokeShare(userID, shareID string) error {
+	now := time.Now()
+	result := ms.db.Model(&Share{}).
+		Where("id = ? AND owner_id = ? AND revoked_at IS NULL", shareID, userID).
+		Update("revoked_at", &now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("未找到分享记录")
+	}
+	return nil
+}
+
+// 校验分享令牌并返回分享记录
+func (ms *MusicService) resolveShare(tokenString string) (*Share, error) {
+	share, err := ms.loadShare(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if share.MaxUses > 0 && share.UseCount >= share.MaxUses {
+		return nil, ErrShareExhausted
+	}
+	return share, nil
+}
+
+// loadShare 校验分享令牌、撤销和过期，不检查使用次数
+func (ms *MusicService) loadShare(tokenString string) (*Share, error) {
+	shareID, err := parseShareToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	var share Share
+	if err := ms.db.Where("id = ?", shareID).First(&share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareInvalid
+		}
+		return nil, err
+	}
+
+	if share.RevokedAt != nil {
+		return nil, ErrShareRevoked
+	}
+	if time.Now().After(share.ExpiresAt) {
+		return nil, ErrShareExpired
+	}
+	return &share, nil
+}
+
+// 消耗一次使用次数，并发请求下通过条件更新保证不超过上限
+func (ms *MusicService) consumeShare(share *Share) error {
+	query := ms.db.Model(&Share{}).Where("id = ?", share.ID)
+	if share.MaxUses > 0 {
+		query = query.Where("use_count < max_uses")
+	}
+	result := query.Update("use_count", gorm.Expr("use_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareExhausted
+	}
+	return nil
+}
+
+// 获取分享中包含的音乐，范围限定在分享者当前可访问的音乐库内
+func (ms *MusicService) getShareMusicList(share *Share) ([]Music, error) {
+	libraries := ms.librariesFor(ms.lookupCaller(share.OwnerID))
+
+	switch share.Type {
+	case ShareTypeTrack:
+		var music Music
+		if err := ms.db.Where("library IN ?", libraries).First(&music, share.MusicID).Error; err != nil {
+			return nil, err
+		}
+		return []Music{music}, nil
+	case ShareTypeFavorites:
+		return ms.getUserMusicList(share.OwnerID, libraries)
+	}
+	return nil, ErrShareInvalid
+}
+
+// 获取分享中的单首音乐，确保不能借分享访问其他音乐
+func (ms *MusicService) getShareMusic(share *Share, musicID uint) (*Music, error) {
+	libraries := ms.librariesFor(ms.lookupCaller(share.OwnerID))
+
+	switch share.Type {
+	case ShareTypeTrack:
+		if musicID != share.MusicID {
+			return nil, gorm.ErrRecordNotFound
+		}
+	case ShareTypeFavorites:
+		fav, err := ms.isInMyMusic(share.OwnerID, musicID, libraries)
+		if err != nil {
+			return nil, err
+		}
+		if !fav {
+			return nil, gorm.ErrRecordNotFound
+		}
+	default:
+		return nil, ErrShareInvalid
+	}
+
+	var music Music
+	if err := ms.db.Where("library IN ?", libraries).First(&music, musicID).Error; err != nil {
+		return nil, err
+	}
+	return &music, nil
+}
@@ -1,10 +1,12 @@
 package music
 
 import (
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 
 	"github.com/fsnotify/fsnotify"
 	"gorm.io/gorm"
@@ -12,11 +14,15 @@ import (
 
 type FileWatcher struct {
 	watcher  *fsnotify.Watcher
+	library  string
 	musicDir string
 	db       *gorm.DB
+	events   *EventHub
+	filter   *FileFilter
+	scanning atomic.Bool
 }
 
-func NewFileWatcher(musicDir string, db *gorm.DB) (*FileWatcher, error) {
+func NewFileWatcher(library, musicDir string, db *gorm.DB, events *EventHub, filter *FileFilter) (*FileWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -29,19 +35,22 @@ func NewFileWatcher(musicDir string, db *gorm.DB) (*FileWatcher, error) {
 
 	return &FileWatcher{
 		watcher:  watcher,
+		library:  library,
 		musicDir: musicDir,
 		db:       db,
+		events:   events,
+		filter:   filter,
 	}, nil
 }
 
 func (fw *FileWatcher) Start() error {
-	// 添加监控目录
-	err := fw.watcher.Add(fw.musicDir)
+	// 添加监控目录（包括未被忽略的子目录）
+	err := fw.watchDir(fw.musicDir)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("开始监控音乐目录: %s", fw.musicDir)
+	log.Printf("开始监控音乐目录: %s (音乐库: %s)", fw.musicDir, fw.library)
 
 	// 初始化：扫描现有文件
 	fw.scanExistingFiles()
@@ -62,19 +71,32 @@ func (fw *FileWatcher) watch() {
 
 			log.Printf("检测到文件事件: %s - %s", event.Op, event.Name)
 
-			// 只处理音频文件
-			if !isMusicFile(event.Name) {
-				log.Printf("忽略非音乐文件: %s", event.Name)
+			// 忽略规则变化后重新扫描，清理新被忽略的文件并加入重新包含的文件
+			if filepath.Base(event.Name) == IgnoreFileName {
+				fw.filter.InvalidateDir(filepath.Dir(event.Name))
+				fw.Rescan()
 				continue
 			}
 
 			switch {
 			case event.Op&fsnotify.Create == fsnotify.Create:
-				fw.handleCreate(event.Name)
+				info, err := os.Stat(event.Name)
+				if err != nil {
+					continue
+				}
+				if info.IsDir() {
+					fw.handleCreateDir(event.Name)
+				} else if fw.filter.Accept(event.Name) {
+					fw.handleCreate(event.Name)
+				}
 			case event.Op&fsnotify.Remove == fsnotify.Remove:
 				fw.handleDelete(event.Name)
 			case event.Op&fsnotify.Rename == fsnotify.Rename:
 				fw.handleDelete(event.Name)
+			case event.Op&fsnotify.Write == fsnotify.Write:
+				if fw.filter.Accept(event.Name) {
+					fw.handleWrite(event.Name)
+				}
 			}
 
 		case err, ok := <-fw.watcher.Errors:
@@ -87,63 +109,229 @@ func (fw *FileWatcher) watch() {
 }
 
 func (fw *FileWatcher) scanExistingFiles() {
-	files, err := os.ReadDir(fw.musicDir)
+	if !fw.scanning.CompareAndSwap(false, true) {
+		log.Printf("扫描已在进行中，忽略本次请求")
+		return
+	}
+	defer fw.scanning.Store(false)
+
+	fw.scan()
+}
+
+// Rescan 在后台重新扫描音乐目录，扫描进度通过事件推送
+func (fw *FileWatcher) Rescan() bool {
+	if !fw.scanning.CompareAndSwap(false, true) {
+		return false
+	}
+
+	go func() {
+		defer fw.scanning.Store(false)
+		fw.scan()
+	}()
+	return true
+}
+
+func (fw *FileWatcher) scan() {
+	musicFiles, err := fw.collectMusicFiles(fw.musicDir)
 	if err != nil {
 		log.Printf("扫描目录失败: %v", err)
 		return
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	total := len(musicFiles)
+	fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventRescanStarted, Total: total})
+
+	for i, filePath := range musicFiles {
+		fw.handleCreate(filePath)
+		fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventRescanProgress, Scanned: i + 1, Total: total})
+	}
+
+	fw.pruneMissingFiles()
+
+	fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventRescanDone, Scanned: total, Total: total})
+	log.Printf("已扫描现有音乐文件，共 %d 个", total)
+}
+
+// 递归收集目录下未被忽略的音乐文件
+func (fw *FileWatcher) collectMusicFiles(root string) ([]string, error) {
+	var musicFiles []string
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("读取路径失败: %s - %v", filePath, err)
+			return nil
+		}
+		if d.IsDir() {
+			if filePath != root && fw.filter.IsIgnored(filePath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fw.filter.Accept(filePath) {
+			musicFiles = append(musicFiles, filePath)
 		}
+		return nil
+	})
+	return musicFiles, err
+}
 
-		filePath := filepath.Join(fw.musicDir, file.Name())
-		if isMusicFile(filePath) {
-			fw.handleCreate(filePath)
+// 递归监控目录，跳过被忽略的子目录
+func (fw *FileWatcher) watchDir(root string) error {
+	return filepath.WalkDir(root, func(dirPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if dirPath == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
 		}
+		if dirPath != root && fw.filter.IsIgnored(dirPath, true) {
+			return filepath.SkipDir
+		}
+		return fw.watcher.Add(dirPath)
+	})
+}
+
+// 新建目录时加入监控，并索引其中已有的文件（例如整个专辑被移动进来）
+func (fw *FileWatcher) handleCreateDir(dirPath string) {
+	if fw.filter.IsIgnored(dirPath, true) {
+		return
+	}
+	if err := fw.watchDir(dirPath); err != nil {
+		log.Printf("监控目录失败: %s - %v", dirPath, err)
+		return
 	}
 
-	log.Printf("已扫描现有音乐文件，共 %d 个", len(files))
+	musicFiles, err := fw.collectMusicFiles(dirPath)
+	if err != nil {
+		log.Printf("扫描目录失败: %v", err)
+		return
+	}
+	for _, filePath := range musicFiles {
+		fw.handleCreate(filePath)
+	}
+}
+
+// 清理数据库中文件已不存在或已被排除的音乐记录
+func (fw *FileWatcher) pruneMissingFiles() {
+	var musicList []Music
+	if err := fw.db.Where("library = ?", fw.library).Find(&musicList).Error; err != nil {
+		log.Printf("查询音乐列表失败: %v", err)
+		return
+	}
+
+	for _, music := range musicList {
+		fullPath := filepath.Join(fw.musicDir, filepath.FromSlash(music.FilePath))
+		if _, err := os.Stat(fullPath); !os.IsNotExist(err) && fw.filter.Accept(fullPath) {
+			continue
+		}
+		fw.handleDelete(fullPath)
+	}
 }
 
 func (fw *FileWatcher) handleCreate(filePath string) {
+	if _, err := fw.indexFile(filePath); err != nil {
+		log.Printf("添加音乐失败: %v", err)
+	}
+}
+
+// indexFile 将文件加入数据库，已存在时返回已有记录
+func (fw *FileWatcher) indexFile(filePath string) (*Music, error) {
 	fileName := getFileName(filePath)
 	relativePath := getRelativePath(fw.musicDir, filePath)
 
-	// 检查是否已存在
+	// 检查是否已存在，不同目录下可以有同名文件
 	var existing Music
-	result := fw.db.Where("name = ?", fileName).First(&existing)
+	result := fw.db.Where("library = ? AND path_hash = ?", fw.library, HashFilePath(relativePath)).First(&existing)
 	if result.Error == nil {
-		log.Printf("音乐已存在: %s", fileName)
-		return
+		log.Printf("音乐已存在: %s", relativePath)
+		// 补全旧版本未记录的标签信息
+		if existing.Title == "" {
+			tags := readTags(filePath, existing.FilePath)
+			fw.db.Model(&existing).Updates(Music{Title: tags.Title, Artist: tags.Artist, Album: tags.Album})
+		}
+		if existing.SampleRate == 0 {
+			fw.updateGapless(&existing, filePath)
+		}
+		return &existing, nil
 	}
 
 	// 添加到数据库
+	tags := readTags(filePath, relativePath)
 	music := Music{
+		Library:  fw.library,
 		Name:     fileName,
 		FilePath: relativePath,
+		PathHash: HashFilePath(relativePath),
+		Title:    tags.Title,
+		Artist:   tags.Artist,
+		Album:    tags.Album,
+	}
+	if info, err := readGapless(filePath); err == nil {
+		music.applyGapless(info)
 	}
 
 	if err := fw.db.Create(&music).Error; err != nil {
-		log.Printf("添加音乐失败: %v", err)
-		return
+		// 文件监控和调用方可能同时索引同一文件
+		if fw.db.Where("library = ? AND path_hash = ?", fw.library, music.PathHash).First(&existing).Error == nil {
+			return &existing, nil
+		}
+		return nil, err
 	}
 
 	log.Printf("✅ 新增音乐: %s (ID: %d)", fileName, music.ID)
+	fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventTrackAdded, Music: &music})
+	return &music, nil
 }
 
+func (fw *FileWatcher) handleWrite(filePath string) {
+	relativePath := getRelativePath(fw.musicDir, filePath)
+
+	var existing Music
+	if err := fw.db.Where("library = ? AND path_hash = ?", fw.library, HashFilePath(relativePath)).First(&existing).Error; err != nil {
+		return
+	}
+
+	// 文件内容变化后重新计算采样数
+	fw.updateGapless(&existing, filePath)
+	fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventTrackUpdated, Music: &existing})
+}
+
+// updateGapless 重新读取无缝播放信息，无法识别的格式保持不变
+func (fw *FileWatcher) updateGapless(music *Music, filePath string) {
+	info, err := readGapless(filePath)
+	if err != nil {
+		return
+	}
+	music.applyGapless(info)
+	fw.db.Model(music).Select("sample_rate", "sample_count", "encoder_delay", "encoder_padding").Updates(music)
+}
+
+// 删除文件或目录对应的音乐记录，删除目录时移除其下所有音乐
 func (fw *FileWatcher) handleDelete(filePath string) {
-	fileName := getFileName(filePath)
+	relativePath := getRelativePath(fw.musicDir, filePath)
 
-	result := fw.db.Where("name = ?", fileName).Delete(&Music{})
-	if result.Error != nil {
-		log.Printf("删除音乐失败: %v", result.Error)
+	var musicList []Music
+	err := fw.db.Where("library = ? AND (file_path = ? OR file_path LIKE ?)",
+		fw.library, relativePath, escapeLike(relativePath)+"/%").
+		Find(&musicList).Error
+	if err != nil {
+		log.Printf("查询音乐失败: %v", err)
 		return
 	}
 
-	if result.RowsAffected > 0 {
-		log.Printf("🗑️  删除音乐: %s", fileName)
+	for _, existing := range musicList {
+		result := fw.db.Delete(&existing)
+		if result.Error != nil {
+			log.Printf("删除音乐失败: %v", result.Error)
+			continue
+		}
+
+		if result.RowsAffected > 0 {
+			log.Printf("🗑️  删除音乐: %s", existing.Name)
+			fw.events.Publish(LibraryEvent{Library: fw.library, Type: EventTrackRemoved, Music: &existing})
+		}
 	}
 }
 
@@ -169,15 +357,8 @@ func (fw *FileWatcher) Close() error {
 }
 
 // 辅助函数
-func isMusicFile(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	musicExts := []string{".mp3", ".flac", ".wav", ".aac", ".ogg", ".m4a"}
-	for _, musicExt := range musicExts {
-		if ext == musicExt {
-			return true
-		}
-	}
-	return false
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 func getFileName(filePath string) string {
@@ -0,0 +1,102 @@
+package music
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm/schema"
+)
+
+// insertArgs 按插入 Music 时的列顺序生成参数，values 中的列检查取值，其他列不检查
+func insertArgs(t *testing.T, values map[string]driver.Value) []driver.Value {
+	t.Helper()
+	s, err := schema.Parse(&Music{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []driver.Value
+	for _, name := range s.DBNames {
+		if name == "id" {
+			continue
+		}
+		if value, ok := values[name]; ok {
+			args = append(args, value)
+		} else {
+			args = append(args, sqlmock.AnyArg())
+		}
+	}
+	return args
+}
+
+// 曲目按库内路径的哈希查找和去重，超出索引长度的长路径和不同目录下的同名文件都能索引
+func TestIndexFileByPathHash(t *testing.T) {
+	dir := t.TempDir()
+	segment := strings.Repeat("x", 200)
+	long := filepath.Join(segment, segment, segment, segment)
+	files := []string{
+		filepath.Join(dir, long, "a", "song.mp3"),
+		filepath.Join(dir, long, "b", "song.mp3"),
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, mock := newMockDB(t)
+	fw := &FileWatcher{library: "music", musicDir: dir, db: db, events: NewEventHub()}
+	for i, file := range files {
+		relativePath := getRelativePath(dir, file)
+		if len(relativePath) <= 700 {
+			t.Fatalf("测试路径长度为 %d，应超出旧的索引长度", len(relativePath))
+		}
+		hash := HashFilePath(relativePath)
+		mock.ExpectQuery("SELECT \\* FROM `musics` WHERE library = \\? AND path_hash = \\?").
+			WithArgs("music", hash, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `musics`").
+			WithArgs(insertArgs(t, map[string]driver.Value{"library": "music", "name": "song", "file_path": relativePath, "path_hash": hash})...).
+			WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+		mock.ExpectCommit()
+
+		music, err := fw.indexFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if music.ID != uint(i+1) || music.PathHash != hash {
+			t.Errorf("索引结果错误: id = %d, path_hash = %s", music.ID, music.PathHash)
+		}
+	}
+
+	// 已索引的文件按路径哈希找到已有记录
+	relativePath := getRelativePath(dir, files[0])
+	mock.ExpectQuery("SELECT \\* FROM `musics` WHERE library = \\? AND path_hash = \\?").
+		WithArgs("music", HashFilePath(relativePath), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "library", "file_path", "path_hash", "title", "sample_rate"}).
+			AddRow(1, "music", relativePath, HashFilePath(relativePath), "Song", 44100))
+	music, err := fw.indexFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if music.ID != 1 {
+		t.Errorf("应返回已有记录，id = %d", music.ID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// 与 MySQL 的 SHA2(file_path, 256) 一致，迁移时用它补全已有曲目
+	if got := HashFilePath("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("HashFilePath = %s", got)
+	}
+}
@@ -0,0 +1,137 @@
+package podcast
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (ps *PodcastService) GetPodcasts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	podcasts, err := ps.getUserPodcasts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取订阅列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": podcasts})
+}
+
+func (ps *PodcastService) Subscribe(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req SubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	podcast, err := ps.subscribe(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": podcast, "message": "订阅成功"})
+}
+
+func (ps *PodcastService) Unsubscribe(c *gin.Context) {
+	userID := c.GetString("user_id")
+	podcastID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的播客ID"})
+		return
+	}
+
+	if err := ps.unsubscribe(userID, uint(podcastID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "取消订阅成功"})
+}
+
+func (ps *PodcastService) RefreshPodcast(c *gin.Context) {
+	userID := c.GetString("user_id")
+	podcastID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的播客ID"})
+		return
+	}
+
+	podcast, err := ps.refreshUserPodcast(userID, uint(podcastID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": podcast, "message": "刷新成功"})
+}
+
+func (ps *PodcastService) GetEpisodes(c *gin.Context) {
+	userID := c.GetString("user_id")
+	podcastID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的播客ID"})
+		return
+	}
+	if !ps.isSubscribed(userID, uint(podcastID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未订阅该播客"})
+		return
+	}
+
+	episodes, err := ps.getEpisodes(userID, uint(podcastID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取单集列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": episodes})
+}
+
+func (ps *PodcastService) DownloadEpisode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	episodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的单集ID"})
+		return
+	}
+
+	if err := ps.requestDownload(userID, uint(episodeID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已开始下载"})
+}
+
+// StreamEpisode 播放已下载的单集，只有订阅了该播客的用户可以访问
+func (ps *PodcastService) StreamEpisode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	episodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的单集ID"})
+		return
+	}
+
+	filePath, err := ps.episodeFile(userID, uint(episodeID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.File(filePath)
+}
+
+func (ps *PodcastService) UpdateEpisodeState(c *gin.Context) {
+	userID := c.GetString("user_id")
+	episodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的单集ID"})
+		return
+	}
+	var req EpisodeStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	if err := ps.updateEpisodeState(userID, uint(episodeID), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
+}
@@ -0,0 +1,244 @@
+package podcast
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 订阅源解析，支持 RSS 2.0（含 iTunes 扩展）和 Atom
+
+type feed struct {
+	Title       string
+	Description string
+	Link        string
+	ImageURL    string
+	Items       []feedItem
+}
+
+type feedItem struct {
+	GUID            string
+	Title           string
+	Description     string
+	EnclosureURL    string
+	EnclosureType   string
+	EnclosureLength int64
+	PublishedAt     time.Time
+	Duration        int
+}
+
+type rssDocument struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title       string `xml:"title"`
+		Description string `xml:"description"`
+		Link        string `xml:"link"`
+		// 不带命名空间的字段会匹配任意命名空间，itunes:image 需放在前面
+		ITunesImage struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Image struct {
+			URL string `xml:"url"`
+		} `xml:"image"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Description string `xml:"description"`
+			GUID        string `xml:"guid"`
+			Link        string `xml:"link"`
+			PubDate     string `xml:"pubDate"`
+			Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure   struct {
+				URL    string `xml:"url,attr"`
+				Type   string `xml:"type,attr"`
+				Length string `xml:"length,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	XMLName  xml.Name   `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string     `xml:"title"`
+	Subtitle string     `xml:"subtitle"`
+	Logo     string     `xml:"logo"`
+	Links    []atomLink `xml:"link"`
+	Entries  []struct {
+		ID        string     `xml:"id"`
+		Title     string     `xml:"title"`
+		Summary   string     `xml:"summary"`
+		Content   string     `xml:"content"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Links     []atomLink `xml:"link"`
+	} `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+var errUnknownFeed = errors.New("无法识别的订阅源格式")
+
+func fetchFeed(client *http.Client, url string) (*feed, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取订阅源失败: HTTP %d", resp.StatusCode)
+	}
+
+	// 限制订阅源大小，避免异常源占满内存
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseFeed(data)
+}
+
+func parseFeed(data []byte) (*feed, error) {
+	var rss rssDocument
+	if err := xml.Unmarshal(data, &rss); err == nil {
+		return rssToFeed(&rss), nil
+	}
+
+	var atom atomDocument
+	if err := xml.Unmarshal(data, &atom); err == nil {
+		return atomToFeed(&atom), nil
+	}
+	return nil, errUnknownFeed
+}
+
+func rssToFeed(doc *rssDocument) *feed {
+	ch := doc.Channel
+	f := &feed{
+		Title:       strings.TrimSpace(ch.Title),
+		Description: strings.TrimSpace(ch.Description),
+		Link:        strings.TrimSpace(ch.Link),
+		ImageURL:    ch.ITunesImage.Href,
+	}
+	if f.ImageURL == "" {
+		f.ImageURL = ch.Image.URL
+	}
+
+	for _, item := range ch.Items {
+		if item.Enclosure.URL == "" {
+			continue
+		}
+		length, _ := strconv.ParseInt(item.Enclosure.Length, 10, 64)
+		guid := strings.TrimSpace(item.GUID)
+		if guid == "" {
+			guid = item.Enclosure.URL
+		}
+		f.Items = append(f.Items, feedItem{
+			GUID:            guid,
+			Title:           strings.TrimSpace(item.Title),
+			Description:     strings.TrimSpace(item.Description),
+			EnclosureURL:    item.Enclosure.URL,
+			EnclosureType:   item.Enclosure.Type,
+			EnclosureLength: length,
+			PublishedAt:     parseFeedTime(item.PubDate),
+			Duration:        parseDuration(item.Duration),
+		})
+	}
+	return f
+}
+
+func atomToFeed(doc *atomDocument) *feed {
+	f := &feed{
+		Title:       strings.TrimSpace(doc.Title),
+		Description: strings.TrimSpace(doc.Subtitle),
+		ImageURL:    doc.Logo,
+	}
+	for _, link := range doc.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			f.Link = link.Href
+			break
+		}
+	}
+
+	for _, entry := range doc.Entries {
+		var enclosure *atomLink
+		for i := range entry.Links {
+			if entry.Links[i].Rel == "enclosure" {
+				enclosure = &entry.Links[i]
+				break
+			}
+		}
+		if enclosure == nil || enclosure.Href == "" {
+			continue
+		}
+
+		length, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+		published := entry.Published
+		if published == "" {
+			published = entry.Updated
+		}
+		description := entry.Summary
+		if description == "" {
+			description = entry.Content
+		}
+		guid := strings.TrimSpace(entry.ID)
+		if guid == "" {
+			guid = enclosure.Href
+		}
+		f.Items = append(f.Items, feedItem{
+			GUID:            guid,
+			Title:           strings.TrimSpace(entry.Title),
+			Description:     strings.TrimSpace(description),
+			EnclosureURL:    enclosure.Href,
+			EnclosureType:   enclosure.Type,
+			EnclosureLength: length,
+			PublishedAt:     parseFeedTime(published),
+		})
+	}
+	return f
+}
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseDuration 解析 itunes:duration，支持秒数、MM:SS 和 HH:MM:SS
+func parseDuration(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	total := 0
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
@@ -0,0 +1,84 @@
+package podcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// 订阅地址由用户提交，服务端拉取订阅源和单集时只允许访问公网地址，
+// 避免被用来探测内网服务或云主机的元数据接口。
+// 连接时检查实际解析出的 IP，重定向和 DNS 重新绑定同样受限。
+
+var errPrivateAddress = errors.New("不允许访问内网地址")
+
+const maxRedirects = 10
+
+// CGNAT 地址段，net.IP 没有对应的判断方法
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+// checkFetchURL 检查地址的协议，并解析主机名确认不指向内网地址，用于在订阅时给出明确的错误
+func (ps *PodcastService) checkFetchURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("订阅地址只支持 http 和 https")
+	}
+	if ps.cfg.AllowPrivateHosts {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("无法解析主机名: %s", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+// newFetchClient 创建拉取订阅源和单集使用的客户端，未允许访问内网时在建立连接前检查目标 IP
+func newFetchClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 不使用环境变量中的代理，否则检查的是代理的地址
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("重定向次数过多")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("不支持重定向到 %s 地址", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,540 @@
+package podcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 单集下载状态
+const (
+	EpisodeStatusPending     = "pending"
+	EpisodeStatusDownloading = "downloading"
+	EpisodeStatusDownloaded  = "downloaded"
+	EpisodeStatusFailed      = "failed"
+)
+
+const defaultMaxEpisodeSize = 1 << 30
+
+var errEpisodeTooLarge = errors.New("单集文件超过大小上限")
+
+type Podcast struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	FeedURL      string     `gorm:"type:varchar(512);not null;unique" json:"feed_url"`
+	Title        string     `gorm:"type:varchar(255)" json:"title"`
+	Description  string     `gorm:"type:text" json:"description"`
+	Link         string     `gorm:"type:varchar(1024)" json:"link"`
+	ImageURL     string     `gorm:"type:varchar(1024)" json:"image_url"`
+	LastPolledAt *time.Time `json:"last_polled_at"`
+	LastError    string     `gorm:"type:varchar(1024)" json:"last_error"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// 同一订阅源只保存一份，用户通过订阅关系关联
+type Subscription struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"type:varchar(255);not null;index:idx_podcast_subscription,unique" json:"user_id"`
+	PodcastID uint      `gorm:"not null;index:idx_podcast_subscription,unique" json:"podcast_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (Subscription) TableName() string {
+	return "podcast_subscriptions"
+}
+
+type Episode struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	PodcastID       uint      `gorm:"not null;index:idx_episode_guid,unique" json:"podcast_id"`
+	GUID            string    `gorm:"type:varchar(512);not null;index:idx_episode_guid,unique" json:"guid"`
+	Title           string    `gorm:"type:varchar(512)" json:"title"`
+	Description     string    `gorm:"type:text" json:"description"`
+	EnclosureURL    string    `gorm:"type:varchar(2048)" json:"enclosure_url"`
+	EnclosureType   string    `gorm:"type:varchar(128)" json:"enclosure_type"`
+	EnclosureLength int64     `json:"enclosure_length"`
+	PublishedAt     time.Time `gorm:"index" json:"published_at"`
+	Duration        int       `json:"duration"` // 秒
+	Status          string    `gorm:"type:varchar(32);not null;default:'pending'" json:"status"`
+	FilePath        string    `gorm:"type:varchar(1024)" json:"-"`
+	MusicID         *uint     `json:"music_id"` // 下载完成后对应的音乐记录
+	Error           string    `gorm:"type:varchar(1024)" json:"error,omitempty"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (Episode) TableName() string {
+	return "podcast_episodes"
+}
+
+// 用户的收听进度
+type EpisodeState struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    string    `gorm:"type:varchar(255);not null;index:idx_episode_state,unique" json:"-"`
+	EpisodeID uint      `gorm:"not null;index:idx_episode_state,unique" json:"episode_id"`
+	Position  int       `json:"position"` // 秒
+	Played    bool      `json:"played"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (EpisodeState) TableName() string {
+	return "podcast_episode_states"
+}
+
+type SubscribeRequest struct {
+	FeedURL string `json:"feed_url" binding:"required,url"`
+}
+
+type EpisodeStateRequest struct {
+	Position int  `json:"position" binding:"min=0"`
+	Played   bool `json:"played"`
+}
+
+type EpisodeResponse struct {
+	Episode
+	StreamURL string `json:"stream_url,omitempty"`
+	Position  int    `json:"position"`
+	Played    bool   `json:"played"`
+}
+
+func (ps *PodcastService) subscribe(userID string, req *SubscribeRequest) (*Podcast, error) {
+	if err := ps.checkFetchURL(context.Background(), req.FeedURL); err != nil {
+		return nil, err
+	}
+	podcast := Podcast{FeedURL: req.FeedURL}
+	if err := ps.db.Where("feed_url = ?", req.FeedURL).FirstOrCreate(&podcast).Error; err != nil {
+		return nil, err
+	}
+
+	sub := Subscription{UserID: userID, PodcastID: podcast.ID}
+	err := ps.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&sub).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 首次订阅时立即拉取，失败原因记录在 LastError 中，等待下次轮询重试
+	if podcast.LastPolledAt == nil {
+		_ = ps.refreshPodcast(&podcast)
+	}
+	return &podcast, nil
+}
+
+// 取消订阅，没有其他订阅者时删除订阅源、单集和已下载的文件
+func (ps *PodcastService) unsubscribe(userID string, podcastID uint) error {
+	result := ps.db.Where("user_id = ? AND podcast_id = ?", userID, podcastID).Delete(&Subscription{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("未订阅该播客")
+	}
+
+	var count int64
+	if err := ps.db.Model(&Subscription{}).Where("podcast_id = ?", podcastID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return ps.removePodcast(podcastID)
+}
+
+func (ps *PodcastService) removePodcast(podcastID uint) error {
+	var episodes []Episode
+	if err := ps.db.Where("podcast_id = ?", podcastID).Find(&episodes).Error; err != nil {
+		return err
+	}
+
+	// 删除文件后由文件监控移除对应的音乐记录
+	for _, ep := range episodes {
+		if ep.FilePath != "" {
+			_ = os.Remove(ep.FilePath)
+		}
+	}
+
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		episodeIDs := tx.Model(&Episode{}).Select("id").Where("podcast_id = ?", podcastID)
+		if err := tx.Where("episode_id IN (?)", episodeIDs).Delete(&EpisodeState{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("podcast_id = ?", podcastID).Delete(&Episode{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Podcast{}, podcastID).Error
+	})
+}
+
+func (ps *PodcastService) hasSubscribers(podcastID uint) bool {
+	var count int64
+	if err := ps.db.Model(&Subscription{}).Where("podcast_id = ?", podcastID).Count(&count).Error; err != nil {
+		return true
+	}
+	return count > 0
+}
+
+// DeleteUserData 删除用户的订阅和收听进度，在删除用户的事务中调用；
+// 无人订阅的播客在下次拉取时删除
+func (ps *PodcastService) DeleteUserData(tx *gorm.DB, userID string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&EpisodeState{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&Subscription{}).Error
+}
+
+func (ps *PodcastService) isSubscribed(userID string, podcastID uint) bool {
+	var count int64
+	ps.db.Model(&Subscription{}).Where("user_id = ? AND podcast_id = ?", userID, podcastID).Count(&count)
+	return count > 0
+}
+
+func (ps *PodcastService) getUserPodcasts(userID string) ([]Podcast, error) {
+	var podcasts []Podcast
+	err := ps.db.Joins("JOIN podcast_subscriptions ON podcast_subscriptions.podcast_id = podcasts.id").
+		Where("podcast_subscriptions.user_id = ?", userID).
+		Order("podcast_subscriptions.created_at DESC").
+		Find(&podcasts).Error
+	return podcasts, err
+}
+
+func (ps *PodcastService) getEpisodes(userID string, podcastID uint) ([]EpisodeResponse, error) {
+	var episodes []Episode
+	err := ps.db.Where("podcast_id = ?", podcastID).Order("published_at DESC").Find(&episodes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var states []EpisodeState
+	err = ps.db.Where("user_id = ? AND episode_id IN (?)", userID,
+		ps.db.Model(&Episode{}).Select("id").Where("podcast_id = ?", podcastID)).
+		Find(&states).Error
+	if err != nil {
+		return nil, err
+	}
+	stateMap := make(map[uint]EpisodeState, len(states))
+	for _, st := range states {
+		stateMap[st.EpisodeID] = st
+	}
+
+	result := make([]EpisodeResponse, 0, len(episodes))
+	for _, ep := range episodes {
+		resp := EpisodeResponse{Episode: ep}
+		if ep.Status == EpisodeStatusDownloaded {
+			resp.StreamURL = fmt.Sprintf("/podcast/episodes/%d/stream", ep.ID)
+		}
+		if st, ok := stateMap[ep.ID]; ok {
+			resp.Position = st.Position
+			resp.Played = st.Played
+		}
+		result = append(result, resp)
+	}
+	return result, nil
+}
+
+// 获取用户已订阅播客中的单集
+func (ps *PodcastService) getUserEpisode(userID string, episodeID uint) (*Episode, error) {
+	var ep Episode
+	if err := ps.db.First(&ep, episodeID).Error; err != nil {
+		return nil, errors.New("单集不存在")
+	}
+	if !ps.isSubscribed(userID, ep.PodcastID) {
+		return nil, errors.New("未订阅该播客")
+	}
+	return &ep, nil
+}
+
+func (ps *PodcastService) updateEpisodeState(userID string, episodeID uint, req *EpisodeStateRequest) error {
+	if _, err := ps.getUserEpisode(userID, episodeID); err != nil {
+		return err
+	}
+
+	state := EpisodeState{
+		UserID:    userID,
+		EpisodeID: episodeID,
+		Position:  req.Position,
+		Played:    req.Played,
+	}
+	return ps.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "episode_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"position", "played", "updated_at"}),
+	}).Create(&state).Error
+}
+
+// refreshPodcast 拉取订阅源，保存新单集并下载最新的几集
+func (ps *PodcastService) refreshPodcast(podcast *Podcast) error {
+	now := time.Now()
+	f, err := fetchFeed(ps.client, podcast.FeedURL)
+	if err != nil {
+		ps.db.Model(podcast).Updates(map[string]interface{}{
+			"last_polled_at": now,
+			"last_error":     err.Error(),
+		})
+		return err
+	}
+
+	podcast.Title = f.Title
+	podcast.Description = f.Description
+	podcast.Link = f.Link
+	podcast.ImageURL = f.ImageURL
+	podcast.LastPolledAt = &now
+	podcast.LastError = ""
+	if err := ps.db.Save(podcast).Error; err != nil {
+		return err
+	}
+
+	for _, item := range f.Items {
+		// 缺少发布时间的单集按发现时间排序
+		if item.PublishedAt.IsZero() {
+			item.PublishedAt = now
+		}
+		ep := Episode{
+			PodcastID:       podcast.ID,
+			GUID:            item.GUID,
+			Title:           item.Title,
+			Description:     item.Description,
+			EnclosureURL:    item.EnclosureURL,
+			EnclosureType:   item.EnclosureType,
+			EnclosureLength: item.EnclosureLength,
+			PublishedAt:     item.PublishedAt,
+			Duration:        item.Duration,
+			Status:          EpisodeStatusPending,
+		}
+		err := ps.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ep).Error
+		if err != nil {
+			log.Printf("保存播客单集失败: %v", err)
+		}
+	}
+
+	go ps.downloadLatest(podcast)
+	return nil
+}
+
+// 自动下载每个播客最新的 AutoDownload 集
+func (ps *PodcastService) downloadLatest(podcast *Podcast) {
+	if ps.cfg.AutoDownload <= 0 {
+		return
+	}
+
+	var episodes []Episode
+	err := ps.db.Where("podcast_id = ?", podcast.ID).
+		Order("published_at DESC").
+		Limit(ps.cfg.AutoDownload).
+		Find(&episodes).Error
+	if err != nil {
+		log.Printf("查询播客单集失败: %v", err)
+		return
+	}
+
+	for i := range episodes {
+		if episodes[i].Status == EpisodeStatusPending {
+			ps.downloadEpisode(podcast, &episodes[i])
+		}
+	}
+}
+
+func (ps *PodcastService) downloadEpisode(podcast *Podcast, ep *Episode) {
+	// 轮询和手动下载可能同时触发，通过条件更新保证同一集只下载一次
+	result := ps.db.Model(&Episode{}).
+		Where("id = ? AND status IN ?", ep.ID, []string{EpisodeStatusPending, EpisodeStatusFailed}).
+		Update("status", EpisodeStatusDownloading)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	filePath, err := ps.fetchEnclosure(podcast, ep)
+	if err != nil {
+		log.Printf("下载播客单集失败: %s - %v", ep.Title, err)
+		ps.db.Model(ep).Updates(map[string]interface{}{
+			"status": EpisodeStatusFailed,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	music, err := ps.indexFile(ps.cfg.Library, filePath)
+	if err != nil {
+		log.Printf("索引播客单集失败: %s - %v", ep.Title, err)
+		ps.db.Model(ep).Updates(map[string]interface{}{
+			"status":    EpisodeStatusFailed,
+			"file_path": filePath,
+			"error":     err.Error(),
+		})
+		return
+	}
+
+	ps.db.Model(ep).Updates(map[string]interface{}{
+		"status":    EpisodeStatusDownloaded,
+		"file_path": filePath,
+		"music_id":  music.ID,
+		"error":     "",
+	})
+}
+
+// 下载到临时文件，完成后再重命名，避免文件监控索引不完整的文件
+func (ps *PodcastService) fetchEnclosure(podcast *Podcast, ep *Episode) (string, error) {
+	resp, err := ps.downloadClient.Get(ep.EnclosureURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > ps.cfg.MaxEpisodeSize {
+		return "", errEpisodeTooLarge
+	}
+
+	// 标题可能重复，目录和文件名带上 ID，避免不同播客或单集互相覆盖
+	dir := filepath.Join(ps.dir, fmt.Sprintf("%s [%d]", sanitizeFileName(podcast.Title, "podcast"), podcast.ID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	ext := enclosureExt(ep.EnclosureURL, resp.Header.Get("Content-Type"), ep.EnclosureType)
+	name := fmt.Sprintf("%s [%d]", sanitizeFileName(podcast.Title+" - "+ep.Title, "episode"), ep.ID)
+	finalPath := filepath.Join(dir, name+ext)
+
+	tmp, err := os.CreateTemp(dir, ".download-*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	// 多读一个字节以判断是否超过上限，超过时由 defer 删除临时文件
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, ps.cfg.MaxEpisodeSize+1))
+	if err == nil && n > ps.cfg.MaxEpisodeSize {
+		err = errEpisodeTooLarge
+	}
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		return "", err
+	}
+	return finalPath, nil
+}
+
+func enclosureExt(rawURL string, contentTypes ...string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if ext := strings.ToLower(path.Ext(u.Path)); ext != "" && len(ext) <= 5 {
+			return ext
+		}
+	}
+	for _, ct := range contentTypes {
+		if ct == "" {
+			continue
+		}
+		if exts, err := mime.ExtensionsByType(ct); err == nil && len(exts) > 0 {
+			return exts[0]
+		}
+	}
+	return ".mp3"
+}
+
+// 去除文件名中的非法字符
+func sanitizeFileName(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	name = strings.Trim(name, ". ")
+
+	if runes := []rune(name); len(runes) > 120 {
+		name = string(runes[:120])
+	}
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// 获取用户已订阅播客中已下载单集的文件路径
+func (ps *PodcastService) episodeFile(userID string, episodeID uint) (string, error) {
+	ep, err := ps.getUserEpisode(userID, episodeID)
+	if err != nil {
+		return "", err
+	}
+	if ep.Status != EpisodeStatusDownloaded || ep.FilePath == "" {
+		return "", errors.New("该单集尚未下载")
+	}
+	return ep.FilePath, nil
+}
+
+// 手动下载未自动下载或下载失败的单集
+func (ps *PodcastService) requestDownload(userID string, episodeID uint) error {
+	ep, err := ps.getUserEpisode(userID, episodeID)
+	if err != nil {
+		return err
+	}
+	if ep.Status != EpisodeStatusPending && ep.Status != EpisodeStatusFailed {
+		return errors.New("该单集已下载或正在下载")
+	}
+
+	var podcast Podcast
+	if err := ps.db.First(&podcast, ep.PodcastID).Error; err != nil {
+		return err
+	}
+	go ps.downloadEpisode(&podcast, ep)
+	return nil
+}
+
+func (ps *PodcastService) refreshUserPodcast(userID string, podcastID uint) (*Podcast, error) {
+	if !ps.isSubscribed(userID, podcastID) {
+		return nil, errors.New("未订阅该播客")
+	}
+	var podcast Podcast
+	if err := ps.db.First(&podcast, podcastID).Error; err != nil {
+		return nil, err
+	}
+	if err := ps.refreshPodcast(&podcast); err != nil {
+		return nil, err
+	}
+	return &podcast, nil
+}
+
+// poll 定时拉取所有订阅源
+func (ps *PodcastService) poll() {
+	ticker := time.NewTicker(ps.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ps.pollOnce()
+	}
+}
+
+func (ps *PodcastService) pollOnce() {
+	var podcasts []Podcast
+	if err := ps.db.Find(&podcasts).Error; err != nil {
+		log.Printf("加载播客列表失败: %v", err)
+		return
+	}
+	for i := range podcasts {
+		// 订阅者都已注销的播客不再拉取
+		if !ps.hasSubscribers(podcasts[i].ID) {
+			if err := ps.removePodcast(podcasts[i].ID); err != nil {
+				log.Printf("删除无人订阅的播客失败: %s - %v", podcasts[i].FeedURL, err)
+			}
+			continue
+		}
+		if err := ps.refreshPodcast(&podcasts[i]); err != nil {
+			log.Printf("拉取订阅源失败: %s - %v", podcasts[i].FeedURL, err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package podcast
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"myapp/servers/music"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestFetchEnclosureUniquePaths(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer stub.Close()
+
+	ps := &PodcastService{cfg: &PodcastConfig{MaxEpisodeSize: defaultMaxEpisodeSize}, dir: t.TempDir(), downloadClient: stub.Client()}
+	first := &Podcast{ID: 1, Title: "Daily News"}
+	second := &Podcast{ID: 2, Title: "Daily News"}
+	downloads := []struct {
+		podcast *Podcast
+		episode *Episode
+	}{
+		{first, &Episode{ID: 10, Title: "Weekly Recap", EnclosureURL: stub.URL + "/a.mp3"}},
+		{first, &Episode{ID: 11, Title: "Weekly Recap", EnclosureURL: stub.URL + "/b.mp3"}},
+		{second, &Episode{ID: 12, Title: "Weekly Recap", EnclosureURL: stub.URL + "/c.mp3"}},
+	}
+
+	paths := map[string]bool{}
+	for _, d := range downloads {
+		path, err := ps.fetchEnclosure(d.podcast, d.episode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paths[path] {
+			t.Fatalf("同名单集使用了相同的文件: %s", path)
+		}
+		paths[path] = true
+		if rel, err := filepath.Rel(ps.dir, path); err != nil || filepath.IsAbs(rel) || rel[0] == '.' {
+			t.Errorf("文件不在播客目录中: %s", path)
+		}
+	}
+
+	// 后下载的单集没有覆盖之前的文件
+	for _, d := range downloads {
+		path, _ := ps.fetchEnclosure(d.podcast, d.episode)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "/" + filepath.Base(d.episode.EnclosureURL)
+		if string(data) != want {
+			t.Errorf("%s 的内容为 %q，应为 %q", path, data, want)
+		}
+	}
+}
+
+// 超过大小上限的单集放弃下载，不留下不完整的文件
+func TestFetchEnclosureSizeLimit(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("length") == "" {
+			// 不设置 Content-Length，只能边下载边计数
+			w.Header().Set("Transfer-Encoding", "chunked")
+		} else {
+			w.Header().Set("Content-Length", "2048")
+		}
+		w.Write(make([]byte, 2048))
+	}))
+	defer stub.Close()
+
+	ps := &PodcastService{cfg: &PodcastConfig{MaxEpisodeSize: 1024}, dir: t.TempDir(), downloadClient: stub.Client()}
+	podcast := &Podcast{ID: 1, Title: "Show"}
+	for _, rawURL := range []string{stub.URL + "/big.mp3", stub.URL + "/big.mp3?length=1"} {
+		if _, err := ps.fetchEnclosure(podcast, &Episode{ID: 1, Title: "Big", EnclosureURL: rawURL}); !errors.Is(err, errEpisodeTooLarge) {
+			t.Errorf("%s: 应该超过大小上限，err = %v", rawURL, err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(ps.dir, "*", "*"))
+	if len(files) != 0 {
+		t.Errorf("不应留下文件: %v", files)
+	}
+
+	ps.cfg.MaxEpisodeSize = 2048
+	if _, err := ps.fetchEnclosure(podcast, &Episode{ID: 1, Title: "Big", EnclosureURL: stub.URL + "/big.mp3"}); err != nil {
+		t.Errorf("恰好等于上限时应该可以下载，err = %v", err)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"Show: Part 1/2": "Show_ Part 1_2",
+		"  ...  ":        "fallback",
+		"../../etc":      "_.._etc",
+	}
+	for name, want := range cases {
+		if got := sanitizeFileName(name, "fallback"); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q，应为 %q", name, got, want)
+		}
+	}
+}
+
+// 播客音乐库不通过音乐接口开放，单集只能经订阅检查后播放
+func TestLibraryConfigInternal(t *testing.T) {
+	cfg := &PodcastConfig{Dir: "/data/podcasts", Library: "podcasts"}
+	if lib := cfg.LibraryConfig(); !lib.Internal || lib.Public {
+		t.Errorf("播客音乐库应仅供播客服务使用: %+v", lib)
+	}
+}
+
+func TestFetchClientRejectsPrivateAddresses(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer stub.Close()
+
+	if _, err := newFetchClient(time.Second, false).Get(stub.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("不应访问本机地址，err = %v", err)
+	}
+	resp, err := newFetchClient(time.Second, true).Get(stub.URL)
+	if err != nil {
+		t.Fatalf("允许内网地址时应该可以访问，err = %v", err)
+	}
+	resp.Body.Close()
+
+	ps := &PodcastService{cfg: &PodcastConfig{}}
+	for _, rawURL := range []string{
+		stub.URL,
+		"http://localhost/feed.xml",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/feed.xml",
+		"http://[::1]/feed.xml",
+		"file:///etc/passwd",
+		"ftp://example.com/feed.xml",
+	} {
+		if err := ps.checkFetchURL(context.Background(), rawURL); err == nil {
+			t.Errorf("checkFetchURL(%q) 应该失败", rawURL)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+	for addr, want := range cases {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v", addr, got)
+		}
+	}
+}
+
+// newMockDB 返回使用 sqlmock 的 gorm 连接，按顺序校验执行的 SQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+// waitExpectations 等待后台下载执行完预期的 SQL
+func waitExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := mock.ExpectationsWereMet()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectExec(mock sqlmock.Sqlmock, query string, result driver.Result) {
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WillReturnResult(result)
+	mock.ExpectCommit()
+}
+
+var episodeColumns = []string{"id", "podcast_id", "guid", "title", "enclosure_url", "status", "published_at"}
+
+// 订阅后立即拉取并下载最新一集；轮询时已保存的单集不重复插入，已下载的不重复下载
+func TestSubscribePollAndDownload(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		items    = []string{"ep-1", "ep-2"}
+		requests = map[string]int{}
+	)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		if r.URL.Path != "/feed.xml" {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte(r.URL.Path))
+			return
+		}
+		fmt.Fprint(w, `<rss version="2.0"><channel><title>Daily News</title>`)
+		for i, guid := range items {
+			fmt.Fprintf(w, `<item><guid>%s</guid><title>Episode %d</title><pubDate>Mon, 0%d Jan 2024 08:00:00 +0000</pubDate>`+
+				`<enclosure url="http://%s/%s.mp3" type="audio/mpeg" length="5"/></item>`, guid, i+1, i+1, r.Host, guid)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	}))
+	defer stub.Close()
+	feedURL := stub.URL + "/feed.xml"
+
+	db, mock := newMockDB(t)
+	var indexed []string
+	ps := &PodcastService{
+		cfg:            &PodcastConfig{Library: "podcasts", AutoDownload: 1, MaxEpisodeSize: defaultMaxEpisodeSize, AllowPrivateHosts: true},
+		db:             db,
+		dir:            t.TempDir(),
+		client:         stub.Client(),
+		downloadClient: stub.Client(),
+		indexFile: func(library, filePath string) (*music.Music, error) {
+			indexed = append(indexed, filepath.Base(filePath))
+			return &music.Music{ID: uint(len(indexed))}, nil
+		},
+	}
+	published := func(day int) time.Time { return time.Date(2024, 1, day, 8, 0, 0, 0, time.UTC) }
+
+	// 首次订阅：创建订阅源和订阅关系，拉取后保存两集，并下载最新的第 2 集
+	mock.ExpectQuery("SELECT \\* FROM `podcasts` WHERE feed_url = \\?").
+		WithArgs(feedURL, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectExec(mock, "INSERT INTO `podcasts`", sqlmock.NewResult(1, 1))
+	expectExec(mock, "INSERT INTO `podcast_subscriptions` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(1, 1))
+	expectExec(mock, "UPDATE `podcasts` SET", sqlmock.NewResult(0, 1))
+	expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(1, 1))
+	expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(2, 1))
+	mock.ExpectQuery("SELECT \\* FROM `podcast_episodes` WHERE podcast_id = \\? ORDER BY published_at DESC LIMIT \\?").
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(episodeColumns).
+			AddRow(2, 1, "ep-2", "Episode 2", stub.URL+"/ep-2.mp3", EpisodeStatusPending, published(2)))
+	expectExec(mock, "UPDATE `podcast_episodes` SET `status`=\\? WHERE id = \\? AND status IN", sqlmock.NewResult(0, 1))
+	expectExec(mock, "UPDATE `podcast_episodes` SET .*`music_id`=\\?", sqlmock.NewResult(0, 1))
+
+	podcast, err := ps.subscribe("user-1", &SubscribeRequest{FeedURL: feedURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if podcast.ID != 1 || podcast.Title != "Daily News" {
+		t.Errorf("订阅源信息不正确: %+v", podcast)
+	}
+	waitExpectations(t, mock)
+
+	// 轮询：订阅源新增第 3 集，已有的两集插入时忽略冲突，只下载新的一集
+	mu.Lock()
+	items = append(items, "ep-3")
+	mu.Unlock()
+	mock.ExpectQuery("SELECT \\* FROM `podcasts`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "feed_url", "title", "last_polled_at"}).
+			AddRow(1, feedURL, "Daily News", time.Now()))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `podcast_subscriptions` WHERE podcast_id = \\?").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	expectExec(mock, "UPDATE `podcasts` SET", sqlmock.NewResult(0, 1))
+	expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(0, 0))
+	expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(0, 0))
+	expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(3, 1))
+	mock.ExpectQuery("SELECT \\* FROM `podcast_episodes` WHERE podcast_id = \\?").
+		WillReturnRows(sqlmock.NewRows(episodeColumns).
+			AddRow(3, 1, "ep-3", "Episode 3", stub.URL+"/ep-3.mp3", EpisodeStatusPending, published(3)))
+	expectExec(mock, "UPDATE `podcast_episodes` SET `status`=\\? WHERE id = \\? AND status IN", sqlmock.NewResult(0, 1))
+	expectExec(mock, "UPDATE `podcast_episodes` SET .*`music_id`=\\?", sqlmock.NewResult(0, 1))
+
+	ps.pollOnce()
+	waitExpectations(t, mock)
+
+	// 再次轮询：最新一集已下载，不再发起下载
+	mock.ExpectQuery("SELECT \\* FROM `podcasts`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "feed_url", "title", "last_polled_at"}).
+			AddRow(1, feedURL, "Daily News", time.Now()))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `podcast_subscriptions`").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	expectExec(mock, "UPDATE `podcasts` SET", sqlmock.NewResult(0, 1))
+	for range 3 {
+		expectExec(mock, "INSERT INTO `podcast_episodes` .* ON DUPLICATE KEY UPDATE", sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("SELECT \\* FROM `podcast_episodes` WHERE podcast_id = \\?").
+		WillReturnRows(sqlmock.NewRows(episodeColumns).
+			AddRow(3, 1, "ep-3", "Episode 3", stub.URL+"/ep-3.mp3", EpisodeStatusDownloaded, published(3)))
+
+	ps.pollOnce()
+	waitExpectations(t, mock)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["/feed.xml"] != 3 {
+		t.Errorf("订阅源应拉取 3 次，实际 %d 次", requests["/feed.xml"])
+	}
+	if requests["/ep-1.mp3"] != 0 || requests["/ep-2.mp3"] != 1 || requests["/ep-3.mp3"] != 1 {
+		t.Errorf("单集下载次数不正确: %v", requests)
+	}
+	if want := []string{"Daily News - Episode 2 [2].mp3", "Daily News - Episode 3 [3].mp3"}; !slices.Equal(indexed, want) {
+		t.Errorf("索引的文件为 %v，应为 %v", indexed, want)
+	}
+}
@@ -0,0 +1,18 @@
+package podcast
+
+import "myapp/middleware"
+
+func (ps *PodcastService) RegisterRoutes() {
+	podcastGroup := ps.rg
+	podcastGroup.Use(middleware.AuthMiddleware())
+
+	podcastGroup.GET("", ps.GetPodcasts)                 // 我的订阅
+	podcastGroup.POST("", ps.Subscribe)                  // 订阅
+	podcastGroup.DELETE("/:id", ps.Unsubscribe)          // 取消订阅
+	podcastGroup.POST("/:id/refresh", ps.RefreshPodcast) // 立即拉取
+	podcastGroup.GET("/:id/episodes", ps.GetEpisodes)    // 单集列表
+
+	podcastGroup.POST("/episodes/:id/download", ps.DownloadEpisode) // 下载单集
+	podcastGroup.GET("/episodes/:id/stream", ps.StreamEpisode)      // 播放已下载的单集
+	podcastGroup.PUT("/episodes/:id/state", ps.UpdateEpisodeState)  // 更新收听进度
+}
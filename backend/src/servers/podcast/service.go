@@ -0,0 +1,94 @@
+package podcast
+
+import (
+	"context"
+	logger "myapp/log"
+	"myapp/servers/music"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PodcastConfig struct {
+	// 单集下载目录，作为音乐库 Library 注册，单集通过播客接口播放
+	Dir     string
+	Library string
+
+	PollInterval time.Duration
+	// 每个播客自动下载最新的集数，0 表示不自动下载
+	AutoDownload int
+	// 单集文件大小上限（字节），超过时放弃下载，0 表示使用默认值
+	MaxEpisodeSize int64
+	// 允许订阅内网地址的播客，仅在可信的局域网环境中开启
+	AllowPrivateHosts bool
+}
+
+// LibraryConfig 返回播客单集所在音乐库的配置，需在创建 MusicService 前加入音乐库列表；
+// 单集只对订阅了该播客的用户可见，因此音乐库不通过音乐接口开放，由播客接口检查订阅后播放
+func (cfg *PodcastConfig) LibraryConfig() music.LibraryConfig {
+	return music.LibraryConfig{
+		Name:     cfg.Library,
+		Dir:      cfg.Dir,
+		Internal: true,
+	}
+}
+
+type PodcastService struct {
+	cfg *PodcastConfig
+	db  *gorm.DB
+	ms  *music.MusicService
+	// 将下载完成的单集加入音乐库，默认为 ms.IndexFile
+	indexFile      func(library, filePath string) (*music.Music, error)
+	dir            string
+	client         *http.Client
+	downloadClient *http.Client
+	rg             *gin.RouterGroup
+}
+
+func NewPodcastService(ctx context.Context, cfg *PodcastConfig, db *gorm.DB, r *gin.Engine, ms *music.MusicService) *PodcastService {
+	dir, ok := ms.LibraryDir(cfg.Library)
+	if !ok {
+		logger.ZError(&ctx, "播客音乐库未配置", nil, "library", cfg.Library)
+		return nil
+	}
+
+	err := db.AutoMigrate(&Podcast{}, &Subscription{}, &Episode{}, &EpisodeState{})
+	if err != nil {
+		logger.ZError(&ctx, "数据库自动迁移失败", err)
+		return nil
+	}
+
+	// 服务重启时中断的下载重新排队
+	db.Model(&Episode{}).
+		Where("status = ?", EpisodeStatusDownloading).
+		Update("status", EpisodeStatusPending)
+
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Hour
+	}
+	if cfg.MaxEpisodeSize <= 0 {
+		cfg.MaxEpisodeSize = defaultMaxEpisodeSize
+	}
+
+	rg := r.Group("/podcast")
+
+	return &PodcastService{
+		cfg:            cfg,
+		db:             db,
+		ms:             ms,
+		indexFile:      ms.IndexFile,
+		dir:            dir,
+		client:         newFetchClient(30*time.Second, cfg.AllowPrivateHosts),
+		downloadClient: newFetchClient(30*time.Minute, cfg.AllowPrivateHosts),
+		rg:             rg,
+	}
+}
+
+func (ps *PodcastService) Start() {
+	// 启动订阅源轮询
+	go ps.poll()
+
+	ps.RegisterRoutes()
+}
@@ -0,0 +1,102 @@
+package scrobble
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (ss *ScrobbleService) GetAccounts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	accounts, err := ss.getAccounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取绑定的服务失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": accounts})
+}
+
+func (ss *ScrobbleService) SaveAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req AccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	account, err := ss.saveAccount(userID, c.Param("backend"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": account, "message": "保存成功"})
+}
+
+func (ss *ScrobbleService) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if err := ss.deleteAccount(userID, c.Param("backend")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解除绑定"})
+}
+
+func (ss *ScrobbleService) NowPlaying(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req NowPlayingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	if err := ss.nowPlaying(userID, &req); err != nil {
+		if errors.Is(err, ErrSkipped) {
+			c.JSON(http.StatusOK, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+func (ss *ScrobbleService) RecordListen(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req ListenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+
+	queued, err := ss.recordListen(userID, &req)
+	if err != nil {
+		if errors.Is(err, ErrSkipped) {
+			c.JSON(http.StatusOK, gin.H{"data": gin.H{"queued": 0}, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"queued": queued}, "message": "已记录"})
+}
+
+func (ss *ScrobbleService) GetQueue(c *gin.Context) {
+	userID := c.GetString("user_id")
+	items, err := ss.getQueue(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取离线队列失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items})
+}
+
+func (ss *ScrobbleService) RetryQueue(c *gin.Context) {
+	userID := c.GetString("user_id")
+	count, err := ss.retryFailed(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重试失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"retried": count}, "message": "已重新加入队列"})
+}
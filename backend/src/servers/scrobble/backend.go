@@ -0,0 +1,93 @@
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Track 提交给听歌记录服务的曲目信息
+type Track struct {
+	Artist     string
+	Title      string
+	Album      string
+	Duration   int       // 秒，未知时为 0
+	ListenedAt time.Time // 开始播放的时间，正在播放时为零值
+}
+
+// Backend 听歌记录服务，新增服务时实现该接口并在 NewScrobbleService 中注册
+type Backend interface {
+	// Name 服务名称，对应 ScrobblerAccount.Backend
+	Name() string
+	// DefaultURL 未配置 API 地址时使用的地址
+	DefaultURL() string
+	// BatchSize 单次提交的最大条数
+	BatchSize() int
+	// Validate 检查账号配置是否完整
+	Validate(account *ScrobblerAccount) error
+	NowPlaying(ctx context.Context, account *ScrobblerAccount, track *Track) error
+	Scrobble(ctx context.Context, account *ScrobblerAccount, tracks []Track) error
+}
+
+// PermanentError 重试也不会成功的错误，例如令牌无效，对应的记录不再重试
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func permanent(format string, args ...interface{}) error {
+	return &PermanentError{Err: fmt.Errorf(format, args...)}
+}
+
+func isPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
+
+// httpStatusError 按 HTTP 状态码区分错误，限流和服务端错误可以重试，其余 4xx 不再重试
+func httpStatusError(resp *http.Response, message string) error {
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, message)
+	}
+	return permanent("HTTP %d: %s", resp.StatusCode, message)
+}
+
+// checkAPIURL 自定义 API 地址只能指向该服务的官方地址或配置允许的主机，
+// 避免用户借助服务端向内网地址发起请求并通过错误信息读取响应
+func checkAPIURL(backend Backend, account *ScrobblerAccount, allowedHosts []string) error {
+	if account.APIURL == "" {
+		return nil
+	}
+	u, err := url.Parse(account.APIURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return permanent("无效的 API 地址: %s", account.APIURL)
+	}
+	if def, err := url.Parse(backend.DefaultURL()); err == nil && strings.EqualFold(u.Host, def.Host) {
+		return nil
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return permanent("不允许的 API 地址: %s，请联系管理员将其加入 SCROBBLE_ALLOWED_HOSTS", u.Host)
+}
+
+// accountURL 返回账号配置的 API 地址，便于指向自建服务或本地测试服务
+func accountURL(backend Backend, account *ScrobblerAccount) string {
+	if account.APIURL != "" {
+		return account.APIURL
+	}
+	return backend.DefaultURL()
+}
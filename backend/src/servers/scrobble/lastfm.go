@@ -0,0 +1,146 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Last.fm 风格的签名 API（Libre.fm 等兼容服务同样适用）：
+// 参数按名称排序后拼接，再加上共享密钥做 MD5 得到 api_sig
+
+const lastFMName = "lastfm"
+
+type lastFM struct {
+	client *http.Client
+}
+
+// 会话失效、密钥错误等无法通过重试恢复的错误码
+var lastFMPermanentErrors = map[int]bool{
+	2:  true, // 服务不存在
+	3:  true, // 方法不存在
+	4:  true, // 认证失败
+	6:  true, // 参数错误
+	9:  true, // 会话无效
+	10: true, // API Key 无效
+	13: true, // 签名无效
+	26: true, // API Key 已停用
+}
+
+func (fm *lastFM) Name() string {
+	return lastFMName
+}
+
+func (fm *lastFM) DefaultURL() string {
+	return "https://ws.audioscrobbler.com/2.0/"
+}
+
+func (fm *lastFM) BatchSize() int {
+	return 50
+}
+
+func (fm *lastFM) Validate(account *ScrobblerAccount) error {
+	if account.APIKey == "" || account.APISecret == "" || account.SessionKey == "" {
+		return errors.New("Last.fm 需要 API Key、共享密钥和会话密钥")
+	}
+	return nil
+}
+
+func (fm *lastFM) NowPlaying(ctx context.Context, account *ScrobblerAccount, track *Track) error {
+	params := url.Values{}
+	params.Set("method", "track.updateNowPlaying")
+	params.Set("artist", track.Artist)
+	params.Set("track", track.Title)
+	if track.Album != "" {
+		params.Set("album", track.Album)
+	}
+	if track.Duration > 0 {
+		params.Set("duration", strconv.Itoa(track.Duration))
+	}
+	return fm.call(ctx, account, params)
+}
+
+func (fm *lastFM) Scrobble(ctx context.Context, account *ScrobblerAccount, tracks []Track) error {
+	params := url.Values{}
+	params.Set("method", "track.scrobble")
+	for i, track := range tracks {
+		params.Set(fmt.Sprintf("artist[%d]", i), track.Artist)
+		params.Set(fmt.Sprintf("track[%d]", i), track.Title)
+		params.Set(fmt.Sprintf("timestamp[%d]", i), strconv.FormatInt(track.ListenedAt.Unix(), 10))
+		if track.Album != "" {
+			params.Set(fmt.Sprintf("album[%d]", i), track.Album)
+		}
+		if track.Duration > 0 {
+			params.Set(fmt.Sprintf("duration[%d]", i), strconv.Itoa(track.Duration))
+		}
+	}
+	return fm.call(ctx, account, params)
+}
+
+func (fm *lastFM) call(ctx context.Context, account *ScrobblerAccount, params url.Values) error {
+	params.Set("api_key", account.APIKey)
+	params.Set("sk", account.SessionKey)
+	params.Set("api_sig", signLastFM(params, account.APISecret))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, accountURL(fm, account), strings.NewReader(params.Encode()))
+	if err != nil {
+		return permanent("无效的 API 地址: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := fm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("无法解析响应: %v", err)
+	}
+	if result.Error != 0 {
+		if lastFMPermanentErrors[result.Error] {
+			return permanent("Last.fm 错误 %d: %s", result.Error, result.Message)
+		}
+		return fmt.Errorf("Last.fm 错误 %d: %s", result.Error, result.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError(resp, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// signLastFM 计算 api_sig，format 和 callback 不参与签名
+func signLastFM(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key == "format" || key == "callback" || key == "api_sig" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString(params.Get(key))
+	}
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ListenBrainz JSON API：POST /1/submit-listens，使用用户令牌认证
+
+const listenBrainzName = "listenbrainz"
+
+type listenBrainz struct {
+	client *http.Client
+}
+
+type lbSubmission struct {
+	ListenType string     `json:"listen_type"`
+	Payload    []lbListen `json:"payload"`
+}
+
+type lbListen struct {
+	ListenedAt    int64           `json:"listened_at,omitempty"`
+	TrackMetadata lbTrackMetadata `json:"track_metadata"`
+}
+
+type lbTrackMetadata struct {
+	ArtistName     string           `json:"artist_name"`
+	TrackName      string           `json:"track_name"`
+	ReleaseName    string           `json:"release_name,omitempty"`
+	AdditionalInfo lbAdditionalInfo `json:"additional_info"`
+}
+
+type lbAdditionalInfo struct {
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	SubmissionClient string `json:"submission_client"`
+}
+
+func (lb *listenBrainz) Name() string {
+	return listenBrainzName
+}
+
+func (lb *listenBrainz) DefaultURL() string {
+	return "https://api.listenbrainz.org"
+}
+
+func (lb *listenBrainz) BatchSize() int {
+	return 100
+}
+
+func (lb *listenBrainz) Validate(account *ScrobblerAccount) error {
+	if account.Token == "" {
+		return errors.New("ListenBrainz 需要用户令牌")
+	}
+	return nil
+}
+
+func (lb *listenBrainz) NowPlaying(ctx context.Context, account *ScrobblerAccount, track *Track) error {
+	return lb.submit(ctx, account, "playing_now", []Track{*track})
+}
+
+func (lb *listenBrainz) Scrobble(ctx context.Context, account *ScrobblerAccount, tracks []Track) error {
+	listenType := "import"
+	if len(tracks) == 1 {
+		listenType = "single"
+	}
+	return lb.submit(ctx, account, listenType, tracks)
+}
+
+func (lb *listenBrainz) submit(ctx context.Context, account *ScrobblerAccount, listenType string, tracks []Track) error {
+	submission := lbSubmission{ListenType: listenType}
+	for _, track := range tracks {
+		listen := lbListen{
+			TrackMetadata: lbTrackMetadata{
+				ArtistName:  track.Artist,
+				TrackName:   track.Title,
+				ReleaseName: track.Album,
+				AdditionalInfo: lbAdditionalInfo{
+					DurationMs:       track.Duration * 1000,
+					SubmissionClient: "digital-hub",
+				},
+			},
+		}
+		if listenType != "playing_now" {
+			listen.ListenedAt = track.ListenedAt.Unix()
+		}
+		submission.Payload = append(submission.Payload, listen)
+	}
+
+	body, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(accountURL(lb, account), "/") + "/1/submit-listens"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanent("无效的 API 地址: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+account.Token)
+
+	resp, err := lb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &result) != nil || result.Error == "" {
+			result.Error = strings.TrimSpace(string(data))
+		}
+		return httpStatusError(resp, result.Error)
+	}
+	return nil
+}
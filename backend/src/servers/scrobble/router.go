@@ -0,0 +1,18 @@
+package scrobble
+
+import "myapp/middleware"
+
+func (ss *ScrobbleService) RegisterRoutes() {
+	scrobbleGroup := ss.rg
+	scrobbleGroup.Use(middleware.AuthMiddleware())
+
+	scrobbleGroup.GET("/accounts", ss.GetAccounts)               // 已绑定的服务
+	scrobbleGroup.PUT("/accounts/:backend", ss.SaveAccount)      // 绑定或修改服务
+	scrobbleGroup.DELETE("/accounts/:backend", ss.DeleteAccount) // 解除绑定
+
+	scrobbleGroup.POST("/now-playing", ss.NowPlaying) // 更新正在播放
+	scrobbleGroup.POST("/listens", ss.RecordListen)   // 记录一次收听
+
+	scrobbleGroup.GET("/queue", ss.GetQueue)          // 离线队列
+	scrobbleGroup.POST("/queue/retry", ss.RetryQueue) // 重试失败的记录
+}
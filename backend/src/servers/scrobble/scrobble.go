@@ -0,0 +1,480 @@
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/servers/music"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 待提交记录的状态
+const (
+	ScrobbleStatusPending = "pending"
+	ScrobbleStatusFailed  = "failed"
+)
+
+// 重试间隔上限
+const maxRetryDelay = 6 * time.Hour
+
+// ErrSkipped 曲目不满足提交条件（过短或缺少标签），播放器无需提示
+var ErrSkipped = errors.New("不满足听歌记录的提交条件")
+
+// ScrobblerAccount 用户绑定的听歌记录服务，每个用户每种服务一个账号
+type ScrobblerAccount struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserID        string     `gorm:"type:varchar(255);not null;index:idx_scrobbler_user_backend,unique" json:"-"`
+	Backend       string     `gorm:"type:varchar(32);not null;index:idx_scrobbler_user_backend,unique" json:"backend"`
+	Enabled       bool       `gorm:"not null;default:true" json:"enabled"`
+	APIURL        string     `gorm:"type:varchar(512)" json:"api_url"`
+	Token         string     `gorm:"type:varchar(255)" json:"-"` // ListenBrainz 用户令牌
+	APIKey        string     `gorm:"type:varchar(255)" json:"-"` // Last.fm API Key
+	APISecret     string     `gorm:"type:varchar(255)" json:"-"` // Last.fm 共享密钥
+	SessionKey    string     `gorm:"type:varchar(255)" json:"-"` // Last.fm 会话密钥
+	LastError     string     `gorm:"type:varchar(1024)" json:"last_error"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (ScrobblerAccount) TableName() string {
+	return "user_scrobblers"
+}
+
+// PendingScrobble 离线队列中的一条听歌记录，提交成功后删除。
+// 保存曲目信息的快照，音乐被删除后仍能提交
+type PendingScrobble struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	AccountID     uint      `gorm:"not null;index" json:"account_id"`
+	UserID        string    `gorm:"type:varchar(255);not null;index" json:"-"`
+	MusicID       uint      `json:"music_id"`
+	Artist        string    `gorm:"type:varchar(255);not null" json:"artist"`
+	Title         string    `gorm:"type:varchar(255);not null" json:"title"`
+	Album         string    `gorm:"type:varchar(255)" json:"album"`
+	Duration      int       `json:"duration"`
+	ListenedAt    time.Time `json:"listened_at"`
+	Status        string    `gorm:"type:varchar(16);not null;default:'pending';index:idx_scrobble_due" json:"status"`
+	Attempts      int       `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index:idx_scrobble_due" json:"next_attempt_at"`
+	LastError     string    `gorm:"type:varchar(1024)" json:"last_error"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (PendingScrobble) TableName() string {
+	return "scrobble_queue"
+}
+
+type AccountRequest struct {
+	Enabled    *bool  `json:"enabled"`
+	APIURL     string `json:"api_url" binding:"omitempty,url"`
+	Token      string `json:"token"`
+	APIKey     string `json:"api_key"`
+	APISecret  string `json:"api_secret"`
+	SessionKey string `json:"session_key"`
+}
+
+type NowPlayingRequest struct {
+	MusicID  uint `json:"music_id" binding:"required"`
+	Duration int  `json:"duration" binding:"min=0"`
+}
+
+type ListenRequest struct {
+	MusicID    uint  `json:"music_id" binding:"required"`
+	Duration   int   `json:"duration" binding:"min=0"`
+	ListenedAt int64 `json:"listened_at"` // 开始播放的 Unix 时间，为空时按当前时间减去时长计算
+}
+
+// AccountResponse 不返回密钥，只标记是否已配置
+type AccountResponse struct {
+	ScrobblerAccount
+	Configured bool  `json:"configured"`
+	Pending    int64 `json:"pending"`
+	Failed     int64 `json:"failed"`
+}
+
+func (ss *ScrobbleService) getBackend(name string) (Backend, error) {
+	backend, ok := ss.backends[name]
+	if !ok {
+		return nil, errors.New("不支持的听歌记录服务: " + name)
+	}
+	return backend, nil
+}
+
+func (ss *ScrobbleService) getAccounts(userID string) ([]AccountResponse, error) {
+	var accounts []ScrobblerAccount
+	if err := ss.db.Where("user_id = ?", userID).Order("id ASC").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		AccountID uint
+		Status    string
+		Total     int64
+	}
+	err := ss.db.Model(&PendingScrobble{}).
+		Select("account_id, status, COUNT(*) AS total").
+		Where("user_id = ?", userID).
+		Group("account_id, status").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]AccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		resp := AccountResponse{ScrobblerAccount: account}
+		if backend, ok := ss.backends[account.Backend]; ok {
+			resp.Configured = backend.Validate(&account) == nil
+		}
+		for _, count := range counts {
+			if count.AccountID != account.ID {
+				continue
+			}
+			if count.Status == ScrobbleStatusPending {
+				resp.Pending = count.Total
+			} else {
+				resp.Failed = count.Total
+			}
+		}
+		responses = append(responses, resp)
+	}
+	return responses, nil
+}
+
+// saveAccount 创建或修改账号，密钥字段为空时保留原值
+func (ss *ScrobbleService) saveAccount(userID, backendName string, req *AccountRequest) (*ScrobblerAccount, error) {
+	backend, err := ss.getBackend(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	var account ScrobblerAccount
+	err = ss.db.Where("user_id = ? AND backend = ?", userID, backendName).First(&account).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	account.UserID = userID
+	account.Backend = backendName
+	if account.ID == 0 {
+		account.Enabled = true
+	}
+	if req.Enabled != nil {
+		account.Enabled = *req.Enabled
+	}
+	account.APIURL = req.APIURL
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&account.Token, req.Token},
+		{&account.APIKey, req.APIKey},
+		{&account.APISecret, req.APISecret},
+		{&account.SessionKey, req.SessionKey},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+
+	if err := backend.Validate(&account); err != nil {
+		return nil, err
+	}
+	if err := checkAPIURL(backend, &account, ss.cfg.AllowedHosts); err != nil {
+		return nil, err
+	}
+	account.LastError = ""
+	if err := ss.db.Save(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (ss *ScrobbleService) deleteAccount(userID, backendName string) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		var account ScrobblerAccount
+		if err := tx.Where("user_id = ? AND backend = ?", userID, backendName).First(&account).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("未绑定该服务")
+			}
+			return err
+		}
+		if err := tx.Where("account_id = ?", account.ID).Delete(&PendingScrobble{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&account).Error
+	})
+}
+
+func (ss *ScrobbleService) enabledAccounts(userID string) ([]ScrobblerAccount, error) {
+	var accounts []ScrobblerAccount
+	err := ss.db.Where("user_id = ? AND enabled = ?", userID, true).Find(&accounts).Error
+	return accounts, err
+}
+
+// trackFor 读取音乐的标签信息，艺术家和标题是各服务的必填项
+func (ss *ScrobbleService) trackFor(userID string, musicID uint, duration int) (*music.Music, *Track, error) {
+	var m music.Music
+	err := ss.db.Where("library IN ?", ss.ms.LibrariesFor(userID)).First(&m, musicID).Error
+	if err != nil {
+		return nil, nil, errors.New("音乐不存在")
+	}
+
+	title := m.Title
+	if title == "" {
+		title = strings.TrimSuffix(m.Name, filepath.Ext(m.Name))
+	}
+	if m.Artist == "" {
+		return nil, nil, fmt.Errorf("%w: 缺少艺术家信息", ErrSkipped)
+	}
+	return &m, &Track{Artist: m.Artist, Title: title, Album: m.Album, Duration: duration}, nil
+}
+
+// nowPlaying 通知所有已启用的服务正在播放，不进入离线队列
+func (ss *ScrobbleService) nowPlaying(userID string, req *NowPlayingRequest) error {
+	accounts, err := ss.enabledAccounts(userID)
+	if err != nil || len(accounts) == 0 {
+		return err
+	}
+	_, track, err := ss.trackFor(userID, req.MusicID, req.Duration)
+	if err != nil {
+		return err
+	}
+
+	for i := range accounts {
+		account := accounts[i]
+		backend, ok := ss.backends[account.Backend]
+		if !ok || checkAPIURL(backend, &account, ss.cfg.AllowedHosts) != nil {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(ss.ctx, ss.cfg.Timeout)
+			defer cancel()
+			if err := backend.NowPlaying(ctx, &account, track); err != nil {
+				log.Printf("更新正在播放失败: %s - %v", account.Backend, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// recordListen 为每个已启用的服务写入一条待提交记录
+func (ss *ScrobbleService) recordListen(userID string, req *ListenRequest) (int, error) {
+	accounts, err := ss.enabledAccounts(userID)
+	if err != nil || len(accounts) == 0 {
+		return 0, err
+	}
+	// 与 Last.fm 规则一致，30 秒以下的曲目不记录
+	if req.Duration > 0 && req.Duration < 30 {
+		return 0, fmt.Errorf("%w: 曲目过短", ErrSkipped)
+	}
+	m, track, err := ss.trackFor(userID, req.MusicID, req.Duration)
+	if err != nil {
+		return 0, err
+	}
+
+	listenedAt := time.Unix(req.ListenedAt, 0)
+	if req.ListenedAt <= 0 {
+		listenedAt = time.Now().Add(-time.Duration(req.Duration) * time.Second)
+	}
+	if listenedAt.After(time.Now().Add(time.Minute)) {
+		return 0, errors.New("无效的播放时间")
+	}
+
+	now := time.Now()
+	var pending []PendingScrobble
+	for _, account := range accounts {
+		pending = append(pending, PendingScrobble{
+			AccountID:     account.ID,
+			UserID:        userID,
+			MusicID:       m.ID,
+			Artist:        track.Artist,
+			Title:         track.Title,
+			Album:         track.Album,
+			Duration:      track.Duration,
+			ListenedAt:    listenedAt,
+			Status:        ScrobbleStatusPending,
+			NextAttemptAt: now,
+		})
+	}
+	if err := ss.db.Create(&pending).Error; err != nil {
+		return 0, err
+	}
+
+	ss.wakeUp()
+	return len(pending), nil
+}
+
+func (ss *ScrobbleService) getQueue(userID string) ([]PendingScrobble, error) {
+	var items []PendingScrobble
+	err := ss.db.Where("user_id = ?", userID).Order("listened_at DESC").Limit(500).Find(&items).Error
+	return items, err
+}
+
+// retryFailed 将提交失败的记录重新放回队列
+func (ss *ScrobbleService) retryFailed(userID string) (int64, error) {
+	result := ss.db.Model(&PendingScrobble{}).
+		Where("user_id = ? AND status = ?", userID, ScrobbleStatusFailed).
+		Updates(map[string]interface{}{
+			"status":          ScrobbleStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error == nil && result.RowsAffected > 0 {
+		ss.wakeUp()
+	}
+	return result.RowsAffected, result.Error
+}
+
+func (ss *ScrobbleService) wakeUp() {
+	select {
+	case ss.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker 定时提交离线队列，新的听歌记录写入后立即唤醒
+func (ss *ScrobbleService) worker() {
+	ticker := time.NewTicker(ss.cfg.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		ss.flush()
+		select {
+		case <-ss.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ss.wake:
+		}
+	}
+}
+
+// dueQuery 到期待提交的记录，只包含已启用且服务可用的账号，
+// 停用账号积压的记录不会占满每次加载的上限而导致其他账号一直得不到提交
+func (ss *ScrobbleService) dueQuery(now time.Time) *gorm.DB {
+	backends := make([]string, 0, len(ss.backends))
+	for name := range ss.backends {
+		backends = append(backends, name)
+	}
+	return ss.db.Model(&PendingScrobble{}).
+		Select("scrobble_queue.*").
+		Joins("JOIN user_scrobblers ON user_scrobblers.id = scrobble_queue.account_id").
+		Where("user_scrobblers.enabled = ? AND user_scrobblers.backend IN ?", true, backends).
+		Where("scrobble_queue.status = ? AND scrobble_queue.next_attempt_at <= ?", ScrobbleStatusPending, now).
+		Order("scrobble_queue.listened_at ASC").Limit(1000)
+}
+
+// flush 按账号分批提交到期的记录
+func (ss *ScrobbleService) flush() {
+	var due []PendingScrobble
+	err := ss.dueQuery(time.Now()).Find(&due).Error
+	if err != nil {
+		log.Printf("加载听歌记录队列失败: %v", err)
+		return
+	}
+
+	byAccount := make(map[uint][]PendingScrobble)
+	var accountIDs []uint
+	for _, item := range due {
+		if _, ok := byAccount[item.AccountID]; !ok {
+			accountIDs = append(accountIDs, item.AccountID)
+		}
+		byAccount[item.AccountID] = append(byAccount[item.AccountID], item)
+	}
+
+	for _, accountID := range accountIDs {
+		var account ScrobblerAccount
+		if err := ss.db.First(&account, accountID).Error; err != nil {
+			continue
+		}
+		// 加载记录后账号可能刚被停用，停用的账号保留记录，重新启用后继续提交
+		backend, ok := ss.backends[account.Backend]
+		if !ok || !account.Enabled {
+			continue
+		}
+
+		items := byAccount[accountID]
+		for start := 0; start < len(items); start += backend.BatchSize() {
+			end := min(start+backend.BatchSize(), len(items))
+			if !ss.submit(backend, &account, items[start:end]) {
+				break
+			}
+		}
+	}
+}
+
+// submit 提交一批记录，返回 false 时停止提交该账号的剩余记录
+func (ss *ScrobbleService) submit(backend Backend, account *ScrobblerAccount, items []PendingScrobble) bool {
+	tracks := make([]Track, 0, len(items))
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		tracks = append(tracks, Track{
+			Artist:     item.Artist,
+			Title:      item.Title,
+			Album:      item.Album,
+			Duration:   item.Duration,
+			ListenedAt: item.ListenedAt,
+		})
+		ids = append(ids, item.ID)
+	}
+
+	// 允许的主机可能在账号保存后被管理员移除，提交前再次检查
+	err := checkAPIURL(backend, account, ss.cfg.AllowedHosts)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ss.ctx, ss.cfg.Timeout)
+		err = backend.Scrobble(ctx, account, tracks)
+		cancel()
+	}
+
+	if err == nil {
+		now := time.Now()
+		ss.db.Where("id IN ?", ids).Delete(&PendingScrobble{})
+		ss.db.Model(account).Updates(map[string]interface{}{"last_error": "", "last_success_at": &now})
+		return true
+	}
+
+	log.Printf("提交听歌记录失败: %s - %v", account.Backend, err)
+	ss.db.Model(account).Update("last_error", truncate(err.Error(), 1024))
+
+	for _, item := range items {
+		updates := map[string]interface{}{
+			"attempts":   item.Attempts + 1,
+			"last_error": truncate(err.Error(), 1024),
+		}
+		if isPermanent(err) || item.Attempts+1 >= ss.cfg.MaxAttempts {
+			updates["status"] = ScrobbleStatusFailed
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(retryDelay(ss.cfg.RetryInterval, item.Attempts))
+		}
+		ss.db.Model(&PendingScrobble{}).Where("id = ?", item.ID).Updates(updates)
+	}
+	return false
+}
+
+// retryDelay 指数退避，最长 maxRetryDelay
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 0; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// DeleteUserData 删除用户的听歌记录账号和未提交的记录，在删除用户的事务中调用
+func (ss *ScrobbleService) DeleteUserData(tx *gorm.DB, userID string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&PendingScrobble{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&ScrobblerAccount{}).Error
+}
@@ -0,0 +1,190 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+var testTracks = []Track{
+	{Artist: "Artist", Title: "Song A", Album: "Album", Duration: 200, ListenedAt: time.Unix(1700000000, 0)},
+	{Artist: "Artist", Title: "Song B", Duration: 180, ListenedAt: time.Unix(1700000300, 0)},
+}
+
+func TestListenBrainzScrobble(t *testing.T) {
+	var got lbSubmission
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/1/submit-listens" {
+			t.Errorf("请求错误: %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Token user-token" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		got = lbSubmission{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer stub.Close()
+
+	lb := &listenBrainz{client: stub.Client()}
+	account := &ScrobblerAccount{APIURL: stub.URL + "/", Token: "user-token"}
+	if err := lb.Scrobble(context.Background(), account, testTracks); err != nil {
+		t.Fatal(err)
+	}
+	if got.ListenType != "import" || len(got.Payload) != 2 {
+		t.Fatalf("提交内容错误: %+v", got)
+	}
+	first := got.Payload[0]
+	if first.ListenedAt != 1700000000 || first.TrackMetadata.TrackName != "Song A" ||
+		first.TrackMetadata.ReleaseName != "Album" || first.TrackMetadata.AdditionalInfo.DurationMs != 200000 {
+		t.Errorf("曲目信息错误: %+v", first)
+	}
+
+	// 正在播放不带播放时间
+	if err := lb.NowPlaying(context.Background(), account, &testTracks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got.ListenType != "playing_now" || got.Payload[0].ListenedAt != 0 {
+		t.Errorf("正在播放内容错误: %+v", got)
+	}
+}
+
+func TestListenBrainzErrors(t *testing.T) {
+	status := http.StatusTooManyRequests
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"stub error"}`))
+	}))
+	defer stub.Close()
+
+	lb := &listenBrainz{client: stub.Client()}
+	account := &ScrobblerAccount{APIURL: stub.URL, Token: "user-token"}
+
+	err := lb.Scrobble(context.Background(), account, testTracks[:1])
+	if err == nil || isPermanent(err) || !strings.Contains(err.Error(), "stub error") {
+		t.Errorf("限流应该可以重试，err = %v", err)
+	}
+
+	status = http.StatusUnauthorized
+	if err := lb.Scrobble(context.Background(), account, testTracks[:1]); !isPermanent(err) {
+		t.Errorf("令牌无效不应重试，err = %v", err)
+	}
+}
+
+func TestLastFMScrobble(t *testing.T) {
+	errorCode := 0
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			return
+		}
+		form := r.PostForm
+		if form.Get("method") != "track.scrobble" || form.Get("artist[1]") != "Artist" ||
+			form.Get("track[1]") != "Song B" || form.Get("timestamp[0]") != "1700000000" || form.Get("format") != "json" {
+			t.Errorf("参数错误: %v", form)
+		}
+		// 按 Last.fm 的规则重新计算签名
+		var b strings.Builder
+		keys := []string{"album[0]", "api_key", "artist[0]", "artist[1]", "duration[0]", "duration[1]",
+			"method", "sk", "timestamp[0]", "timestamp[1]", "track[0]", "track[1]"}
+		for _, key := range keys {
+			b.WriteString(key + form.Get(key))
+		}
+		b.WriteString("secret")
+		sum := md5.Sum([]byte(b.String()))
+		if form.Get("api_sig") != hex.EncodeToString(sum[:]) {
+			t.Errorf("api_sig 错误")
+		}
+		if errorCode != 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": errorCode, "message": "stub error"})
+			return
+		}
+		w.Write([]byte(`{"scrobbles":{}}`))
+	}))
+	defer stub.Close()
+
+	fm := &lastFM{client: stub.Client()}
+	account := &ScrobblerAccount{APIURL: stub.URL, APIKey: "key", APISecret: "secret", SessionKey: "session"}
+	if err := fm.Scrobble(context.Background(), account, testTracks); err != nil {
+		t.Fatal(err)
+	}
+
+	errorCode = 9 // 会话无效
+	if err := fm.Scrobble(context.Background(), account, testTracks); !isPermanent(err) {
+		t.Errorf("会话无效不应重试，err = %v", err)
+	}
+	errorCode = 11 // 服务暂时不可用
+	if err := fm.Scrobble(context.Background(), account, testTracks); err == nil || isPermanent(err) {
+		t.Errorf("服务暂时不可用应该可以重试，err = %v", err)
+	}
+}
+
+func TestCheckAPIURL(t *testing.T) {
+	lb := &listenBrainz{}
+	allowed := []string{"listenbrainz.example.com", "127.0.0.1:8100"}
+	cases := []struct {
+		url string
+		ok  bool
+	}{
+		{"", true},
+		{"https://api.listenbrainz.org", true},
+		{"https://listenbrainz.example.com/", true},
+		{"http://127.0.0.1:8100", true},
+		{"http://127.0.0.1:6379", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://localhost", false},
+		{"file:///etc/passwd", false},
+		{"gopher://listenbrainz.example.com", false},
+	}
+	for _, tc := range cases {
+		err := checkAPIURL(lb, &ScrobblerAccount{APIURL: tc.url}, allowed)
+		if (err == nil) != tc.ok {
+			t.Errorf("checkAPIURL(%q) = %v", tc.url, err)
+		}
+		if err != nil && !isPermanent(err) {
+			t.Errorf("checkAPIURL(%q) 应该返回不再重试的错误", tc.url)
+		}
+	}
+}
+
+// 停用账号的记录不参与加载，避免占满每次加载的上限
+func TestDueQueryOnlyEnabledAccounts(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &ScrobbleService{db: db, backends: map[string]Backend{listenBrainzName: &listenBrainz{}}}
+
+	var due []PendingScrobble
+	stmt := ss.dueQuery(time.Now()).Find(&due).Statement
+	sql := stmt.SQL.String()
+	for _, want := range []string{"JOIN user_scrobblers", "user_scrobblers.enabled = ?", "user_scrobblers.backend IN", "LIMIT"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL 缺少 %q: %s", want, sql)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if d := retryDelay(time.Minute, 0); d != time.Minute {
+		t.Errorf("retryDelay(0) = %v", d)
+	}
+	if d := retryDelay(time.Minute, 3); d != 8*time.Minute {
+		t.Errorf("retryDelay(3) = %v", d)
+	}
+	if d := retryDelay(time.Minute, 100); d != maxRetryDelay {
+		t.Errorf("retryDelay(100) = %v", d)
+	}
+}
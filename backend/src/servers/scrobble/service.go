@@ -0,0 +1,78 @@
+package scrobble
+
+import (
+	"context"
+	logger "myapp/log"
+	"myapp/servers/music"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScrobbleConfig struct {
+	// 离线队列的检查间隔，也是失败重试的初始间隔
+	RetryInterval time.Duration
+	// 超过该次数仍失败的记录不再自动重试
+	MaxAttempts int
+	// 单次请求超时
+	Timeout time.Duration
+	// 账号自定义 API 地址允许的主机（可带端口），各服务的官方地址总是允许
+	AllowedHosts []string
+}
+
+// ScrobbleService 将用户的听歌记录提交到 ListenBrainz、Last.fm 等服务。
+// 提交失败的记录保存在离线队列中，由后台协程重试
+type ScrobbleService struct {
+	ctx      context.Context
+	cfg      *ScrobbleConfig
+	db       *gorm.DB
+	ms       *music.MusicService
+	backends map[string]Backend
+	wake     chan struct{}
+	rg       *gin.RouterGroup
+}
+
+func NewScrobbleService(ctx context.Context, cfg *ScrobbleConfig, db *gorm.DB, r *gin.Engine, ms *music.MusicService) *ScrobbleService {
+	err := db.AutoMigrate(&ScrobblerAccount{}, &PendingScrobble{})
+	if err != nil {
+		logger.ZError(&ctx, "数据库自动迁移失败", err)
+		return nil
+	}
+
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 20
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	ss := &ScrobbleService{
+		ctx:      ctx,
+		cfg:      cfg,
+		db:       db,
+		ms:       ms,
+		backends: make(map[string]Backend),
+		wake:     make(chan struct{}, 1),
+		rg:       r.Group("/scrobble"),
+	}
+	for _, backend := range []Backend{
+		&listenBrainz{client: client},
+		&lastFM{client: client},
+	} {
+		ss.backends[backend.Name()] = backend
+	}
+	return ss
+}
+
+func (ss *ScrobbleService) Start() {
+	// 启动离线队列提交
+	go ss.worker()
+
+	ss.RegisterRoutes()
+}
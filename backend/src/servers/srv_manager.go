@@ -6,7 +6,12 @@ import (
 	"myapp/config"
 	"myapp/database"
 	logger "myapp/log"
+	"myapp/servers/dlna"
+	"myapp/servers/mpd"
 	"myapp/servers/music"
+	"myapp/servers/podcast"
+	"myapp/servers/scrobble"
+	"myapp/servers/tagging"
 	"myapp/servers/user"
 
 	"github.com/gin-contrib/cors"
@@ -22,6 +27,11 @@ type ServerManager struct {
 	r            *gin.Engine
 	musicService *music.MusicService
 	us           *user.UserService
+	ps           *podcast.PodcastService
+	ds           *dlna.DLNAService
+	mpd          *mpd.MPDService
+	ss           *scrobble.ScrobbleService
+	ts           *tagging.TaggingService
 }
 
 func NewServerManager(ctx *context.Context, config *config.Config) *ServerManager {
@@ -32,27 +42,75 @@ func NewServerManager(ctx *context.Context, config *config.Config) *ServerManage
 	}
 
 	r := gin.Default()
+	// 登录锁定和审计日志按客户端 IP 记录，只信任配置的反向代理转发的地址
+	if err := r.SetTrustedProxies(config.SrvConfig.TrustedProxies); err != nil {
+		logger.ZFatal(ctx, "配置可信代理失败", err)
+	}
 
 	// // 配置 CORS
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
-	us := user.NewUserService(nil, db, r)
+	us := user.NewUserService(*ctx, &config.UserConfig, db, r)
 	if us == nil {
 		logger.ZFatal(ctx, "初始化用户服务失败", nil)
 	}
 
+	// 播客单集存放在独立的音乐库中，只对订阅者开放，通过播客接口播放
+	config.MusicConfig.AddLibrary(config.PodcastConfig.LibraryConfig())
+	config.MusicConfig.TrustedProxies = config.SrvConfig.TrustedProxies
+
 	// 初始化音乐服务等
 	musicService := music.NewMusicService(*ctx, &config.MusicConfig, db, r)
 	if musicService == nil {
 		logger.ZFatal(ctx, "初始化音乐服务失败", nil)
 	}
 
+	// 初始化播客服务
+	ps := podcast.NewPodcastService(*ctx, &config.PodcastConfig, db, r, musicService)
+	if ps == nil {
+		logger.ZFatal(ctx, "初始化播客服务失败", nil)
+	}
+
+	// 初始化 DLNA 媒体服务器（可选）
+	var ds *dlna.DLNAService
+	if config.DLNAConfig.Enabled {
+		config.DLNAConfig.HTTPPort = config.SrvConfig.Port
+		ds = dlna.NewDLNAService(*ctx, &config.DLNAConfig, db, r, musicService)
+		if ds == nil {
+			logger.ZFatal(ctx, "初始化 DLNA 服务失败", nil)
+		}
+	}
+
+	// 初始化 MPD 协议服务（可选）
+	var mpdService *mpd.MPDService
+	if config.MPDConfig.Enabled {
+		mpdService = mpd.NewMPDService(*ctx, &config.MPDConfig, db, musicService)
+		mpdService.SetAuthenticator(us.Authenticate)
+	}
+
+	// 初始化听歌记录服务
+	ss := scrobble.NewScrobbleService(*ctx, &config.ScrobbleConfig, db, r, musicService)
+	if ss == nil {
+		logger.ZFatal(ctx, "初始化听歌记录服务失败", nil)
+	}
+
+	// 初始化指纹和标签修正服务
+	ts := tagging.NewTaggingService(*ctx, &config.TaggingConfig, db, r, musicService)
+	if ts == nil {
+		logger.ZFatal(ctx, "初始化标签服务失败", nil)
+	}
+
+	// 删除用户时清理各服务中的用户数据
+	us.OnUserDeleted(musicService.DeleteUserData)
+	us.OnUserDeleted(ps.DeleteUserData)
+	us.OnUserDeleted(ss.DeleteUserData)
+
 	return &ServerManager{
 		cfg:          config,
 		db:           db,
@@ -60,6 +118,11 @@ func NewServerManager(ctx *context.Context, config *config.Config) *ServerManage
 		ctx:          ctx,
 		r:            r,
 		us:           us,
+		ps:           ps,
+		ds:           ds,
+		mpd:          mpdService,
+		ss:           ss,
+		ts:           ts,
 	}
 }
 
@@ -71,6 +134,25 @@ func (srvMgr *ServerManager) StartAllServers() {
 
 		// 启动用户服务
 		srvMgr.us.Start()
+
+		// 启动播客服务
+		srvMgr.ps.Start()
+
+		// 启动听歌记录服务
+		srvMgr.ss.Start()
+
+		// 启动指纹和标签修正服务
+		srvMgr.ts.Start()
+
+		// 启动 DLNA 服务
+		if srvMgr.ds != nil {
+			srvMgr.ds.Start()
+		}
+
+		// 启动 MPD 服务
+		if srvMgr.mpd != nil {
+			srvMgr.mpd.Start()
+		}
 	}
 
 	// 添加外键约束
@@ -88,6 +170,18 @@ func (srvMgr *ServerManager) StartAllServers() {
 		FOREIGN KEY (music_id) REFERENCES musics(id) 
 		ON DELETE CASCADE
 	`)
+	// 评分、标签、评论随用户和音乐一起删除
+	for _, table := range []string{"music_ratings", "music_tags", "music_comments"} {
+		srvMgr.db.Exec(`ALTER TABLE ` + table + ` ADD CONSTRAINT fk_` + table + `_user
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE`)
+		srvMgr.db.Exec(`ALTER TABLE ` + table + ` ADD CONSTRAINT fk_` + table + `_music
+			FOREIGN KEY (music_id) REFERENCES musics(id) ON DELETE CASCADE`)
+	}
+	// 刷新令牌、登录会话、邮件令牌、恢复码、外部身份和 API 密钥随用户一起删除
+	for _, table := range []string{"refresh_tokens", "sessions", "email_tokens", "recovery_codes", "oidc_identities", "api_keys"} {
+		srvMgr.db.Exec(`ALTER TABLE ` + table + ` ADD CONSTRAINT fk_` + table + `_user
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE`)
+	}
 
 	// 启动HTTP服务器
 	addr := srvMgr.cfg.SrvConfig.Addr + ":" + srvMgr.cfg.SrvConfig.Port
@@ -0,0 +1,114 @@
+package tagging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AcoustID 兼容的在线查询服务，返回 MusicBrainz 录音信息。
+// 地址可以指向自建的 acoustid-server 或本地测试服务
+
+const acoustIDName = "acoustid"
+
+type acoustID struct {
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+type acoustIDResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			ID      string `json:"id"`
+			Title   string `json:"title"`
+			Artists []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artists"`
+			ReleaseGroups []struct {
+				Title string `json:"title"`
+				Type  string `json:"type"`
+			} `json:"releasegroups"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+func (ac *acoustID) Name() string {
+	return acoustIDName
+}
+
+func (ac *acoustID) Lookup(ctx context.Context, query *Query) ([]Match, error) {
+	form := url.Values{}
+	form.Set("client", ac.apiKey)
+	form.Set("duration", strconv.Itoa(query.Duration))
+	form.Set("fingerprint", query.Encoded)
+	form.Set("meta", "recordings releasegroups")
+	form.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ac.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ac.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	var result acoustIDResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("无法解析响应: HTTP %d", resp.StatusCode)
+	}
+	if result.Status != "ok" {
+		return nil, fmt.Errorf("AcoustID 错误 %d: %s", result.Error.Code, result.Error.Message)
+	}
+
+	var matches []Match
+	for _, r := range result.Results {
+		for _, recording := range r.Recordings {
+			if recording.Title == "" {
+				continue
+			}
+			var artist strings.Builder
+			for _, a := range recording.Artists {
+				artist.WriteString(a.Name)
+				artist.WriteString(a.JoinPhrase)
+			}
+			match := Match{
+				Score:       r.Score,
+				RecordingID: recording.ID,
+				Title:       recording.Title,
+				Artist:      artist.String(),
+			}
+			// 优先使用专辑，其次是单曲、EP 等
+			for _, group := range recording.ReleaseGroups {
+				if match.Album == "" || group.Type == "Album" {
+					match.Album = group.Title
+				}
+				if group.Type == "Album" {
+					break
+				}
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,118 @@
+package tagging
+
+import (
+	"myapp/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (ts *TaggingService) Scan(c *gin.Context) {
+	ts.wakeUp()
+	c.JSON(http.StatusOK, gin.H{"message": "已开始处理"})
+}
+
+func (ts *TaggingService) GetMusicFingerprint(c *gin.Context) {
+	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return
+	}
+
+	record, err := ts.getFingerprint(uint(musicID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "尚未计算指纹"})
+		return
+	}
+	suggestions, err := ts.getSuggestions("", uint(musicID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取修正建议失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"fingerprint": record, "suggestions": suggestions}})
+}
+
+func (ts *TaggingService) RefreshFingerprint(c *gin.Context) {
+	musicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的音乐ID"})
+		return
+	}
+
+	record, err := ts.refresh(uint(musicID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	suggestions, err := ts.getSuggestions(SuggestionStatusPending, uint(musicID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取修正建议失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"fingerprint": record, "suggestions": suggestions}})
+}
+
+func (ts *TaggingService) GetSuggestions(c *gin.Context) {
+	status := c.DefaultQuery("status", SuggestionStatusPending)
+	if status == "all" {
+		status = ""
+	}
+	suggestions, err := ts.getSuggestions(status, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取修正建议失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": suggestions})
+}
+
+func (ts *TaggingService) ApproveSuggestion(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的建议ID"})
+		return
+	}
+	var req ApproveRequest
+	// 请求体可以为空
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+	}
+
+	music, err := ts.approve(c.GetString("user_id"), uint(id), &req)
+	record := &middleware.AuditRecord{
+		Action:     middleware.AuditSuggestionApprove,
+		TargetType: middleware.AuditTargetSuggestion,
+		TargetID:   strconv.FormatUint(id, 10),
+	}
+	if music != nil {
+		record.Detail = map[string]interface{}{
+			"music_id": music.ID,
+			"title":    music.Title,
+			"artist":   music.Artist,
+			"album":    music.Album,
+		}
+	}
+	middleware.RecordAudit(c, record, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": music, "message": "已更新标签"})
+}
+
+func (ts *TaggingService) RejectSuggestion(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的建议ID"})
+		return
+	}
+
+	if err := ts.reject(c.GetString("user_id"), uint(id)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已拒绝"})
+}
@@ -0,0 +1,397 @@
+package tagging
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// 与 Chromaprint 默认算法（CHROMAPRINT_ALGORITHM_TEST2，fpcalc 的默认值）一致的指纹计算：
+// 11025Hz 单声道 → 4096 点 FFT（帧移 1365）→ 12 维色度特征 → 平滑、归一化
+// → 16 个 Haar 滤波分类器，每帧得到一个 32 位子指纹
+
+const (
+	SampleRate = 11025
+
+	fpFrameSize   = 4096
+	fpFrameStep   = fpFrameSize / 3 // 重叠 4096 - 4096/3 个采样
+	fpMinFreq     = 28
+	fpMaxFreq     = 3520
+	fpBands       = 12
+	fpAlgorithmID = 1 // TEST2
+)
+
+var ErrAudioTooShort = errors.New("音频过短，无法计算指纹")
+
+var chromaFilterCoefficients = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+var grayCode = [4]uint32{0, 1, 3, 2}
+
+type fpFilter struct {
+	kind   int
+	y      int // 起始色度带
+	height int // 色度带数量
+	width  int // 帧数
+}
+
+type fpClassifier struct {
+	filter     fpFilter
+	thresholds [3]float64
+}
+
+var fpClassifiers = [16]fpClassifier{
+	{fpFilter{0, 4, 3, 15}, [3]float64{1.98215, 2.35817, 2.63523}},
+	{fpFilter{4, 4, 6, 15}, [3]float64{-1.03809, -0.651211, -0.282167}},
+	{fpFilter{1, 0, 4, 16}, [3]float64{-0.298702, 0.119262, 0.558497}},
+	{fpFilter{3, 8, 2, 12}, [3]float64{-0.105439, 0.0153946, 0.135898}},
+	{fpFilter{3, 4, 4, 8}, [3]float64{-0.142891, 0.0258736, 0.200632}},
+	{fpFilter{4, 0, 3, 5}, [3]float64{-0.826319, -0.590612, -0.368214}},
+	{fpFilter{1, 2, 2, 9}, [3]float64{-0.557409, -0.233035, 0.0534525}},
+	{fpFilter{2, 7, 3, 4}, [3]float64{-0.0646826, 0.00620476, 0.0784847}},
+	{fpFilter{2, 6, 2, 16}, [3]float64{-0.192387, -0.029699, 0.215855}},
+	{fpFilter{2, 1, 3, 2}, [3]float64{-0.0397818, -0.00568076, 0.0292026}},
+	{fpFilter{5, 10, 1, 15}, [3]float64{-0.53823, -0.369934, -0.190235}},
+	{fpFilter{3, 6, 2, 10}, [3]float64{-0.124877, 0.0296483, 0.139239}},
+	{fpFilter{2, 1, 1, 14}, [3]float64{-0.101475, 0.0225617, 0.231971}},
+	{fpFilter{3, 5, 6, 4}, [3]float64{-0.0799915, -0.00729616, 0.063262}},
+	{fpFilter{1, 9, 2, 12}, [3]float64{-0.272556, 0.019424, 0.302559}},
+	{fpFilter{3, 4, 2, 14}, [3]float64{-0.164292, -0.0321188, 0.08463}},
+}
+
+const fpMaxFilterWidth = 16
+
+// Fingerprint 计算 11025Hz 单声道 16 位采样的原始指纹
+func Fingerprint(samples []int16) ([]uint32, error) {
+	image := chromaImage(samples)
+	if len(image) < fpMaxFilterWidth {
+		return nil, ErrAudioTooShort
+	}
+
+	integral := newIntegralImage(image)
+	fp := make([]uint32, 0, len(image)-fpMaxFilterWidth+1)
+	for offset := 0; offset+fpMaxFilterWidth <= len(image); offset++ {
+		var bits uint32
+		for _, classifier := range fpClassifiers {
+			bits = (bits << 2) | grayCode[classifier.classify(integral, offset)]
+		}
+		fp = append(fp, bits)
+	}
+	return fp, nil
+}
+
+// chromaImage 每帧得到平滑、归一化后的 12 维色度特征
+func chromaImage(samples []int16) [][fpBands]float64 {
+	window := make([]float64, fpFrameSize)
+	for i := range window {
+		// Hamming 窗，同时将采样缩放到 [-1, 1]
+		window[i] = (0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(fpFrameSize-1))) / math.MaxInt16
+	}
+
+	minIndex := max(1, freqToIndex(fpMinFreq))
+	maxIndex := min(fpFrameSize/2, freqToIndex(fpMaxFreq))
+	notes := make([]int, fpFrameSize/2+1)
+	for i := minIndex; i < maxIndex; i++ {
+		freq := float64(i) * SampleRate / fpFrameSize
+		octave := math.Log2(freq / (440.0 / 16.0))
+		notes[i] = int(fpBands * (octave - math.Floor(octave)))
+	}
+
+	var chroma [][fpBands]float64
+	buf := make([]complex128, fpFrameSize)
+	for start := 0; start+fpFrameSize <= len(samples); start += fpFrameStep {
+		for i := range buf {
+			buf[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fft(buf)
+
+		var features [fpBands]float64
+		for i := minIndex; i < maxIndex; i++ {
+			re, im := real(buf[i]), imag(buf[i])
+			features[notes[i]] += re*re + im*im
+		}
+		chroma = append(chroma, features)
+	}
+
+	// 相邻帧加权平滑
+	n := len(chroma) - len(chromaFilterCoefficients) + 1
+	if n <= 0 {
+		return nil
+	}
+	image := make([][fpBands]float64, n)
+	for row := range image {
+		for j, coef := range chromaFilterCoefficients {
+			for band := 0; band < fpBands; band++ {
+				image[row][band] += coef * chroma[row+j][band]
+			}
+		}
+		normalize(&image[row])
+	}
+	return image
+}
+
+func freqToIndex(freq float64) int {
+	return int(math.Round(fpFrameSize * freq / SampleRate))
+}
+
+// normalize 按欧几里得范数归一化，接近静音的帧置零
+func normalize(v *[fpBands]float64) {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	norm := math.Sqrt(sum)
+	if norm < 0.01 {
+		*v = [fpBands]float64{}
+		return
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// fft 原地基 2 快速傅里叶变换，长度必须是 2 的幂
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := a[start+k]
+				v := a[start+k+size/2] * w
+				a[start+k] = u + v
+				a[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+// integralImage 积分图，行为时间，列为色度带
+type integralImage struct {
+	sums [][fpBands + 1]float64
+}
+
+func newIntegralImage(image [][fpBands]float64) *integralImage {
+	sums := make([][fpBands + 1]float64, len(image)+1)
+	for x, row := range image {
+		for y := 0; y < fpBands; y++ {
+			sums[x+1][y+1] = row[y] + sums[x][y+1] + sums[x+1][y] - sums[x][y]
+		}
+	}
+	return &integralImage{sums: sums}
+}
+
+// area 返回 [x1, x2) × [y1, y2) 区域内的和
+func (ii *integralImage) area(x1, y1, x2, y2 int) float64 {
+	return ii.sums[x2][y2] - ii.sums[x1][y2] - ii.sums[x2][y1] + ii.sums[x1][y1]
+}
+
+func subtractLog(a, b float64) float64 {
+	return math.Log((1 + a) / (1 + b))
+}
+
+func (f *fpFilter) apply(ii *integralImage, x int) float64 {
+	y, w, h := f.y, f.width, f.height
+	switch f.kind {
+	case 0:
+		return subtractLog(ii.area(x, y, x+w, y+h), 0)
+	case 1:
+		h2 := h / 2
+		return subtractLog(ii.area(x, y+h2, x+w, y+h), ii.area(x, y, x+w, y+h2))
+	case 2:
+		w2 := w / 2
+		return subtractLog(ii.area(x+w2, y, x+w, y+h), ii.area(x, y, x+w2, y+h))
+	case 3:
+		w2, h2 := w/2, h/2
+		a := ii.area(x, y+h2, x+w2, y+h) + ii.area(x+w2, y, x+w, y+h2)
+		b := ii.area(x, y, x+w2, y+h2) + ii.area(x+w2, y+h2, x+w, y+h)
+		return subtractLog(a, b)
+	case 4:
+		h3 := h / 3
+		a := ii.area(x, y+h3, x+w, y+2*h3)
+		b := ii.area(x, y, x+w, y+h3) + ii.area(x, y+2*h3, x+w, y+h)
+		return subtractLog(a, b)
+	default:
+		w3 := w / 3
+		a := ii.area(x+w3, y, x+2*w3, y+h)
+		b := ii.area(x, y, x+w3, y+h) + ii.area(x+2*w3, y, x+w, y+h)
+		return subtractLog(a, b)
+	}
+}
+
+func (c *fpClassifier) classify(ii *integralImage, x int) int {
+	value := c.filter.apply(ii, x)
+	switch {
+	case value < c.thresholds[0]:
+		return 0
+	case value < c.thresholds[1]:
+		return 1
+	case value < c.thresholds[2]:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// EncodeFingerprint 压缩为 fpcalc / AcoustID 使用的字符串格式
+func EncodeFingerprint(fp []uint32) string {
+	var normal, exceptional []uint32
+	var last uint32
+	for _, sub := range fp {
+		x := sub ^ last
+		last = sub
+		bit, lastBit := uint32(1), uint32(0)
+		for ; x != 0; x >>= 1 {
+			if x&1 != 0 {
+				value := bit - lastBit
+				if value >= 7 {
+					normal = append(normal, 7)
+					exceptional = append(exceptional, value-7)
+				} else {
+					normal = append(normal, value)
+				}
+				lastBit = bit
+			}
+			bit++
+		}
+		normal = append(normal, 0)
+	}
+
+	size := len(fp)
+	out := []byte{fpAlgorithmID, byte(size >> 16), byte(size >> 8), byte(size)}
+	out = packBits(out, normal, 3)
+	out = packBits(out, exceptional, 5)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecodeFingerprint 解析 EncodeFingerprint 生成的字符串
+func DecodeFingerprint(encoded string) ([]uint32, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < 4 {
+		return nil, errors.New("无效的指纹")
+	}
+	size := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	r := &bitReader{data: data[4:]}
+
+	var normal []uint32
+	zeros, exceptionalCount := 0, 0
+	for zeros < size {
+		value, ok := r.read(3)
+		if !ok {
+			return nil, errors.New("指纹数据不完整")
+		}
+		switch value {
+		case 0:
+			zeros++
+		case 7:
+			exceptionalCount++
+		}
+		normal = append(normal, value)
+	}
+	// 3 位数据按字节对齐后才是 5 位数据
+	r.align()
+	for i := range normal {
+		if normal[i] != 7 {
+			continue
+		}
+		extra, ok := r.read(5)
+		if !ok {
+			return nil, errors.New("指纹数据不完整")
+		}
+		normal[i] += extra
+	}
+
+	fp := make([]uint32, 0, size)
+	var x, last uint32
+	lastBit := uint32(0)
+	for _, value := range normal {
+		if value == 0 {
+			last ^= x
+			fp = append(fp, last)
+			x, lastBit = 0, 0
+			continue
+		}
+		lastBit += value
+		if lastBit > 32 {
+			return nil, errors.New("无效的指纹")
+		}
+		x |= 1 << (lastBit - 1)
+	}
+	return fp, nil
+}
+
+// packBits 按低位在前的顺序将每个值写入 n 位
+func packBits(out []byte, values []uint32, n uint) []byte {
+	var acc uint32
+	var used uint
+	for _, value := range values {
+		acc |= (value & (1<<n - 1)) << used
+		used += n
+		for used >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			used -= 8
+		}
+	}
+	if used > 0 {
+		out = append(out, byte(acc))
+	}
+	return out
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint // 已读取的位数
+}
+
+func (r *bitReader) read(n uint) (uint32, bool) {
+	var value uint32
+	for i := uint(0); i < n; i++ {
+		byteIndex := r.pos / 8
+		if int(byteIndex) >= len(r.data) {
+			return 0, false
+		}
+		value |= uint32(r.data[byteIndex]>>(r.pos%8)&1) << i
+		r.pos++
+	}
+	return value, true
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// Similarity 在 ±maxOffset 帧内对齐两个指纹，返回相同位的最大比例（0~1）
+func Similarity(a, b []uint32, maxOffset int) float64 {
+	best := 0.0
+	for offset := -maxOffset; offset <= maxOffset; offset++ {
+		var diff, total int
+		for i := range a {
+			j := i + offset
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			diff += bits.OnesCount32(a[i] ^ b[j])
+			total += 32
+		}
+		// 重叠部分太少时不可信
+		if total < 32*fpMaxFilterWidth*4 {
+			continue
+		}
+		best = max(best, 1-float64(diff)/float64(total))
+	}
+	return best
+}
@@ -0,0 +1,115 @@
+package tagging
+
+import (
+	"math/rand"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testdata/tones.wav：11025Hz 单声道 16 位，8 个和弦音各 0.75 秒，由脚本合成
+
+func loadFixture(t *testing.T) []int16 {
+	f, err := os.Open("testdata/tones.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	audio, err := decodeWAV(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return audio.Samples
+}
+
+func TestFingerprintEncodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]uint32, 500)
+	for i := range random {
+		random[i] = rng.Uint32()
+	}
+	fixture, err := Fingerprint(loadFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]uint32{
+		"空":    {},
+		"全零":   {0, 0, 0},
+		"全一":   {0xFFFFFFFF, 0, 0xFFFFFFFF},
+		"最高位":  {1 << 31, 1, 1<<31 | 1},
+		"随机":   random,
+		"音频指纹": fixture,
+	}
+	for name, fp := range cases {
+		decoded, err := DecodeFingerprint(EncodeFingerprint(fp))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !slices.Equal(decoded, fp) {
+			t.Errorf("%s: 解码结果与原指纹不一致", name)
+		}
+	}
+}
+
+func TestDecodeFingerprintInvalid(t *testing.T) {
+	encoded := EncodeFingerprint([]uint32{0x12345678, 0x9ABCDEF0, 0x0F0F0F0F})
+	for _, s := range []string{"", "!!!", "AQ", encoded[:len(encoded)-4]} {
+		if _, err := DecodeFingerprint(s); err == nil {
+			t.Errorf("DecodeFingerprint(%q) 应该失败", s)
+		}
+	}
+}
+
+// 与 Chromaprint 的参考实现对比，期望值在测试时由 fpcalc 计算，未安装 fpcalc 时跳过
+func TestFingerprintMatchesFpcalc(t *testing.T) {
+	bin, err := exec.LookPath("fpcalc")
+	if err != nil {
+		t.Skip("未安装 fpcalc，跳过与参考实现的对比")
+	}
+
+	raw := fpcalc(t, bin, "-raw")
+	want := make([]uint32, 0)
+	for _, field := range strings.Split(raw, ",") {
+		// 旧版本的 fpcalc 输出有符号整数
+		v, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			t.Fatalf("无法解析 fpcalc 输出: %q", raw)
+		}
+		want = append(want, uint32(v))
+	}
+
+	got, err := Fingerprint(loadFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("指纹长度为 %d，fpcalc 为 %d", len(got), len(want))
+	}
+	// FFT 实现不同会带来浮点误差，阈值附近的个别位可能不同
+	if sim := Similarity(got, want, 0); sim < 0.99 {
+		t.Errorf("与 fpcalc 的相同位比例为 %.4f", sim)
+	}
+	if encoded := fpcalc(t, bin); EncodeFingerprint(want) != encoded {
+		t.Errorf("压缩结果与 fpcalc 不一致")
+	}
+}
+
+// fpcalc 返回 FINGERPRINT 行的值
+func fpcalc(t *testing.T, bin string, args ...string) string {
+	out, err := exec.Command(bin, append(args, "testdata/tones.wav")...).Output()
+	if err != nil {
+		t.Fatalf("fpcalc 执行失败: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if value, ok := strings.CutPrefix(line, "FINGERPRINT="); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	t.Fatalf("fpcalc 输出中没有指纹: %s", out)
+	return ""
+}
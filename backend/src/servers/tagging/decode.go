@@ -0,0 +1,202 @@
+package tagging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 与 fpcalc 一致，只取开头 120 秒计算指纹
+const fingerprintSeconds = 120
+
+// decodedAudio 解码后的 11025Hz 单声道采样
+type decodedAudio struct {
+	Samples  []int16
+	Duration int // 整首歌的时长（秒）
+}
+
+// decodeAudio 优先使用 ffmpeg 解码任意格式，未安装 ffmpeg 时只支持 PCM 编码的 WAV 文件
+func decodeAudio(ctx context.Context, ffmpeg, path string) (*decodedAudio, error) {
+	if ffmpeg != "" {
+		if bin, err := exec.LookPath(ffmpeg); err == nil {
+			return decodeWithFFmpeg(ctx, bin, path)
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".wav" && ext != ".wave" {
+		return nil, fmt.Errorf("未找到 ffmpeg，无法解码 %s 文件", ext)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeWAV(bufio.NewReader(f))
+}
+
+// decodeWithFFmpeg 由 ffmpeg 输出 s16le 单声道 11025Hz 采样，解码整个文件以得到准确的时长
+func decodeWithFFmpeg(ctx context.Context, bin, path string) (*decodedAudio, error) {
+	cmd := exec.CommandContext(ctx, bin,
+		"-nostdin", "-v", "error",
+		"-i", path,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(SampleRate),
+		"-f", "s16le", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	limit := fingerprintSeconds * SampleRate
+	samples := make([]int16, 0, limit)
+	total := 0
+	r := bufio.NewReaderSize(stdout, 64<<10)
+	var frame [2]byte
+	for {
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			break
+		}
+		if total < limit {
+			samples = append(samples, int16(binary.LittleEndian.Uint16(frame[:])))
+		}
+		total++
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg 解码失败: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return &decodedAudio{Samples: samples, Duration: int(math.Round(float64(total) / SampleRate))}, nil
+}
+
+// decodeWAV 解析 PCM WAV，混合为单声道并重采样到 11025Hz
+func decodeWAV(r io.Reader) (*decodedAudio, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("不是有效的 WAV 文件")
+	}
+
+	var channels, bitsPerSample int
+	var sampleRate int
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, errors.New("WAV 文件缺少音频数据")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil || len(body) < 16 {
+				return nil, errors.New("无效的 WAV 格式信息")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			// 1 为 PCM，0xFFFE 为扩展格式
+			if (format != 1 && format != 0xFFFE) || channels == 0 || sampleRate == 0 ||
+				(bitsPerSample != 8 && bitsPerSample != 16 && bitsPerSample != 24 && bitsPerSample != 32) {
+				return nil, errors.New("只支持整数 PCM 编码的 WAV 文件")
+			}
+			if size%2 == 1 {
+				io.CopyN(io.Discard, r, 1)
+			}
+		case "data":
+			if channels == 0 {
+				return nil, errors.New("无效的 WAV 格式信息")
+			}
+			return readPCM(io.LimitReader(r, size), channels, bitsPerSample, sampleRate)
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, errors.New("WAV 文件缺少音频数据")
+			}
+		}
+	}
+}
+
+func readPCM(r io.Reader, channels, bitsPerSample, sampleRate int) (*decodedAudio, error) {
+	bytesPerSample := bitsPerSample / 8
+	frame := make([]byte, bytesPerSample*channels)
+	// 重采样前最多需要的原始采样数
+	limit := fingerprintSeconds * sampleRate
+	mono := make([]float64, 0, min(limit, 1<<22))
+	total := 0
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	for {
+		if _, err := io.ReadFull(br, frame); err != nil {
+			break
+		}
+		total++
+		if total > limit {
+			continue
+		}
+		var sum float64
+		for ch := 0; ch < channels; ch++ {
+			sum += pcmSample(frame[ch*bytesPerSample:(ch+1)*bytesPerSample], bitsPerSample)
+		}
+		mono = append(mono, sum/float64(channels))
+	}
+
+	return &decodedAudio{
+		Samples:  resample(mono, sampleRate),
+		Duration: int(math.Round(float64(total) / float64(sampleRate))),
+	}, nil
+}
+
+// pcmSample 将一个采样转换为 16 位范围内的浮点数
+func pcmSample(b []byte, bitsPerSample int) float64 {
+	switch bitsPerSample {
+	case 8:
+		return (float64(b[0]) - 128) * 256
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b)))
+	case 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16
+		if v&0x800000 != 0 {
+			v |= ^0xFFFFFF
+		}
+		return float64(v) / 256
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 65536
+	}
+}
+
+// resample 降采样到 11025Hz，每个输出采样取对应区间内输入采样的平均值以抑制混叠
+func resample(in []float64, rate int) []int16 {
+	ratio := float64(rate) / SampleRate
+	n := int(float64(len(in)) / ratio)
+	out := make([]int16, n)
+	for i := range out {
+		start := int(float64(i) * ratio)
+		end := max(start+1, int(float64(i+1)*ratio))
+		end = min(end, len(in))
+		var sum float64
+		for _, v := range in[start:end] {
+			sum += v
+		}
+		v := sum / float64(end-start)
+		out[i] = int16(max(math.MinInt16, min(math.MaxInt16, math.Round(v))))
+	}
+	return out
+}
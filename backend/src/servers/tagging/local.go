@@ -0,0 +1,106 @@
+package tagging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 离线查询：从本地数据文件加载参考指纹，按指纹相似度匹配。
+// 数据文件每行一个 JSON 对象，可由 MusicBrainz / AcoustID 数据转储导出：
+// {"fingerprint":"AQAD...","duration":215,"recording_id":"...","title":"...","artist":"...","album":"..."}
+
+const localName = "local"
+
+// 时长相差超过该秒数的参考指纹不参与比较
+const localDurationTolerance = 7
+
+// 对齐时允许的最大偏移帧数，约 10 秒
+const localMaxOffset = 80
+
+type localReference struct {
+	Fingerprint string `json:"fingerprint"`
+	Duration    int    `json:"duration"`
+	RecordingID string `json:"recording_id"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+
+	fp []uint32
+}
+
+type localDump struct {
+	refs []localReference // 按时长排序
+}
+
+func loadLocalDump(path string) (*localDump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var refs []localReference
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ref localReference
+		if err := json.Unmarshal(scanner.Bytes(), &ref); err != nil {
+			return nil, fmt.Errorf("第 %d 行格式错误: %v", line, err)
+		}
+		if ref.fp, err = DecodeFingerprint(ref.Fingerprint); err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		ref.Fingerprint = ""
+		refs = append(refs, ref)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Duration < refs[j].Duration })
+	return &localDump{refs: refs}, nil
+}
+
+func (ld *localDump) Name() string {
+	return localName
+}
+
+func (ld *localDump) Lookup(ctx context.Context, query *Query) ([]Match, error) {
+	start := sort.Search(len(ld.refs), func(i int) bool {
+		return ld.refs[i].Duration >= query.Duration-localDurationTolerance
+	})
+
+	var matches []Match
+	for _, ref := range ld.refs[start:] {
+		if ref.Duration > query.Duration+localDurationTolerance {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// 相同位比例 0.5 相当于随机，换算为 0~1 的分数
+		score := (Similarity(query.Fingerprint, ref.fp, localMaxOffset) - 0.5) * 2
+		if score <= 0 {
+			continue
+		}
+		matches = append(matches, Match{
+			Score:       score,
+			RecordingID: ref.RecordingID,
+			Title:       ref.Title,
+			Artist:      ref.Artist,
+			Album:       ref.Album,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches, nil
+}
@@ -0,0 +1,29 @@
+package tagging
+
+import (
+	"context"
+)
+
+// Query 按指纹查询标签
+type Query struct {
+	Fingerprint []uint32
+	Encoded     string // EncodeFingerprint 的结果
+	Duration    int    // 秒
+}
+
+// Match 查询到的候选标签，RecordingID 为 MusicBrainz 录音 ID
+type Match struct {
+	Score       float64
+	RecordingID string
+	Title       string
+	Artist      string
+	Album       string
+}
+
+// Provider 标签查询服务，新增服务时实现该接口并在 NewTaggingService 中注册
+type Provider interface {
+	// Name 服务名称，记录在 TagSuggestion.Provider 中
+	Name() string
+	// Lookup 返回按可信度排序的候选标签，未找到时返回空列表
+	Lookup(ctx context.Context, query *Query) ([]Match, error)
+}
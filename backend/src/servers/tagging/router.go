@@ -0,0 +1,16 @@
+package tagging
+
+import "myapp/middleware"
+
+func (ts *TaggingService) RegisterRoutes() {
+	taggingGroup := ts.rg
+	taggingGroup.Use(middleware.AuthMiddleware(), middleware.RequirePermission(middleware.PermTaggingReview))
+
+	taggingGroup.POST("/scan", ts.Scan)                            // 立即处理新增的音乐
+	taggingGroup.GET("/music/:id", ts.GetMusicFingerprint)         // 指纹和建议
+	taggingGroup.POST("/music/:id/refresh", ts.RefreshFingerprint) // 重新计算并查询
+
+	taggingGroup.GET("/suggestions", ts.GetSuggestions)                 // 修正建议列表
+	taggingGroup.POST("/suggestions/:id/approve", ts.ApproveSuggestion) // 采纳
+	taggingGroup.POST("/suggestions/:id/reject", ts.RejectSuggestion)   // 拒绝
+}
@@ -0,0 +1,96 @@
+package tagging
+
+import (
+	"context"
+	logger "myapp/log"
+	"myapp/servers/music"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TaggingConfig struct {
+	// ffmpeg 可执行文件，未安装时只能处理 WAV 文件
+	FFmpeg string
+	// 检查新增音乐的间隔
+	Interval      time.Duration
+	DecodeTimeout time.Duration
+	LookupTimeout time.Duration
+	// 低于该分数的候选不保存
+	MinScore float64
+
+	// AcoustID 兼容服务，未配置 API Key 时不启用
+	AcoustIDURL string
+	AcoustIDKey string
+	// 离线参考指纹数据文件（JSON Lines），为空时不启用
+	LocalDump string
+}
+
+// TaggingService 为音乐计算声学指纹，并通过查询服务给出标签修正建议，由管理员审核
+type TaggingService struct {
+	ctx       context.Context
+	cfg       *TaggingConfig
+	db        *gorm.DB
+	ms        *music.MusicService
+	providers []Provider
+	wake      chan struct{}
+	rg        *gin.RouterGroup
+}
+
+func NewTaggingService(ctx context.Context, cfg *TaggingConfig, db *gorm.DB, r *gin.Engine, ms *music.MusicService) *TaggingService {
+	err := db.AutoMigrate(&MusicFingerprint{}, &TagSuggestion{})
+	if err != nil {
+		logger.ZError(&ctx, "数据库自动迁移失败", err)
+		return nil
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.DecodeTimeout <= 0 {
+		cfg.DecodeTimeout = 2 * time.Minute
+	}
+	if cfg.LookupTimeout <= 0 {
+		cfg.LookupTimeout = 30 * time.Second
+	}
+	if cfg.AcoustIDURL == "" {
+		cfg.AcoustIDURL = "https://api.acoustid.org/v2/lookup"
+	}
+
+	var providers []Provider
+	// 离线数据优先，在线服务作为补充
+	if cfg.LocalDump != "" {
+		dump, err := loadLocalDump(cfg.LocalDump)
+		if err != nil {
+			logger.ZError(&ctx, "加载离线指纹数据失败", err, "path", cfg.LocalDump)
+		} else {
+			providers = append(providers, dump)
+		}
+	}
+	if cfg.AcoustIDKey != "" {
+		providers = append(providers, &acoustID{
+			client: &http.Client{Timeout: cfg.LookupTimeout},
+			url:    cfg.AcoustIDURL,
+			apiKey: cfg.AcoustIDKey,
+		})
+	}
+
+	return &TaggingService{
+		ctx:       ctx,
+		cfg:       cfg,
+		db:        db,
+		ms:        ms,
+		providers: providers,
+		wake:      make(chan struct{}, 1),
+		rg:        r.Group("/tagging"),
+	}
+}
+
+func (ts *TaggingService) Start() {
+	// 启动指纹计算和标签查询
+	go ts.worker()
+
+	ts.RegisterRoutes()
+}
@@ -0,0 +1,379 @@
+package tagging
+
+import (
+	"context"
+	"errors"
+	"log"
+	"myapp/servers/music"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 指纹状态
+const (
+	FingerprintStatusOK     = "ok"
+	FingerprintStatusFailed = "failed"
+)
+
+// 标签修正建议的状态
+const (
+	SuggestionStatusPending  = "pending"
+	SuggestionStatusApproved = "approved"
+	SuggestionStatusRejected = "rejected"
+)
+
+// 每个服务最多保留的候选数
+const maxSuggestionsPerProvider = 3
+
+// 每轮处理的音乐数
+const batchSize = 20
+
+// MusicFingerprint 每首音乐一条指纹记录，解码失败时记录错误，不再自动重试
+type MusicFingerprint struct {
+	MusicID     uint       `gorm:"primaryKey;autoIncrement:false" json:"music_id"`
+	Fingerprint string     `gorm:"type:mediumtext" json:"fingerprint"` // Chromaprint 压缩格式
+	Duration    int        `json:"duration"`
+	Status      string     `gorm:"type:varchar(16);not null;index" json:"status"`
+	Error       string     `gorm:"type:varchar(1024)" json:"error"`
+	LookedUpAt  *time.Time `gorm:"index" json:"looked_up_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (MusicFingerprint) TableName() string {
+	return "music_fingerprints"
+}
+
+// TagSuggestion 标签查询服务给出的修正建议，管理员审核通过后写入音乐记录
+type TagSuggestion struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	MusicID     uint       `gorm:"not null;index" json:"music_id"`
+	Provider    string     `gorm:"type:varchar(32);not null" json:"provider"`
+	Score       float64    `json:"score"`
+	RecordingID string     `gorm:"type:varchar(64)" json:"recording_id"`
+	Title       string     `gorm:"type:varchar(255)" json:"title"`
+	Artist      string     `gorm:"type:varchar(255)" json:"artist"`
+	Album       string     `gorm:"type:varchar(255)" json:"album"`
+	Status      string     `gorm:"type:varchar(16);not null;index" json:"status"`
+	ReviewedBy  string     `gorm:"type:varchar(255)" json:"reviewed_by"`
+	ReviewedAt  *time.Time `json:"reviewed_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (TagSuggestion) TableName() string {
+	return "tag_suggestions"
+}
+
+// SuggestionResponse 附带当前的标签，便于对比
+type SuggestionResponse struct {
+	TagSuggestion
+	Music *music.Music `json:"music"`
+}
+
+// ApproveRequest 审核时可以修改建议的标签
+type ApproveRequest struct {
+	Title  *string `json:"title"`
+	Artist *string `json:"artist"`
+	Album  *string `json:"album"`
+}
+
+func (ts *TaggingService) wakeUp() {
+	select {
+	case ts.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker 定时为新增的音乐计算指纹并查询标签
+func (ts *TaggingService) worker() {
+	ticker := time.NewTicker(ts.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		// 处理完一批后立即继续，直到没有待处理的音乐
+		for ts.ctx.Err() == nil && ts.processBatch() {
+		}
+		select {
+		case <-ts.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ts.wake:
+		}
+	}
+}
+
+// processBatch 处理一批音乐，返回是否还有剩余
+func (ts *TaggingService) processBatch() bool {
+	// 音乐删除后清理对应的指纹和建议
+	ts.db.Where("music_id NOT IN (?)", ts.db.Model(&music.Music{}).Select("id")).Delete(&MusicFingerprint{})
+	ts.db.Where("music_id NOT IN (?)", ts.db.Model(&music.Music{}).Select("id")).Delete(&TagSuggestion{})
+
+	var pending []music.Music
+	err := ts.db.Where("id NOT IN (?)", ts.db.Model(&MusicFingerprint{}).Select("music_id")).
+		Order("id ASC").Limit(batchSize).Find(&pending).Error
+	if err != nil {
+		log.Printf("加载待计算指纹的音乐失败: %v", err)
+		return false
+	}
+	for i := range pending {
+		if ts.ctx.Err() != nil {
+			return false
+		}
+		ts.fingerprint(&pending[i])
+	}
+
+	var unresolved []MusicFingerprint
+	if len(ts.providers) > 0 {
+		err = ts.db.Where("status = ? AND looked_up_at IS NULL", FingerprintStatusOK).
+			Order("music_id ASC").Limit(batchSize).Find(&unresolved).Error
+		if err != nil {
+			log.Printf("加载待查询的指纹失败: %v", err)
+			return false
+		}
+		for i := range unresolved {
+			if ts.ctx.Err() != nil {
+				return false
+			}
+			if err := ts.lookup(&unresolved[i]); err != nil {
+				// 查询服务不可用，等下一轮再试
+				log.Printf("查询标签失败: music=%d - %v", unresolved[i].MusicID, err)
+				return len(pending) == batchSize
+			}
+		}
+	}
+
+	return len(pending) == batchSize || len(unresolved) == batchSize
+}
+
+// fingerprint 解码音频并保存指纹，失败时同样保存记录避免反复尝试
+func (ts *TaggingService) fingerprint(m *music.Music) *MusicFingerprint {
+	record := &MusicFingerprint{MusicID: m.ID, Status: FingerprintStatusOK}
+
+	err := func() error {
+		path, ok := ts.ms.MusicFilePath(m)
+		if !ok {
+			return errors.New("音乐库不存在")
+		}
+		ctx, cancel := context.WithTimeout(ts.ctx, ts.cfg.DecodeTimeout)
+		defer cancel()
+		audio, err := decodeAudio(ctx, ts.cfg.FFmpeg, path)
+		if err != nil {
+			return err
+		}
+		fp, err := Fingerprint(audio.Samples)
+		if err != nil {
+			return err
+		}
+		record.Fingerprint = EncodeFingerprint(fp)
+		record.Duration = audio.Duration
+		return nil
+	}()
+	if err != nil {
+		record.Status = FingerprintStatusFailed
+		record.Error = truncate(err.Error(), 1024)
+	}
+
+	if err := ts.db.Save(record).Error; err != nil {
+		log.Printf("保存指纹失败: music=%d - %v", m.ID, err)
+	}
+	return record
+}
+
+// lookup 依次查询所有服务，保存与当前标签不同的候选
+func (ts *TaggingService) lookup(record *MusicFingerprint) error {
+	var m music.Music
+	if err := ts.db.First(&m, record.MusicID).Error; err != nil {
+		return err
+	}
+	fp, err := DecodeFingerprint(record.Fingerprint)
+	if err != nil {
+		return err
+	}
+	query := &Query{Fingerprint: fp, Encoded: record.Fingerprint, Duration: record.Duration}
+
+	var suggestions []TagSuggestion
+	for _, provider := range ts.providers {
+		ctx, cancel := context.WithTimeout(ts.ctx, ts.cfg.LookupTimeout)
+		matches, err := provider.Lookup(ctx, query)
+		cancel()
+		if err != nil {
+			// 在线服务暂时不可用时下一轮重试
+			return err
+		}
+
+		for i, match := range matches {
+			if i >= maxSuggestionsPerProvider || match.Score < ts.cfg.MinScore {
+				break
+			}
+			if sameTags(&m, &match) {
+				if i == 0 {
+					// 可信度最高的候选与当前标签一致，无需修正
+					break
+				}
+				continue
+			}
+			suggestions = append(suggestions, TagSuggestion{
+				MusicID:     m.ID,
+				Provider:    provider.Name(),
+				Score:       match.Score,
+				RecordingID: match.RecordingID,
+				Title:       match.Title,
+				Artist:      match.Artist,
+				Album:       match.Album,
+				Status:      SuggestionStatusPending,
+			})
+		}
+	}
+
+	return ts.db.Transaction(func(tx *gorm.DB) error {
+		// 重新查询时替换未审核的旧建议
+		err := tx.Where("music_id = ? AND status = ?", m.ID, SuggestionStatusPending).Delete(&TagSuggestion{}).Error
+		if err != nil {
+			return err
+		}
+		if len(suggestions) > 0 {
+			if err := tx.Create(&suggestions).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		return tx.Model(record).Update("looked_up_at", &now).Error
+	})
+}
+
+func sameTags(m *music.Music, match *Match) bool {
+	return strings.EqualFold(m.Title, match.Title) &&
+		strings.EqualFold(m.Artist, match.Artist) &&
+		(match.Album == "" || strings.EqualFold(m.Album, match.Album))
+}
+
+// refresh 立即重新计算指纹并查询
+func (ts *TaggingService) refresh(musicID uint) (*MusicFingerprint, error) {
+	var m music.Music
+	if err := ts.db.First(&m, musicID).Error; err != nil {
+		return nil, errors.New("音乐不存在")
+	}
+	record := ts.fingerprint(&m)
+	if record.Status != FingerprintStatusOK {
+		return nil, errors.New(record.Error)
+	}
+	if len(ts.providers) > 0 {
+		if err := ts.lookup(record); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func (ts *TaggingService) getFingerprint(musicID uint) (*MusicFingerprint, error) {
+	var record MusicFingerprint
+	if err := ts.db.First(&record, musicID).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (ts *TaggingService) getSuggestions(status string, musicID uint) ([]SuggestionResponse, error) {
+	query := ts.db.Order("music_id ASC, score DESC").Limit(500)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if musicID != 0 {
+		query = query.Where("music_id = ?", musicID)
+	}
+	var suggestions []TagSuggestion
+	if err := query.Find(&suggestions).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(suggestions))
+	for _, s := range suggestions {
+		ids = append(ids, s.MusicID)
+	}
+	var musics []music.Music
+	if err := ts.db.Where("id IN ?", ids).Find(&musics).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*music.Music, len(musics))
+	for i := range musics {
+		byID[musics[i].ID] = &musics[i]
+	}
+
+	responses := make([]SuggestionResponse, 0, len(suggestions))
+	for _, s := range suggestions {
+		responses = append(responses, SuggestionResponse{TagSuggestion: s, Music: byID[s.MusicID]})
+	}
+	return responses, nil
+}
+
+// approve 将建议的标签写入音乐记录，同一首音乐的其他建议标记为拒绝
+func (ts *TaggingService) approve(userID string, id uint, req *ApproveRequest) (*music.Music, error) {
+	var suggestion TagSuggestion
+	if err := ts.db.First(&suggestion, id).Error; err != nil {
+		return nil, errors.New("建议不存在")
+	}
+	if suggestion.Status != SuggestionStatusPending {
+		return nil, errors.New("该建议已处理")
+	}
+
+	title, artist, album := suggestion.Title, suggestion.Artist, suggestion.Album
+	if req.Title != nil {
+		title = strings.TrimSpace(*req.Title)
+	}
+	if req.Artist != nil {
+		artist = strings.TrimSpace(*req.Artist)
+	}
+	if req.Album != nil {
+		album = strings.TrimSpace(*req.Album)
+	}
+	if title == "" {
+		return nil, errors.New("标题不能为空")
+	}
+
+	m, err := ts.ms.UpdateTags(suggestion.MusicID, title, artist, album)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ts.db.Model(&suggestion).Updates(map[string]interface{}{
+		"status":      SuggestionStatusApproved,
+		"reviewed_by": userID,
+		"reviewed_at": &now,
+	})
+	ts.db.Model(&TagSuggestion{}).
+		Where("music_id = ? AND status = ?", suggestion.MusicID, SuggestionStatusPending).
+		Updates(map[string]interface{}{
+			"status":      SuggestionStatusRejected,
+			"reviewed_by": userID,
+			"reviewed_at": &now,
+		})
+	return m, nil
+}
+
+func (ts *TaggingService) reject(userID string, id uint) error {
+	now := time.Now()
+	result := ts.db.Model(&TagSuggestion{}).
+		Where("id = ? AND status = ?", id, SuggestionStatusPending).
+		Updates(map[string]interface{}{
+			"status":      SuggestionStatusRejected,
+			"reviewed_by": userID,
+			"reviewed_at": &now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("建议不存在或已处理")
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
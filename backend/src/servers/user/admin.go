@@ -0,0 +1,210 @@
+package user
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"myapp/middleware"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	tempPasswordLength  = 12
+	tempPasswordCharset = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+)
+
+// UserDeleteHook 删除用户时由其他服务清理该用户的数据，在同一事务中执行
+type UserDeleteHook func(tx *gorm.DB, userID string) error
+
+// OnUserDeleted 注册删除用户时的清理函数
+func (us *UserService) OnUserDeleted(hook UserDeleteHook) {
+	us.deleteHooks = append(us.deleteHooks, hook)
+}
+
+type UserListQuery struct {
+	Query    string `form:"q"`      // 按用户名或邮箱模糊搜索
+	Role     string `form:"role"`   // 按角色筛选
+	Active   *bool  `form:"active"` // 按启用状态筛选
+	Locked   bool   `form:"locked"` // 只显示登录被锁定的用户
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+type UserList struct {
+	Items    []*UserResponse `json:"items"`
+	Total    int64           `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+type PasswordResetRequest struct {
+	Password string `json:"password"` // 为空时生成临时密码
+}
+
+// listUsers 分页查询用户
+func (us *UserService) listUsers(q *UserListQuery) (*UserList, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = defaultPageSize
+	}
+	q.PageSize = min(q.PageSize, maxPageSize)
+
+	query := us.db.Model(&User{})
+	if keyword := strings.TrimSpace(q.Query); keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("username LIKE ? OR email LIKE ?", like, like)
+	}
+	if q.Role != "" {
+		query = query.Where("id IN (?)", us.db.Model(&UserRole{}).Select("user_id").Where("role_name = ?", q.Role))
+	}
+	if q.Active != nil {
+		query = query.Where("active = ?", *q.Active)
+	}
+	if q.Locked {
+		query = query.Where("locked_until > ?", time.Now())
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var users []User
+	err := query.Order("created_at DESC").
+		Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 批量查询角色
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	var userRoles []UserRole
+	if len(ids) > 0 {
+		if err := us.db.Where("user_id IN ?", ids).Order("role_name ASC").Find(&userRoles).Error; err != nil {
+			return nil, err
+		}
+	}
+	roles := make(map[string][]string, len(users))
+	for _, ur := range userRoles {
+		roles[ur.UserID] = append(roles[ur.UserID], ur.RoleName)
+	}
+
+	list := &UserList{Items: []*UserResponse{}, Total: total, Page: q.Page, PageSize: q.PageSize}
+	for i := range users {
+		list.Items = append(list.Items, newUserResponse(&users[i], roles[users[i].ID]))
+	}
+	return list, nil
+}
+
+func (us *UserService) findUser(userID string) (*User, error) {
+	user, err := us.getUserByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("用户不存在")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// setUserActive 启用或停用用户，停用后立即吊销其所有令牌
+func (us *UserService) setUserActive(adminID, userID string, active bool) (*UserResponse, error) {
+	if adminID == userID && !active {
+		return nil, errors.New("不能停用自己的账号")
+	}
+	if !active {
+		if err := us.checkLastAdmin(us.db, userID); err != nil {
+			return nil, err
+		}
+	}
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Active = active
+	if err := us.updateUser(user); err != nil {
+		return nil, err
+	}
+	if !active {
+		if err := us.revokeAllTokens(userID); err != nil {
+			return nil, err
+		}
+	}
+	return us.toResponse(user)
+}
+
+// forcePasswordReset 重置用户密码并要求下次登录后修改，返回生成的临时密码
+func (us *UserService) forcePasswordReset(userID, password string) (string, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	generated := ""
+	if password == "" {
+		if generated, err = randomPassword(tempPasswordLength); err != nil {
+			return "", err
+		}
+		password = generated
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("密码加密失败")
+	}
+
+	user.Password = string(hashed)
+	user.MustChangePassword = true
+	if err := us.updateUser(user); err != nil {
+		return "", err
+	}
+	if err := us.revokeAllTokens(userID); err != nil {
+		return "", err
+	}
+	return generated, nil
+}
+
+// checkLastAdmin 确保移除该用户的管理员身份后系统中仍有启用的管理员
+func (us *UserService) checkLastAdmin(tx *gorm.DB, userID string) error {
+	roles, err := us.userRoles(userID)
+	if err != nil || !slices.Contains(roles, middleware.RoleAdmin) {
+		return err
+	}
+	var others int64
+	err = tx.Model(&UserRole{}).
+		Joins("JOIN users ON users.id = user_roles.user_id").
+		Where("user_roles.role_name = ? AND user_roles.user_id <> ? AND users.active = ?", middleware.RoleAdmin, userID, true).
+		Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return errors.New("系统中至少需要保留一个启用的管理员")
+	}
+	return nil
+}
+
+func randomPassword(n int) (string, error) {
+	buf := make([]byte, n)
+	limit := big.NewInt(int64(len(tempPasswordCharset)))
+	for i := range buf {
+		idx, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = tempPasswordCharset[idx.Int64()]
+	}
+	return string(buf), nil
+}
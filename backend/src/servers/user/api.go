@@ -1,12 +1,17 @@
 package user
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	logger "myapp/log"
 	"myapp/middleware"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
-
 	"github.com/gin-gonic/gin"
 )
 
@@ -23,9 +28,14 @@ func (us *UserService) RegisterUser(c *gin.Context) {
 	}
 
 	userResp, err := us.registerUser(&req)
+	us.audit(c, registerEvent(&req, userResp), err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    http.StatusInternalServerError,
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrRegistrationClosed) || errors.Is(err, ErrInviteRequired) || errors.Is(err, ErrInviteInvalid) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
 			"message": "注册用户失败",
 			"error":   err.Error(),
 		})
@@ -50,24 +60,83 @@ func (us *UserService) Login(c *gin.Context) {
 		})
 		return
 	}
-	userResp, err := us.login(&req)
+	userResp, err := us.login(&req, c.ClientIP())
+	us.audit(c, loginEvent(AuditLogin, req.Username, userResp), err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    http.StatusInternalServerError,
-			"message": "用户登录失败",
+		loginFailed(c, "用户登录失败", err)
+		return
+	}
+
+	// 开启两步验证的用户先返回挑战令牌，验证码通过后再签发令牌
+	if userResp.TwoFactorEnabled {
+		challenge, err := us.issueLoginChallenge(userResp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "生成Token失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "请输入两步验证码",
+			"data": gin.H{
+				"two_factor_required": true,
+				"challenge":           challenge,
+				"expires_in":          int64(loginChallengeTTL.Seconds()),
+			},
+		})
+		return
+	}
+	us.respondLogin(c, userResp)
+}
+
+// 登录第二步：提交挑战令牌和验证码（或恢复码）
+func (us *UserService) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
 			"error":   err.Error(),
 		})
 		return
 	}
+	userResp, err := us.loginWithSecondFactor(&req, c.ClientIP())
+	us.audit(c, loginEvent(AuditLogin2FA, "", userResp), err)
+	if err != nil {
+		loginFailed(c, "两步验证失败", err)
+		return
+	}
+	us.respondLogin(c, userResp)
+}
 
-	// 生成token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userResp.ID,
-		"iat":     time.Now().Unix(),
-		"exp":     time.Now().Add(time.Hour * 72).Unix(), // 72小时过期
+// loginFailed 按错误类型返回登录失败的状态码，锁定时通过 Retry-After 告知剩余时间
+func loginFailed(c *gin.Context, message string, err error) {
+	status := http.StatusUnauthorized
+	var locked *LockedError
+	switch {
+	case errors.As(err, &locked):
+		status = http.StatusTooManyRequests
+		c.Header("Retry-After", fmt.Sprint(int(time.Until(locked.Until).Seconds())+1))
+	case errors.Is(err, ErrEmailNotVerified), errors.Is(err, ErrUserInactive):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrChallengeInvalid),
+		errors.Is(err, ErrTOTPInvalid), errors.Is(err, ErrRecoveryCodeInvalid):
+	default:
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"message": message,
+		"error":   err.Error(),
 	})
+}
 
-	tokenStr, err := token.SignedString([]byte(middleware.JWTSecret))
+// respondLogin 签发访问令牌和刷新令牌，返回登录结果
+func (us *UserService) respondLogin(c *gin.Context, userResp *UserResponse) {
+	tokens, err := us.issueTokens(us.db, userResp.ID, "", clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    http.StatusInternalServerError,
@@ -80,12 +149,116 @@ func (us *UserService) Login(c *gin.Context) {
 		"code":    http.StatusOK,
 		"message": "用户登录成功",
 		"data": gin.H{
-			"user":  userResp,
-			"token": tokenStr,
+			"user":          userResp,
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    tokens.ExpiresIn,
 		},
 	})
 }
 
+func clientInfo(c *gin.Context) *ClientInfo {
+	return &ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
+// 使用刷新令牌换取新的访问令牌，刷新令牌同时轮换
+func (us *UserService) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tokens, err := us.refresh(req.RefreshToken, clientInfo(c))
+	var reused *refreshReusedError
+	if errors.As(err, &reused) {
+		us.audit(c, &AuditEvent{
+			Action:     AuditRefreshReuse,
+			TargetType: TargetSession,
+			TargetID:   reused.SessionID,
+			TargetName: us.usernameOf(reused.UserID),
+			Detail:     gin.H{"user_id": reused.UserID},
+		}, err)
+	}
+	if err != nil {
+		status := http.StatusUnauthorized
+		if !errors.Is(err, ErrRefreshInvalid) && !errors.Is(err, ErrRefreshExpired) && !errors.Is(err, ErrRefreshReused) {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"message": "刷新令牌失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "刷新成功",
+		"data":    tokens,
+	})
+}
+
+// 退出当前会话：吊销当前访问令牌和所属的刷新令牌族
+func (us *UserService) Logout(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if jti := c.GetString("token_id"); jti != "" {
+		expiresAt := c.GetTime("token_expires_at")
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(us.accessTokenTTL())
+		}
+		if err := us.revokeAccessToken(jti, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "退出登录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	if sid := c.GetString("session_id"); sid != "" {
+		if err := us.revokeSession(userID, sid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "退出登录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	us.audit(c, &AuditEvent{Action: AuditLogout, TargetType: TargetSession, TargetID: c.GetString("session_id")}, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已退出登录",
+	})
+}
+
+// 退出所有设备：吊销该用户此前签发的全部令牌
+func (us *UserService) LogoutAll(c *gin.Context) {
+	err := us.revokeAllTokens(c.GetString("user_id"))
+	us.audit(c, &AuditEvent{Action: AuditLogoutAll}, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "退出所有设备失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已退出所有设备",
+	})
+}
+
 func (us *UserService) GetUserProfile(c *gin.Context) {
 	// Implementation for getting user profile
 	userID := c.GetString("user_id")
@@ -97,7 +270,16 @@ func (us *UserService) GetUserProfile(c *gin.Context) {
 		return
 	}
 
-	userResp, err := us.getUserByID(userID)
+	user, err := us.getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取用户信息失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.toResponse(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    http.StatusInternalServerError,
@@ -113,3 +295,1119 @@ func (us *UserService) GetUserProfile(c *gin.Context) {
 		"data":    userResp,
 	})
 }
+
+// 获取所有可分配的权限
+func (us *UserService) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    middleware.Permissions,
+	})
+}
+
+func (us *UserService) GetRoles(c *gin.Context) {
+	roles, err := us.listRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取角色失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    roles,
+	})
+}
+
+func (us *UserService) CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	role, err := us.createRole(&req)
+	us.audit(c, &AuditEvent{Action: AuditRoleCreate, TargetType: TargetRole, TargetID: req.Name, Detail: gin.H{"permissions": req.Permissions}}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "创建角色失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "创建角色成功",
+		"data":    role,
+	})
+}
+
+func (us *UserService) UpdateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	role, err := us.updateRole(c.Param("name"), &req)
+	us.audit(c, &AuditEvent{Action: AuditRoleUpdate, TargetType: TargetRole, TargetID: c.Param("name"), Detail: gin.H{"permissions": req.Permissions}}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "修改角色失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "修改角色成功",
+		"data":    role,
+	})
+}
+
+func (us *UserService) DeleteRole(c *gin.Context) {
+	err := us.deleteRole(c.Param("name"))
+	us.audit(c, &AuditEvent{Action: AuditRoleDelete, TargetType: TargetRole, TargetID: c.Param("name")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "删除角色失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "删除角色成功",
+	})
+}
+
+// 替换用户的角色
+func (us *UserService) SetUserRoles(c *gin.Context) {
+	var req struct {
+		Roles []string `json:"roles" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	roles, err := us.setUserRoles(c.Param("id"), req.Roles)
+	us.audit(c, &AuditEvent{Action: AuditUserRoles, TargetType: TargetUser, TargetID: c.Param("id"), Detail: gin.H{"roles": req.Roles}}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "分配角色失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "分配角色成功",
+		"data":    roles,
+	})
+}
+
+// 分页查询用户，支持按用户名/邮箱搜索、按角色和启用状态筛选
+func (us *UserService) ListUsers(c *gin.Context) {
+	var query UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的查询参数",
+			"error":   err.Error(),
+		})
+		return
+	}
+	list, err := us.listUsers(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取用户列表失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    list,
+	})
+}
+
+func (us *UserService) GetUser(c *gin.Context) {
+	user, err := us.findUser(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "获取用户信息失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.toResponse(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取用户信息失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取用户信息成功",
+		"data":    userResp,
+	})
+}
+
+// 启用或停用用户
+func (us *UserService) SetUserActive(c *gin.Context) {
+	var req struct {
+		Active *bool `json:"active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.setUserActive(c.GetString("user_id"), c.Param("id"), *req.Active)
+	us.audit(c, &AuditEvent{Action: AuditUserActive, TargetType: TargetUser, TargetID: c.Param("id"), Detail: gin.H{"active": *req.Active}}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "修改用户状态失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "修改用户状态成功",
+		"data":    userResp,
+	})
+}
+
+// 强制重置密码，未指定新密码时返回生成的临时密码
+func (us *UserService) ResetUserPassword(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	tempPassword, err := us.forcePasswordReset(c.Param("id"), req.Password)
+	us.audit(c, &AuditEvent{Action: AuditUserPassword, TargetType: TargetUser, TargetID: c.Param("id"), Detail: gin.H{"generated": req.Password == ""}}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "重置密码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "重置密码成功，用户需在下次登录后修改密码",
+		"data": gin.H{
+			"temporary_password": tempPassword,
+		},
+	})
+}
+
+// 删除用户及其相关数据
+func (us *UserService) DeleteUser(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "删除用户失败",
+			"error":   "不能删除自己的账号",
+		})
+		return
+	}
+	user, err := us.findUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "删除用户失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// 删除后无法再查到用户名，提前记下
+	err = us.deleteUserByID(userID)
+	us.audit(c, &AuditEvent{Action: AuditUserDelete, TargetType: TargetUser, TargetID: userID, TargetName: user.Username}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "删除用户失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "删除用户成功",
+	})
+}
+
+// 修改用户名和邮箱
+func (us *UserService) UpdateProfile(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.updateProfile(c.GetString("user_id"), &req)
+	us.audit(c, &AuditEvent{Action: AuditProfileUpdate, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "修改用户信息失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "修改用户信息成功",
+		"data":    userResp,
+	})
+}
+
+// 修改密码，其他会话全部失效，当前客户端获得新的令牌
+func (us *UserService) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userID := c.GetString("user_id")
+	err := us.changePassword(userID, &req)
+	us.audit(c, &AuditEvent{Action: AuditPasswordChange, TargetType: TargetUser, TargetID: userID}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "修改密码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tokens, err := us.issueTokens(us.db, userID, "", clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "生成Token失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "修改密码成功",
+		"data":    tokens,
+	})
+}
+
+// 上传头像，表单字段为 avatar
+func (us *UserService) UploadAvatar(c *gin.Context) {
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请选择头像文件",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.saveAvatar(c.GetString("user_id"), file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "上传头像失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "上传头像成功",
+		"data":    userResp,
+	})
+}
+
+// 获取用户头像，无需登录
+func (us *UserService) GetAvatar(c *gin.Context) {
+	path, ok := us.avatarPath(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "头像不存在",
+		})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(path)
+}
+
+// 注销账号，宽限期后删除账号及其数据
+func (us *UserService) DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请输入密码确认",
+			"error":   err.Error(),
+		})
+		return
+	}
+	at, err := us.scheduleDeletion(c.GetString("user_id"), req.Password)
+	us.audit(c, &AuditEvent{Action: AuditDeleteRequest, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "注销账号失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已申请注销，账号将在宽限期后删除，期间重新登录可以撤销",
+		"data": gin.H{
+			"deletion_scheduled_at": at.Unix(),
+		},
+	})
+}
+
+// 撤销注销
+func (us *UserService) CancelDeletion(c *gin.Context) {
+	err := us.cancelDeletion(c.GetString("user_id"))
+	us.audit(c, &AuditEvent{Action: AuditDeleteCancel, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "撤销注销失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已撤销注销",
+	})
+}
+
+// 向当前邮箱发送验证邮件
+func (us *UserService) SendVerification(c *gin.Context) {
+	if err := us.sendVerification(c.GetString("user_id")); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrMailCooldown) {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"message": "发送验证邮件失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "验证邮件已发送",
+	})
+}
+
+// 未登录时按邮箱重新发送验证邮件，无论邮箱是否注册都返回成功
+func (us *UserService) ResendVerification(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := us.resendVerification(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "发送验证邮件失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "如果该邮箱已注册且未验证，验证邮件将很快送达",
+	})
+}
+
+// 使用邮件中的令牌验证邮箱
+func (us *UserService) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userResp, err := us.verifyEmail(req.Token)
+	event := &AuditEvent{Action: AuditEmailVerify}
+	if userResp != nil {
+		event.TargetType, event.TargetID = TargetUser, userResp.ID
+	}
+	us.audit(c, event, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "邮箱验证失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "邮箱验证成功",
+		"data":    userResp,
+	})
+}
+
+// 忘记密码，无论邮箱是否注册都返回成功
+func (us *UserService) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := us.requestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "发送重置密码邮件失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "如果该邮箱已注册，重置密码邮件将很快送达",
+	})
+}
+
+// 使用邮件中的令牌重置密码，所有会话随即失效
+func (us *UserService) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	userID, err := us.resetPassword(&req)
+	event := &AuditEvent{Action: AuditPasswordReset}
+	if userID != "" {
+		event.TargetType, event.TargetID = TargetUser, userID
+	}
+	us.audit(c, event, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "重置密码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "密码已重置，请重新登录",
+	})
+}
+
+// 生成两步验证密钥，返回 otpauth 链接
+func (us *UserService) SetupTwoFactor(c *gin.Context) {
+	setup, err := us.setupTOTP(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "获取两步验证密钥失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "请使用验证器应用扫描二维码或手动输入密钥，然后提交验证码",
+		"data":    setup,
+	})
+}
+
+// 提交验证码开启两步验证，返回恢复码（只显示一次）
+func (us *UserService) EnableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	codes, err := us.enableTOTP(c.GetString("user_id"), req.Code)
+	us.audit(c, &AuditEvent{Action: AuditTOTPEnable, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "开启两步验证失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "两步验证已开启，请妥善保存恢复码",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// 关闭两步验证
+func (us *UserService) DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	err := us.disableTOTP(c.GetString("user_id"), &req)
+	us.audit(c, &AuditEvent{Action: AuditTOTPDisable, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "关闭两步验证失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "两步验证已关闭",
+	})
+}
+
+// 查询剩余恢复码数量
+func (us *UserService) GetRecoveryCodes(c *gin.Context) {
+	remaining, err := us.remainingRecoveryCodes(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取恢复码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data": gin.H{
+			"remaining": remaining,
+		},
+	})
+}
+
+// 重新生成恢复码，旧的恢复码全部失效
+func (us *UserService) RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	codes, err := us.regenerateRecoveryCodes(c.GetString("user_id"), req.Code)
+	us.audit(c, &AuditEvent{Action: AuditRecoveryCodes, TargetType: TargetUser, TargetID: c.GetString("user_id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "生成恢复码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已生成新的恢复码，请妥善保存",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// 管理员重置用户的两步验证，用户丢失设备和恢复码时使用
+func (us *UserService) ResetUserTwoFactor(c *gin.Context) {
+	err := us.adminResetTOTP(c.Param("id"))
+	us.audit(c, &AuditEvent{Action: AuditUser2FAReset, TargetType: TargetUser, TargetID: c.Param("id")}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "重置两步验证失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已重置两步验证",
+	})
+}
+
+// OIDC 登录配置，前端据此显示登录按钮
+func (us *UserService) GetOIDCConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data": gin.H{
+			"enabled": us.oidc != nil,
+			"name":    us.cfg.OIDC.Name,
+		},
+	})
+}
+
+// 跳转到身份提供方登录，redirect 为登录后前端跳转的页面
+func (us *UserService) OIDCLogin(c *gin.Context) {
+	if us.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "OIDC 登录失败",
+			"error":   ErrOIDCDisabled.Error(),
+		})
+		return
+	}
+	// 只允许站内路径，防止开放重定向
+	redirect := c.Query("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = "/"
+	}
+	// 邀请模式下首次登录自动创建用户需要邀请码
+	authURL, state, err := us.oidc.authURL(c.Request.Context(), redirect, c.Query("invite"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"code":    http.StatusBadGateway,
+			"message": "OIDC 登录失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// state 同时写入 Cookie，回调时只接受发起登录的浏览器；回调是跨站跳转，需要 Lax
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), us.rg.BasePath()+"/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// 身份提供方回调：校验后签发令牌，通过 URL 片段交给前端，片段不会发送到服务器
+func (us *UserService) OIDCCallback(c *gin.Context) {
+	appURL := strings.TrimRight(us.cfg.AppURL, "/")
+	fail := func(err error) {
+		c.Redirect(http.StatusFound, appURL+"/user/login?oidc_error="+url.QueryEscape(err.Error()))
+	}
+	if us.oidc == nil {
+		fail(ErrOIDCDisabled)
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		fail(errors.New("身份提供方拒绝了登录: " + errCode + " " + c.Query("error_description")))
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, us.rg.BasePath()+"/oidc", "", c.Request.TLS != nil, true)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		fail(errors.New("登录请求与当前浏览器不匹配，请重试"))
+		return
+	}
+	st, ok := us.oidc.takeState(state)
+	if !ok {
+		fail(errors.New("登录请求无效或已过期，请重试"))
+		return
+	}
+	claims, err := us.oidc.exchange(c.Request.Context(), c.Query("code"), st)
+	if err != nil {
+		logger.ZError(&us.ctx, "OIDC 登录失败", err)
+		fail(err)
+		return
+	}
+	userResp, err := us.oidcLogin(claims, st.invite)
+	event := loginEvent(AuditOIDCLogin, claims.PreferredUsername, userResp)
+	event.Detail = gin.H{"issuer": us.cfg.OIDC.Issuer, "subject": claims.Subject}
+	us.audit(c, event, err)
+	if err != nil {
+		fail(err)
+		return
+	}
+	tokens, err := us.issueTokens(us.db, userResp.ID, "", clientInfo(c))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	fragment := url.Values{}
+	fragment.Set("token", tokens.AccessToken)
+	fragment.Set("refresh_token", tokens.RefreshToken)
+	fragment.Set("expires_in", fmt.Sprint(tokens.ExpiresIn))
+	fragment.Set("redirect", st.redirect)
+	c.Redirect(http.StatusFound, appURL+"/user/oidc/callback#"+fragment.Encode())
+}
+
+// 可分配给 API 密钥的范围及说明
+func (us *UserService) GetAPIKeyScopes(c *gin.Context) {
+	scopes := map[string]string{
+		middleware.ScopeRead:  "只读访问",
+		middleware.ScopeWrite: "读写访问",
+	}
+	for perm, desc := range middleware.Permissions {
+		scopes[perm] = desc
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    scopes,
+	})
+}
+
+func (us *UserService) ListAPIKeys(c *gin.Context) {
+	keys, err := us.listAPIKeys(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取 API 密钥失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    keys,
+	})
+}
+
+// 创建 API 密钥，明文只在此时返回一次
+func (us *UserService) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	key, err := us.createAPIKey(c.GetString("user_id"), &req)
+	event := &AuditEvent{Action: AuditAPIKeyCreate, TargetType: TargetAPIKey, TargetName: req.Name, Detail: gin.H{"scopes": req.Scopes}}
+	if key != nil {
+		event.TargetID = key.ID
+	}
+	us.audit(c, event, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "创建 API 密钥失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "创建成功，请立即保存密钥，之后将无法再次查看",
+		"data":    key,
+	})
+}
+
+func (us *UserService) RevokeAPIKey(c *gin.Context) {
+	err := us.revokeAPIKey(c.GetString("user_id"), c.Param("id"))
+	us.audit(c, &AuditEvent{Action: AuditAPIKeyRevoke, TargetType: TargetAPIKey, TargetID: c.Param("id")}, err)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "吊销 API 密钥失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "API 密钥已吊销",
+	})
+}
+
+// 当前被临时封禁的 IP
+func (us *UserService) ListBlockedIPs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    us.blockedIPs(),
+	})
+}
+
+// 解除 IP 封禁
+func (us *UserService) UnblockIP(c *gin.Context) {
+	err := us.unblockIP(c.Param("ip"))
+	us.audit(c, &AuditEvent{Action: AuditIPUnblock, TargetType: TargetIP, TargetID: c.Param("ip")}, err)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "解除封禁失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已解除封禁",
+	})
+}
+
+// 解除账号的登录锁定
+func (us *UserService) UnlockUser(c *gin.Context) {
+	userResp, err := us.unlockUser(c.Param("id"))
+	us.audit(c, &AuditEvent{Action: AuditUserUnlock, TargetType: TargetUser, TargetID: c.Param("id")}, err)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "解除锁定失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已解除锁定",
+		"data":    userResp,
+	})
+}
+
+// 当前用户的登录会话
+func (us *UserService) ListSessions(c *gin.Context) {
+	sessions, err := us.listSessions(c.GetString("user_id"), c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取登录会话失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    sessions,
+	})
+}
+
+// 退出指定会话，可以是当前会话
+func (us *UserService) RevokeSession(c *gin.Context) {
+	err := us.revokeUserSession(c.GetString("user_id"), c.Param("id"))
+	us.audit(c, &AuditEvent{Action: AuditSessionRevoke, TargetType: TargetSession, TargetID: c.Param("id")}, err)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "退出会话失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "已退出该会话",
+	})
+}
+
+// 注册策略，注册页面据此决定是否显示邀请码输入框
+func (us *UserService) GetRegistrationConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    us.registrationConfig(),
+	})
+}
+
+// 邀请码列表
+func (us *UserService) ListInvites(c *gin.Context) {
+	invites, err := us.listInvites()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取邀请码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    invites,
+	})
+}
+
+// 创建邀请码，明文只在此时返回
+func (us *UserService) CreateInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的请求数据",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// 指定角色相当于分配角色，需要角色管理权限
+	if req.Role != "" && !middleware.Can(c, middleware.PermRolesManage) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "创建邀请码失败",
+			"error":   "没有分配角色的权限",
+		})
+		return
+	}
+	invite, err := us.createInvite(c.GetString("user_id"), middleware.CurrentRoles(c), &req)
+	event := &AuditEvent{Action: AuditInviteCreate, TargetType: TargetInvite, TargetName: req.Note, Detail: gin.H{"role": req.Role, "max_uses": req.MaxUses}}
+	if invite != nil {
+		event.TargetID = invite.ID
+	}
+	us.audit(c, event, err)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrInviteRoleDenied) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"message": "创建邀请码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "邀请码已创建",
+		"data":    invite,
+	})
+}
+
+// 作废邀请码
+func (us *UserService) RevokeInvite(c *gin.Context) {
+	err := us.revokeInvite(c.Param("id"))
+	us.audit(c, &AuditEvent{Action: AuditInviteRevoke, TargetType: TargetInvite, TargetID: c.Param("id")}, err)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "作废邀请码失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "邀请码已作废",
+	})
+}
+
+// 查询审计日志
+func (us *UserService) ListAuditEvents(c *gin.Context) {
+	var query AuditQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的查询参数",
+			"error":   err.Error(),
+		})
+		return
+	}
+	list, err := us.listAuditEvents(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "获取审计日志失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    http.StatusOK,
+		"message": "获取成功",
+		"data":    list,
+	})
+}
+
+// 按查询条件导出审计日志，每行一个 JSON 对象，不分页
+func (us *UserService) ExportAuditEvents(c *gin.Context) {
+	var query AuditQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "无效的查询参数",
+			"error":   err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+	// 已经开始输出，出错时只能记录日志
+	err := us.exportAuditEvents(&query, c.Writer)
+	if err != nil {
+		logger.ZError(&us.ctx, "导出审计日志失败", err)
+	}
+	us.audit(c, &AuditEvent{Action: AuditExport, Detail: gin.H{"query": c.Request.URL.RawQuery}}, err)
+}
@@ -0,0 +1,148 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"myapp/middleware"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeysPerUser = 50
+	apiKeyTouchPeriod = time.Minute // 最近使用时间的更新间隔，避免每个请求都写数据库
+)
+
+// APIKey 个人 API 密钥，只保存哈希，明文仅在创建时返回一次
+type APIKey struct {
+	ID         string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	UserID     string     `gorm:"type:varchar(255);not null;index" json:"-"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16);not null" json:"prefix"` // 密钥开头几位，便于用户辨认
+	KeyHash    string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"type:varchar(64)" json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required,max=100"`
+	Scopes    []string `json:"scopes" binding:"required"`
+	ExpiresIn int      `json:"expires_in_days"` // 有效天数，0 表示永不过期
+}
+
+// CreatedAPIKey 创建密钥的返回值，Key 只在此时返回
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey 创建 API 密钥
+func (us *UserService) createAPIKey(userID string, req *CreateAPIKeyRequest) (*CreatedAPIKey, error) {
+	scopes := uniqueStrings(req.Scopes)
+	if len(scopes) == 0 {
+		return nil, errors.New("至少需要一个范围")
+	}
+	for _, scope := range scopes {
+		if !middleware.ValidScope(scope) {
+			return nil, errors.New("未知的范围: " + scope)
+		}
+	}
+	if req.ExpiresIn < 0 {
+		return nil, errors.New("有效期不能为负数")
+	}
+
+	var count int64
+	if err := us.db.Model(&APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, errors.New("API 密钥数量已达上限")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	key := middleware.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	apiKey := &APIKey{
+		ID:      uuid.New().String(),
+		UserID:  userID,
+		Name:    strings.TrimSpace(req.Name),
+		Prefix:  key[:len(middleware.APIKeyPrefix)+6],
+		KeyHash: hashAPIKey(key),
+		Scopes:  scopes,
+	}
+	if req.ExpiresIn > 0 {
+		at := time.Now().Add(time.Duration(req.ExpiresIn) * 24 * time.Hour)
+		apiKey.ExpiresAt = &at
+	}
+	if err := us.db.Create(apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+func (us *UserService) listAPIKeys(userID string) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := us.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// revokeAPIKey 吊销 API 密钥，立即生效
+func (us *UserService) revokeAPIKey(userID, keyID string) error {
+	result := us.db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("API 密钥不存在")
+	}
+	return nil
+}
+
+// validateAPIKey 供认证中间件调用：密钥未吊销、未过期且所属用户已启用
+func (us *UserService) validateAPIKey(key, ip string) (*middleware.APIKeyInfo, bool) {
+	var apiKey APIKey
+	if err := us.db.Where("key_hash = ? AND revoked_at IS NULL", hashAPIKey(key)).First(&apiKey).Error; err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, false
+	}
+	user, err := us.getUserByID(apiKey.UserID)
+	if err != nil || !user.Active || user.DeletionScheduledAt != nil || user.MustChangePassword {
+		return nil, false
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchPeriod {
+		err := us.db.Model(&APIKey{}).Where("id = ?", apiKey.ID).
+			Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": truncate(ip, 64)}).Error
+		if err != nil {
+			log.Printf("更新 API 密钥使用时间失败: %v", err)
+		}
+	}
+	return &middleware.APIKeyInfo{
+		ID:        apiKey.ID,
+		UserID:    apiKey.UserID,
+		Scopes:    apiKey.Scopes,
+		ExpiresAt: apiKey.ExpiresAt,
+	}, true
+}
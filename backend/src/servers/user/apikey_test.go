@@ -0,0 +1,70 @@
+package user
+
+import (
+	"myapp/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+var apiKeyColumns = []string{"id", "user_id", "key_hash", "scopes", "expires_at", "last_used_at"}
+
+// 过期、已吊销或所属用户被停用的密钥不能通过认证，吊销后立即生效
+func TestValidateAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := middleware.APIKeyPrefix + "test-key"
+	recent := time.Now().Add(-time.Second)
+	expired := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	cases := []struct {
+		name    string
+		key     *sqlmock.Rows // nil 表示已吊销，查询条件排除了吊销的密钥
+		active  bool
+		want    int
+		touched bool
+	}{
+		{"有效", sqlmock.NewRows(apiKeyColumns).AddRow("k1", "u1", hashAPIKey(key), `["read"]`, future, recent), true, http.StatusOK, false},
+		{"更新最近使用时间", sqlmock.NewRows(apiKeyColumns).AddRow("k1", "u1", hashAPIKey(key), `["read"]`, nil, nil), true, http.StatusOK, true},
+		{"已过期", sqlmock.NewRows(apiKeyColumns).AddRow("k1", "u1", hashAPIKey(key), `["read"]`, expired, recent), true, http.StatusUnauthorized, false},
+		{"已吊销", nil, true, http.StatusUnauthorized, false},
+		{"用户已停用", sqlmock.NewRows(apiKeyColumns).AddRow("k1", "u1", hashAPIKey(key), `["read"]`, nil, recent), false, http.StatusUnauthorized, false},
+	}
+	for _, tc := range cases {
+		us, mock := newTestService(t, nil)
+		middleware.ValidateAPIKey = us.validateAPIKey
+
+		query := mock.ExpectQuery("SELECT \\* FROM `api_keys` WHERE key_hash = \\? AND revoked_at IS NULL").
+			WithArgs(hashAPIKey(key), 1)
+		if tc.key == nil {
+			query.WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+		} else {
+			query.WillReturnRows(tc.key)
+		}
+		if tc.key != nil && tc.name != "已过期" {
+			mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "active"}).AddRow("u1", "alice", tc.active))
+		}
+		if tc.touched {
+			expectExec(mock, "UPDATE `api_keys` SET `last_used_at`=\\?,`last_used_ip`=\\? WHERE id = \\?", sqlmock.NewResult(0, 1))
+		}
+
+		r := gin.New()
+		r.GET("/music/songs", middleware.AuthMiddleware(), func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetString("user_id"))
+		})
+		req := httptest.NewRequest(http.MethodGet, "/music/songs", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want || (tc.want == http.StatusOK && w.Body.String() != "u1") {
+			t.Errorf("%s: %d %s", tc.name, w.Code, w.Body)
+		}
+		checkExpectations(t, mock)
+	}
+	middleware.ValidateAPIKey = nil
+}
@@ -0,0 +1,274 @@
+package user
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"myapp/middleware"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 安全审计日志
+// 记录登录、账号安全设置和管理操作，由接口层在调用服务方法后写入，成功和失败都会记录。
+// 审计事件不随用户删除，操作者和目标的名称在写入时保存一份，删除后仍然可以辨认。
+// 音乐、标签和 MPD 等服务通过 middleware.Audit 写入，事件类型定义在 middleware 中。
+
+// 审计事件类型
+const (
+	AuditRegister     = "auth.register"
+	AuditLogin        = "auth.login"
+	AuditLogin2FA     = "auth.login_2fa"
+	AuditOIDCLogin    = "auth.oidc_login"
+	AuditRefreshReuse = "auth.refresh_reuse" // 刷新令牌被重复使用，会话已吊销
+	AuditLogout       = "auth.logout"
+	AuditLogoutAll    = "auth.logout_all"
+
+	AuditPasswordChange = "account.password_change"
+	AuditPasswordReset  = "account.password_reset" // 通过邮件重置密码
+	AuditEmailVerify    = "account.email_verify"
+	AuditProfileUpdate  = "account.profile_update"
+	AuditDeleteRequest  = "account.delete_request"
+	AuditDeleteCancel   = "account.delete_cancel"
+	AuditAccountPurge   = "account.purge" // 注销宽限期结束后删除账号
+	AuditTOTPEnable     = "account.2fa_enable"
+	AuditTOTPDisable    = "account.2fa_disable"
+	AuditRecoveryCodes  = "account.recovery_codes"
+	AuditAPIKeyCreate   = "account.api_key_create"
+	AuditAPIKeyRevoke   = "account.api_key_revoke"
+	AuditSessionRevoke  = "account.session_revoke"
+
+	AuditUserActive   = "admin.user_active"
+	AuditUserPassword = "admin.user_password_reset"
+	AuditUserUnlock   = "admin.user_unlock"
+	AuditUser2FAReset = "admin.user_2fa_reset"
+	AuditUserDelete   = "admin.user_delete"
+	AuditUserRoles    = "admin.user_roles"
+	AuditRoleCreate   = "admin.role_create"
+	AuditRoleUpdate   = "admin.role_update"
+	AuditRoleDelete   = "admin.role_delete"
+	AuditIPUnblock    = "admin.ip_unblock"
+	AuditInviteCreate = "admin.invite_create"
+	AuditInviteRevoke = "admin.invite_revoke"
+	AuditExport       = "admin.audit_export"
+)
+
+// 审计目标类型
+const (
+	TargetUser    = middleware.AuditTargetUser
+	TargetRole    = "role"
+	TargetAPIKey  = "api_key"
+	TargetSession = "session"
+	TargetInvite  = "invite"
+	TargetIP      = "ip"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+
+	auditExportBatch = 500
+)
+
+// AuditEvent 审计事件
+type AuditEvent struct {
+	ID         uint                   `gorm:"primaryKey" json:"id"`
+	ActorID    string                 `gorm:"type:varchar(255);index" json:"actor_id"` // 操作者，未登录时为空
+	ActorName  string                 `gorm:"type:varchar(255)" json:"actor_name"`
+	Action     string                 `gorm:"type:varchar(64);not null;index" json:"action"`
+	TargetType string                 `gorm:"type:varchar(32);index:idx_audit_target" json:"target_type"`
+	TargetID   string                 `gorm:"type:varchar(255);index:idx_audit_target" json:"target_id"`
+	TargetName string                 `gorm:"type:varchar(255)" json:"target_name"`
+	IP         string                 `gorm:"type:varchar(64);index" json:"ip"`
+	UserAgent  string                 `gorm:"type:varchar(512)" json:"user_agent"`
+	Result     string                 `gorm:"type:varchar(16);not null;index" json:"result"`
+	Error      string                 `gorm:"type:varchar(255)" json:"error,omitempty"`
+	Detail     map[string]interface{} `gorm:"serializer:json;type:text" json:"detail,omitempty"`
+	CreatedAt  time.Time              `gorm:"not null;index" json:"created_at"`
+}
+
+type AuditQuery struct {
+	Actor      string    `form:"actor"`  // 操作者 ID 或名称
+	Action     string    `form:"action"` // 事件类型，以 . 结尾时按前缀匹配，如 admin.
+	TargetType string    `form:"target_type"`
+	Target     string    `form:"target"` // 目标 ID 或名称
+	IP         string    `form:"ip"`
+	Result     string    `form:"result"` // success 或 failure
+	From       time.Time `form:"from"`   // RFC 3339 格式
+	To         time.Time `form:"to"`
+	Page       int       `form:"page"`
+	PageSize   int       `form:"page_size"`
+}
+
+type AuditList struct {
+	Items    []AuditEvent `json:"items"`
+	Total    int64        `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// audit 补全操作者、客户端和结果后写入审计事件，写入失败只记录日志，不影响请求；
+// c 为 nil 时表示后台任务产生的事件，没有操作者和客户端信息
+func (us *UserService) audit(c *gin.Context, event *AuditEvent, err error) {
+	if event.ActorID == "" && c != nil {
+		event.ActorID = c.GetString("user_id")
+	}
+	if event.ActorName == "" && event.ActorID != "" {
+		event.ActorName = us.usernameOf(event.ActorID)
+	}
+	if event.TargetName == "" && event.TargetType == TargetUser && event.TargetID != "" {
+		event.TargetName = us.usernameOf(event.TargetID)
+	}
+	if event.IP == "" && c != nil {
+		event.IP = truncate(c.ClientIP(), 64)
+	}
+	if c != nil {
+		if keyID := c.GetString("api_key_id"); keyID != "" {
+			if event.Detail == nil {
+				event.Detail = map[string]interface{}{}
+			}
+			event.Detail["api_key_id"] = keyID
+		}
+		event.UserAgent = truncate(c.Request.UserAgent(), 512)
+	}
+	event.Result = AuditSuccess
+	if err != nil {
+		event.Result = AuditFailure
+		event.Error = truncate(err.Error(), 255)
+	}
+	event.CreatedAt = time.Now()
+	if err := us.db.Create(event).Error; err != nil {
+		log.Printf("写入审计日志失败（%s）: %v", event.Action, err)
+	}
+}
+
+// recordAudit 写入其他服务提交的审计事件，设置为 middleware.Audit
+func (us *UserService) recordAudit(c *gin.Context, record *middleware.AuditRecord, err error) {
+	us.audit(c, &AuditEvent{
+		ActorID:    record.ActorID,
+		Action:     record.Action,
+		TargetType: record.TargetType,
+		TargetID:   record.TargetID,
+		TargetName: record.TargetName,
+		IP:         truncate(record.IP, 64),
+		Detail:     record.Detail,
+	}, err)
+}
+
+// usernameOf 查询用户名，用户不存在时返回空
+func (us *UserService) usernameOf(userID string) string {
+	var names []string
+	us.db.Model(&User{}).Where("id = ?", userID).Limit(1).Pluck("username", &names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+func (us *UserService) auditFilter(q *AuditQuery) *gorm.DB {
+	query := us.db.Model(&AuditEvent{})
+	if actor := strings.TrimSpace(q.Actor); actor != "" {
+		query = query.Where("actor_id = ? OR actor_name = ?", actor, actor)
+	}
+	if action := strings.TrimSpace(q.Action); strings.HasSuffix(action, ".") {
+		query = query.Where("action LIKE ?", action+"%")
+	} else if action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if q.TargetType != "" {
+		query = query.Where("target_type = ?", q.TargetType)
+	}
+	if target := strings.TrimSpace(q.Target); target != "" {
+		query = query.Where("target_id = ? OR target_name = ?", target, target)
+	}
+	if q.IP != "" {
+		query = query.Where("ip = ?", q.IP)
+	}
+	if q.Result != "" {
+		query = query.Where("result = ?", q.Result)
+	}
+	if !q.From.IsZero() {
+		query = query.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("created_at < ?", q.To)
+	}
+	return query
+}
+
+// listAuditEvents 分页查询审计事件，最新的在前
+func (us *UserService) listAuditEvents(q *AuditQuery) (*AuditList, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = defaultPageSize
+	}
+	q.PageSize = min(q.PageSize, maxPageSize)
+
+	query := us.auditFilter(q)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	list := &AuditList{Items: []AuditEvent{}, Total: total, Page: q.Page, PageSize: q.PageSize}
+	err := query.Order("id DESC").
+		Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).
+		Find(&list.Items).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// exportAuditEvents 按时间顺序逐批写出符合条件的审计事件，每行一个 JSON 对象
+func (us *UserService) exportAuditEvents(q *AuditQuery, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var events []AuditEvent
+	return us.auditFilter(q).FindInBatches(&events, auditExportBatch, func(tx *gorm.DB, batch int) error {
+		for i := range events {
+			if err := enc.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// purgeAuditEvents 删除超过保留期限的审计事件，未配置保留期限时永久保留
+func (us *UserService) purgeAuditEvents() {
+	if us.cfg.AuditRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-us.cfg.AuditRetention)
+	if err := us.db.Where("created_at < ?", cutoff).Delete(&AuditEvent{}).Error; err != nil {
+		log.Printf("清理审计日志失败: %v", err)
+	}
+}
+
+// loginEvent 登录事件，成功时操作者和目标都是登录的用户，失败时只记录提交的用户名
+func loginEvent(action, username string, userResp *UserResponse) *AuditEvent {
+	event := &AuditEvent{Action: action, TargetType: TargetUser, TargetName: username}
+	if userResp != nil {
+		event.ActorID = userResp.ID
+		event.ActorName = userResp.Username
+		event.TargetID = userResp.ID
+		event.TargetName = userResp.Username
+		if action == AuditLogin && userResp.TwoFactorEnabled {
+			event.Detail = map[string]interface{}{"two_factor_required": true}
+		}
+	}
+	return event
+}
+
+// registerEvent 注册事件，使用邀请码时记录邀请码前缀
+func registerEvent(req *RegisterRequest, userResp *UserResponse) *AuditEvent {
+	event := loginEvent(AuditRegister, req.Username, userResp)
+	if code := normalizeInviteCode(req.InviteCode); code != "" {
+		event.Detail = map[string]interface{}{"invite": code[:min(4, len(code))]}
+	}
+	return event
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"myapp/middleware"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// expectAuditInsert 写入审计事件，返回写入的列和参数
+func expectAuditInsert(t *testing.T, mock sqlmock.Sqlmock) func(column string) driver.Value {
+	columns := modelColumns(t, &AuditEvent{}, false)
+	values := []driver.Value{}
+	expectExecArgs(mock, "INSERT INTO `audit_events`", recordArgs(&values, len(columns)))
+	return func(column string) driver.Value {
+		t.Helper()
+		i := slices.Index(columns, column)
+		if i < 0 || i >= len(values) {
+			t.Fatalf("审计事件中没有 %s 列: %v", column, values)
+		}
+		return values[i]
+	}
+}
+
+func expectExecArgs(mock sqlmock.Sqlmock, query string, args []driver.Value) {
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+// 其他服务通过 middleware.RecordAudit 写入的事件由用户服务补全操作者和客户端信息
+func TestRecordAuditHook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	middleware.Audit = us.recordAudit
+	t.Cleanup(func() { middleware.Audit = nil })
+
+	// HTTP 请求：操作者、IP、User-Agent 和使用的 API 密钥来自请求
+	mock.ExpectQuery("SELECT `username` FROM `users` WHERE id = \\?").
+		WithArgs("u1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice"))
+	column := expectAuditInsert(t, mock)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/music/rescan", nil)
+	c.Request.RemoteAddr = "10.0.0.9:52000"
+	c.Request.Header.Set("User-Agent", "curl/8.0")
+	c.Set("user_id", "u1")
+	c.Set("api_key_id", "k1")
+	middleware.RecordAudit(c, &middleware.AuditRecord{
+		Action:     middleware.AuditLibraryRescan,
+		TargetType: middleware.AuditTargetLibrary,
+		TargetID:   "music",
+	}, errors.New("扫描正在进行中"))
+	checkExpectations(t, mock)
+
+	want := map[string]driver.Value{
+		"actor_id":    "u1",
+		"actor_name":  "alice",
+		"action":      middleware.AuditLibraryRescan,
+		"target_type": middleware.AuditTargetLibrary,
+		"target_id":   "music",
+		"ip":          "10.0.0.9",
+		"user_agent":  "curl/8.0",
+		"result":      AuditFailure,
+		"error":       "扫描正在进行中",
+	}
+	for name, value := range want {
+		if got := column(name); got != value {
+			t.Errorf("%s 为 %v，应为 %v", name, got, value)
+		}
+	}
+	if detail, _ := column("detail").(string); !strings.Contains(detail, `"api_key_id":"k1"`) {
+		t.Errorf("应记录使用的 API 密钥: %v", column("detail"))
+	}
+
+	// 不经过 HTTP 的协议（MPD）：没有请求上下文，由调用方提供操作者和 IP
+	mock.ExpectQuery("SELECT `username` FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("bob"))
+	column = expectAuditInsert(t, mock)
+	middleware.RecordAudit(nil, &middleware.AuditRecord{ActorID: "u2", Action: middleware.AuditMPDLogin, IP: "192.168.1.5"}, nil)
+	checkExpectations(t, mock)
+	if column("actor_name") != "bob" || column("ip") != "192.168.1.5" || column("result") != AuditSuccess {
+		t.Errorf("MPD 事件记录不正确: %v %v %v", column("actor_name"), column("ip"), column("result"))
+	}
+}
+
+// 导出按条件筛选，每行一个 JSON 对象，导出操作本身也会记录
+func TestExportAuditEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	created := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT \\* FROM `audit_events` WHERE action LIKE \\? AND result = \\? ORDER BY `audit_events`.`id` LIMIT \\?").
+		WithArgs("admin.%", AuditFailure, auditExportBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor_id", "action", "result", "detail", "created_at"}).
+			AddRow(1, "u1", AuditUserDelete, AuditFailure, nil, created).
+			AddRow(2, "u1", AuditRoleUpdate, AuditFailure, `{"role":"curator"}`, created))
+	mock.ExpectQuery("SELECT `username` FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("admin"))
+	column := expectAuditInsert(t, mock)
+
+	r := gin.New()
+	r.GET("/user/admin/audit/export", func(c *gin.Context) {
+		c.Set("user_id", "admin-1")
+	}, us.ExportAuditEvents)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user/admin/audit/export?action=admin.&result=failure", nil))
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-ndjson") {
+		t.Fatalf("导出失败: %d %v", w.Code, w.Header())
+	}
+	var events []AuditEvent
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("第 %d 行不是 JSON: %s", len(events)+1, scanner.Text())
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 || events[0].ID != 1 || events[1].Action != AuditRoleUpdate || events[1].Detail["role"] != "curator" {
+		t.Errorf("导出内容不正确: %+v", events)
+	}
+	checkExpectations(t, mock)
+	if column("action") != AuditExport || column("actor_name") != "admin" {
+		t.Errorf("导出操作应记录审计事件: %v %v", column("action"), column("actor_name"))
+	}
+}
@@ -0,0 +1,297 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"myapp/middleware"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 邮箱验证和找回密码使用签名的一次性令牌：令牌本身是 JWT，数据库只记录 jti，
+// 使用后标记为已用；重新发送时同一用途的旧令牌全部作废。
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+	mailCooldown     = time.Minute // 同一用途两次发送邮件的最小间隔
+)
+
+var (
+	ErrEmailTokenInvalid = errors.New("链接无效或已过期")
+	ErrEmailNotVerified  = errors.New("邮箱尚未验证，请先完成邮箱验证")
+	ErrMailCooldown      = errors.New("发送过于频繁，请稍后再试")
+)
+
+// EmailToken 已签发的邮件令牌
+type EmailToken struct {
+	ID        string    `gorm:"type:varchar(64);primaryKey"` // jti
+	UserID    string    `gorm:"type:varchar(255);not null;index"`
+	Purpose   string    `gorm:"type:varchar(32);not null"`
+	Email     string    `gorm:"type:varchar(255);not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=3"`
+}
+
+// 各用途对应的邮件模板、前端页面和有效期
+var emailPurposes = map[string]struct {
+	path string
+	ttl  time.Duration
+}{
+	PurposeVerifyEmail:   {path: "/user/verify-email", ttl: verifyEmailTTL},
+	PurposeResetPassword: {path: "/user/reset-password", ttl: resetPasswordTTL},
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errors.New("邮箱格式不正确")
+	}
+	return email, nil
+}
+
+// emailVerificationRequired 开启邮箱验证后未验证的用户不能登录，管理员除外，避免开启前创建的管理员账号被锁定
+func (us *UserService) emailVerificationRequired(user *User) bool {
+	if !us.cfg.RequireEmailVerification || user.EmailVerified {
+		return false
+	}
+	roles, err := us.userRoles(user.ID)
+	if err != nil {
+		return true
+	}
+	return !slices.Contains(roles, middleware.RoleAdmin)
+}
+
+// issueEmailToken 签发邮件令牌，同一用途之前签发的未使用令牌随即作废
+func (us *UserService) issueEmailToken(user *User, purpose string) (string, error) {
+	var recent int64
+	err := us.db.Model(&EmailToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, purpose, time.Now().Add(-mailCooldown)).
+		Count(&recent).Error
+	if err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", ErrMailCooldown
+	}
+
+	now := time.Now()
+	record := EmailToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: now.Add(emailPurposes[purpose].ttl),
+	}
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).Delete(&EmailToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":     purpose,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"jti":     record.ID,
+		"iat":     now.Unix(),
+		"exp":     record.ExpiresAt.Unix(),
+	})
+	return token.SignedString([]byte(middleware.JWTSecret))
+}
+
+// consumeEmailToken 校验令牌签名和用途并标记为已使用，返回令牌对应的用户
+func (us *UserService) consumeEmailToken(tx *gorm.DB, tokenStr, purpose string) (*User, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(middleware.JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrEmailTokenInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrEmailTokenInvalid
+	}
+	typ, _ := claims["typ"].(string)
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["user_id"].(string)
+	email, _ := claims["email"].(string)
+	if typ != purpose || jti == "" || userID == "" {
+		return nil, ErrEmailTokenInvalid
+	}
+
+	// 条件更新保证令牌只能使用一次
+	result := tx.Model(&EmailToken{}).
+		Where("id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", jti, userID, purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrEmailTokenInvalid
+	}
+
+	var user User
+	if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEmailTokenInvalid
+		}
+		return nil, err
+	}
+	// 发送后修改过邮箱的，旧邮箱收到的链接不再有效
+	if user.Email != email {
+		return nil, ErrEmailTokenInvalid
+	}
+	return &user, nil
+}
+
+// sendEmailLink 签发令牌并按模板发送带链接的邮件
+func (us *UserService) sendEmailLink(user *User, purpose string) error {
+	if user.Email == "" {
+		return errors.New("未设置邮箱")
+	}
+	token, err := us.issueEmailToken(user, purpose)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimRight(us.cfg.AppURL, "/") + emailPurposes[purpose].path + "?token=" + url.QueryEscape(token)
+	msg, err := us.renderMail(purpose, user.Email, &MailData{
+		Username:  user.Username,
+		URL:       link,
+		ExpiresIn: formatDuration(emailPurposes[purpose].ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return us.sendMail(msg)
+}
+
+// sendEmailLinkAsync 在后台发送邮件，用于不应向调用方暴露结果的公开接口
+func (us *UserService) sendEmailLinkAsync(user *User, purpose string) {
+	go func() {
+		if err := us.sendEmailLink(user, purpose); err != nil && !errors.Is(err, ErrMailCooldown) {
+			log.Printf("发送邮件失败: %s - %v", user.Username, err)
+		}
+	}()
+}
+
+// sendVerification 向当前邮箱发送验证邮件
+func (us *UserService) sendVerification(userID string) error {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return errors.New("邮箱已验证")
+	}
+	return us.sendEmailLink(user, PurposeVerifyEmail)
+}
+
+// resendVerification 公开接口：向使用该邮箱且未验证的账号重新发送验证邮件，不透露邮箱是否已注册
+func (us *UserService) resendVerification(email string) error {
+	var users []User
+	if err := us.db.Where("email = ? AND email_verified = ? AND active = ?", strings.TrimSpace(email), false, true).Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		us.sendEmailLinkAsync(&users[i], PurposeVerifyEmail)
+	}
+	return nil
+}
+
+// verifyEmail 使用邮件中的令牌完成邮箱验证
+func (us *UserService) verifyEmail(token string) (*UserResponse, error) {
+	var user *User
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = us.consumeEmailToken(tx, token, PurposeVerifyEmail); err != nil {
+			return err
+		}
+		user.EmailVerified = true
+		return tx.Save(user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return us.toResponse(user)
+}
+
+// requestPasswordReset 向使用该邮箱的账号发送重置密码邮件，不透露邮箱是否已注册
+func (us *UserService) requestPasswordReset(email string) error {
+	var users []User
+	if err := us.db.Where("email = ? AND active = ?", strings.TrimSpace(email), true).Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		us.sendEmailLinkAsync(&users[i], PurposeResetPassword)
+	}
+	return nil
+}
+
+// resetPassword 使用邮件中的令牌设置新密码，并吊销该用户所有会话
+func (us *UserService) resetPassword(req *ResetPasswordRequest) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("密码加密失败")
+	}
+	var user *User
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = us.consumeEmailToken(tx, req.Token, PurposeResetPassword); err != nil {
+			return err
+		}
+		user.Password = string(hashed)
+		user.MustChangePassword = false
+		// 能收到邮件说明邮箱属于该用户
+		user.EmailVerified = true
+		// 重置密码后解除因旧密码输错产生的锁定
+		user.FailedLogins = 0
+		user.LockedUntil = nil
+		user.UpdatedAt = time.Now()
+		return tx.Save(user).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return user.ID, us.revokeAllTokens(user.ID)
+}
+
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d 小时", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%d 分钟", int(d/time.Minute))
+}
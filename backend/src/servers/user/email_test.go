@@ -0,0 +1,131 @@
+package user
+
+import (
+	"errors"
+	"myapp/middleware"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+func signEmailToken(t *testing.T, purpose, email string, exp time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":     purpose,
+		"user_id": "u1",
+		"email":   email,
+		"jti":     "jti-1",
+		"iat":     time.Now().Unix(),
+		"exp":     exp.Unix(),
+	}).SignedString([]byte(middleware.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// 签发令牌时作废同一用途的旧令牌，有效期按用途区分
+func TestIssueEmailToken(t *testing.T) {
+	for purpose, ttl := range map[string]time.Duration{PurposeVerifyEmail: verifyEmailTTL, PurposeResetPassword: resetPasswordTTL} {
+		us, mock := newTestService(t, nil)
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM `email_tokens` WHERE user_id = \\? AND purpose = \\? AND created_at > \\?").
+			WithArgs("u1", purpose, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM `email_tokens` WHERE user_id = \\? AND purpose = \\? AND used_at IS NULL").
+			WithArgs("u1", purpose).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO `email_tokens`").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		tokenStr, err := us.issueEmailToken(&User{ID: "u1", Email: "alice@example.com"}, purpose)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkExpectations(t, mock)
+
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(tokenStr, claims, func(*jwt.Token) (interface{}, error) {
+			return []byte(middleware.JWTSecret), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if claims["typ"] != purpose || claims["email"] != "alice@example.com" {
+			t.Errorf("%s: 令牌声明不正确: %v", purpose, claims)
+		}
+		exp, _ := claims["exp"].(float64)
+		if d := time.Until(time.Unix(int64(exp), 0)); d > ttl || d < ttl-time.Minute {
+			t.Errorf("%s: 有效期为 %s，应为 %s", purpose, d, ttl)
+		}
+	}
+}
+
+// 冷却时间内重复发送被拒绝
+func TestIssueEmailTokenCooldown(t *testing.T) {
+	us, mock := newTestService(t, nil)
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `email_tokens`").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	if _, err := us.issueEmailToken(&User{ID: "u1", Email: "alice@example.com"}, PurposeResetPassword); !errors.Is(err, ErrMailCooldown) {
+		t.Errorf("err = %v", err)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestConsumeEmailToken(t *testing.T) {
+	valid := time.Now().Add(time.Hour)
+	consume := "UPDATE `email_tokens` SET `used_at`=\\? WHERE id = \\? AND user_id = \\? AND purpose = \\? AND used_at IS NULL AND expires_at > \\?"
+	cases := []struct {
+		name   string
+		token  string
+		expect func(mock sqlmock.Sqlmock)
+		ok     bool
+	}{
+		{"有效", signEmailToken(t, PurposeResetPassword, "alice@example.com", valid), func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(consume).
+				WithArgs(sqlmock.AnyArg(), "jti-1", "u1", PurposeResetPassword, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+				WillReturnRows(sqlmock.NewRows(userColumns).AddRow("u1", "alice", "alice@example.com", "", true, false, 0, nil))
+		}, true},
+		// 用途不符和签名过期在查询数据库之前拒绝
+		{"验证邮箱的令牌不能重置密码", signEmailToken(t, PurposeVerifyEmail, "alice@example.com", valid), func(sqlmock.Sqlmock) {}, false},
+		{"令牌已过期", signEmailToken(t, PurposeResetPassword, "alice@example.com", time.Now().Add(-time.Minute)), func(sqlmock.Sqlmock) {}, false},
+		{"签名无效", signEmailToken(t, PurposeResetPassword, "alice@example.com", valid) + "x", func(sqlmock.Sqlmock) {}, false},
+		// 已使用、已被新令牌作废或数据库中已过期
+		{"令牌已使用", signEmailToken(t, PurposeResetPassword, "alice@example.com", valid), func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(consume).WillReturnResult(sqlmock.NewResult(0, 0))
+		}, false},
+		{"发送后修改了邮箱", signEmailToken(t, PurposeResetPassword, "old@example.com", valid), func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(consume).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+				WillReturnRows(sqlmock.NewRows(userColumns).AddRow("u1", "alice", "alice@example.com", "", true, false, 0, nil))
+		}, false},
+	}
+	for _, tc := range cases {
+		us, mock := newTestService(t, nil)
+		mock.ExpectBegin()
+		tc.expect(mock)
+		if tc.ok {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+		// 与验证邮箱、重置密码一样在事务中使用令牌
+		var user *User
+		err := us.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			user, err = us.consumeEmailToken(tx, tc.token, PurposeResetPassword)
+			return err
+		})
+		if tc.ok && (err != nil || user.ID != "u1") {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrEmailTokenInvalid) {
+			t.Errorf("%s: 应返回 ErrEmailTokenInvalid，err = %v", tc.name, err)
+		}
+		checkExpectations(t, mock)
+	}
+}
@@ -0,0 +1,225 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"myapp/middleware"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 注册策略
+// open 任何人都可以注册；invite 需要管理员生成的邀请码；closed 不接受注册。
+// 单点登录自动创建用户同样受注册策略限制，另外还需要开启 OIDC_AUTO_PROVISION。
+// 第一个管理员由 ADMIN_USERNAME 配置，不通过注册产生，注册策略始终生效。
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("暂不开放注册")
+	ErrInviteRequired     = errors.New("需要邀请码才能注册")
+	ErrInviteInvalid      = errors.New("邀请码无效或已过期")
+	ErrInviteRoleDenied   = errors.New("没有分配该角色的权限")
+)
+
+// Invite 邀请码，只保存哈希，明文仅在创建时返回一次
+type Invite struct {
+	ID        string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	CodeHash  string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	Prefix    string     `gorm:"type:varchar(16);not null" json:"prefix"` // 邀请码开头几位，便于辨认
+	Note      string     `gorm:"type:varchar(255)" json:"note"`
+	Role      string     `gorm:"type:varchar(64)" json:"role"`       // 注册后获得的角色，为空时使用默认角色
+	MaxUses   int        `gorm:"not null;default:0" json:"max_uses"` // 0 表示不限次数
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedBy string     `gorm:"type:varchar(255)" json:"created_by"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	Note      string `json:"note" binding:"max=255"`
+	Role      string `json:"role"`
+	MaxUses   int    `json:"max_uses"`
+	ExpiresIn int    `json:"expires_in_days"` // 有效天数，0 表示永不过期
+}
+
+// CreatedInvite 创建邀请码的返回值，Code 只在此时返回
+type CreatedInvite struct {
+	*Invite
+	Code string `json:"code"`
+}
+
+// RegistrationConfig 注册页面需要的配置
+type RegistrationConfig struct {
+	Mode string `json:"mode"`
+}
+
+// registrationMode 配置的注册策略，未配置时开放注册
+func (us *UserService) registrationMode() string {
+	if us.cfg.RegistrationMode == "" {
+		return RegistrationOpen
+	}
+	return us.cfg.RegistrationMode
+}
+
+func validRegistrationMode(mode string) bool {
+	return mode == "" || mode == RegistrationOpen || mode == RegistrationInvite || mode == RegistrationClosed
+}
+
+// registrationConfig 返回给注册页面的策略
+func (us *UserService) registrationConfig() *RegistrationConfig {
+	return &RegistrationConfig{Mode: us.registrationMode()}
+}
+
+func (us *UserService) noAdmin(tx *gorm.DB) (bool, error) {
+	var admins int64
+	err := tx.Model(&UserRole{}).Where("role_name = ?", middleware.RoleAdmin).Count(&admins).Error
+	return admins == 0, err
+}
+
+// 邀请码使用易于手动输入的大写字母和数字，分组显示
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashInviteCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeInviteCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// createInvite 管理员创建邀请码；指定角色相当于分配角色，
+// 创建者需要角色管理权限，并且不能邀请权限超过自己的角色
+func (us *UserService) createInvite(createdBy string, creatorRoles []string, req *CreateInviteRequest) (*CreatedInvite, error) {
+	if req.MaxUses < 0 {
+		return nil, errors.New("使用次数不能为负数")
+	}
+	if req.ExpiresIn < 0 {
+		return nil, errors.New("有效期不能为负数")
+	}
+	if req.Role != "" {
+		var count int64
+		if err := us.db.Model(&Role{}).Where("name = ?", req.Role).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, errors.New("角色不存在")
+		}
+		if !middleware.HasPermission(creatorRoles, middleware.PermRolesManage) {
+			return nil, ErrInviteRoleDenied
+		}
+		perms, err := us.rolePermissions(req.Role)
+		if err != nil {
+			return nil, err
+		}
+		for _, perm := range perms {
+			if !middleware.HasPermission(creatorRoles, perm) {
+				return nil, ErrInviteRoleDenied
+			}
+		}
+	}
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	raw := base32.StdEncoding.EncodeToString(buf)
+	code := fmt.Sprintf("%s-%s-%s-%s", raw[0:4], raw[4:8], raw[8:12], raw[12:16])
+	invite := &Invite{
+		ID:        uuid.New().String(),
+		CodeHash:  hashInviteCode(code),
+		Prefix:    raw[0:4],
+		Note:      strings.TrimSpace(req.Note),
+		Role:      req.Role,
+		MaxUses:   req.MaxUses,
+		CreatedBy: createdBy,
+	}
+	if req.ExpiresIn > 0 {
+		at := time.Now().Add(time.Duration(req.ExpiresIn) * 24 * time.Hour)
+		invite.ExpiresAt = &at
+	}
+	if err := us.db.Create(invite).Error; err != nil {
+		return nil, err
+	}
+	return &CreatedInvite{Invite: invite, Code: code}, nil
+}
+
+func (us *UserService) listInvites() ([]Invite, error) {
+	invites := []Invite{}
+	err := us.db.Order("created_at DESC").Find(&invites).Error
+	return invites, err
+}
+
+// revokeInvite 作废邀请码，已注册的用户不受影响
+func (us *UserService) revokeInvite(id string) error {
+	result := us.db.Model(&Invite{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请码不存在")
+	}
+	return nil
+}
+
+// useInvite 在注册事务中占用一次邀请码，条件更新保证并发注册不会超过使用次数
+func useInvite(tx *gorm.DB, code string) (*Invite, error) {
+	hash := hashInviteCode(code)
+	result := tx.Model(&Invite{}).
+		Where("code_hash = ? AND revoked_at IS NULL", hash).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_uses = 0 OR uses < max_uses").
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInviteInvalid
+	}
+	var invite Invite
+	if err := tx.Where("code_hash = ?", hash).First(&invite).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// checkRegistration 按注册策略检查并占用邀请码，开放注册时也可以使用邀请码获得指定角色
+func (us *UserService) checkRegistration(tx *gorm.DB, inviteCode string) (*Invite, error) {
+	mode := us.registrationMode()
+	switch {
+	case mode == RegistrationClosed:
+		return nil, ErrRegistrationClosed
+	case inviteCode != "":
+		return useInvite(tx, inviteCode)
+	case mode == RegistrationInvite:
+		return nil, ErrInviteRequired
+	}
+	return nil, nil
+}
+
+// assignInviteRole 使用带角色的邀请码注册时分配该角色，角色已被删除时使用默认角色
+func (us *UserService) assignInviteRole(tx *gorm.DB, userID string, invite *Invite) error {
+	if invite == nil || invite.Role == "" {
+		return us.assignDefaultRole(tx, userID)
+	}
+	var count int64
+	if err := tx.Model(&Role{}).Where("name = ?", invite.Role).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return us.assignDefaultRole(tx, userID)
+	}
+	return tx.Create(&UserRole{UserID: userID, RoleName: invite.Role}).Error
+}
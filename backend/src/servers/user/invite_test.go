@@ -0,0 +1,101 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testInviteCode = "ABCD-EFGH-IJKL-MNOP"
+
+var errDuplicate = errors.New("Duplicate entry 'carol' for key 'users.username'")
+
+func expectUsernameFree(mock sqlmock.Sqlmock, username string) {
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").
+		WithArgs(username, 1).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+}
+
+// useInvite 的条件更新：未吊销、未过期且未用完
+func expectUseInvite(mock sqlmock.Sqlmock, rows int64) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `invites` SET `uses`=uses + 1 WHERE (code_hash = ? AND revoked_at IS NULL) AND (expires_at IS NULL OR expires_at > ?) AND (max_uses = 0 OR uses < max_uses)")).
+		WithArgs(hashInviteCode(testInviteCode), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+func TestNormalizeInviteCode(t *testing.T) {
+	for _, code := range []string{testInviteCode, "abcd-efgh-ijkl-mnop", " ABCD EFGH IJKL MNOP ", "ABCDEFGHIJKLMNOP"} {
+		if hashInviteCode(code) != hashInviteCode(testInviteCode) {
+			t.Errorf("%q 应与 %q 等价", code, testInviteCode)
+		}
+	}
+}
+
+// 使用带角色的邀请码注册：占用邀请码、创建用户和分配角色在同一事务中完成
+func TestRegisterWithInvite(t *testing.T) {
+	us, mock := newTestService(t, &UserConfig{RegistrationMode: RegistrationInvite})
+
+	expectUsernameFree(mock, "carol")
+	mock.ExpectBegin()
+	expectUseInvite(mock, 1)
+	mock.ExpectQuery("SELECT \\* FROM `invites` WHERE code_hash = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code_hash", "role", "max_uses", "uses"}).
+			AddRow("i1", hashInviteCode(testInviteCode), "curator", 5, 1))
+	mock.ExpectExec("INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `roles` WHERE name = \\?").
+		WithArgs("curator").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO `user_roles`").
+		WithArgs(sqlmock.AnyArg(), "curator").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT `role_name` FROM `user_roles`").
+		WillReturnRows(sqlmock.NewRows([]string{"role_name"}).AddRow("curator"))
+
+	resp, err := us.registerUser(&RegisterRequest{Username: "carol", Password: "secret", InviteCode: " abcd efgh ijkl mnop "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Roles) != 1 || resp.Roles[0] != "curator" {
+		t.Errorf("应获得邀请码指定的角色: %v", resp.Roles)
+	}
+	checkExpectations(t, mock)
+}
+
+// 注册失败时回滚，邀请码的使用次数不会被占用
+func TestRegisterInviteRejected(t *testing.T) {
+	cases := []struct {
+		name   string
+		mode   string
+		code   string
+		expect func(mock sqlmock.Sqlmock)
+		want   error
+	}{
+		{"关闭注册", RegistrationClosed, testInviteCode, func(sqlmock.Sqlmock) {}, ErrRegistrationClosed},
+		{"缺少邀请码", RegistrationInvite, "", func(sqlmock.Sqlmock) {}, ErrInviteRequired},
+		{"邀请码已用完、过期或吊销", RegistrationInvite, testInviteCode, func(mock sqlmock.Sqlmock) {
+			expectUseInvite(mock, 0)
+		}, ErrInviteInvalid},
+		{"创建用户失败", RegistrationOpen, testInviteCode, func(mock sqlmock.Sqlmock) {
+			expectUseInvite(mock, 1)
+			mock.ExpectQuery("SELECT \\* FROM `invites` WHERE code_hash = \\?").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "code_hash"}).AddRow("i1", hashInviteCode(testInviteCode)))
+			mock.ExpectExec("INSERT INTO `users`").WillReturnError(errDuplicate)
+		}, errDuplicate},
+	}
+	for _, tc := range cases {
+		us, mock := newTestService(t, &UserConfig{RegistrationMode: tc.mode})
+		expectUsernameFree(mock, "carol")
+		mock.ExpectBegin()
+		tc.expect(mock)
+		mock.ExpectRollback()
+
+		_, err := us.registerUser(&RegisterRequest{Username: "carol", Password: "secret", InviteCode: tc.code})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v，应为 %v", tc.name, err, tc.want)
+		}
+		checkExpectations(t, mock)
+	}
+}
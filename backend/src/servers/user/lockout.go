@@ -0,0 +1,300 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"myapp/middleware"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 登录防暴力破解
+// 按账号和 IP 分别记录连续失败次数，超过阈值后锁定，锁定时长随失败次数指数增长。
+// 账号的失败次数保存在数据库中，重启后仍然有效；IP 的记录保存在内存中，一段时间没有失败后清除。
+// 用户不存在和密码错误返回相同的错误，避免通过登录接口探测用户名；
+// 不存在的用户名同样在内存中记录失败次数并按账号阈值锁定，锁定响应也无法用来区分用户名是否存在。
+
+const (
+	defaultLoginMaxAttempts   = 5
+	defaultLoginIPMaxAttempts = 20
+	defaultLoginLockout       = time.Minute
+	defaultLoginLockoutMax    = 24 * time.Hour
+
+	// IP 超过这么久没有失败时清除记录
+	loginIPWindow = time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	ErrUserInactive       = errors.New("用户未激活")
+)
+
+// LockedError 登录尝试过多，Until 之前不能再次尝试
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "尝试次数过多，请稍后再试"
+}
+
+// 用户不存在时也进行一次哈希比较，使响应时间与密码错误时一致
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type ipAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// IPBlock 被临时封禁的 IP，供管理员查看
+type IPBlock struct {
+	IP          string    `json:"ip"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type loginGuard struct {
+	mu  sync.Mutex
+	ips map[string]*ipAttempts
+	// 不存在的用户名，按小写保存
+	unknown map[string]*ipAttempts
+	// 当前时间，测试时替换
+	now func() time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{ips: map[string]*ipAttempts{}, unknown: map[string]*ipAttempts{}, now: time.Now}
+}
+
+func (us *UserService) loginMaxAttempts() int {
+	if us.cfg.LoginMaxAttempts > 0 {
+		return us.cfg.LoginMaxAttempts
+	}
+	return defaultLoginMaxAttempts
+}
+
+func (us *UserService) loginIPMaxAttempts() int {
+	if us.cfg.LoginIPMaxAttempts > 0 {
+		return us.cfg.LoginIPMaxAttempts
+	}
+	return defaultLoginIPMaxAttempts
+}
+
+// lockoutDuration 失败次数达到阈值时锁定基础时长，之后每多失败一次时长翻倍，不超过上限
+func (us *UserService) lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	base, limit := us.cfg.LoginLockout, us.cfg.LoginLockoutMax
+	if base <= 0 {
+		base = defaultLoginLockout
+	}
+	if limit <= 0 {
+		limit = defaultLoginLockoutMax
+	}
+	d := base
+	for i := threshold; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// checkIP 检查 IP 是否处于锁定期
+func (us *UserService) checkIP(ip string) error {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	if a, ok := us.guard.ips[ip]; ok && us.guard.now().Before(a.lockedUntil) {
+		return &LockedError{Until: a.lockedUntil}
+	}
+	return nil
+}
+
+// recordIPFailure 记录 IP 的一次失败
+func (us *UserService) recordIPFailure(ip string) {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	now := us.guard.now()
+	a, ok := us.guard.ips[ip]
+	if !ok || now.Sub(a.lastFailure) > loginIPWindow {
+		a = &ipAttempts{}
+		us.guard.ips[ip] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if d := us.lockoutDuration(a.failures, us.loginIPMaxAttempts()); d > 0 {
+		a.lockedUntil = now.Add(d)
+		log.Printf("IP %s 登录失败 %d 次，锁定至 %s", ip, a.failures, a.lockedUntil.Format(time.DateTime))
+	}
+}
+
+// checkUnknownUser 检查不存在的用户名是否处于锁定期
+func (us *UserService) checkUnknownUser(username string) error {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	if a, ok := us.guard.unknown[strings.ToLower(username)]; ok && us.guard.now().Before(a.lockedUntil) {
+		return &LockedError{Until: a.lockedUntil}
+	}
+	return nil
+}
+
+// recordUnknownUserFailure 记录不存在的用户名的一次失败，与真实账号使用相同的阈值和锁定时长
+func (us *UserService) recordUnknownUserFailure(username string) {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	key := strings.ToLower(username)
+	a, ok := us.guard.unknown[key]
+	if !ok {
+		a = &ipAttempts{}
+		us.guard.unknown[key] = a
+	}
+	a.failures++
+	a.lastFailure = us.guard.now()
+	if d := us.lockoutDuration(a.failures, us.loginMaxAttempts()); d > 0 {
+		a.lockedUntil = a.lastFailure.Add(d)
+	}
+}
+
+// purgeIPAttempts 清除长时间没有失败的 IP 和不存在用户名的记录
+func (us *UserService) purgeIPAttempts() {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	now := us.guard.now()
+	for _, records := range []map[string]*ipAttempts{us.guard.ips, us.guard.unknown} {
+		for key, a := range records {
+			if now.Sub(a.lastFailure) > loginIPWindow && now.After(a.lockedUntil) {
+				delete(records, key)
+			}
+		}
+	}
+}
+
+// blockedIPs 当前处于锁定期的 IP
+func (us *UserService) blockedIPs() []IPBlock {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	now := us.guard.now()
+	blocks := []IPBlock{}
+	for ip, a := range us.guard.ips {
+		if now.Before(a.lockedUntil) {
+			blocks = append(blocks, IPBlock{IP: ip, Failures: a.failures, LastFailure: a.lastFailure, LockedUntil: a.lockedUntil})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].LockedUntil.After(blocks[j].LockedUntil) })
+	return blocks
+}
+
+func (us *UserService) unblockIP(ip string) error {
+	us.guard.mu.Lock()
+	defer us.guard.mu.Unlock()
+	if _, ok := us.guard.ips[ip]; !ok {
+		return errors.New("该 IP 没有登录失败记录")
+	}
+	delete(us.guard.ips, ip)
+	return nil
+}
+
+// checkAccount 检查账号是否处于锁定期
+func (us *UserService) checkAccount(user *User) error {
+	if user.LockedUntil != nil && us.guard.now().Before(*user.LockedUntil) {
+		return &LockedError{Until: *user.LockedUntil}
+	}
+	return nil
+}
+
+// recordLoginFailure 记录账号和 IP 的一次失败，账号为 nil 时（用户不存在）只记录 IP
+func (us *UserService) recordLoginFailure(user *User, ip string) {
+	us.recordIPFailure(ip)
+	if user == nil {
+		return
+	}
+	// 使用表达式累加，避免并发请求互相覆盖
+	err := us.db.Model(&User{}).Where("id = ?", user.ID).
+		Update("failed_logins", gorm.Expr("failed_logins + 1")).Error
+	if err != nil {
+		log.Printf("记录登录失败次数失败: %v", err)
+		return
+	}
+	var failures int
+	if err := us.db.Model(&User{}).Where("id = ?", user.ID).Pluck("failed_logins", &failures).Error; err != nil {
+		return
+	}
+	if d := us.lockoutDuration(failures, us.loginMaxAttempts()); d > 0 {
+		until := us.guard.now().Add(d)
+		us.db.Model(&User{}).Where("id = ?", user.ID).Update("locked_until", until)
+		log.Printf("用户 %s 登录失败 %d 次，锁定至 %s", user.Username, failures, until.Format(time.DateTime))
+	}
+}
+
+// resetLoginFailures 登录成功后清除账号的失败记录；
+// IP 的记录不清除，否则攻击者可以穿插登录自己的账号来绕过 IP 限制
+func (us *UserService) resetLoginFailures(user *User) {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+	err := us.db.Model(&User{}).Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+	if err != nil {
+		log.Printf("清除登录失败次数失败: %v", err)
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+}
+
+// unlockUser 管理员解除账号锁定
+func (us *UserService) unlockUser(userID string) (*UserResponse, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	err = us.db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+	if err != nil {
+		return nil, err
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	return us.toResponse(user)
+}
+
+// ErrPasswordAuthDisabled 开启两步验证的账号不能只凭密码通过其他协议登录
+var ErrPasswordAuthDisabled = errors.New("账号已开启两步验证，请使用 API 密钥代替密码")
+
+// Authenticate 供 MPD 等不经过网页登录的协议使用用户名和密码认证，返回用户ID和 API 密钥的范围（密码登录时为 nil）；
+// 与网页登录共用锁定和账号状态检查，申请注销的账号不能通过其他协议登录。
+// 开启两步验证的账号无法在这些协议中输入验证码，只能使用本人的 API 密钥代替密码
+func (us *UserService) Authenticate(username, password, ip string) (string, []string, error) {
+	if strings.HasPrefix(password, middleware.APIKeyPrefix) {
+		return us.authenticateAPIKey(username, password, ip)
+	}
+	userResp, err := us.login(&LoginRequest{Username: username, Password: password}, ip)
+	if err != nil {
+		return "", nil, err
+	}
+	if userResp.TwoFactorEnabled {
+		return "", nil, ErrPasswordAuthDisabled
+	}
+	if userResp.DeletionScheduledAt != nil {
+		return "", nil, ErrUserInactive
+	}
+	return userResp.ID, nil, nil
+}
+
+// authenticateAPIKey 使用 API 密钥代替密码，密钥必须属于该用户，失败同样计入 IP 的失败次数
+func (us *UserService) authenticateAPIKey(username, key, ip string) (string, []string, error) {
+	if err := us.checkIP(ip); err != nil {
+		return "", nil, err
+	}
+	info, ok := us.validateAPIKey(key, ip)
+	if !ok || us.usernameOf(info.UserID) != username {
+		us.recordIPFailure(ip)
+		return "", nil, ErrInvalidCredentials
+	}
+	return info.UserID, info.Scopes, nil
+}
@@ -0,0 +1,242 @@
+package user
+
+import (
+	"database/sql/driver"
+	"errors"
+	"myapp/middleware"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testClock 可手动推进的时钟，替换 loginGuard.now
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time          { return c.now }
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestClock(us *UserService) *testClock {
+	clock := &testClock{now: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)}
+	us.guard.now = clock.Now
+	return clock
+}
+
+var userColumns = []string{"id", "username", "email", "password", "active", "email_verified", "failed_logins", "locked_until"}
+
+func TestLockoutDuration(t *testing.T) {
+	us := &UserService{cfg: &UserConfig{LoginLockout: time.Minute, LoginLockoutMax: time.Hour}}
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{11, time.Hour}, // 64 分钟超过上限
+		{100, time.Hour},
+	}
+	for _, tc := range cases {
+		if got := us.lockoutDuration(tc.failures, 5); got != tc.want {
+			t.Errorf("失败 %d 次锁定 %s，应为 %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+// IP 的失败记录保存在内存中，按步骤推进时钟检查锁定状态
+func TestIPLockout(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		fail    bool // 记录一次失败，否则只检查
+		locked  bool
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{"达到阈值后锁定", []step{
+			{0, true, false},
+			{0, true, false},
+			{0, true, true},
+		}},
+		{"锁定到期后解除", []step{
+			{0, true, false}, {0, true, false}, {0, true, true},
+			{59 * time.Second, false, true},
+			{2 * time.Second, false, false},
+		}},
+		{"解除后再次失败，锁定时长翻倍", []step{
+			{0, true, false}, {0, true, false}, {0, true, true},
+			{61 * time.Second, true, true},
+			{61 * time.Second, false, true},
+			{61 * time.Second, false, false},
+		}},
+		{"超过统计窗口后重新计数", []step{
+			{0, true, false}, {0, true, false},
+			{loginIPWindow + time.Second, true, false},
+			{0, true, false},
+			{0, true, true},
+		}},
+	}
+	for _, tc := range cases {
+		us := &UserService{cfg: &UserConfig{LoginIPMaxAttempts: 3, LoginLockout: time.Minute}, guard: newLoginGuard()}
+		clock := newTestClock(us)
+		for i, s := range tc.steps {
+			clock.Advance(s.advance)
+			if s.fail {
+				us.recordIPFailure("10.0.0.1")
+			}
+			var locked *LockedError
+			if got := errors.As(us.checkIP("10.0.0.1"), &locked); got != s.locked {
+				t.Errorf("%s 第 %d 步: 锁定状态为 %v", tc.name, i+1, got)
+			}
+		}
+		if err := us.checkIP("10.0.0.2"); err != nil {
+			t.Errorf("%s: 其他 IP 不应受影响", tc.name)
+		}
+	}
+}
+
+// 不存在的用户名与真实账号一样在达到阈值后锁定，锁定不区分大小写
+func TestUnknownUsernameLockout(t *testing.T) {
+	us, mock := newTestService(t, &UserConfig{LoginMaxAttempts: 2, LoginLockout: time.Minute})
+	clock := newTestClock(us)
+
+	cases := []struct {
+		advance  time.Duration
+		username string
+		locked   bool
+	}{
+		{0, "ghost", false},
+		{0, "ghost", false},
+		{0, "ghost", true},
+		{0, "GHOST", true},
+		{30 * time.Second, "ghost", true},
+		{31 * time.Second, "Ghost", false},
+	}
+	for i, tc := range cases {
+		clock.Advance(tc.advance)
+		mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").
+			WithArgs(tc.username, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+		_, err := us.login(&LoginRequest{Username: tc.username, Password: "wrong"}, "10.0.0.1")
+		var locked *LockedError
+		if errors.As(err, &locked) != tc.locked {
+			t.Errorf("第 %d 次登录: err = %v", i+1, err)
+		}
+		if !tc.locked && !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("第 %d 次登录应返回与密码错误相同的错误，err = %v", i+1, err)
+		}
+	}
+	checkExpectations(t, mock)
+}
+
+// 账号的失败次数保存在数据库中，达到阈值后写入锁定时间；锁定期间即使密码正确也不能登录，
+// 到期后登录成功会清除失败次数
+func TestAccountLockout(t *testing.T) {
+	us, mock := newTestService(t, &UserConfig{LoginMaxAttempts: 5, LoginLockout: time.Minute})
+	clock := newTestClock(us)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := func(failures int, lockedUntil *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows(userColumns).AddRow("u1", "alice", "", string(hash), true, true, failures, lockedUntil)
+	}
+
+	// 第 5 次密码错误：累加失败次数后锁定 1 分钟
+	until := clock.Now().Add(time.Minute)
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").WillReturnRows(user(4, nil))
+	expectExec(mock, "UPDATE `users` SET `failed_logins`=failed_logins \\+ 1", sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT `failed_logins` FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"failed_logins"}).AddRow(5))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `users` SET `locked_until`=\\?").
+		WithArgs(until, sqlmock.AnyArg(), "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if _, err := us.login(&LoginRequest{Username: "alice", Password: "wrong"}, "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 锁定期间密码正确也被拒绝，不再累加失败次数
+	clock.Advance(30 * time.Second)
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").WillReturnRows(user(5, &until))
+	var locked *LockedError
+	if _, err := us.login(&LoginRequest{Username: "alice", Password: "secret"}, "10.0.0.1"); !errors.As(err, &locked) || !locked.Until.Equal(until) {
+		t.Fatalf("账号应处于锁定期，err = %v", err)
+	}
+
+	// 到期后登录成功，清除失败次数和锁定时间
+	clock.Advance(31 * time.Second)
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").WillReturnRows(user(5, &until))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `users` SET `failed_logins`=\\?,`locked_until`=\\?").
+		WithArgs(0, nil, sqlmock.AnyArg(), "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT `role_name` FROM `user_roles`").
+		WillReturnRows(sqlmock.NewRows([]string{"role_name"}).AddRow(middleware.RoleUser))
+	resp, err := us.login(&LoginRequest{Username: "alice", Password: "secret"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FailedLogins != 0 || resp.LockedUntil != nil {
+		t.Errorf("登录成功后应清除锁定: %+v", resp)
+	}
+	checkExpectations(t, mock)
+}
+
+// 通过邮件重置密码后解除锁定
+func TestResetPasswordClearsLockout(t *testing.T) {
+	us, mock := newTestService(t, nil)
+	until := time.Now().Add(time.Hour)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":     PurposeResetPassword,
+		"user_id": "u1",
+		"email":   "alice@example.com",
+		"jti":     "token-1",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(middleware.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Save 按字段顺序更新除主键外的所有列，最后一个参数为主键
+	columns := modelColumns(t, &User{}, false)
+	saved := []driver.Value{}
+	args := recordArgs(&saved, len(columns)+1)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `email_tokens` SET `used_at`=\\? WHERE id = \\? AND user_id = \\? AND purpose = \\? AND used_at IS NULL AND expires_at > \\?").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow("u1", "alice", "alice@example.com", "old", true, true, 7, until))
+	mock.ExpectExec("UPDATE `users` SET .*`failed_logins`=\\?,`locked_until`=\\?").
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// 随后吊销该用户的所有会话
+	expectExec(mock, "UPDATE `refresh_tokens` SET `revoked_at`=\\?", sqlmock.NewResult(0, 0))
+	expectExec(mock, "UPDATE `sessions` SET `revoked_at`=\\?", sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\* FROM `token_revocations`").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectExec(mock, "INSERT INTO `token_revocations`", sqlmock.NewResult(1, 1))
+
+	if _, err := us.resetPassword(&ResetPasswordRequest{Token: token, NewPassword: "new-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	checkExpectations(t, mock)
+	if len(saved) != len(args) {
+		t.Fatalf("更新参数为 %v", saved)
+	}
+	if v := saved[slices.Index(columns, "failed_logins")]; v != int64(0) {
+		t.Errorf("失败次数应清零，实际为 %v", v)
+	}
+	if v := saved[slices.Index(columns, "locked_until")]; v != nil {
+		t.Errorf("锁定时间应清除，实际为 %v", v)
+	}
+}
@@ -0,0 +1,258 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Message 待发送的邮件，正文为纯文本
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送方式，新增方式时实现该接口并在 newMailer 中注册
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type MailConfig struct {
+	Driver string // smtp、file 或 log，默认 log
+	From   string // 发件人地址
+
+	SMTPHost     string
+	SMTPPort     int // 465 使用 TLS 直连，其他端口在服务器支持时使用 STARTTLS
+	SMTPUsername string
+	SMTPPassword string
+
+	Dir         string // file 方式保存邮件的目录
+	TemplateDir string // 自定义邮件模板目录，文件名为 <模板名>.tmpl
+}
+
+const mailTimeout = 30 * time.Second
+
+func newMailer(cfg *MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("未配置 SMTP 服务器")
+		}
+		return &SMTPMailer{cfg: cfg}, nil
+	case "file":
+		return &DevMailer{From: cfg.From, Dir: cfg.Dir}, nil
+	case "", "log":
+		return &DevMailer{From: cfg.From}, nil
+	}
+	return nil, errors.New("未知的邮件发送方式: " + cfg.Driver)
+}
+
+// SMTPMailer 通过 SMTP 服务器发送邮件
+type SMTPMailer struct {
+	cfg *MailConfig
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	port := m.cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(m.cfg.SMTPHost, fmt.Sprint(port))
+	tlsConfig := &tls.Config{ServerName: m.cfg.SMTPHost}
+
+	dialer := &net.Dialer{Timeout: mailTimeout}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(mailTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if m.cfg.SMTPUsername != "" {
+		auth := smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMessage(m.cfg.From, msg)); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// DevMailer 开发环境使用：设置了目录时将邮件保存为 .eml 文件，否则写入日志
+type DevMailer struct {
+	From string
+	Dir  string
+}
+
+func (m *DevMailer) Send(ctx context.Context, msg *Message) error {
+	if m.Dir == "" {
+		log.Printf("邮件 -> %s\n主题: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405.000") + "-" + randomHex(4) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMessage(m.From, msg), 0644)
+}
+
+// buildMessage 生成 RFC 5322 格式的邮件，主题和正文按 UTF-8 编码
+func buildMessage(from string, msg *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", randomHex(16), mailDomain(from))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	qp.Close()
+	return buf.Bytes()
+}
+
+func mailDomain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return strings.Trim(addr[i+1:], "> ")
+	}
+	return "localhost"
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 内置邮件模板，每个模板定义 subject 和 body 两部分，
+// 可以在 TemplateDir 中放置同名的 .tmpl 文件覆盖
+var defaultMailTemplates = map[string]string{
+	"verify_email": `{{define "subject"}}验证你的邮箱{{end}}{{define "body"}}{{.Username}}，你好：
+
+请打开下面的链接验证你的邮箱地址：
+
+{{.URL}}
+
+链接在 {{.ExpiresIn}} 内有效，只能使用一次。如果这不是你本人的操作，请忽略这封邮件。
+{{end}}`,
+	"reset_password": `{{define "subject"}}重置密码{{end}}{{define "body"}}{{.Username}}，你好：
+
+我们收到了重置你账号密码的请求，请打开下面的链接设置新密码：
+
+{{.URL}}
+
+链接在 {{.ExpiresIn}} 内有效，只能使用一次。如果这不是你本人的操作，请忽略这封邮件，你的密码不会改变。
+{{end}}`,
+}
+
+// MailData 模板中可以使用的字段
+type MailData struct {
+	Username  string
+	URL       string
+	ExpiresIn string
+}
+
+// loadMailTemplates 加载内置模板，并用 TemplateDir 中的同名文件覆盖
+func loadMailTemplates(dir string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(defaultMailTemplates))
+	for name, text := range defaultMailTemplates {
+		if dir != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, name+".tmpl")); err == nil {
+				text = string(data)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("解析邮件模板 %s 失败: %w", name, err)
+		}
+		if tmpl.Lookup("subject") == nil || tmpl.Lookup("body") == nil {
+			return nil, fmt.Errorf("邮件模板 %s 需要定义 subject 和 body", name)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// renderMail 按模板生成邮件
+func (us *UserService) renderMail(name, to string, data *MailData) (*Message, error) {
+	tmpl, ok := us.mailTemplates[name]
+	if !ok {
+		return nil, errors.New("邮件模板不存在: " + name)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+	return &Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}, nil
+}
+
+func (us *UserService) sendMail(msg *Message) error {
+	ctx, cancel := context.WithTimeout(us.ctx, mailTimeout)
+	defer cancel()
+	return us.mailer.Send(ctx, msg)
+}
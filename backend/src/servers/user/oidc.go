@@ -0,0 +1,608 @@
+package user
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"myapp/middleware"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OpenID Connect 登录：授权码模式 + PKCE。
+// 身份提供方的地址从发现文档（/.well-known/openid-configuration）获取，
+// ID 令牌使用 JWKS 中的公钥校验签名、签发者、受众、有效期和 nonce。
+// 登录成功后按 (issuer, sub) 关联本地用户，没有关联时按已验证的邮箱关联或自动创建用户；
+// 自动创建用户与注册一样受注册策略限制，邀请模式下需要在发起登录时提供邀请码。
+
+const (
+	oidcStateCookie  = "oidc_state" // 保存发起登录的浏览器的 state，回调时比对，防止登录 CSRF
+	oidcStateTTL     = 10 * time.Minute
+	oidcHTTPTimeout  = 15 * time.Second
+	oidcJWKSCooldown = time.Minute // 遇到未知 kid 时重新获取 JWKS 的最小间隔
+	oidcLeeway       = time.Minute // 校验 ID 令牌时间时允许的时钟误差
+)
+
+var ErrOIDCDisabled = errors.New("未配置 OIDC 登录")
+
+type OIDCConfig struct {
+	Issuer       string // 身份提供方地址，为空时不启用
+	Name         string // 登录按钮上显示的名称
+	ClientID     string
+	ClientSecret string   // 为空时按公共客户端处理，只依赖 PKCE
+	RedirectURL  string   // 回调地址，即本服务的 /user/oidc/callback
+	Scopes       []string // 默认 openid profile email
+
+	RoleClaim   string            // 包含用户组或角色的声明，例如 groups，为空时不同步角色
+	RoleMapping map[string]string // 声明中的值 -> 本地角色
+
+	AutoProvision bool // 没有关联的本地用户时自动创建
+	LinkByEmail   bool // 按已验证的邮箱关联已有用户
+}
+
+// OIDCIdentity 外部身份与本地用户的关联
+type OIDCIdentity struct {
+	Issuer    string    `gorm:"type:varchar(255);primaryKey"`
+	Subject   string    `gorm:"type:varchar(255);primaryKey"`
+	UserID    string    `gorm:"type:varchar(255);not null;index"`
+	Email     string    `gorm:"type:varchar(255)"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	LastLogin time.Time
+}
+
+// oidcProvider 发现文档中用到的字段
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcState struct {
+	verifier  string
+	nonce     string
+	redirect  string // 登录后前端跳转的页面
+	invite    string // 自动创建用户时使用的邀请码
+	expiresAt time.Time
+}
+
+// oidcClient 缓存发现文档、JWKS 和进行中的登录请求
+type oidcClient struct {
+	cfg  *OIDCConfig
+	http *http.Client
+
+	mu          sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]interface{}
+	keysFetched time.Time
+	states      map[string]*oidcState
+}
+
+// oidcClaims ID 令牌中用到的声明
+type oidcClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+	Groups            []string
+}
+
+func newOIDCClient(cfg *OIDCConfig) *oidcClient {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	return &oidcClient{
+		cfg:    cfg,
+		http:   &http.Client{Timeout: oidcHTTPTimeout},
+		keys:   map[string]interface{}{},
+		states: map[string]*oidcState{},
+	}
+}
+
+func (oc *oidcClient) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := oc.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover 获取发现文档，成功后缓存
+func (oc *oidcClient) discover(ctx context.Context) (*oidcProvider, error) {
+	oc.mu.Lock()
+	provider := oc.provider
+	oc.mu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	issuer := strings.TrimRight(oc.cfg.Issuer, "/")
+	var doc oidcProvider
+	if err := oc.getJSON(ctx, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("获取 OIDC 发现文档失败: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("发现文档的 issuer 不匹配: %s", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少必要的端点")
+	}
+
+	oc.mu.Lock()
+	oc.provider = &doc
+	oc.mu.Unlock()
+	return &doc, nil
+}
+
+// key 按 kid 查找签名公钥，找不到时重新获取 JWKS（身份提供方可能轮换了密钥）
+func (oc *oidcClient) key(ctx context.Context, provider *oidcProvider, kid string) (interface{}, error) {
+	oc.mu.Lock()
+	key, ok := oc.keys[kid]
+	stale := time.Since(oc.keysFetched) > oidcJWKSCooldown
+	oc.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := oc.getJSON(ctx, provider.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("获取 JWKS 失败: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, raw := range set.Keys {
+		id, pub, err := parseJWK(raw)
+		if err != nil {
+			log.Printf("忽略无法解析的 JWK: %v", err)
+			continue
+		}
+		keys[id] = pub
+	}
+
+	oc.mu.Lock()
+	oc.keys = keys
+	oc.keysFetched = time.Now()
+	oc.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// 只有一个密钥且令牌没有 kid 时直接使用
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// parseJWK 解析 RSA 和 EC 公钥
+func parseJWK(raw json.RawMessage) (string, interface{}, error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("密钥 %s 不用于签名", jwk.Kid)
+	}
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("不支持的曲线: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return "", nil, fmt.Errorf("不支持的密钥类型: %s", jwk.Kty)
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// authURL 生成跳转到身份提供方的授权地址并返回 state，state、nonce 和 PKCE 校验码保存在内存中
+func (oc *oidcClient) authURL(ctx context.Context, redirect, invite string) (string, string, error) {
+	provider, err := oc.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := randomToken()
+	st := &oidcState{
+		verifier:  randomToken(),
+		nonce:     randomToken(),
+		redirect:  redirect,
+		invite:    invite,
+		expiresAt: time.Now().Add(oidcStateTTL),
+	}
+	oc.mu.Lock()
+	for key, old := range oc.states {
+		if time.Now().After(old.expiresAt) {
+			delete(oc.states, key)
+		}
+	}
+	oc.states[state] = st
+	oc.mu.Unlock()
+
+	scopes := oc.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	challenge := sha256.Sum256([]byte(st.verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", oc.cfg.ClientID)
+	params.Set("redirect_uri", oc.cfg.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", st.nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// takeState 取出并删除登录请求，state 只能使用一次
+func (oc *oidcClient) takeState(state string) (*oidcState, bool) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	st, ok := oc.states[state]
+	if !ok {
+		return nil, false
+	}
+	delete(oc.states, state)
+	if time.Now().After(st.expiresAt) {
+		return nil, false
+	}
+	return st, true
+}
+
+// exchange 用授权码换取令牌并校验 ID 令牌
+func (oc *oidcClient) exchange(ctx context.Context, code string, st *oidcState) (*oidcClaims, error) {
+	provider, err := oc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oc.cfg.RedirectURL)
+	form.Set("code_verifier", st.verifier)
+	if oc.cfg.ClientSecret == "" {
+		form.Set("client_id", oc.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oc.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oc.cfg.ClientID), url.QueryEscape(oc.cfg.ClientSecret))
+	}
+	resp, err := oc.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析令牌响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return nil, fmt.Errorf("换取令牌失败: HTTP %d %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return nil, errors.New("令牌响应中没有 ID 令牌")
+	}
+	return oc.verifyIDToken(ctx, provider, result.IDToken, st.nonce)
+}
+
+// verifyIDToken 校验 ID 令牌的签名、签发者、受众、有效期和 nonce
+func (oc *oidcClient) verifyIDToken(ctx context.Context, provider *oidcProvider, raw, nonce string) (*oidcClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithoutClaimsValidation(),
+	)
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return oc.key(ctx, provider, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ID 令牌签名无效: %w", err)
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(oc.cfg.Issuer, "/") {
+		return nil, errors.New("ID 令牌的签发者不匹配")
+	}
+	if !claims.VerifyAudience(oc.cfg.ClientID, true) {
+		return nil, errors.New("ID 令牌的受众不匹配")
+	}
+	// 有多个受众时 azp 必须是本客户端
+	if aud, ok := claims["aud"].([]interface{}); ok && len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != oc.cfg.ClientID {
+			return nil, errors.New("ID 令牌的 azp 不匹配")
+		}
+	}
+	if !claims.VerifyExpiresAt(now.Add(-oidcLeeway).Unix(), true) {
+		return nil, errors.New("ID 令牌已过期")
+	}
+	if !claims.VerifyIssuedAt(now.Add(oidcLeeway).Unix(), false) {
+		return nil, errors.New("ID 令牌的签发时间无效")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID 令牌的 nonce 不匹配")
+	}
+
+	result := &oidcClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	result.Name, _ = claims["name"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = v
+	case string:
+		result.EmailVerified = v == "true"
+	}
+	if result.Subject == "" {
+		return nil, errors.New("ID 令牌缺少 sub")
+	}
+	if oc.cfg.RoleClaim != "" {
+		result.Groups = claimStrings(claims[oc.cfg.RoleClaim])
+	}
+	return result, nil
+}
+
+// claimStrings 角色声明可能是字符串数组、单个字符串或以空格分隔的字符串
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// mapRoles 按配置将声明中的值映射为本地角色
+func (oc *oidcClient) mapRoles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		if role, ok := oc.cfg.RoleMapping[group]; ok && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		roles = []string{middleware.RoleUser}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// oidcLogin 根据 ID 令牌中的身份查找、关联或创建本地用户
+func (us *UserService) oidcLogin(claims *oidcClaims, invite string) (*UserResponse, error) {
+	issuer := strings.TrimRight(us.cfg.OIDC.Issuer, "/")
+
+	var identity OIDCIdentity
+	err := us.db.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user *User
+	if err == nil {
+		if user, err = us.findUser(identity.UserID); err != nil {
+			return nil, err
+		}
+	} else {
+		if user, err = us.linkOIDCUser(claims, invite); err != nil {
+			return nil, err
+		}
+		identity = OIDCIdentity{Issuer: issuer, Subject: claims.Subject, UserID: user.ID}
+	}
+	if !user.Active {
+		return nil, ErrUserInactive
+	}
+
+	identity.Email = claims.Email
+	identity.LastLogin = time.Now()
+	if err := us.db.Save(&identity).Error; err != nil {
+		return nil, err
+	}
+
+	if us.cfg.OIDC.RoleClaim != "" {
+		us.syncOIDCRoles(user.ID, us.oidc.mapRoles(claims.Groups))
+	}
+	return us.toResponse(user)
+}
+
+// linkOIDCUser 首次登录时按已验证的邮箱关联已有用户，或按注册策略自动创建用户
+func (us *UserService) linkOIDCUser(claims *oidcClaims, inviteCode string) (*User, error) {
+	if us.cfg.OIDC.LinkByEmail && claims.Email != "" && claims.EmailVerified {
+		var users []User
+		if err := us.db.Where("email = ? AND email_verified = ?", claims.Email, true).Limit(2).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		// 多个用户使用同一邮箱时无法确定关联哪一个
+		if len(users) == 1 {
+			log.Printf("OIDC 身份 %s 已关联到用户 %s", claims.Subject, users[0].Username)
+			return &users[0], nil
+		}
+	}
+	if !us.cfg.OIDC.AutoProvision {
+		return nil, errors.New("没有与该身份关联的用户，请联系管理员")
+	}
+
+	username, err := us.availableUsername(claims)
+	if err != nil {
+		return nil, err
+	}
+	email, _ := normalizeEmail(claims.Email)
+	now := time.Now()
+	user := &User{
+		ID:            uuid.New().String(),
+		Username:      username,
+		Email:         email,
+		EmailVerified: email != "" && claims.EmailVerified,
+		// 没有本地密码，只能通过身份提供方登录
+		Password:  "",
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		invite, err := us.checkRegistration(tx, inviteCode)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return us.assignInviteRole(tx, user.ID, invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("已通过 OIDC 创建用户 %s", username)
+	return user, nil
+}
+
+// availableUsername 根据声明生成不重复的用户名
+func (us *UserService) availableUsername(claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > 16 {
+		base = base[:16]
+	}
+	for i := 0; i < 100; i++ {
+		name := base
+		if i > 0 {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		if _, err := us.getUserByName(name); errors.Is(err, gorm.ErrRecordNotFound) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("无法生成可用的用户名")
+}
+
+// syncOIDCRoles 角色与身份提供方不一致时更新，失败时（例如会移除最后一个管理员）保留原角色
+func (us *UserService) syncOIDCRoles(userID string, roles []string) {
+	current, err := us.userRoles(userID)
+	if err != nil {
+		log.Printf("查询用户角色失败: %v", err)
+		return
+	}
+	if slices.Equal(current, roles) {
+		return
+	}
+	if _, err := us.setUserRoles(userID, roles); err != nil {
+		log.Printf("同步 OIDC 角色失败: %v", err)
+	}
+}
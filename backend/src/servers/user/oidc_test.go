@@ -0,0 +1,237 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockIdP 模拟身份提供方：发现文档、JWKS 和令牌端点，
+// 令牌端点校验 PKCE 并返回签名的 ID 令牌
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// 授权请求中的参数，由测试模拟浏览器跳转时填入
+	challenge string
+	nonce     string
+	// 为空时使用授权请求中的 nonce
+	tokenNonce string
+}
+
+const (
+	mockClientID = "digital-hub"
+	mockCode     = "auth-code"
+)
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test-key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("code") != mockCode || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	nonce := idp.nonce
+	if idp.tokenNonce != "" {
+		nonce = idp.tokenNonce
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"sub":                "subject-1",
+		"aud":                mockClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice",
+		"groups":             []string{"staff"},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Error(err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+}
+
+// authorize 发起登录，模拟浏览器打开授权地址，返回回调中的 state
+func (idp *mockIdP) authorize(t *testing.T, oc *oidcClient) string {
+	authURL, state, err := oc.authURL(context.Background(), "/music", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatalf("授权地址错误: %s", authURL)
+	}
+	q := u.Query()
+	if q.Get("state") != state || q.Get("client_id") != mockClientID || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("授权参数错误: %v", q)
+	}
+	idp.challenge = q.Get("code_challenge")
+	idp.nonce = q.Get("nonce")
+	return state
+}
+
+func newTestOIDCClient(idp *mockIdP) *oidcClient {
+	return newOIDCClient(&OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://localhost/user/oidc/callback",
+		RoleClaim:   "groups",
+	})
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	oc := newTestOIDCClient(idp)
+
+	state := idp.authorize(t, oc)
+	st, ok := oc.takeState(state)
+	if !ok {
+		t.Fatal("state 应该有效")
+	}
+	if st.redirect != "/music" {
+		t.Errorf("redirect = %q", st.redirect)
+	}
+	if _, ok := oc.takeState(state); ok {
+		t.Error("state 只能使用一次")
+	}
+
+	claims, err := oc.exchange(context.Background(), mockCode, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "alice@example.com" || !claims.EmailVerified ||
+		claims.PreferredUsername != "alice" || len(claims.Groups) != 1 || claims.Groups[0] != "staff" {
+		t.Errorf("声明解析错误: %+v", claims)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	oc := newTestOIDCClient(idp)
+
+	state := idp.authorize(t, oc)
+	st, _ := oc.takeState(state)
+	st.verifier = "another-verifier"
+	if _, err := oc.exchange(context.Background(), mockCode, st); err == nil {
+		t.Fatal("PKCE 校验码不匹配时应该失败")
+	}
+}
+
+func TestOIDCRejectsWrongNonce(t *testing.T) {
+	idp := newMockIdP(t)
+	oc := newTestOIDCClient(idp)
+
+	state := idp.authorize(t, oc)
+	idp.tokenNonce = "replayed-nonce"
+	st, _ := oc.takeState(state)
+	_, err := oc.exchange(context.Background(), mockCode, st)
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("nonce 不匹配时应该失败，err = %v", err)
+	}
+}
+
+func TestOIDCRejectsUnknownState(t *testing.T) {
+	idp := newMockIdP(t)
+	oc := newTestOIDCClient(idp)
+
+	idp.authorize(t, oc)
+	if _, ok := oc.takeState("forged-state"); ok {
+		t.Fatal("未知的 state 应该无效")
+	}
+}
+
+// 回调必须来自发起登录的浏览器，state Cookie 不匹配时不换取令牌
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := newMockIdP(t)
+	r := gin.New()
+	us := &UserService{
+		cfg:  &UserConfig{AppURL: "http://app.local", OIDC: OIDCConfig{Issuer: idp.server.URL}},
+		rg:   r.Group("/user"),
+		oidc: newTestOIDCClient(idp),
+	}
+	us.rg.GET("/oidc/login", us.OIDCLogin)
+	us.rg.GET("/oidc/callback", us.OIDCCallback)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("发起登录应该跳转，status = %d", w.Code)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || cookies[0].Value != state || !cookies[0].HttpOnly {
+		t.Fatalf("应该设置 state Cookie: %v", cookies)
+	}
+
+	// 攻击者把自己的回调地址发给受害者，受害者的浏览器没有该 state 的 Cookie
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user/oidc/callback?code="+mockCode+"&state="+state, nil))
+	if !strings.Contains(w.Header().Get("Location"), "oidc_error=") {
+		t.Fatalf("没有 Cookie 的回调应该失败，Location = %s", w.Header().Get("Location"))
+	}
+	if _, ok := us.oidc.takeState(state); !ok {
+		t.Error("校验 Cookie 失败时不应消耗 state")
+	}
+}
@@ -0,0 +1,258 @@
+package user
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeletionGrace = 7 * 24 * time.Hour
+	maxAvatarSize        = 2 << 20
+)
+
+// 允许上传的头像格式及保存时使用的扩展名
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+type UpdateProfileRequest struct {
+	Username *string `json:"username" binding:"omitempty,min=3,max=20"`
+	Email    *string `json:"email" binding:"omitempty,max=255"`
+	Password string  `json:"password"` // 修改邮箱时需要当前密码
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=3"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func (us *UserService) deletionGrace() time.Duration {
+	if us.cfg.DeletionGracePeriod > 0 {
+		return us.cfg.DeletionGracePeriod
+	}
+	return defaultDeletionGrace
+}
+
+func checkPassword(user *User, password string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return errors.New("密码错误")
+	}
+	return nil
+}
+
+// updateProfile 修改用户名和邮箱，修改邮箱需要校验当前密码
+func (us *UserService) updateProfile(userID string, req *UpdateProfileRequest) (*UserResponse, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Username != nil && *req.Username != user.Username {
+		existUser, err := us.getUserByName(*req.Username)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if existUser != nil {
+			return nil, errors.New("用户名已存在")
+		}
+		user.Username = *req.Username
+	}
+	emailChanged := false
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			return nil, err
+		}
+		if email == "" && us.cfg.RequireEmailVerification {
+			return nil, errors.New("邮箱不能为空")
+		}
+		// 邮箱可以用来重置密码，修改前需要确认当前密码；修改后需要重新验证
+		if email != user.Email {
+			if user.Password == "" {
+				return nil, errors.New("通过单点登录创建的账号不能修改邮箱")
+			}
+			if err := checkPassword(user, req.Password); err != nil {
+				return nil, err
+			}
+			user.Email = email
+			user.EmailVerified = false
+			emailChanged = email != ""
+		}
+	}
+	user.UpdatedAt = time.Now()
+
+	if err := us.updateUser(user); err != nil {
+		return nil, err
+	}
+	if emailChanged {
+		us.sendEmailLinkAsync(user, PurposeVerifyEmail)
+	}
+	return us.toResponse(user)
+}
+
+// changePassword 校验旧密码后修改密码，并吊销所有已有会话
+func (us *UserService) changePassword(userID string, req *ChangePasswordRequest) error {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(user, req.OldPassword); err != nil {
+		return errors.New("原密码错误")
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("密码加密失败")
+	}
+	user.Password = string(hashed)
+	user.MustChangePassword = false
+	user.UpdatedAt = time.Now()
+	if err := us.updateUser(user); err != nil {
+		return err
+	}
+	return us.revokeAllTokens(userID)
+}
+
+func (us *UserService) avatarDir() string {
+	if us.cfg.AvatarDir != "" {
+		return us.cfg.AvatarDir
+	}
+	return "./avatars"
+}
+
+// saveAvatar 保存上传的头像，按内容识别图片格式，替换旧头像
+func (us *UserService) saveAvatar(userID string, file *multipart.FileHeader) (*UserResponse, error) {
+	if file.Size > maxAvatarSize {
+		return nil, errors.New("头像不能超过 2MB")
+	}
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxAvatarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAvatarSize {
+		return nil, errors.New("头像不能超过 2MB")
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, errors.New("头像只支持 PNG、JPEG、GIF 和 WebP 格式")
+	}
+
+	if err := os.MkdirAll(us.avatarDir(), 0755); err != nil {
+		return nil, err
+	}
+	// 文件名带上时间戳，避免客户端缓存旧头像
+	name := user.ID + "-" + time.Now().Format("20060102150405") + ext
+	if err := os.WriteFile(filepath.Join(us.avatarDir(), name), data, 0644); err != nil {
+		return nil, err
+	}
+
+	old := user.Avatar
+	user.Avatar = name
+	user.UpdatedAt = time.Now()
+	if err := us.updateUser(user); err != nil {
+		os.Remove(filepath.Join(us.avatarDir(), name))
+		return nil, err
+	}
+	us.removeAvatar(old)
+	return us.toResponse(user)
+}
+
+// avatarPath 返回用户头像文件路径
+func (us *UserService) avatarPath(userID string) (string, bool) {
+	user, err := us.getUserByID(userID)
+	if err != nil || user.Avatar == "" {
+		return "", false
+	}
+	return filepath.Join(us.avatarDir(), user.Avatar), true
+}
+
+func (us *UserService) removeAvatar(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(us.avatarDir(), filepath.Base(name))); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除头像失败: %v", err)
+	}
+}
+
+// scheduleDeletion 注销账号：吊销所有会话，宽限期后删除账号及其数据，宽限期内重新登录后可以撤销
+func (us *UserService) scheduleDeletion(userID, password string) (*time.Time, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(user, password); err != nil {
+		return nil, err
+	}
+	if err := us.checkLastAdmin(us.db, userID); err != nil {
+		return nil, err
+	}
+
+	at := time.Now().Add(us.deletionGrace())
+	user.DeletionScheduledAt = &at
+	if err := us.updateUser(user); err != nil {
+		return nil, err
+	}
+	if err := us.revokeAllTokens(userID); err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
+
+// cancelDeletion 撤销注销
+func (us *UserService) cancelDeletion(userID string) error {
+	result := us.db.Model(&User{}).
+		Where("id = ? AND deletion_scheduled_at IS NOT NULL", userID).
+		Update("deletion_scheduled_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("账号未申请注销")
+	}
+	return nil
+}
+
+// purgeDeletedUsers 删除已过宽限期的注销账号
+func (us *UserService) purgeDeletedUsers() {
+	var users []User
+	if err := us.db.Where("deletion_scheduled_at < ?", time.Now()).Find(&users).Error; err != nil {
+		log.Printf("查询待注销账号失败: %v", err)
+		return
+	}
+	for _, user := range users {
+		err := us.deleteUserByID(user.ID)
+		us.audit(nil, &AuditEvent{Action: AuditAccountPurge, TargetType: TargetUser, TargetID: user.ID, TargetName: user.Username}, err)
+		if err != nil {
+			log.Printf("删除注销账号失败: %s - %v", user.Username, err)
+			continue
+		}
+		log.Printf("已删除注销账号: %s", user.Username)
+	}
+}
@@ -0,0 +1,359 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"myapp/middleware"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Role 角色，内置角色不能删除，内置管理员角色的权限不能修改
+type Role struct {
+	Name        string    `gorm:"type:varchar(64);primaryKey" json:"name"`
+	Description string    `gorm:"type:varchar(255)" json:"description"`
+	Builtin     bool      `gorm:"not null;default:false" json:"builtin"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	Permissions []string `gorm:"-" json:"permissions"`
+}
+
+// RolePermission 角色拥有的权限
+type RolePermission struct {
+	RoleName   string `gorm:"type:varchar(64);primaryKey"`
+	Permission string `gorm:"type:varchar(64);primaryKey"`
+}
+
+// UserRole 用户拥有的角色
+type UserRole struct {
+	UserID   string `gorm:"type:varchar(255);primaryKey"`
+	RoleName string `gorm:"type:varchar(64);primaryKey;index"`
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+var builtinRoles = []Role{
+	{Name: middleware.RoleAdmin, Description: "管理员", Builtin: true, Permissions: []string{middleware.PermAll}},
+	{Name: middleware.RoleUser, Description: "普通用户", Builtin: true},
+}
+
+// initRoles 创建内置角色、迁移旧的数字角色并加载角色权限
+func (us *UserService) initRoles() error {
+	for _, role := range builtinRoles {
+		var count int64
+		if err := us.db.Model(&Role{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := us.saveRole(us.db, &role); err != nil {
+			return err
+		}
+	}
+
+	if err := us.migrateLegacyRoles(); err != nil {
+		return err
+	}
+	if err := us.bootstrapAdmin(); err != nil {
+		return err
+	}
+
+	var roles []Role
+	if err := us.db.Find(&roles).Error; err != nil {
+		return err
+	}
+	for _, role := range roles {
+		perms, err := us.rolePermissions(role.Name)
+		if err != nil {
+			return err
+		}
+		middleware.SetRolePermissions(role.Name, perms)
+	}
+	middleware.LookupRoles = func(userID string) []string {
+		roles, err := us.userRoles(userID)
+		if err != nil {
+			log.Printf("查询用户角色失败: %v", err)
+		}
+		return roles
+	}
+	return nil
+}
+
+// migrateLegacyRoles 为还没有分配角色的用户按旧的 role 字段分配角色
+func (us *UserService) migrateLegacyRoles() error {
+	var users []User
+	err := us.db.Where("id NOT IN (?)", us.db.Model(&UserRole{}).Select("user_id")).Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		role := middleware.RoleUser
+		if slices.Contains(us.cfg.LegacyAdminRoles, user.Role) {
+			role = middleware.RoleAdmin
+		}
+		if err := us.db.Create(&UserRole{UserID: user.ID, RoleName: role}).Error; err != nil {
+			return err
+		}
+	}
+	if len(users) > 0 {
+		log.Printf("已为 %d 个用户迁移角色", len(users))
+	}
+	return nil
+}
+
+func (us *UserService) rolePermissions(role string) ([]string, error) {
+	perms := []string{}
+	err := us.db.Model(&RolePermission{}).Where("role_name = ?", role).
+		Order("permission ASC").Pluck("permission", &perms).Error
+	return perms, err
+}
+
+func (us *UserService) userRoles(userID string) ([]string, error) {
+	roles := []string{}
+	err := us.db.Model(&UserRole{}).Where("user_id = ?", userID).
+		Order("role_name ASC").Pluck("role_name", &roles).Error
+	return roles, err
+}
+
+func (us *UserService) listRoles() ([]Role, error) {
+	var roles []Role
+	if err := us.db.Order("builtin DESC, name ASC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		perms, err := us.rolePermissions(roles[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		roles[i].Permissions = perms
+	}
+	return roles, nil
+}
+
+func validatePermissions(perms []string) error {
+	for _, perm := range perms {
+		if _, ok := middleware.Permissions[perm]; !ok {
+			return errors.New("未知的权限: " + perm)
+		}
+	}
+	return nil
+}
+
+// saveRole 保存角色及其权限
+func (us *UserService) saveRole(tx *gorm.DB, role *Role) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(role).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_name = ?", role.Name).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+		for _, perm := range role.Permissions {
+			if err := tx.Create(&RolePermission{RoleName: role.Name, Permission: perm}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (us *UserService) createRole(req *RoleRequest) (*Role, error) {
+	if !roleNamePattern.MatchString(req.Name) {
+		return nil, errors.New("角色名只能包含小写字母、数字、下划线和短横线，长度 2~32")
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := us.db.Model(&Role{}).Where("name = ?", req.Name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("角色已存在")
+	}
+
+	role := &Role{Name: req.Name, Description: req.Description, Permissions: uniqueStrings(req.Permissions)}
+	if err := us.saveRole(us.db, role); err != nil {
+		return nil, err
+	}
+	middleware.SetRolePermissions(role.Name, role.Permissions)
+	return role, nil
+}
+
+func (us *UserService) updateRole(name string, req *RoleRequest) (*Role, error) {
+	var role Role
+	if err := us.db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("角色不存在")
+		}
+		return nil, err
+	}
+	if role.Name == middleware.RoleAdmin {
+		return nil, errors.New("不能修改管理员角色")
+	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		return nil, err
+	}
+
+	role.Description = req.Description
+	role.Permissions = uniqueStrings(req.Permissions)
+	if err := us.saveRole(us.db, &role); err != nil {
+		return nil, err
+	}
+	middleware.SetRolePermissions(role.Name, role.Permissions)
+	return &role, nil
+}
+
+func (us *UserService) deleteRole(name string) error {
+	var role Role
+	if err := us.db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("角色不存在")
+		}
+		return err
+	}
+	if role.Builtin {
+		return errors.New("不能删除内置角色")
+	}
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_name = ?", name).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_name = ?", name).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		return err
+	}
+	middleware.RemoveRole(name)
+	return nil
+}
+
+// setUserRoles 替换用户的角色，之前签发的访问令牌随即失效，客户端刷新后获得新角色
+func (us *UserService) setUserRoles(userID string, roles []string) ([]string, error) {
+	if _, err := us.getUserByID(userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("用户不存在")
+		}
+		return nil, err
+	}
+	roles = uniqueStrings(roles)
+	if len(roles) == 0 {
+		return nil, errors.New("至少需要一个角色")
+	}
+	var count int64
+	if err := us.db.Model(&Role{}).Where("name IN ?", roles).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(roles) {
+		return nil, errors.New("角色不存在")
+	}
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		// 不能移除最后一个管理员
+		if !slices.Contains(roles, middleware.RoleAdmin) {
+			if err := us.checkLastAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if err := tx.Create(&UserRole{UserID: userID, RoleName: role}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.revokeAccessTokens(userID); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// bootstrapAdmin 系统中还没有管理员时，将配置的 ADMIN_USERNAME 设为管理员，
+// 该用户不存在时使用 ADMIN_PASSWORD 创建；注册和单点登录创建的用户不会自动成为管理员
+func (us *UserService) bootstrapAdmin() error {
+	noAdmin, err := us.noAdmin(us.db)
+	if err != nil || !noAdmin {
+		return err
+	}
+	username := strings.TrimSpace(us.cfg.BootstrapAdmin)
+	if username == "" {
+		log.Printf("警告: 系统中还没有管理员，请设置 ADMIN_USERNAME 和 ADMIN_PASSWORD 后重启")
+		return nil
+	}
+
+	return us.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Where("username = ?", username).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if us.cfg.BootstrapAdminPassword == "" {
+				return fmt.Errorf("管理员 %s 不存在，需要设置 ADMIN_PASSWORD", username)
+			}
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(us.cfg.BootstrapAdminPassword), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			user = User{
+				ID:        uuid.New().String(),
+				Username:  username,
+				Password:  string(hashedPassword),
+				Active:    true,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			log.Printf("已创建管理员 %s", username)
+		} else if err != nil {
+			return err
+		}
+		// 已有的用户保留原来的角色，再加上管理员角色
+		err = tx.Where(UserRole{UserID: user.ID, RoleName: middleware.RoleAdmin}).
+			FirstOrCreate(&UserRole{UserID: user.ID, RoleName: middleware.RoleAdmin}).Error
+		if err != nil {
+			return err
+		}
+		log.Printf("已将 %s 设为管理员", username)
+		return nil
+	})
+}
+
+// assignDefaultRole 新用户的角色，管理员只能由 bootstrapAdmin 或其他管理员指定
+func (us *UserService) assignDefaultRole(tx *gorm.DB, userID string) error {
+	return tx.Create(&UserRole{UserID: userID, RoleName: middleware.RoleUser}).Error
+}
+
+func uniqueStrings(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != "" && !slices.Contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
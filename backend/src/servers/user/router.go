@@ -1,18 +1,94 @@
 package user
 
-import "myapp/middleware"
+import (
+	"myapp/middleware"
+	"net/http"
+)
 
 func (us *UserService) RegisterRouters() {
 	userGroup := us.rg
 
 	userGroup.POST("/register", us.RegisterUser)
+	userGroup.GET("/register/config", us.GetRegistrationConfig) // 注册策略
 	userGroup.POST("/login", us.Login)
+	userGroup.POST("/login/2fa", us.LoginTwoFactor) // 两步验证
+	userGroup.POST("/refresh", us.RefreshToken)     // 刷新访问令牌
+	userGroup.GET("/avatar/:id", us.GetAvatar)      // 用户头像
+
+	// OpenID Connect 登录
+	userGroup.GET("/oidc/config", us.GetOIDCConfig)  // 是否启用及显示名称
+	userGroup.GET("/oidc/login", us.OIDCLogin)       // 跳转到身份提供方
+	userGroup.GET("/oidc/callback", us.OIDCCallback) // 身份提供方回调
+
+	// 邮箱验证和找回密码
+	userGroup.POST("/email/verify", us.VerifyEmail)              // 验证邮箱
+	userGroup.POST("/email/verification", us.ResendVerification) // 按邮箱重新发送验证邮件
+	userGroup.POST("/password/forgot", us.ForgotPassword)        // 发送重置密码邮件
+	userGroup.POST("/password/reset", us.ResetPassword)          // 重置密码
+
+	// 管理员重置密码后，修改密码前只能查看自己的信息、修改密码和退出登录
+	middleware.AllowBeforePasswordChange(http.MethodGet, userGroup.BasePath()+"/me")
+	middleware.AllowBeforePasswordChange(http.MethodPut, userGroup.BasePath()+"/me/password")
+	middleware.AllowBeforePasswordChange(http.MethodPost, userGroup.BasePath()+"/logout")
 
 	authGroup := userGroup.Use(middleware.AuthMiddleware())
+	// 账号安全相关的操作只允许登录会话进行，不接受 API 密钥
+	sessionOnly := middleware.RejectAPIKey()
 	authGroup.GET("/me", us.GetUserProfile)
+	authGroup.PUT("/me", sessionOnly, us.UpdateProfile)                               // 修改用户名和邮箱
+	authGroup.PUT("/me/password", sessionOnly, us.ChangePassword)                     // 修改密码
+	authGroup.POST("/me/avatar", us.UploadAvatar)                                     // 上传头像
+	authGroup.POST("/me/email/verification", us.SendVerification)                     // 发送验证邮件
+	authGroup.DELETE("/me", sessionOnly, us.DeleteAccount)                            // 注销账号
+	authGroup.DELETE("/me/deletion", sessionOnly, us.CancelDeletion)                  // 撤销注销
+	authGroup.POST("/me/2fa/setup", sessionOnly, us.SetupTwoFactor)                   // 生成两步验证密钥
+	authGroup.POST("/me/2fa/enable", sessionOnly, us.EnableTwoFactor)                 // 开启两步验证
+	authGroup.POST("/me/2fa/disable", sessionOnly, us.DisableTwoFactor)               // 关闭两步验证
+	authGroup.GET("/me/2fa/recovery-codes", us.GetRecoveryCodes)                      // 剩余恢复码数量
+	authGroup.POST("/me/2fa/recovery-codes", sessionOnly, us.RegenerateRecoveryCodes) // 重新生成恢复码
+	authGroup.GET("/me/api-keys/scopes", us.GetAPIKeyScopes)                          // 可选的密钥范围
+	authGroup.GET("/me/api-keys", sessionOnly, us.ListAPIKeys)                        // API 密钥列表
+	authGroup.POST("/me/api-keys", sessionOnly, us.CreateAPIKey)                      // 创建 API 密钥
+	authGroup.DELETE("/me/api-keys/:id", sessionOnly, us.RevokeAPIKey)                // 吊销 API 密钥
+	authGroup.GET("/me/sessions", sessionOnly, us.ListSessions)                       // 登录会话列表
+	authGroup.DELETE("/me/sessions/:id", sessionOnly, us.RevokeSession)               // 退出指定会话
+	authGroup.POST("/logout", us.Logout)                                              // 退出当前会话
+	authGroup.POST("/logout-all", sessionOnly, us.LogoutAll)                          // 退出所有设备
+
+	adminGroup := userGroup.Group("/admin")
+
+	// 用户管理
+	userAdmin := adminGroup.Group("/users", middleware.RequirePermission(middleware.PermUsersManage))
+	userAdmin.GET("", us.ListUsers)                             // 用户列表
+	userAdmin.GET("/:id", us.GetUser)                           // 用户详情
+	userAdmin.PUT("/:id/active", us.SetUserActive)              // 启用/停用
+	userAdmin.POST("/:id/password-reset", us.ResetUserPassword) // 强制重置密码
+	userAdmin.POST("/:id/unlock", us.UnlockUser)                // 解除登录锁定
+	userAdmin.DELETE("/:id/2fa", us.ResetUserTwoFactor)         // 重置两步验证
+	userAdmin.DELETE("/:id", us.DeleteUser)                     // 删除用户及其数据
+
+	// 登录失败被封禁的 IP
+	ipAdmin := adminGroup.Group("/blocked-ips", middleware.RequirePermission(middleware.PermUsersManage))
+	ipAdmin.GET("", us.ListBlockedIPs)   // 封禁列表
+	ipAdmin.DELETE("/:ip", us.UnblockIP) // 解除封禁
+
+	// 邀请码
+	inviteAdmin := adminGroup.Group("/invites", middleware.RequirePermission(middleware.PermUsersManage))
+	inviteAdmin.GET("", us.ListInvites)         // 邀请码列表
+	inviteAdmin.POST("", us.CreateInvite)       // 创建邀请码
+	inviteAdmin.DELETE("/:id", us.RevokeInvite) // 作废邀请码
 
-	// userGroup.GET("/:id", us.GetUser)
-	// userGroup.PUT("/:id", us.UpdateUser)
-	// userGroup.DELETE("/:id", us.DeleteUser)
+	// 审计日志
+	auditAdmin := adminGroup.Group("/audit", middleware.RequirePermission(middleware.PermAuditRead))
+	auditAdmin.GET("", us.ListAuditEvents)          // 分页查询
+	auditAdmin.GET("/export", us.ExportAuditEvents) // 导出为 JSON Lines
 
+	// 角色管理
+	roleAdmin := adminGroup.Group("", middleware.RequirePermission(middleware.PermRolesManage))
+	roleAdmin.GET("/permissions", us.GetPermissions)   // 可分配的权限
+	roleAdmin.GET("/roles", us.GetRoles)               // 角色列表
+	roleAdmin.POST("/roles", us.CreateRole)            // 创建角色
+	roleAdmin.PUT("/roles/:name", us.UpdateRole)       // 修改角色权限
+	roleAdmin.DELETE("/roles/:name", us.DeleteRole)    // 删除角色
+	roleAdmin.PUT("/users/:id/roles", us.SetUserRoles) // 分配角色
 }
@@ -1,40 +1,122 @@
 package user
 
 import (
+	"context"
+	"fmt"
 	logger "myapp/log"
+	"myapp/middleware"
+	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type UserConfig struct {
-	// Add configuration fields here
+	AccessTokenTTL  time.Duration // 访问令牌有效期
+	RefreshTokenTTL time.Duration // 刷新令牌有效期，每次刷新后重新计算
+
+	// 邮件发送和邮箱验证
+	Mail                     MailConfig
+	AppURL                   string // 前端地址，用于生成邮件中的链接
+	RequireEmailVerification bool   // 未验证邮箱的用户不能登录
+
+	TOTPIssuer string // 两步验证应用中显示的服务名称
+
+	RegistrationMode string // 注册策略：open、invite 或 closed
+
+	AuditRetention time.Duration // 审计日志保留时长，0 表示永久保留
+
+	OIDC OIDCConfig // OpenID Connect 登录
+
+	// 登录失败锁定
+	LoginMaxAttempts   int           // 账号连续失败多少次后锁定
+	LoginIPMaxAttempts int           // 同一 IP 连续失败多少次后锁定
+	LoginLockout       time.Duration // 首次锁定时长，之后每次失败翻倍
+	LoginLockoutMax    time.Duration // 锁定时长上限
+
+	AvatarDir           string        // 头像存放目录
+	DeletionGracePeriod time.Duration // 注销账号的宽限期
+
+	// 旧版数字角色中属于管理员的角色编号，首次启动时迁移为 admin 角色
+	LegacyAdminRoles []int32
+
+	// 系统中还没有管理员时，启动时将该用户设为管理员，用户不存在时使用密码创建
+	BootstrapAdmin         string
+	BootstrapAdminPassword string
 }
 
 type UserService struct {
 	// Add service methods here
+	ctx context.Context
 	cfg *UserConfig
 	db  *gorm.DB
 	rg  *gin.RouterGroup
+
+	mailer        Mailer
+	oidc          *oidcClient // 未配置时为 nil
+	guard         *loginGuard
+	mailTemplates map[string]*template.Template
+
+	deleteHooks []UserDeleteHook
 }
 
-func NewUserService(cfg *UserConfig, db *gorm.DB, r *gin.Engine) *UserService {
-	err := db.AutoMigrate(&User{})
+func NewUserService(ctx context.Context, cfg *UserConfig, db *gorm.DB, r *gin.Engine) *UserService {
+	err := db.AutoMigrate(&User{}, &RefreshToken{}, &TokenRevocation{}, &Role{}, &RolePermission{}, &UserRole{}, &EmailToken{}, &RecoveryCode{}, &OIDCIdentity{}, &APIKey{}, &Session{}, &Invite{}, &AuditEvent{})
 	if err != nil {
-		logger.ZError(nil, "数据库自动迁移失败", err)
+		logger.ZError(&ctx, "数据库自动迁移失败", err)
+		return nil
+	}
+
+	if !validRegistrationMode(cfg.RegistrationMode) {
+		logger.ZError(&ctx, "注册策略配置错误", fmt.Errorf("未知的注册策略: %s", cfg.RegistrationMode))
+		return nil
+	}
+
+	mailer, err := newMailer(&cfg.Mail)
+	if err != nil {
+		logger.ZError(&ctx, "初始化邮件发送失败", err)
+		return nil
+	}
+	templates, err := loadMailTemplates(cfg.Mail.TemplateDir)
+	if err != nil {
+		logger.ZError(&ctx, "加载邮件模板失败", err)
 		return nil
 	}
 
 	rg := r.Group("/user")
 
-	return &UserService{
+	us := &UserService{
+		ctx: ctx,
 		cfg: cfg,
 		db:  db,
 		rg:  rg,
+
+		mailer:        mailer,
+		mailTemplates: templates,
+		oidc:          newOIDCClient(&cfg.OIDC),
+		guard:         newLoginGuard(),
 	}
+	if err := us.initRoles(); err != nil {
+		logger.ZError(&ctx, "初始化角色失败", err)
+		return nil
+	}
+	if err := us.loadRevocations(); err != nil {
+		logger.ZError(&ctx, "加载令牌吊销列表失败", err)
+		return nil
+	}
+	if err := us.backfillSessions(); err != nil {
+		logger.ZError(&ctx, "补建登录会话失败", err)
+		return nil
+	}
+	middleware.ValidateAPIKey = us.validateAPIKey
+	middleware.Audit = us.recordAudit
+	return us
 }
 
 func (us *UserService) Start() {
 	// Implementation for retrieving user by ID
 	us.RegisterRouters()
+
+	go us.purgeLoop()
 }
@@ -0,0 +1,81 @@
+package user
+
+import (
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// newTestService 返回使用 sqlmock 的用户服务，测试按顺序声明预期执行的 SQL
+func newTestService(t *testing.T, cfg *UserConfig) (*UserService, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg == nil {
+		cfg = &UserConfig{}
+	}
+	return &UserService{cfg: cfg, db: db, guard: newLoginGuard()}, mock
+}
+
+// expectExec 声明一条在默认事务中执行的写语句
+func expectExec(mock sqlmock.Sqlmock, query string, result driver.Result) {
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WillReturnResult(result)
+	mock.ExpectCommit()
+}
+
+func checkExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// argRecorder 记录 SQL 参数，用于检查列数较多的写语句
+type argRecorder struct {
+	values *[]driver.Value
+}
+
+func (r argRecorder) Match(v driver.Value) bool {
+	*r.values = append(*r.values, v)
+	return true
+}
+
+// recordArgs 返回 n 个参数匹配器，依次把参数记录到 values 中
+func recordArgs(values *[]driver.Value, n int) []driver.Value {
+	args := make([]driver.Value, n)
+	for i := range args {
+		args[i] = argRecorder{values}
+	}
+	return args
+}
+
+// modelColumns 返回 gorm 写入模型时的列顺序，Save 更新时不包含主键
+func modelColumns(t *testing.T, model interface{}, withPrimaryKey bool) []string {
+	t.Helper()
+	s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{}
+	for _, field := range s.Fields {
+		if field.DBName != "" && (withPrimaryKey || !field.PrimaryKey) {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 登录会话
+// 每次登录开始一个会话，会话 ID 即刷新令牌族 ID，访问令牌中的 sid 也指向它。
+// 刷新令牌时更新会话的最近活动时间、IP 和 User-Agent，因此最近活动时间的精度为访问令牌的有效期。
+
+// Session 登录会话，供用户查看登录过的设备并单独退出
+type Session struct {
+	ID         string     `gorm:"type:varchar(64);primaryKey" json:"id"`
+	UserID     string     `gorm:"type:varchar(255);not null;index" json:"-"`
+	UserAgent  string     `gorm:"type:varchar(512)" json:"user_agent"`
+	IP         string     `gorm:"type:varchar(64)" json:"ip"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"` // 当前刷新令牌的过期时间
+	RevokedAt  *time.Time `json:"-"`
+
+	Device  string `gorm:"-" json:"device"`  // 从 User-Agent 解析的设备描述
+	Current bool   `gorm:"-" json:"current"` // 是否为发起请求的会话
+}
+
+// createSession 登录时创建会话
+func createSession(tx *gorm.DB, familyID, userID string, client *ClientInfo, now, expiresAt time.Time) error {
+	session := Session{
+		ID:         familyID,
+		UserID:     userID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	}
+	if client != nil {
+		session.UserAgent = truncate(client.UserAgent, 512)
+		session.IP = truncate(client.IP, 64)
+	}
+	return tx.Create(&session).Error
+}
+
+// touchSession 刷新令牌时更新会话的活动信息
+func touchSession(tx *gorm.DB, familyID string, client *ClientInfo, now, expiresAt time.Time) error {
+	updates := map[string]interface{}{"last_seen_at": now, "expires_at": expiresAt}
+	if client != nil {
+		updates["user_agent"] = truncate(client.UserAgent, 512)
+		updates["ip"] = truncate(client.IP, 64)
+	}
+	return tx.Model(&Session{}).Where("id = ?", familyID).Updates(updates).Error
+}
+
+// listSessions 用户当前有效的会话，最近活动的在前
+func (us *UserService) listSessions(userID, currentID string) ([]Session, error) {
+	sessions := []Session{}
+	err := us.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Device = describeDevice(sessions[i].UserAgent)
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// revokeUserSession 用户退出指定会话，该会话的刷新令牌和访问令牌立即失效
+func (us *UserService) revokeUserSession(userID, sessionID string) error {
+	var count int64
+	err := us.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("会话不存在")
+	}
+	return us.revokeSession(userID, sessionID)
+}
+
+// backfillSessions 为升级前已登录、还没有会话记录的令牌族补建会话
+func (us *UserService) backfillSessions() error {
+	var tokens []RefreshToken
+	err := us.db.Where("used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Where("family_id NOT IN (?)", us.db.Model(&Session{}).Select("id")).
+		Find(&tokens).Error
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		session := Session{
+			ID:         token.FamilyID,
+			UserID:     token.UserID,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+			CreatedAt:  token.CreatedAt,
+			LastSeenAt: token.CreatedAt,
+			ExpiresAt:  token.ExpiresAt,
+		}
+		if err := us.db.Create(&session).Error; err != nil {
+			return err
+		}
+	}
+	if len(tokens) > 0 {
+		log.Printf("已为 %d 个登录会话补建记录", len(tokens))
+	}
+	return nil
+}
+
+var (
+	browserPatterns = []struct {
+		name string
+		re   *regexp.Regexp
+	}{
+		{"Edge", regexp.MustCompile(`Edg(e|A|iOS)?/`)},
+		{"Opera", regexp.MustCompile(`OPR/`)},
+		{"Firefox", regexp.MustCompile(`(Firefox|FxiOS)/`)},
+		{"Chrome", regexp.MustCompile(`(Chrome|CriOS)/`)},
+		{"Safari", regexp.MustCompile(`Safari/`)},
+	}
+	osPatterns = []struct {
+		name string
+		re   *regexp.Regexp
+	}{
+		{"Windows", regexp.MustCompile(`Windows`)},
+		{"Android", regexp.MustCompile(`Android`)},
+		{"iOS", regexp.MustCompile(`iPhone|iPad|iPod`)},
+		{"macOS", regexp.MustCompile(`Mac OS X|Macintosh`)},
+		{"Linux", regexp.MustCompile(`Linux`)},
+	}
+	productPattern = regexp.MustCompile(`^[\w.-]+`)
+)
+
+// describeDevice 将 User-Agent 简化为“浏览器 / 系统”形式，非浏览器客户端取产品名
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "未知设备"
+	}
+	var browser, platform string
+	for _, p := range browserPatterns {
+		if p.re.MatchString(userAgent) {
+			browser = p.name
+			break
+		}
+	}
+	for _, p := range osPatterns {
+		if p.re.MatchString(userAgent) {
+			platform = p.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " / " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	if product := productPattern.FindString(userAgent); product != "" {
+		return product
+	}
+	return "未知设备"
+}
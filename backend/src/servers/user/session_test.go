@@ -0,0 +1,96 @@
+package user
+
+import (
+	"myapp/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDescribeDevice(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0":                   "Edge / Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1": "Safari / iOS",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                                  "Firefox / Linux",
+		"curl/8.4.0": "curl",
+		"":           "未知设备",
+	}
+	for ua, want := range cases {
+		if got := describeDevice(ua); got != want {
+			t.Errorf("describeDevice(%q) = %q，应为 %q", ua, got, want)
+		}
+	}
+}
+
+func TestListSessionsMarksCurrent(t *testing.T) {
+	us, mock := newTestService(t, nil)
+	now := time.Now()
+	mock.ExpectQuery("SELECT \\* FROM `sessions` WHERE user_id = \\? AND revoked_at IS NULL AND expires_at > \\? ORDER BY last_seen_at DESC").
+		WithArgs("u3", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "user_agent", "last_seen_at", "expires_at"}).
+			AddRow("phone-session", "u3", "Mozilla/5.0 (Linux; Android 14) Chrome/120.0 Mobile", now, now.Add(time.Hour)).
+			AddRow("laptop-session", "u3", "curl/8.4.0", now.Add(-time.Hour), now.Add(time.Hour)))
+
+	sessions, err := us.listSessions("u3", "laptop-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Current || !sessions[1].Current || sessions[0].Device != "Chrome / Android" {
+		t.Errorf("会话列表不正确: %+v", sessions)
+	}
+	checkExpectations(t, mock)
+}
+
+// 退出指定会话只影响该会话，不能退出其他用户的会话
+func TestRevokeSessionEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	laptop := signAccessToken(t, "u3", "laptop-jti", "laptop-session")
+	phone := signAccessToken(t, "u3", "phone-jti", "phone-session")
+
+	r := gin.New()
+	r.DELETE("/user/me/sessions/:id", middleware.AuthMiddleware(), us.RevokeSession)
+	revoke := func(sessionID string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/user/me/sessions/"+sessionID, nil)
+		req.Header.Set("Authorization", "Bearer "+laptop)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	countSession := func(sessionID string, count int) {
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM `sessions` WHERE id = \\? AND user_id = \\? AND revoked_at IS NULL").
+			WithArgs(sessionID, "u3").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	}
+	expectAudit := func() {
+		mock.ExpectQuery("SELECT `username` FROM `users` WHERE id = \\?").
+			WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("carol"))
+		expectExec(mock, "INSERT INTO `audit_events`", sqlmock.NewResult(1, 1))
+	}
+
+	// 其他用户的会话或已退出的会话
+	countSession("someone-else-session", 0)
+	expectAudit()
+	if code := revoke("someone-else-session"); code != http.StatusNotFound {
+		t.Errorf("不存在的会话应返回 404，实际为 %d", code)
+	}
+
+	countSession("phone-session", 1)
+	expectRevokeSession(mock, "u3", "phone-session")
+	expectAudit()
+	if code := revoke("phone-session"); code != http.StatusOK {
+		t.Fatalf("退出会话失败: %d", code)
+	}
+	checkExpectations(t, mock)
+
+	if authStatus(phone) != http.StatusUnauthorized {
+		t.Error("被退出的会话的访问令牌应失效")
+	}
+	if authStatus(laptop) != http.StatusOK {
+		t.Error("当前会话不应受影响")
+	}
+}
@@ -0,0 +1,332 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"myapp/middleware"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 登录后签发短期访问令牌（JWT）和刷新令牌。
+// 刷新令牌只保存哈希，每次使用后轮换；同一次登录产生的刷新令牌属于同一个令牌族（会话），
+// 已使用过的刷新令牌再次出现说明令牌可能被盗用，整个令牌族立即失效。
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// 旧版登录令牌的有效期，用户级吊销条目至少保留这么久
+	legacyTokenTTL = 72 * time.Hour
+
+	revocationPurgeInterval = time.Hour
+)
+
+var (
+	ErrRefreshInvalid = errors.New("刷新令牌无效")
+	ErrRefreshExpired = errors.New("刷新令牌已过期")
+	ErrRefreshReused  = errors.New("刷新令牌已被使用，该会话已失效，请重新登录")
+)
+
+// refreshReusedError 刷新令牌被重复使用，携带被吊销的会话供审计记录
+type refreshReusedError struct {
+	UserID    string
+	SessionID string
+}
+
+func (e *refreshReusedError) Error() string { return ErrRefreshReused.Error() }
+
+func (e *refreshReusedError) Unwrap() error { return ErrRefreshReused }
+
+// RefreshToken 刷新令牌，ID 为令牌的 SHA-256 哈希
+type RefreshToken struct {
+	ID        string     `gorm:"type:varchar(64);primaryKey" json:"-"`
+	FamilyID  string     `gorm:"type:varchar(64);not null;index" json:"family_id"`
+	UserID    string     `gorm:"type:varchar(255);not null;index" json:"user_id"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	UserAgent string     `gorm:"type:varchar(512)" json:"user_agent"`
+	IP        string     `gorm:"type:varchar(64)" json:"ip"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TokenRevocation 持久化的吊销列表，启动时加载到中间件
+type TokenRevocation struct {
+	ID        uint      `gorm:"primaryKey"`
+	Kind      string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_token_revocation"`
+	Key       string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_token_revocation"`
+	At        time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TokenPair 登录和刷新接口返回的令牌
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问令牌有效期（秒）
+}
+
+// ClientInfo 签发令牌时记录的客户端信息
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func (us *UserService) accessTokenTTL() time.Duration {
+	if us.cfg.AccessTokenTTL > 0 {
+		return us.cfg.AccessTokenTTL
+	}
+	return defaultAccessTokenTTL
+}
+
+func (us *UserService) refreshTokenTTL() time.Duration {
+	if us.cfg.RefreshTokenTTL > 0 {
+		return us.cfg.RefreshTokenTTL
+	}
+	return defaultRefreshTokenTTL
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens 签发访问令牌和刷新令牌，familyID 为空时开始新的会话
+func (us *UserService) issueTokens(tx *gorm.DB, userID, familyID string, client *ClientInfo) (*TokenPair, error) {
+	newSession := familyID == ""
+	if newSession {
+		familyID = uuid.New().String()
+	}
+	roles, err := us.userRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	var mustChange []bool
+	if err := tx.Model(&User{}).Where("id = ?", userID).Pluck("must_change_password", &mustChange).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"typ":     "access",
+		"user_id": userID,
+		"roles":   roles,
+		"jti":     uuid.New().String(),
+		"sid":     familyID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(us.accessTokenTTL()).Unix(),
+	}
+	// 管理员重置密码后，修改密码前的令牌只能用于修改密码
+	if len(mustChange) > 0 && mustChange[0] {
+		claims["pwd_change"] = true
+	}
+	access := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	accessStr, err := access.SignedString([]byte(middleware.JWTSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	refreshStr := base64.RawURLEncoding.EncodeToString(buf)
+	refresh := RefreshToken{
+		ID:        hashRefreshToken(refreshStr),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: now.Add(us.refreshTokenTTL()),
+	}
+	if client != nil {
+		refresh.UserAgent = truncate(client.UserAgent, 512)
+		refresh.IP = truncate(client.IP, 64)
+	}
+	if err := tx.Create(&refresh).Error; err != nil {
+		return nil, err
+	}
+	if newSession {
+		err = createSession(tx, familyID, userID, client, now, refresh.ExpiresAt)
+	} else {
+		err = touchSession(tx, familyID, client, now, refresh.ExpiresAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessStr,
+		RefreshToken: refreshStr,
+		ExpiresIn:    int64(us.accessTokenTTL().Seconds()),
+	}, nil
+}
+
+// refresh 使用刷新令牌换取新的令牌，旧刷新令牌随即失效
+func (us *UserService) refresh(token string, client *ClientInfo) (*TokenPair, error) {
+	var pair *TokenPair
+	var reused *RefreshToken
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var current RefreshToken
+		if err := tx.Where("id = ?", hashRefreshToken(token)).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRefreshInvalid
+			}
+			return err
+		}
+		if current.UsedAt != nil || current.RevokedAt != nil {
+			reused = &current
+			return ErrRefreshReused
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrRefreshExpired
+		}
+
+		user, err := us.getUserByID(current.UserID)
+		if err != nil || !user.Active {
+			return ErrRefreshInvalid
+		}
+
+		// 条件更新保证并发请求中只有一个能使用该令牌
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", current.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = &current
+			return ErrRefreshReused
+		}
+
+		pair, err = us.issueTokens(tx, current.UserID, current.FamilyID, client)
+		return err
+	})
+
+	if reused != nil {
+		log.Printf("检测到刷新令牌重用，吊销会话 %s（用户 %s）", reused.FamilyID, reused.UserID)
+		if err := us.revokeSession(reused.UserID, reused.FamilyID); err != nil {
+			log.Printf("吊销会话失败: %v", err)
+		}
+		return nil, &refreshReusedError{UserID: reused.UserID, SessionID: reused.FamilyID}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// revokeSession 吊销整个令牌族及其签发的访问令牌
+func (us *UserService) revokeSession(userID, familyID string) error {
+	now := time.Now()
+	err := us.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	err = us.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", familyID, userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	return us.revoke(middleware.RevokeKindSession, familyID, now, now.Add(us.accessTokenTTL()))
+}
+
+// revokeAccessToken 吊销单个访问令牌，直到其过期
+func (us *UserService) revokeAccessToken(jti string, expiresAt time.Time) error {
+	return us.revoke(middleware.RevokeKindToken, jti, time.Now(), expiresAt)
+}
+
+// revokeAllTokens 吊销用户所有会话和此前签发的全部令牌（退出所有设备）
+func (us *UserService) revokeAllTokens(userID string) error {
+	now := time.Now()
+	err := us.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	err = us.db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	return us.revokeAccessTokens(userID)
+}
+
+// revokeAccessTokens 使用户此前签发的访问令牌失效，刷新令牌不受影响，
+// 用于角色变更后让客户端重新获取携带新角色的令牌
+func (us *UserService) revokeAccessTokens(userID string) error {
+	// 令牌签发时间精确到秒，截断后吊销之后立即签发的新令牌仍然有效
+	now := time.Now().Truncate(time.Second)
+	return us.revoke(middleware.RevokeKindUser, userID, now, now.Add(max(us.accessTokenTTL(), legacyTokenTTL)))
+}
+
+// revoke 写入吊销列表并同步到中间件
+func (us *UserService) revoke(kind, key string, at, expiresAt time.Time) error {
+	entry := TokenRevocation{Kind: kind, Key: key, At: at, ExpiresAt: expiresAt}
+	err := us.db.Where(TokenRevocation{Kind: kind, Key: key}).
+		Assign(TokenRevocation{At: at, ExpiresAt: expiresAt}).
+		FirstOrCreate(&entry).Error
+	if err != nil {
+		return err
+	}
+	middleware.Revoke(kind, key, at, expiresAt)
+	return nil
+}
+
+// loadRevocations 启动时将未过期的吊销条目加载到中间件
+func (us *UserService) loadRevocations() error {
+	var entries []TokenRevocation
+	if err := us.db.Where("expires_at > ?", time.Now()).Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		middleware.Revoke(entry.Kind, entry.Key, entry.At, entry.ExpiresAt)
+	}
+	return nil
+}
+
+// purgeLoop 定期清理过期的吊销条目、刷新令牌、登录会话、邮件令牌、已过宽限期的注销账号和过期的审计日志
+func (us *UserService) purgeLoop() {
+	ticker := time.NewTicker(revocationPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-us.ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			middleware.PurgeRevocations(now)
+			if err := us.db.Where("expires_at < ?", now).Delete(&TokenRevocation{}).Error; err != nil {
+				log.Printf("清理令牌吊销列表失败: %v", err)
+			}
+			// 过期的刷新令牌保留一段时间，便于识别重用
+			if err := us.db.Where("expires_at < ?", now.Add(-us.refreshTokenTTL())).Delete(&RefreshToken{}).Error; err != nil {
+				log.Printf("清理刷新令牌失败: %v", err)
+			}
+			if err := us.db.Where("expires_at < ?", now.Add(-us.refreshTokenTTL())).Delete(&Session{}).Error; err != nil {
+				log.Printf("清理登录会话失败: %v", err)
+			}
+			us.purgeDeletedUsers()
+			us.purgeAuditEvents()
+		}
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
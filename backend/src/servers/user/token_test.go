@@ -0,0 +1,199 @@
+package user
+
+import (
+	"database/sql/driver"
+	"errors"
+	"myapp/middleware"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var refreshColumns = []string{"id", "family_id", "user_id", "expires_at", "used_at", "revoked_at"}
+
+// signAccessToken 签发测试用的访问令牌
+func signAccessToken(t *testing.T, userID, jti, sid string) string {
+	t.Helper()
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":     "access",
+		"user_id": userID,
+		"jti":     jti,
+		"sid":     sid,
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Minute).Unix(),
+	}).SignedString([]byte(middleware.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authStatus 使用访问令牌请求需要登录的接口，返回状态码
+func authStatus(token string) int {
+	r := gin.New()
+	r.GET("/protected", middleware.AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+// expectRevokeSession 吊销令牌族、会话，并写入会话级吊销条目
+func expectRevokeSession(mock sqlmock.Sqlmock, userID, familyID string) {
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `refresh_tokens` SET `revoked_at`=\\? WHERE user_id = \\? AND family_id = \\? AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), userID, familyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `sessions` SET `revoked_at`=\\? WHERE id = \\? AND user_id = \\? AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), familyID, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectRevocation(mock, middleware.RevokeKindSession, familyID)
+}
+
+func expectRevocation(mock sqlmock.Sqlmock, kind, key string) {
+	mock.ExpectQuery("SELECT \\* FROM `token_revocations` WHERE `token_revocations`.`kind` = \\? AND `token_revocations`.`key` = \\?").
+		WithArgs(kind, key, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectExec(mock, "INSERT INTO `token_revocations`", sqlmock.NewResult(1, 1))
+}
+
+// 刷新后旧刷新令牌标记为已使用，新令牌属于同一令牌族
+func TestRefreshRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	old := "old-refresh-token"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `refresh_tokens` WHERE id = \\?").
+		WithArgs(hashRefreshToken(old), 1).
+		WillReturnRows(sqlmock.NewRows(refreshColumns).AddRow(hashRefreshToken(old), "rotate-family", "u1", time.Now().Add(time.Hour), nil, nil))
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "active"}).AddRow("u1", "alice", true))
+	mock.ExpectExec("UPDATE `refresh_tokens` SET `used_at`=\\? WHERE id = \\? AND used_at IS NULL AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), hashRefreshToken(old)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT `role_name` FROM `user_roles`").
+		WillReturnRows(sqlmock.NewRows([]string{"role_name"}).AddRow(middleware.RoleUser))
+	mock.ExpectQuery("SELECT `must_change_password` FROM `users`").
+		WillReturnRows(sqlmock.NewRows([]string{"must_change_password"}).AddRow(false))
+	columns := modelColumns(t, &RefreshToken{}, true)
+	inserted := []driver.Value{}
+	mock.ExpectExec("INSERT INTO `refresh_tokens`").
+		WithArgs(recordArgs(&inserted, len(columns))...).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `sessions` SET .* WHERE id = \\?").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pair, err := us.refresh(old, &ClientInfo{UserAgent: "test", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpectations(t, mock)
+	if pair.RefreshToken == "" || pair.RefreshToken == old {
+		t.Fatal("应签发新的刷新令牌")
+	}
+	if len(inserted) != len(columns) ||
+		inserted[slices.Index(columns, "id")] != hashRefreshToken(pair.RefreshToken) ||
+		inserted[slices.Index(columns, "family_id")] != "rotate-family" {
+		t.Errorf("新刷新令牌应保存哈希并沿用令牌族: %v", inserted)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(pair.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(middleware.JWTSecret), nil
+	}); err != nil || claims["sid"] != "rotate-family" {
+		t.Errorf("访问令牌应属于原会话: %v %v", claims, err)
+	}
+	if authStatus(pair.AccessToken) != http.StatusOK {
+		t.Error("新访问令牌应可以使用")
+	}
+}
+
+// 已使用的刷新令牌再次出现时吊销整个令牌族，该会话签发的访问令牌随即失效
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		family string
+		usedAt interface{}
+		raced  bool // 读取时未使用，条件更新时已被并发请求使用
+	}{
+		{"重放已轮换的令牌", "reuse-family", time.Now().Add(-time.Minute), false},
+		{"并发使用同一令牌", "race-family", nil, true},
+	}
+	for _, tc := range cases {
+		us, mock := newTestService(t, nil)
+		token := "refresh-" + tc.family
+		access := signAccessToken(t, "u1", "jti-"+tc.family, tc.family)
+		if authStatus(access) != http.StatusOK {
+			t.Fatalf("%s: 吊销前访问令牌应有效", tc.name)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT \\* FROM `refresh_tokens` WHERE id = \\?").
+			WillReturnRows(sqlmock.NewRows(refreshColumns).AddRow(hashRefreshToken(token), tc.family, "u1", time.Now().Add(time.Hour), tc.usedAt, nil))
+		if tc.raced {
+			mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = \\?").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "active"}).AddRow("u1", "alice", true))
+			mock.ExpectExec("UPDATE `refresh_tokens` SET `used_at`=\\?").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectRollback()
+		expectRevokeSession(mock, "u1", tc.family)
+
+		_, err := us.refresh(token, nil)
+		var reused *refreshReusedError
+		if !errors.As(err, &reused) || !errors.Is(err, ErrRefreshReused) || reused.SessionID != tc.family {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+		checkExpectations(t, mock)
+		if authStatus(access) != http.StatusUnauthorized {
+			t.Errorf("%s: 会话吊销后访问令牌应失效", tc.name)
+		}
+	}
+}
+
+// 退出登录吊销当前访问令牌和所在会话，同一用户的其他会话不受影响
+func TestLogoutRevokesSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	current := signAccessToken(t, "u2", "logout-jti", "logout-session")
+	other := signAccessToken(t, "u2", "other-jti", "other-session")
+
+	expectRevocation(mock, middleware.RevokeKindToken, "logout-jti")
+	expectRevokeSession(mock, "u2", "logout-session")
+	mock.ExpectQuery("SELECT `username` FROM `users` WHERE id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("bob"))
+	expectExec(mock, "INSERT INTO `audit_events`", sqlmock.NewResult(1, 1))
+
+	r := gin.New()
+	r.POST("/user/logout", middleware.AuthMiddleware(), us.Logout)
+	req := httptest.NewRequest(http.MethodPost, "/user/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+current)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("退出登录失败: %d %s", w.Code, w.Body)
+	}
+	checkExpectations(t, mock)
+
+	if authStatus(current) != http.StatusUnauthorized {
+		t.Error("退出后访问令牌应失效")
+	}
+	if authStatus(other) != http.StatusOK {
+		t.Error("其他会话不应受影响")
+	}
+}
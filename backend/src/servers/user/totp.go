@@ -0,0 +1,374 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"myapp/middleware"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 两步验证：基于时间的一次性密码（TOTP，RFC 6238，HMAC-SHA1、6 位、30 秒），
+// 以及一次性恢复码。开启后登录分为两步：密码正确时返回短期有效的挑战令牌，
+// 客户端再提交挑战令牌和验证码换取访问令牌。
+
+const (
+	totpDigits    = 6
+	totpPeriod    = 30
+	totpSkew      = 1 // 允许前后各一个时间步的时钟误差
+	totpSecretLen = 20
+
+	recoveryCodeCount = 10
+	recoveryCodeLen   = 10
+
+	loginChallengeTTL = 5 * time.Minute
+	loginChallengeTyp = "2fa"
+)
+
+var (
+	ErrTOTPInvalid      = errors.New("验证码错误")
+	ErrChallengeInvalid = errors.New("两步验证已过期，请重新登录")
+
+	ErrRecoveryCodeInvalid = errors.New("恢复码无效")
+)
+
+// RecoveryCode 恢复码，只保存 SHA-256 哈希
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   string `gorm:"type:varchar(255);not null;index"`
+	CodeHash string `gorm:"type:varchar(64);not null"`
+	UsedAt   *time.Time
+}
+
+type TOTPSetup struct {
+	Secret string `json:"secret"`      // Base32 编码的密钥，用于手动输入
+	URI    string `json:"otpauth_uri"` // otpauth:// 链接，客户端据此生成二维码
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge    string `json:"challenge" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (us *UserService) totpIssuer() string {
+	if us.cfg.TOTPIssuer != "" {
+		return us.cfg.TOTPIssuer
+	}
+	return "digital-hub"
+}
+
+// totpCode 计算指定时间步的验证码（RFC 4226 动态截断）
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP 在允许的时钟误差内查找匹配的时间步，只接受晚于 lastStep 的时间步，防止验证码重放
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTP 校验验证码并记录使用的时间步，同一验证码只能使用一次
+func (us *UserService) verifyTOTP(user *User, code string) error {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	step, ok := matchTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return ErrTOTPInvalid
+	}
+	result := us.db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTOTPInvalid
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// setupTOTP 生成新的密钥，验证通过后才会开启两步验证
+func (us *UserService) setupTOTP(userID string) (*TOTPSetup, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("两步验证已开启")
+	}
+
+	buf := make([]byte, totpSecretLen)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+	err = us.db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := us.totpIssuer()
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user.Username,
+		RawQuery: params.Encode(),
+	}
+	return &TOTPSetup{Secret: secret, URI: uri.String()}, nil
+}
+
+// enableTOTP 校验首个验证码后开启两步验证，返回恢复码
+func (us *UserService) enableTOTP(userID, code string) ([]string, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("两步验证已开启")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("请先获取两步验证密钥")
+	}
+	if err := us.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		codes, err = us.generateRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// disableTOTP 关闭两步验证，需要密码和验证码（或恢复码）
+func (us *UserService) disableTOTP(userID string, req *DisableTwoFactorRequest) error {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return errors.New("两步验证未开启")
+	}
+	if err := checkPassword(user, req.Password); err != nil {
+		return err
+	}
+	if err := us.verifySecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	return us.resetTOTP(us.db, userID)
+}
+
+// resetTOTP 清除两步验证设置和恢复码，管理员可为丢失设备的用户执行
+func (us *UserService) resetTOTP(tx *gorm.DB, userID string) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// adminResetTOTP 管理员重置用户的两步验证，并吊销其所有会话
+func (us *UserService) adminResetTOTP(userID string) error {
+	if _, err := us.findUser(userID); err != nil {
+		return err
+	}
+	if err := us.resetTOTP(us.db, userID); err != nil {
+		return err
+	}
+	return us.revokeAllTokens(userID)
+}
+
+// regenerateRecoveryCodes 使旧的恢复码失效并生成新的恢复码
+func (us *UserService) regenerateRecoveryCodes(userID, code string) ([]string, error) {
+	user, err := us.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, errors.New("两步验证未开启")
+	}
+	if err := us.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		codes, err = us.generateRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+func (us *UserService) generateRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomPassword(recoveryCodeLen)
+		if err != nil {
+			return nil, err
+		}
+		code = strings.ToLower(code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:])
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode 恢复码忽略大小写和分隔符
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode 使用一个恢复码，每个恢复码只能使用一次
+func (us *UserService) useRecoveryCode(userID, code string) error {
+	result := us.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Limit(1).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func (us *UserService) verifySecondFactor(user *User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return us.useRecoveryCode(user.ID, recoveryCode)
+	}
+	return us.verifyTOTP(user, code)
+}
+
+// remainingRecoveryCodes 未使用的恢复码数量
+func (us *UserService) remainingRecoveryCodes(userID string) (int64, error) {
+	var count int64
+	err := us.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// issueLoginChallenge 密码验证通过后签发挑战令牌，只能用于完成两步验证
+func (us *UserService) issueLoginChallenge(userID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":     loginChallengeTyp,
+		"user_id": userID,
+		"jti":     uuid.New().String(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(loginChallengeTTL).Unix(),
+	})
+	return token.SignedString([]byte(middleware.JWTSecret))
+}
+
+// loginWithSecondFactor 校验挑战令牌和验证码（或恢复码），完成登录；验证码错误与密码错误一样计入失败次数
+func (us *UserService) loginWithSecondFactor(req *TwoFactorLoginRequest, ip string) (*UserResponse, error) {
+	if err := us.checkIP(ip); err != nil {
+		return nil, err
+	}
+	token, err := jwt.Parse(req.Challenge, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(middleware.JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrChallengeInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrChallengeInvalid
+	}
+	typ, _ := claims["typ"].(string)
+	userID, _ := claims["user_id"].(string)
+	if typ != loginChallengeTyp || userID == "" {
+		return nil, ErrChallengeInvalid
+	}
+
+	user, err := us.getUserByID(userID)
+	if err != nil || !user.Active || !user.TOTPEnabled {
+		return nil, ErrChallengeInvalid
+	}
+	// 挑战令牌签发后用户修改了密码或退出了所有设备
+	if iat, ok := claims["iat"].(float64); !ok || us.revokedBefore(userID, time.Unix(int64(iat), 0)) {
+		return nil, ErrChallengeInvalid
+	}
+	if err := us.checkAccount(user); err != nil {
+		return nil, err
+	}
+	if err := us.verifySecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+		us.recordLoginFailure(user, ip)
+		return nil, err
+	}
+	us.resetLoginFailures(user)
+	return us.toResponse(user)
+}
+
+// revokedBefore 检查用户级吊销是否晚于给定时间
+func (us *UserService) revokedBefore(userID string, at time.Time) bool {
+	var entry TokenRevocation
+	err := us.db.Where(&TokenRevocation{Kind: middleware.RevokeKindUser, Key: userID}).First(&entry).Error
+	return err == nil && at.Before(entry.At)
+}
@@ -0,0 +1,165 @@
+package user
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"myapp/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RFC 6238 附录 B 的 SHA-1 测试向量，取 8 位结果的后 6 位
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		if got := totpCode(secret, unix/totpPeriod); got != want {
+			t.Errorf("T=%d: 验证码为 %s，应为 %s", unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTPWindowAndReplay(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	cases := []struct {
+		name     string
+		step     int64
+		lastStep int64
+		ok       bool
+	}{
+		{"当前时间步", current, 0, true},
+		{"前一个时间步", current - 1, 0, true},
+		{"后一个时间步", current + 1, 0, true},
+		{"超出误差范围（过早）", current - 2, 0, false},
+		{"超出误差范围（过晚）", current + 2, 0, false},
+		{"重放已使用的时间步", current, current, false},
+		{"早于已使用的时间步", current - 1, current, false},
+		{"晚于已使用的时间步", current + 1, current, true},
+	}
+	for _, tc := range cases {
+		step, ok := matchTOTP(secret, totpCode(key, tc.step), now, tc.lastStep)
+		if ok != tc.ok || (ok && step != tc.step) {
+			t.Errorf("%s: 结果为 (%d, %v)", tc.name, step, ok)
+		}
+	}
+	if _, ok := matchTOTP(secret, "12345", now, 0); ok {
+		t.Error("位数不对的验证码不应通过")
+	}
+	if _, ok := matchTOTP("not base32!", totpCode(key, current), now, 0); ok {
+		t.Error("密钥无效时不应通过")
+	}
+}
+
+// 并发请求使用同一验证码时，条件更新只有一个能成功
+func TestVerifyTOTPRecordsStep(t *testing.T) {
+	us, mock := newTestService(t, nil)
+	key := []byte("12345678901234567890")
+	user := &User{ID: "u1", TOTPSecret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)}
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+
+	expectExec(mock, "UPDATE `users` SET `totp_last_step`=\\?.* WHERE id = \\? AND totp_last_step < \\?", sqlmock.NewResult(0, 1))
+	if err := us.verifyTOTP(user, code); err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPLastStep == 0 {
+		t.Error("应记录使用的时间步")
+	}
+	// 同一用户再次提交相同的验证码，内存中的时间步已拒绝，不再访问数据库
+	if err := us.verifyTOTP(user, code); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("验证码重放应被拒绝，err = %v", err)
+	}
+	// 另一个请求读取到的是旧的时间步，由数据库的条件更新拒绝
+	stale := &User{ID: "u1", TOTPSecret: user.TOTPSecret}
+	expectExec(mock, "UPDATE `users` SET `totp_last_step`=\\?", sqlmock.NewResult(0, 0))
+	if err := us.verifyTOTP(stale, code); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("并发重放应被拒绝，err = %v", err)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	us, mock := newTestService(t, nil)
+	hash := hashRecoveryCode("abcde-fghij")
+	if hashRecoveryCode(" ABCDE FGHIJ ") != hash || hashRecoveryCode("abcdefghij") != hash {
+		t.Error("恢复码应忽略大小写、空格和分隔符")
+	}
+
+	query := "UPDATE `recovery_codes` SET `used_at`=\\? WHERE user_id = \\? AND code_hash = \\? AND used_at IS NULL LIMIT \\?"
+	expectExec(mock, query, sqlmock.NewResult(0, 1))
+	expectExec(mock, query, sqlmock.NewResult(0, 0))
+	if err := us.useRecoveryCode("u1", "ABCDE-FGHIJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.useRecoveryCode("u1", "abcde-fghij"); !errors.Is(err, ErrRecoveryCodeInvalid) {
+		t.Errorf("恢复码只能使用一次，err = %v", err)
+	}
+	checkExpectations(t, mock)
+}
+
+// 开启两步验证的用户密码正确时只拿到挑战令牌，挑战令牌不能用于访问接口
+func TestLoginReturnsChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	us, mock := newTestService(t, nil)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM `users` WHERE username = \\?").
+		WithArgs("alice", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "active", "email_verified", "totp_enabled", "totp_secret"}).
+			AddRow("u1", "alice", string(hash), true, true, true, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+	mock.ExpectQuery("SELECT `role_name` FROM `user_roles` WHERE user_id = \\?").
+		WillReturnRows(sqlmock.NewRows([]string{"role_name"}).AddRow(middleware.RoleUser))
+	expectExec(mock, "INSERT INTO `audit_events`", sqlmock.NewResult(1, 1))
+
+	r := gin.New()
+	r.POST("/user/login", us.Login)
+	r.GET("/user/profile", middleware.AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user/login", strings.NewReader(`{"username":"alice","password":"secret"}`)))
+	var resp struct {
+		Data struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Challenge         string `json:"challenge"`
+			AccessToken       string `json:"access_token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应无法解析: %s", w.Body)
+	}
+	if w.Code != http.StatusOK || !resp.Data.TwoFactorRequired || resp.Data.Challenge == "" || resp.Data.AccessToken != "" {
+		t.Fatalf("应返回挑战令牌: %d %s", w.Code, w.Body)
+	}
+	checkExpectations(t, mock)
+
+	req := httptest.NewRequest(http.MethodGet, "/user/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Data.Challenge)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("挑战令牌不应通过认证，状态码为 %d", w.Code)
+	}
+}
@@ -3,6 +3,7 @@ package user
 import (
 	"errors"
 	"fmt"
+	"myapp/middleware"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,17 +16,28 @@ type User struct {
 	Username  string    `gorm:"type:varchar(255);not null;unique" json:"username"`
 	Email     string    `gorm:"type:varchar(255)" json:"email"`
 	Password  string    `gorm:"type:varchar(255);not pull" json:"password"`
-	Role      int32     `json:"role"`
+	Role      int32     `json:"-"` // 旧版数字角色，仅用于迁移到 user_roles
 	Active    bool      `json:"active"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoCreateTime" json:"updated_at"`
+
+	EmailVerified       bool       `gorm:"not null;default:false" json:"-"`
+	TOTPSecret          string     `gorm:"column:totp_secret;type:varchar(64)" json:"-"`        // 两步验证密钥，开启前为待验证的密钥
+	TOTPEnabled         bool       `gorm:"column:totp_enabled;not null;default:false" json:"-"` // 是否开启两步验证
+	TOTPLastStep        int64      `gorm:"column:totp_last_step;not null;default:0" json:"-"`   // 最近一次使用的验证码时间步
+	MustChangePassword  bool       `gorm:"not null;default:false" json:"-"`                     // 管理员重置密码后需要修改
+	Avatar              string     `gorm:"type:varchar(255)" json:"-"`                          // 头像文件名
+	FailedLogins        int        `gorm:"not null;default:0" json:"-"`                         // 连续登录失败次数
+	LockedUntil         *time.Time `json:"-"`                                                   // 登录锁定的截止时间
+	DeletionScheduledAt *time.Time `json:"-"`                                                   // 申请注销后的删除时间
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=20"`
 	Email    string `json:"email"`
 	Password string `json:"password" binding:"required,min=3"`
-	Role     int32  `json:"role"`
+
+	InviteCode string `json:"invite_code"` // 邀请注册时必填
 }
 
 type LoginRequest struct {
@@ -34,13 +46,63 @@ type LoginRequest struct {
 }
 
 type UserResponse struct {
-	ID        string `json:"id"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	Role      int32  `json:"role"`
-	Active    bool   `json:"active"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	ID               string   `json:"id"`
+	Username         string   `json:"username"`
+	Email            string   `json:"email"`
+	EmailVerified    bool     `json:"email_verified"`
+	TwoFactorEnabled bool     `json:"two_factor_enabled"`
+	Roles            []string `json:"roles"`
+	Permissions      []string `json:"permissions"`
+	Active           bool     `json:"active"`
+	CreatedAt        int64    `json:"created_at"`
+	UpdatedAt        int64    `json:"updated_at"`
+
+	MustChangePassword  bool   `json:"must_change_password"`
+	AvatarURL           string `json:"avatar_url"`
+	DeletionScheduledAt *int64 `json:"deletion_scheduled_at"` // 申请注销后的删除时间
+	FailedLogins        int    `json:"failed_logins"`         // 连续登录失败次数
+	LockedUntil         *int64 `json:"locked_until"`          // 登录锁定的截止时间，未锁定时为空
+}
+
+// toResponse 生成返回给客户端的用户信息，包含角色和权限
+func (us *UserService) toResponse(user *User) (*UserResponse, error) {
+	roles, err := us.userRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newUserResponse(user, roles), nil
+}
+
+func newUserResponse(user *User, roles []string) *UserResponse {
+	if roles == nil {
+		roles = []string{}
+	}
+	resp := &UserResponse{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Email:              user.Email,
+		EmailVerified:      user.EmailVerified,
+		TwoFactorEnabled:   user.TOTPEnabled,
+		Roles:              roles,
+		Permissions:        middleware.RolePermissions(roles),
+		Active:             user.Active,
+		CreatedAt:          user.CreatedAt.Unix(),
+		UpdatedAt:          user.UpdatedAt.Unix(),
+		MustChangePassword: user.MustChangePassword,
+	}
+	if user.Avatar != "" {
+		resp.AvatarURL = fmt.Sprintf("/user/avatar/%s?v=%d", user.ID, user.UpdatedAt.Unix())
+	}
+	if user.DeletionScheduledAt != nil {
+		at := user.DeletionScheduledAt.Unix()
+		resp.DeletionScheduledAt = &at
+	}
+	resp.FailedLogins = user.FailedLogins
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		until := user.LockedUntil.Unix()
+		resp.LockedUntil = &until
+	}
+	return resp
 }
 
 func (us *UserService) getUserByID(id string) (*User, error) {
@@ -78,9 +140,33 @@ func (us *UserService) createUser(user *User) error {
 	return us.db.Create(user).Error
 }
 
+// deleteUserByID 删除用户及其在各服务中的数据，并吊销其令牌
 func (us *UserService) deleteUserByID(id string) error {
-	// Implementation for deleting a user
-	return us.db.Where("id = ?", id).Delete(&User{}).Error
+	user, err := us.findUser(id)
+	if err != nil {
+		return err
+	}
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := us.checkLastAdmin(tx, id); err != nil {
+			return err
+		}
+		for _, hook := range us.deleteHooks {
+			if err := hook(tx, id); err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{&UserRole{}, &RefreshToken{}, &EmailToken{}, &RecoveryCode{}, &OIDCIdentity{}, &APIKey{}, &Session{}} {
+			if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id = ?", id).Delete(&User{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	us.removeAvatar(user.Avatar)
+	return us.revokeAccessTokens(id)
 }
 
 func (us *UserService) deleteUserByName(username string) error {
@@ -102,6 +188,13 @@ func (us *UserService) registerUser(req *RegisterRequest) (*UserResponse, error)
 	if existUser != nil {
 		return nil, fmt.Errorf("用户名已存在")
 	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if email == "" && us.cfg.RequireEmailVerification {
+		return nil, errors.New("请填写邮箱")
+	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -110,53 +203,70 @@ func (us *UserService) registerUser(req *RegisterRequest) (*UserResponse, error)
 	newUser := &User{
 		ID:        uuid.New().String(),
 		Username:  req.Username,
-		Email:     req.Email,
+		Email:     email,
 		Password:  string(hashedPassword),
-		Role:      req.Role,
 		Active:    true,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	err = us.createUser(newUser)
+	// 角色由服务端分配，不接受客户端指定；邀请码可以指定角色
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		invite, err := us.checkRegistration(tx, req.InviteCode)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(newUser).Error; err != nil {
+			return err
+		}
+		return us.assignInviteRole(tx, newUser.ID, invite)
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &UserResponse{
-		ID:        newUser.ID,
-		Username:  newUser.Username,
-		Email:     newUser.Email,
-		Role:      newUser.Role,
-		Active:    newUser.Active,
-		CreatedAt: newUser.CreatedAt.Unix(),
-		UpdatedAt: newUser.UpdatedAt.Unix(),
-	}, nil
+	if newUser.Email != "" {
+		us.sendEmailLinkAsync(newUser, PurposeVerifyEmail)
+	}
+	return us.toResponse(newUser)
 }
 
-func (us *UserService) login(req *LoginRequest) (*UserResponse, error) {
+// login 校验用户名和密码，失败次数过多时锁定账号和 IP
+func (us *UserService) login(req *LoginRequest, ip string) (*UserResponse, error) {
+	if err := us.checkIP(ip); err != nil {
+		return nil, err
+	}
 	user, err := us.getUserByName(req.Username)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("用户不存在")
+			// 与真实账号一样先检查锁定，锁定后返回相同的错误
+			if err := us.checkUnknownUser(req.Username); err != nil {
+				return nil, err
+			}
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+			us.recordLoginFailure(nil, ip)
+			us.recordUnknownUserFailure(req.Username)
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
-
-	if !user.Active {
-		return nil, errors.New("用户未激活")
+	if err := us.checkAccount(user); err != nil {
+		return nil, err
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
-		return nil, errors.New("密码错误")
-	}
-
-	return &UserResponse{
-		ID:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		Role:      user.Role,
-		Active:    user.Active,
-		CreatedAt: user.CreatedAt.Unix(),
-		UpdatedAt: user.UpdatedAt.Unix(),
-	}, nil
+		us.recordLoginFailure(user, ip)
+		return nil, ErrInvalidCredentials
+	}
+	if !user.Active {
+		return nil, ErrUserInactive
+	}
+	if us.emailVerificationRequired(user) {
+		return nil, ErrEmailNotVerified
+	}
+	// 开启两步验证的用户在第二步通过后才清除失败记录
+	if !user.TOTPEnabled {
+		us.resetLoginFailures(user)
+	}
+
+	return us.toResponse(user)
 }
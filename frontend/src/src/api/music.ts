@@ -2,14 +2,79 @@ import request from '@/utils/request'
 
 export interface Music {
   id: number
+  library: string
   name: string
   file_path: string
 }
 
+export interface LibraryEvent {
+  type: 'track_added' | 'track_removed' | 'track_updated' | 'rescan_started' | 'rescan_progress' | 'rescan_done'
+  music?: Music
+  scanned?: number
+  total?: number
+  timestamp: number
+}
+
+const libraryEventTypes: LibraryEvent['type'][] = [
+  'track_added', 'track_removed', 'track_updated',
+  'rescan_started', 'rescan_progress', 'rescan_done'
+]
+
+export interface MusicFilter {
+  tag?: string // 逗号分隔，需同时包含
+  min_rating?: number
+  max_rating?: number
+}
+
+export interface RatingSummary {
+  music_id: number
+  average: number
+  count: number
+  mine: number
+}
+
+export interface TagCount {
+  tag: string
+  count: number
+}
+
+export interface TrackComment {
+  id: number
+  music_id: number
+  user_id: string
+  username: string
+  parent_id: number | null
+  body: string
+  timestamp: number | null // 秒
+  hidden: boolean
+  deleted: boolean
+  created_at: string
+  updated_at: string
+  replies?: TrackComment[]
+}
+
+export interface Library {
+  name: string
+  public: boolean
+}
+
+// 音频和 SSE 请求无法携带请求头，通过查询参数传递 token
+export function withToken(url: string): string {
+  const token = localStorage.getItem('token')
+  if (!token) return url
+  const sep = url.includes('?') ? '&' : '?'
+  return `${url}${sep}token=${encodeURIComponent(token)}`
+}
+
 export const musicApi = {
   // 获取音乐列表
-  getMusicList(): Promise<Music[]>  {
-    return request.get('/music')
+  getMusicList(library?: string, filter?: MusicFilter): Promise<Music[]>  {
+    return request.get('/music', { params: { library, ...filter } })
+  },
+
+  // 获取可访问的音乐库
+  getLibraries(): Promise<Library[]> {
+    return request.get('/music/libraries')
   },
 
   // 播放音乐
@@ -38,6 +103,88 @@ export const musicApi = {
     return request.get('/music/favorite/ids')
   },
 
+  // 订阅音乐库实时事件，返回的 EventSource 需在组件卸载时关闭
+  subscribeEvents(onEvent: (event: LibraryEvent) => void): EventSource {
+    const source = new EventSource(withToken(`${import.meta.env.VITE_API_BASE_URL}/music/events`))
+    libraryEventTypes.forEach(type => {
+      source.addEventListener(type, (e: MessageEvent) => {
+        onEvent(JSON.parse(e.data))
+      })
+    })
+    return source
+  },
+
+  // 通知听歌记录服务正在播放
+  scrobbleNowPlaying(musicId: number, duration: number) {
+    return request.post('/scrobble/now-playing', { music_id: musicId, duration })
+  },
+
+  // 记录一次收听，listenedAt 为开始播放的 Unix 时间
+  scrobbleListen(musicId: number, duration: number, listenedAt: number) {
+    return request.post('/scrobble/listens', { music_id: musicId, duration, listened_at: listenedAt })
+  },
+
+  // 我的全部评分，键为音乐ID
+  getMyRatings(): Promise<Record<number, number>> {
+    return request.get('/music/ratings')
+  },
+
+  getRating(musicId: number): Promise<RatingSummary> {
+    return request.get(`/music/ratings/${musicId}`)
+  },
+
+  setRating(musicId: number, rating: number) {
+    return request.put(`/music/ratings/${musicId}`, { rating })
+  },
+
+  removeRating(musicId: number) {
+    return request.delete(`/music/ratings/${musicId}`)
+  },
+
+  // 我使用过的标签
+  getMyTags(): Promise<TagCount[]> {
+    return request.get('/music/tags')
+  },
+
+  getMusicTags(musicId: number): Promise<string[]> {
+    return request.get(`/music/tags/${musicId}`)
+  },
+
+  setMusicTags(musicId: number, tags: string[]) {
+    return request.put(`/music/tags/${musicId}`, { tags })
+  },
+
+  addMusicTag(musicId: number, tag: string) {
+    return request.post(`/music/tags/${musicId}`, { tag })
+  },
+
+  removeMusicTag(musicId: number, tag: string) {
+    return request.delete(`/music/tags/${musicId}/${encodeURIComponent(tag)}`)
+  },
+
+  // 获取评论（树形结构）
+  getComments(musicId: number): Promise<TrackComment[]> {
+    return request.get('/music/comments', { params: { music_id: musicId } })
+  },
+
+  // 发表评论，timestamp 形如 "1:23"
+  addComment(musicId: number, body: string, parentId?: number, timestamp?: string): Promise<TrackComment> {
+    return request.post('/music/comments', { music_id: musicId, body, parent_id: parentId, timestamp })
+  },
+
+  editComment(id: number, body: string) {
+    return request.put(`/music/comments/${id}`, { body })
+  },
+
+  deleteComment(id: number) {
+    return request.delete(`/music/comments/${id}`)
+  },
+
+  // 管理员隐藏/恢复评论
+  moderateComment(id: number, hidden: boolean) {
+    return request.put(`/music/comments/${id}/moderation`, { hidden })
+  },
+
   // 检查是否已收藏
   checkFavorite(musicId: number) {
     return request.get<{ is_favorite: boolean }>(`/music/favorite/check/${musicId}`)
@@ -1,19 +1,133 @@
 import Login from '@/components/views/user/Login.vue'
-import request from '@/utils/request'
+import request, { type TokenPair } from '@/utils/request'
 
 export interface User {
   id: string
   username: string
   email: string
-  role: number
+  roles: string[]
+  permissions: string[]
   active: boolean
   created_at: string
+  must_change_password: boolean
+  email_verified: boolean
+  two_factor_enabled: boolean
+  avatar_url: string
+  deletion_scheduled_at: number | null // 申请注销后的删除时间（Unix 秒）
+  failed_logins: number
+  locked_until: number | null // 登录锁定的解除时间（Unix 秒）
+}
+
+export interface IPBlock {
+  ip: string
+  failures: number
+  last_failure: string
+  locked_until: string
+}
+
+export interface UserListQuery {
+  q?: string
+  role?: string
+  active?: boolean
+  locked?: boolean
+  page?: number
+  page_size?: number
+}
+
+export interface UserList {
+  items: User[]
+  total: number
+  page: number
+  page_size: number
+}
+
+export interface Role {
+  name: string
+  description: string
+  builtin: boolean
+  permissions: string[]
+}
+
+export interface APIKey {
+  id: string
+  name: string
+  prefix: string
+  scopes: string[]
+  expires_at: string | null
+  last_used_at: string | null
+  last_used_ip: string
+  revoked_at: string | null
+  created_at: string
+}
+
+export interface Session {
+  id: string
+  device: string // 从 User-Agent 解析的设备描述
+  user_agent: string
+  ip: string
+  created_at: string
+  last_seen_at: string
+  expires_at: string
+  current: boolean // 是否为当前会话
+}
+
+export interface AuditEvent {
+  id: number
+  actor_id: string // 未登录或后台任务时为空
+  actor_name: string
+  action: string // 如 auth.login、admin.user_delete
+  target_type: string
+  target_id: string
+  target_name: string
+  ip: string
+  user_agent: string
+  result: 'success' | 'failure'
+  error?: string
+  detail?: Record<string, unknown>
+  created_at: string
+}
+
+export interface AuditQuery {
+  actor?: string // 操作者 ID 或名称
+  action?: string // 以 . 结尾时按前缀匹配
+  target_type?: string
+  target?: string // 目标 ID 或名称
+  ip?: string
+  result?: 'success' | 'failure'
+  from?: string // RFC 3339
+  to?: string
+  page?: number
+  page_size?: number
+}
+
+export interface AuditList {
+  items: AuditEvent[]
+  total: number
+  page: number
+  page_size: number
 }
 
 export interface RegisterReq {
   username: string
   email: string
   password: string
+  invite_code?: string // 邀请注册时必填
+}
+
+// 注册策略：open 开放注册，invite 凭邀请码注册，closed 关闭注册
+export type RegistrationMode = 'open' | 'invite' | 'closed'
+
+export interface Invite {
+  id: string
+  prefix: string
+  note: string
+  role: string // 注册后获得的角色，为空时使用默认角色
+  max_uses: number // 0 表示不限次数
+  uses: number
+  expires_at: string | null
+  created_by: string
+  revoked_at: string | null
+  created_at: string
 }
 
 export type RegisterRsp = User
@@ -23,11 +137,22 @@ export interface LoginReq {
   password: string
 }
 
-export interface LoginRsp {
-  token: string
+export interface LoginRsp extends TokenPair {
   user: User
 }
 
+// 开启两步验证的用户登录时先返回挑战令牌
+export interface TwoFactorChallenge {
+  two_factor_required: true
+  challenge: string
+  expires_in: number
+}
+
+export interface TOTPSetup {
+  secret: string
+  otpauth_uri: string
+}
+
 
 export const userApi = {
   // 注册用户
@@ -35,14 +160,230 @@ export const userApi = {
     return request.post('/user/register', req)
   },
 
+  getRegistrationConfig(): Promise<{ mode: RegistrationMode }> {
+    return request.get('/user/register/config')
+  },
+
   // 用户登录
-  login(req: LoginReq):  Promise<LoginRsp>  {
+  login(req: LoginReq):  Promise<LoginRsp | TwoFactorChallenge>  {
     return request.post('/user/login', req)
   },
 
+  // OIDC 登录配置，启用时显示单点登录按钮
+  getOidcConfig(): Promise<{ enabled: boolean; name: string }> {
+    return request.get('/user/oidc/config')
+  },
+
+  // 跳转到身份提供方登录的地址，由浏览器直接打开；邀请模式下首次登录需要邀请码
+  oidcLoginUrl(redirect = '/music', invite = ''): string {
+    const params = new URLSearchParams({ redirect })
+    if (invite) params.set('invite', invite)
+    return `${import.meta.env.VITE_API_BASE_URL || 'http://localhost:8080'}/user/oidc/login?${params}`
+  },
+
+  // 登录第二步，提交验证码或恢复码
+  loginTwoFactor(challenge: string, code: { code?: string; recovery_code?: string }): Promise<LoginRsp> {
+    return request.post('/user/login/2fa', { challenge, ...code })
+  },
+
   getCurrentUser(): Promise<User> {
     return request.get('/user/me')
   },
+
+  // 修改用户名和邮箱，修改邮箱时需要提供当前密码
+  updateProfile(req: { username?: string; email?: string; password?: string }): Promise<User> {
+    return request.put('/user/me', req)
+  },
+
+  // 修改密码，其他会话失效，返回当前客户端的新令牌
+  changePassword(oldPassword: string, newPassword: string): Promise<TokenPair> {
+    return request.put('/user/me/password', { old_password: oldPassword, new_password: newPassword })
+  },
+
+  uploadAvatar(file: File): Promise<User> {
+    const form = new FormData()
+    form.append('avatar', file)
+    return request.post('/user/me/avatar', form, { headers: { 'Content-Type': 'multipart/form-data' } })
+  },
+
+  // 注销账号，宽限期内重新登录可以撤销
+  deleteAccount(password: string): Promise<{ deletion_scheduled_at: number }> {
+    return request.delete('/user/me', { data: { password } })
+  },
+
+  cancelDeletion() {
+    return request.delete('/user/me/deletion')
+  },
+
+  // 向当前邮箱发送验证邮件
+  sendVerification() {
+    return request.post('/user/me/email/verification')
+  },
+
+  // 未登录时按邮箱重新发送验证邮件
+  resendVerification(email: string) {
+    return request.post('/user/email/verification', { email })
+  },
+
+  verifyEmail(token: string): Promise<User> {
+    return request.post('/user/email/verify', { token })
+  },
+
+  // 发送重置密码邮件
+  forgotPassword(email: string) {
+    return request.post('/user/password/forgot', { email })
+  },
+
+  resetPassword(token: string, newPassword: string) {
+    return request.post('/user/password/reset', { token, new_password: newPassword })
+  },
+
+  // 生成两步验证密钥，返回的 otpauth 链接可生成二维码
+  setupTwoFactor(): Promise<TOTPSetup> {
+    return request.post('/user/me/2fa/setup')
+  },
+
+  // 提交验证码开启两步验证，返回的恢复码只显示一次
+  enableTwoFactor(code: string): Promise<{ recovery_codes: string[] }> {
+    return request.post('/user/me/2fa/enable', { code })
+  },
+
+  disableTwoFactor(req: { password: string; code?: string; recovery_code?: string }) {
+    return request.post('/user/me/2fa/disable', req)
+  },
+
+  getRecoveryCodes(): Promise<{ remaining: number }> {
+    return request.get('/user/me/2fa/recovery-codes')
+  },
+
+  regenerateRecoveryCodes(code: string): Promise<{ recovery_codes: string[] }> {
+    return request.post('/user/me/2fa/recovery-codes', { code })
+  },
+
+  // 可选的 API 密钥范围及说明
+  getAPIKeyScopes(): Promise<Record<string, string>> {
+    return request.get('/user/me/api-keys/scopes')
+  },
+
+  listAPIKeys(): Promise<APIKey[]> {
+    return request.get('/user/me/api-keys')
+  },
+
+  // 返回的 key 只显示一次，expires_in_days 为 0 表示永不过期
+  createAPIKey(req: { name: string; scopes: string[]; expires_in_days?: number }): Promise<APIKey & { key: string }> {
+    return request.post('/user/me/api-keys', req)
+  },
+
+  revokeAPIKey(id: string) {
+    return request.delete(`/user/me/api-keys/${id}`)
+  },
+
+  // 登录会话列表，最近活动的在前
+  listSessions(): Promise<Session[]> {
+    return request.get('/user/me/sessions')
+  },
+
+  // 退出指定会话，退出当前会话后需要重新登录
+  revokeSession(id: string) {
+    return request.delete(`/user/me/sessions/${id}`)
+  },
+
+  // 退出当前会话
+  logout() {
+    return request.post('/user/logout')
+  },
+
+  // 退出所有设备
+  logoutAll() {
+    return request.post('/user/logout-all')
+  },
   
 
 }
+
+// 管理接口，需要相应权限
+export const adminApi = {
+  listUsers(query: UserListQuery): Promise<UserList> {
+    return request.get('/user/admin/users', { params: query })
+  },
+
+  getUser(id: string): Promise<User> {
+    return request.get(`/user/admin/users/${id}`)
+  },
+
+  setUserActive(id: string, active: boolean): Promise<User> {
+    return request.put(`/user/admin/users/${id}/active`, { active })
+  },
+
+  // 未指定密码时返回生成的临时密码
+  resetPassword(id: string, password?: string): Promise<{ temporary_password: string }> {
+    return request.post(`/user/admin/users/${id}/password-reset`, { password })
+  },
+
+  deleteUser(id: string) {
+    return request.delete(`/user/admin/users/${id}`)
+  },
+
+  // 重置用户的两步验证
+  resetTwoFactor(id: string) {
+    return request.delete(`/user/admin/users/${id}/2fa`)
+  },
+
+  listInvites(): Promise<Invite[]> {
+    return request.get('/user/admin/invites')
+  },
+
+  // 返回的 code 只显示一次，指定角色需要角色管理权限
+  createInvite(req: { note?: string; role?: string; max_uses?: number; expires_in_days?: number }): Promise<Invite & { code: string }> {
+    return request.post('/user/admin/invites', req)
+  },
+
+  revokeInvite(id: string) {
+    return request.delete(`/user/admin/invites/${id}`)
+  },
+
+  listAuditEvents(query: AuditQuery): Promise<AuditList> {
+    return request.get('/user/admin/audit', { params: query })
+  },
+
+  // 按查询条件导出为 JSON Lines 文件
+  exportAuditEvents(query: Omit<AuditQuery, 'page' | 'page_size'>): Promise<Blob> {
+    return request.get('/user/admin/audit/export', { params: query, responseType: 'blob' })
+  },
+
+  unlockUser(id: string): Promise<User> {
+    return request.post(`/user/admin/users/${id}/unlock`)
+  },
+
+  listBlockedIPs(): Promise<IPBlock[]> {
+    return request.get('/user/admin/blocked-ips')
+  },
+
+  unblockIP(ip: string) {
+    return request.delete(`/user/admin/blocked-ips/${encodeURIComponent(ip)}`)
+  },
+
+  setUserRoles(id: string, roles: string[]): Promise<string[]> {
+    return request.put(`/user/admin/users/${id}/roles`, { roles })
+  },
+
+  getPermissions(): Promise<Record<string, string>> {
+    return request.get('/user/admin/permissions')
+  },
+
+  getRoles(): Promise<Role[]> {
+    return request.get('/user/admin/roles')
+  },
+
+  createRole(role: Omit<Role, 'builtin'>): Promise<Role> {
+    return request.post('/user/admin/roles', role)
+  },
+
+  updateRole(name: string, role: Pick<Role, 'description' | 'permissions'>): Promise<Role> {
+    return request.put(`/user/admin/roles/${name}`, role)
+  },
+
+  deleteRole(name: string) {
+    return request.delete(`/user/admin/roles/${name}`)
+  }
+}
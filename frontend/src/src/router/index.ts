@@ -41,6 +41,21 @@ const routes: RouteRecordRaw[] = [
         path: '/user/login',
         name: 'login',
         component: () => import('@/components/views/user/Login.vue')
+    },
+    {
+        path: '/user/verify-email',
+        name: 'verifyEmail',
+        component: () => import('@/components/views/user/VerifyEmail.vue')
+    },
+    {
+        path: '/user/oidc/callback',
+        name: 'oidcCallback',
+        component: () => import('@/components/views/user/OidcCallback.vue')
+    },
+    {
+        path: '/user/reset-password',
+        name: 'resetPassword',
+        component: () => import('@/components/views/user/ResetPassword.vue')
     }
 ]
 
@@ -1,6 +1,7 @@
 import { defineStore } from 'pinia'
 import { ref, computed, nextTick } from 'vue'
 import { ElMessage } from 'element-plus'
+import { musicApi, withToken } from '@/api/music'
 import type { Music } from '@/api/music'
 
 // 定义播放模式类型
@@ -24,6 +25,10 @@ export const usePlayerStore = defineStore('player', () => {
   // 音频元素引用
   let audioElement: HTMLAudioElement | null = null
 
+  // 听歌记录：开始播放的时间，以及本次播放是否已提交
+  let listenStartedAt = 0
+  let listenSubmitted = false
+
   // 计算属性
   const currentIndex = computed(() => {
     if (!currentMusic.value) return -1
@@ -35,7 +40,7 @@ export const usePlayerStore = defineStore('player', () => {
 
   const currentMusicUrl = computed(() => {
     if (!currentMusic.value) return ''
-    return `${import.meta.env.VITE_API_BASE_URL}/music/download/${currentMusic.value.id}`
+    return withToken(`${import.meta.env.VITE_API_BASE_URL}/music/download/${currentMusic.value.id}`)
   })
 
   // 方法
@@ -66,6 +71,8 @@ export const usePlayerStore = defineStore('player', () => {
         try {
           await audioElement.play()
           isPlaying.value = true
+          listenStartedAt = Math.floor(Date.now() / 1000)
+          listenSubmitted = false
           ElMessage.success({
             message: `正在播放：${currentMusic.value?.name}`,
             grouping: true
@@ -164,13 +171,27 @@ export const usePlayerStore = defineStore('player', () => {
   function handleTimeUpdate() {
     if (audioElement) {
       currentTime.value = audioElement.currentTime
+      submitListen()
     }
   }
 
+  // 播放超过一半或 4 分钟后提交听歌记录，未绑定服务时后端直接忽略
+  function submitListen() {
+    if (listenSubmitted || !currentMusic.value || !duration.value) return
+    if (currentTime.value < Math.min(duration.value / 2, 240)) return
+    listenSubmitted = true
+    musicApi.scrobbleListen(currentMusic.value.id, Math.round(duration.value), listenStartedAt)
+      .catch(err => console.error('提交听歌记录失败:', err))
+  }
+
   function handleLoadedMetadata() {
     if (audioElement) {
       duration.value = audioElement.duration
       console.log('音频已加载，时长:', duration.value)
+      if (currentMusic.value) {
+        musicApi.scrobbleNowPlaying(currentMusic.value.id, Math.round(duration.value))
+          .catch(err => console.error('更新正在播放失败:', err))
+      }
     }
   }
 
@@ -11,6 +11,64 @@ const service: AxiosInstance = axios.create({
   }
 })
 
+export interface TokenPair {
+  token: string
+  refresh_token: string
+  expires_in: number // 秒
+}
+
+let refreshTimer: ReturnType<typeof setTimeout> | undefined
+let refreshing: Promise<string> | null = null
+
+// 保存登录令牌，并在访问令牌过期前自动刷新（音频地址通过查询参数携带令牌，需要保持有效）
+export function saveTokens(tokens: TokenPair) {
+  localStorage.setItem('token', tokens.token)
+  localStorage.setItem('refresh_token', tokens.refresh_token)
+  localStorage.setItem('token_expires_at', String(Date.now() + tokens.expires_in * 1000))
+  scheduleRefresh()
+}
+
+export function clearTokens() {
+  clearTimeout(refreshTimer)
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+  localStorage.removeItem('token_expires_at')
+}
+
+function scheduleRefresh() {
+  clearTimeout(refreshTimer)
+  const expiresAt = Number(localStorage.getItem('token_expires_at'))
+  if (!expiresAt || !localStorage.getItem('refresh_token')) return
+  // 提前一分钟刷新
+  const delay = Math.max(expiresAt - Date.now() - 60 * 1000, 0)
+  refreshTimer = setTimeout(() => {
+    refreshAccessToken().catch(() => {})
+  }, delay)
+}
+
+// 刷新访问令牌，并发请求共用同一次刷新，避免刷新令牌被重复使用导致会话失效
+export function refreshAccessToken(): Promise<string> {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token')
+    if (!refreshToken) return Promise.reject(new Error('未登录'))
+    refreshing = axios.post(`${service.defaults.baseURL}/user/refresh`, { refresh_token: refreshToken })
+      .then(res => {
+        saveTokens(res.data.data)
+        return res.data.data.token as string
+      })
+      .catch(err => {
+        clearTokens()
+        throw err
+      })
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
+scheduleRefresh()
+
 // 请求拦截器
 service.interceptors.request.use(
   (config) => {
@@ -32,6 +90,11 @@ service.interceptors.request.use(
 service.interceptors.response.use(
   (response: AxiosResponse) => {
     const res = response.data
+
+    // 文件下载直接返回内容
+    if (response.config.responseType === 'blob') {
+      return res
+    }
     
     // 如果返回的状态码不是 200，则认为是错误
     if (response.status !== 200) {
@@ -41,8 +104,22 @@ service.interceptors.response.use(
     
     return res.data
   },
-  (error) => {
+  async (error) => {
     console.error('响应错误:', error)
+
+    // 访问令牌过期时使用刷新令牌换取新令牌后重试一次
+    const config = error.config
+    if (error.response?.status === 401 && config && !config._retried &&
+        localStorage.getItem('refresh_token') && !config.url?.startsWith('/user/refresh')) {
+      config._retried = true
+      try {
+        const token = await refreshAccessToken()
+        config.headers.Authorization = `Bearer ${token}`
+        return service(config)
+      } catch {
+        // 刷新失败，按未授权处理
+      }
+    }
     
     // 处理不同的错误状态码
     if (error.response) {
@@ -51,7 +128,7 @@ service.interceptors.response.use(
           ElMessage.error('未授权，请重新登录')
           // 可以在这里跳转到登录页
           // router.push('/login')
-          localStorage.removeItem('token')
+          clearTokens()
           break
         case 403:
           ElMessage.error('拒绝访问')